@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+const (
+	headerAPIKey    = "X-API-Key"
+	headerSignature = "X-Signature"
+	headerTimestamp = "X-Timestamp"
+)
+
+// ErrNoCredentials means the request carried nothing this authenticator
+// understands, so another one may still accept it
+var ErrNoCredentials = errors.New("No credentials provided")
+
+// Authenticator resolves the caller of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (models.Principal, error)
+}
+
+type principalKey struct{}
+
+// principalFromContext returns the authenticated caller, or the anonymous
+// principal when authentication is disabled
+func principalFromContext(ctx context.Context) models.Principal {
+	principal, _ := ctx.Value(principalKey{}).(models.Principal)
+	return principal
+}
+
+// authMiddleware rejects requests the authenticator does not accept and
+// stores the principal in the request context for handlers
+func authMiddleware(auth Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := auth.Authenticate(r)
+			if err != nil {
+				utils.Logger.WithError(err).WithField("path", r.URL.Path).Warn("Authentication failed")
+				w.Header().Set("WWW-Authenticate", "ApiKey header=\""+headerAPIKey+"\"")
+				writeError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ChainAuthenticators tries each authenticator in turn. The first one that
+// recognises the request's credentials decides the outcome.
+func ChainAuthenticators(authenticators ...Authenticator) Authenticator {
+	return authenticatorChain(authenticators)
+}
+
+type authenticatorChain []Authenticator
+
+func (c authenticatorChain) Authenticate(r *http.Request) (models.Principal, error) {
+	for _, auth := range c {
+		principal, err := auth.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return models.Principal{}, ErrNoCredentials
+}
+
+// requireScope rejects callers that were not granted scope with 403
+func requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFromContext(r.Context())
+		if !principal.Allows(scope) {
+			utils.Logger.WithFields(logrus.Fields{
+				"client": principal.ClientID,
+				"scope":  scope,
+				"path":   r.URL.Path,
+			}).Warn("Missing required scope")
+			writeError(w, http.StatusForbidden, "Missing required scope "+scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIKeyAuthenticator accepts the X-API-Key header and, for clients with a
+// signing secret, an HMAC signature over the request
+type APIKeyAuthenticator struct {
+	clients *services.ClientService
+}
+
+func NewAPIKeyAuthenticator(clients *services.ClientService) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		clients: clients,
+	}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (models.Principal, error) {
+	apiKey := r.Header.Get(headerAPIKey)
+	if apiKey == "" {
+		return models.Principal{}, ErrNoCredentials
+	}
+
+	client, err := a.clients.AuthenticateKey(apiKey)
+	if err != nil {
+		return models.Principal{}, err
+	}
+
+	if client.SigningSecret != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return models.Principal{}, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = a.clients.VerifySignature(client,
+			r.Header.Get(headerTimestamp), r.Header.Get(headerSignature),
+			r.Method, r.URL.RequestURI(), body)
+		if err != nil {
+			return models.Principal{}, err
+		}
+	}
+
+	return models.Principal{ClientID: models.PrincipalKeyPrefix + client.ID, Scopes: client.Scopes}, nil
+}
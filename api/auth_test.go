@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+const signingSecret = "pos-signing-secret"
+
+func createAuthRouter(t *testing.T) *mux.Router {
+	clients := newTestClientService()
+	register := func(client models.Client, key string) {
+		if err := clients.RegisterClient(client, key); err != nil {
+			t.Fatalf("Failed to register client: %v", err)
+		}
+	}
+	register(models.Client{ID: "alice"}, "alice-key")
+	register(models.Client{ID: "bob"}, "bob-key")
+	register(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+	register(models.Client{ID: "pos", SigningSecret: signingSecret}, "pos-key")
+
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+	)
+	return r
+}
+
+func authRequest(r *mux.Router, method, path, apiKey string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if body != nil {
+		req.Header.Set(contentTypeHeader, jsonContentType)
+	}
+	if apiKey != "" {
+		req.Header.Set(headerAPIKey, apiKey)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	r := createAuthRouter(t)
+	receipt, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: testTime,
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+		Total:        "1.25",
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint, "", receipt, nil)
+		if response.Code != http.StatusUnauthorized {
+			t.Fatalf("Should get 401 but got %d", response.Code)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint, "guess", receipt, nil)
+		if response.Code != http.StatusUnauthorized {
+			t.Fatalf("Should get 401 but got %d", response.Code)
+		}
+	})
+
+	t.Run("health stays public", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/health", "", nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+	})
+
+	response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Should get 200 but got %d", response.Code)
+	}
+	var processResp models.ReceiptResponse
+	json.Unmarshal(response.Body.Bytes(), &processResp)
+	pointsPath := "/receipts/" + processResp.ID + "/points"
+
+	t.Run("owner reads own receipt", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, pointsPath, "alice-key", nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+	})
+
+	t.Run("other client cannot read", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, pointsPath, "bob-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("admin reads any receipt", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, pointsPath, "ops-key", nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+	})
+}
+
+func TestSignedRequests(t *testing.T) {
+	r := createAuthRouter(t)
+	receipt, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: testTime,
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+		Total:        "1.25",
+	})
+
+	sign := func(ts time.Time, body []byte) map[string]string {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		return map[string]string{
+			headerTimestamp: timestamp,
+			headerSignature: services.SignRequest(signingSecret, timestamp, http.MethodPost, processEndpoint, body),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected int
+	}{
+		{name: "valid signature", headers: sign(testClock.Now(), receipt), expected: http.StatusOK},
+		{name: "unsigned", headers: nil, expected: http.StatusUnauthorized},
+		{name: "signature over different body", headers: sign(testClock.Now(), []byte("{}")), expected: http.StatusUnauthorized},
+		{name: "stale timestamp", headers: sign(testClock.Now().Add(-time.Hour), receipt), expected: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			response := authRequest(r, http.MethodPost, processEndpoint, "pos-key", receipt, tc.headers)
+			if response.Code != tc.expected {
+				t.Fatalf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	r := createAuthRouter(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		size    int
+		chunked bool
+	}{
+		{name: "json body", path: processEndpoint, size: maxRequestBytes + 1},
+		{name: "json body without a length", path: processEndpoint, size: maxRequestBytes + 1, chunked: true},
+		{name: "receipt text", path: "/receipts/parse", size: maxParseBytes + 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// unsigned, so the body has to be refused before the signature
+			// check reads it
+			req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewReader(bytes.Repeat([]byte(" "), tc.size)))
+			req.Header.Set(headerAPIKey, "pos-key")
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, req)
+			if response.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d: %s", response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminRoutesWithoutAuth(t *testing.T) {
+	r := mux.NewRouter()
+	SetupRoutes(r, newTestReceiptService(),
+		WithRetailers(services.NewRetailerService(repository.NewInMemoryRetailerStorage())),
+		WithWebhooks(services.NewWebhookService(repository.NewInMemoryWebhookStorage(), services.WebhookConfig{}, testClock)),
+	)
+
+	// the receipt endpoints are open, the admin ones refuse everyone
+	if response := authRequest(r, http.MethodGet, "/receipts", "", nil, nil); response.Code != http.StatusOK {
+		t.Errorf("Expected 200 listing receipts, got %d", response.Code)
+	}
+	for _, path := range []string{"/admin/reviews", "/admin/retailers", "/admin/webhooks"} {
+		if response := authRequest(r, http.MethodGet, path, "", nil, nil); response.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s, got %d", path, response.Code)
+		}
+	}
+}
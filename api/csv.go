@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// maxImportBytes caps CSV imports, enough for tens of thousands of rows.
+// It is applied by the route, before the spec validator reads the file.
+const maxImportBytes = 10 << 20
+
+// ImportReceipts handles POST /receipts/import. Receipts that fail are
+// listed in the result, the request only fails when the file cannot be
+// read.
+func (h *ReceiptHandler) ImportReceipts(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.ImportReceipts(principalFromContext(r.Context()), r.Body)
+	if err != nil {
+		utils.Logger.WithError(err).Warn("Failed to read CSV import")
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ExportReceipts handles GET /receipts/export?format=csv, csv being the
+// only and default format
+func (h *ReceiptHandler) ExportReceipts(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "Unsupported export format "+format)
+		return
+	}
+
+	w.Header().Set(headerContentType, "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="receipts.csv"`)
+	caller := principalFromContext(r.Context())
+	if err := h.service.ExportReceipts(caller, w); err != nil {
+		// the rows already sent cannot be taken back, the client sees a
+		// truncated file
+		utils.Logger.WithError(err).WithFields(logrus.Fields{
+			"client": caller.ClientID,
+		}).Error("Failed to export receipts")
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+func TestImportExport(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob"}, "bob-key")
+	clients.RegisterClient(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+	r := mux.NewRouter()
+	SetupRoutes(r, newTestReceiptService(), WithAuthenticator(NewAPIKeyAuthenticator(clients)))
+
+	csvHeader := map[string]string{contentTypeHeader: "text/csv"}
+	importCSV := func(apiKey, file string) (*http.Response, models.ImportResult) {
+		response := authRequest(r, http.MethodPost, "/receipts/import", apiKey, []byte(file), csvHeader)
+		var result models.ImportResult
+		json.Unmarshal(response.Body.Bytes(), &result)
+		return response.Result(), result
+	}
+	exportCSV := func(apiKey string) [][]string {
+		response := authRequest(r, http.MethodGet, "/receipts/export?format=csv", apiKey, nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		rows, err := csv.NewReader(response.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Export is not CSV: %v", err)
+		}
+		return rows
+	}
+
+	t.Run("import", func(t *testing.T) {
+		file := "key,retailer,purchaseDate,purchaseTime,total,shortDescription,price\n" +
+			"A1,Target,2022-01-01,13:01,8.74,Mountain Dew 12PK,6.49\n" +
+			"A1,Target,2022-01-01,13:01,8.74,Pepsi,2.25\n" +
+			"A2,Target,2022-01-01,,1.25,Pepsi,1.25\n" +
+			"A3,Target,2022-01-01,13:01,1.25,Pepsi\n"
+		response, result := importCSV("alice-key", file)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.StatusCode)
+		}
+		if result.Imported != 1 || result.Failed != 1 || result.Receipts[0].Key != "A1" || !reflect.DeepEqual(result.Receipts[0].Rows, []int{2, 3}) {
+			t.Errorf("Expected A1 imported from rows 2 and 3, got %+v", result)
+		}
+		expected := []models.ImportError{
+			{Rows: []int{5}, Error: "Row has 6 columns, the header has 7"},
+			{Rows: []int{4}, Key: "A2", Error: "Invalid receipt: Purchase time is required"},
+		}
+		if !reflect.DeepEqual(result.Errors, expected) {
+			t.Errorf("Expected errors %+v, got %+v", expected, result.Errors)
+		}
+	})
+
+	t.Run("import for another client", func(t *testing.T) {
+		file := "key,retailer,purchaseDate,purchaseTime,total,shortDescription,price\nB1,Walgreens,2022-01-02,08:13,1.40,Dasani,1.40\n"
+		if _, result := importCSV("bob-key", file); result.Imported != 1 {
+			t.Fatalf("Expected one receipt imported, got %+v", result)
+		}
+	})
+
+	t.Run("missing columns", func(t *testing.T) {
+		response, _ := importCSV("alice-key", "key,retailer\nA1,Target\n")
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.StatusCode)
+		}
+	})
+
+	t.Run("export own receipts", func(t *testing.T) {
+		rows := exportCSV("alice-key")
+		if len(rows) != 3 || rows[1][0] != rows[2][0] || rows[1][5] != "Target" {
+			t.Errorf("Expected the header and the two rows of A1, got %v", rows)
+		}
+	})
+
+	t.Run("admin exports everything", func(t *testing.T) {
+		if rows := exportCSV("ops-key"); len(rows) != 4 {
+			t.Errorf("Expected the header and three rows, got %v", rows)
+		}
+	})
+
+	t.Run("export re-imports", func(t *testing.T) {
+		var file strings.Builder
+		csv.NewWriter(&file).WriteAll(exportCSV("bob-key"))
+		if _, result := importCSV("bob-key", file.String()); result.Imported != 1 || result.Failed != 0 {
+			t.Errorf("Expected the export to import, got %+v", result)
+		}
+	})
+
+	t.Run("export spans storage batches", func(t *testing.T) {
+		clients.RegisterClient(models.Client{ID: "carol"}, "carol-key")
+		// more than two of the service's batches of 500
+		const receipts = 1201
+		var file strings.Builder
+		file.WriteString("key,retailer,purchaseDate,purchaseTime,total,shortDescription,price\n")
+		for i := 0; i < receipts; i++ {
+			fmt.Fprintf(&file, "C%d,Target,2022-01-01,13:01,1.25,Pepsi,1.25\n", i)
+		}
+		if _, result := importCSV("carol-key", file.String()); result.Imported != receipts {
+			t.Fatalf("Expected %d receipts imported, got %d", receipts, result.Imported)
+		}
+		if rows := exportCSV("carol-key"); len(rows) != receipts+1 {
+			t.Errorf("Expected the header and %d rows, got %d rows", receipts, len(rows))
+		}
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/receipts/export?format=xlsx", "alice-key", nil, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+}
+
+func TestImportSizeLimit(t *testing.T) {
+	r := mux.NewRouter()
+	SetupRoutes(r, newTestReceiptService())
+
+	// a header the validator would accept, padded past the limit
+	file := "key,retailer,purchaseDate,purchaseTime,total,shortDescription,price\n" + strings.Repeat(",,,,,,\n", maxImportBytes/7)
+	response := authRequest(r, http.MethodPost, "/receipts/import", "", []byte(file), map[string]string{contentTypeHeader: "text/csv"})
+	if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "10 MiB") {
+		t.Errorf("Expected 400 for a file over 10 MiB, got %d: %s", response.Code, response.Body.String())
+	}
+}
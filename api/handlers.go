@@ -2,11 +2,17 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
 	"github.com/ycChu711/receipt-processor/services"
 	"github.com/ycChu711/receipt-processor/utils"
 )
@@ -16,9 +22,24 @@ const (
 	contentTypeJSON   = "application/json"
 )
 
+// maxRequestBytes caps request bodies of routes without a cap of their own
+const maxRequestBytes = 1 << 20
+
+// maxParseBytes caps plain-text receipts, far above any printed receipt
+const maxParseBytes = 64 << 10
+
+// page sizes of GET /receipts
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
 // ReceiptHandler manages HTTP requests for receipts
 type ReceiptHandler struct {
 	service *services.ReceiptService
+	// jobs takes ?async=true submissions, without it they are processed
+	// while the caller waits
+	jobs *services.JobService
 }
 
 // NewReceiptHandler creates a handler with the given service
@@ -28,7 +49,8 @@ func NewReceiptHandler(service *services.ReceiptService) *ReceiptHandler {
 	}
 }
 
-// ProcessReceipt handles POST /receipts/process
+// ProcessReceipt handles POST /receipts/process. With ?async=true a valid
+// receipt is queued and answered with 202 and its job.
 func (h *ReceiptHandler) ProcessReceipt(w http.ResponseWriter, r *http.Request) {
 	utils.Logger.Info("Processing receipt request")
 
@@ -39,66 +61,211 @@ func (h *ReceiptHandler) ProcessReceipt(w http.ResponseWriter, r *http.Request)
 	// check bad json
 	if err != nil {
 		utils.Logger.WithError(err).Error("Failed to decode receipt JSON")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set(headerContentType, contentTypeJSON)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid receipt format. Please verify input."})
+		writeError(w, http.StatusBadRequest, "Invalid receipt format. Please verify input.")
 		return
 	}
 
 	// validate receipt
-	if err := receipt.Validate(); err != nil {
+	if err := h.service.ValidateReceipt(&receipt); err != nil {
 		utils.Logger.WithError(err).Warn("Receipt validation failed")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set(headerContentType, contentTypeJSON)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid receipt: " + err.Error(),
-		})
+		writeError(w, http.StatusBadRequest, "Invalid receipt: "+err.Error())
+		return
+	}
+
+	if h.jobs != nil && r.URL.Query().Get("async") == "true" {
+		h.submitJob(w, r, receipt)
 		return
 	}
 
 	// process and get id
-	id, err := h.service.ProcessReceipt(receipt)
+	id, err := h.service.ProcessReceipt(principalFromContext(r.Context()), receipt)
 	if err != nil {
 		utils.Logger.WithError(err).Error("Failed to process receipt")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set(headerContentType, contentTypeJSON)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Server error processing receipt",
-		})
+		writeError(w, http.StatusInternalServerError, "Server error processing receipt")
 		return
 	}
 
 	// return id
 	utils.Logger.WithField("id", id).Info("Receipt processed successfully")
-	w.Header().Set(headerContentType, contentTypeJSON)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(models.ReceiptResponse{ID: id})
+	writeJSON(w, http.StatusOK, models.ReceiptResponse{ID: id})
 }
 
-// GetPoints handles the GET /receipts/{id}/points
+// GetPoints handles the GET /receipts/{id}/points, with the per rule
+// breakdown when called with ?breakdown=true
 func (h *ReceiptHandler) GetPoints(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	utils.Logger.WithField("id", id).Info("Getting points for receipt")
 
-	points, found := h.service.GetPoints(id)
+	points, found := h.service.GetPoints(principalFromContext(r.Context()), id)
 	if !found {
-		utils.Logger.WithField("id", id).Warn("Receipt not found")
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set(headerContentType, contentTypeJSON)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "No receipt found for that ID",
-		})
+		h.writeNotFound(w, r, id)
 		return
 	}
 
 	utils.Logger.WithFields(logrus.Fields{
 		"id":     id,
-		"points": points,
+		"points": points.Points,
 	}).Info("Got points for the receipt")
 
+	if r.URL.Query().Get("breakdown") != "true" {
+		points.Breakdown = nil
+	}
+
+	writeJSON(w, http.StatusOK, points)
+}
+
+// GetReceipt handles the GET /receipts/{id}
+func (h *ReceiptHandler) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	receipt, found := h.service.GetReceipt(principalFromContext(r.Context()), id)
+	if !found {
+		h.writeNotFound(w, r, id)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// writeNotFound answers a lookup miss, with 410 when the storage dropped
+// the receipt so callers can tell it from an ID that never existed
+func (h *ReceiptHandler) writeNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	removal, removed := h.service.RemovedReceipt(principalFromContext(r.Context()), id)
+	if !removed {
+		utils.Logger.WithField("id", id).Warn("Receipt not found")
+		writeError(w, http.StatusNotFound, "No receipt found for that ID")
+		return
+	}
+
+	utils.Logger.WithFields(logrus.Fields{
+		"id":     id,
+		"reason": removal.Reason,
+	}).Warn("Receipt no longer stored")
+	if removal.Reason == repository.RemovedExpired {
+		writeError(w, http.StatusGone, "Receipt has expired")
+		return
+	}
+	writeError(w, http.StatusGone, "Receipt was evicted from storage")
+}
+
+// ListReceipts handles GET /receipts?status=&limit=&offset=
+func (h *ReceiptHandler) ListReceipts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	status := models.ReceiptStatus(query.Get("status"))
+	switch status {
+	case "", models.StatusActive, models.StatusPendingReview, models.StatusRejected:
+	default:
+		writeError(w, http.StatusBadRequest, "Unknown receipt status "+string(status))
+		return
+	}
+	offset, limit, ok := queryPage(w, query)
+	if !ok {
+		return
+	}
+
+	list, err := h.service.ListReceipts(principalFromContext(r.Context()), status, offset, limit)
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to list receipts")
+		writeError(w, http.StatusInternalServerError, "Server error listing receipts")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// ParseReceipt handles POST /receipts/parse. The plain-text body is read
+// into a draft, and with ?process=true a valid draft is also processed.
+func (h *ReceiptHandler) ParseReceipt(w http.ResponseWriter, r *http.Request) {
+	text, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxParseBytes))
+	if err != nil {
+		utils.Logger.WithError(err).Warn("Failed to read receipt text")
+		writeError(w, http.StatusBadRequest, "Receipt text is larger than 64 KiB")
+		return
+	}
+	if len(strings.TrimSpace(string(text))) == 0 {
+		writeError(w, http.StatusBadRequest, "Receipt text is empty")
+		return
+	}
+
+	draft := h.service.ParseReceipt(string(text))
+	utils.Logger.WithFields(logrus.Fields{
+		"items":    len(draft.Receipt.Items),
+		"warnings": len(draft.Warnings),
+	}).Info("Parsed receipt text")
+
+	if r.URL.Query().Get("process") != "true" {
+		writeJSON(w, http.StatusOK, models.ParseResponse{Draft: draft})
+		return
+	}
+
+	// validation normalizes the receipt, so work on a copy and keep the
+	// draft as parsed
+	receipt := draft.Receipt
+	receipt.Items = append([]models.Item(nil), draft.Receipt.Items...)
+	if err := h.service.ValidateReceipt(&receipt); err != nil {
+		utils.Logger.WithError(err).Warn("Parsed receipt failed validation")
+		writeJSON(w, http.StatusUnprocessableEntity, models.ParseResponse{Draft: draft, Error: "Invalid receipt: " + err.Error()})
+		return
+	}
+
+	id, err := h.service.ProcessReceipt(principalFromContext(r.Context()), receipt)
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to process receipt")
+		writeError(w, http.StatusInternalServerError, "Server error processing receipt")
+		return
+	}
+
+	utils.Logger.WithField("id", id).Info("Parsed receipt processed successfully")
+	writeJSON(w, http.StatusOK, models.ParseResponse{Draft: draft, ID: id})
+}
+
+// queryPage reads the offset and limit of a listing, writing the error and
+// returning false if either is out of range
+func queryPage(w http.ResponseWriter, query url.Values) (offset, limit int, ok bool) {
+	limit, err := queryInt(query.Get("limit"), defaultListLimit)
+	if err != nil || limit < 1 || limit > maxListLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		return 0, 0, false
+	}
+	offset, err = queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must be 0 or more")
+		return 0, 0, false
+	}
+	return offset, limit, true
+}
+
+// queryCursor reads the limit of a listing paged by the ID of the last
+// item seen, and that ID, writing the error and returning false if the
+// limit is out of range
+func queryCursor(w http.ResponseWriter, query url.Values) (after string, limit int, ok bool) {
+	limit, err := queryInt(query.Get("limit"), defaultListLimit)
+	if err != nil || limit < 1 || limit > maxListLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		return "", 0, false
+	}
+	return query.Get("after"), limit, true
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeJSON sends body as JSON with the given status. Headers have to be
+// set before WriteHeader or they are silently dropped.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
 	w.Header().Set(headerContentType, contentTypeJSON)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(models.PointsResponse{Points: points})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError sends the standard {"error": "..."} body
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
 }
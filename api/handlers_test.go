@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/ycChu711/receipt-processor/models"
 	"github.com/ycChu711/receipt-processor/repository"
 	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
 )
 
 const (
@@ -22,12 +27,32 @@ const (
 	jsonContentType   = "application/json"
 )
 
+// testClock pins "now" so the fixed test dates stay within the purchase
+// date bounds however long the tests live
+var testClock = utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+
+func newTestReceiptService(opts ...services.ServiceOption) *services.ReceiptService {
+	opts = append([]services.ServiceOption{services.WithClock(testClock)}, opts...)
+	return services.NewReceiptService(repository.NewInMemoryStorage(), opts...)
+}
+
+// newTestEventBus returns a receipt storage and an event bus reading its
+// outbox, with handlers subscribed. The bus is not started, tests Flush it.
+func newTestEventBus(handlers ...services.EventHandler) (*repository.InMemoryStorage, *services.EventBus) {
+	storage := repository.NewInMemoryStorage()
+	bus := services.NewEventBus(storage, services.EventBusConfig{})
+	for _, handler := range handlers {
+		bus.Subscribe(handler)
+	}
+	return storage, bus
+}
+
+func newTestClientService() *services.ClientService {
+	return services.NewClientService(repository.NewInMemoryClientStorage(), testClock)
+}
+
 func createTestHandler() *ReceiptHandler {
-	return NewReceiptHandler(
-		services.NewReceiptService(
-			repository.NewInMemoryStorage(),
-		),
-	)
+	return NewReceiptHandler(newTestReceiptService())
 }
 
 func TestProcessReceipt(t *testing.T) {
@@ -79,12 +104,474 @@ func TestProcessReceipt(t *testing.T) {
 			t.Fatalf("Should get 400 for invalid receipt, got %d", response.Code)
 		}
 	})
+
+	// purchase dates outside the accepted window
+	dateTests := []struct {
+		name     string
+		date     string
+		time     string
+		expected int
+	}{
+		{name: "far future", date: "2099-01-01", time: "12:00", expected: http.StatusBadRequest},
+		{name: "long ago", date: "1900-01-01", time: "12:00", expected: http.StatusBadRequest},
+		{name: "tomorrow within skew", date: "2022-06-02", time: "08:00", expected: http.StatusOK},
+		{name: "beyond skew", date: "2022-06-03", time: "13:00", expected: http.StatusBadRequest},
+	}
+	for _, tc := range dateTests {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := models.Receipt{
+				Retailer:     "Safeway",
+				PurchaseDate: tc.date,
+				PurchaseTime: tc.time,
+				Items: []models.Item{
+					{ShortDescription: "Ice Cream", Price: "5.99"},
+				},
+				Total: "5.99",
+			}
+
+			response := sendPostRequest(t, handler.ProcessReceipt, processEndpoint, receipt)
+
+			if response.Code != tc.expected {
+				t.Fatalf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+
+	// store time zones
+	zoneTests := []struct {
+		name          string
+		date          string
+		time          string
+		timeZone      string
+		timestampZone string
+		expected      int
+	}{
+		{name: "iana zone", date: testDate, time: testTime, timeZone: "America/Chicago", expected: http.StatusOK},
+		{name: "utc timestamps", date: testDate, time: testTime, timeZone: "-06:00", timestampZone: "UTC", expected: http.StatusOK},
+		{name: "unknown zone", date: testDate, time: testTime, timeZone: "Mars/Olympus_Mons", expected: http.StatusBadRequest},
+		{name: "bad offset", date: testDate, time: testTime, timeZone: "+25:00", expected: http.StatusBadRequest},
+		{name: "server local zone", date: testDate, time: testTime, timeZone: "Local", expected: http.StatusBadRequest},
+		{name: "time skipped by dst", date: "2022-03-13", time: "02:30", timeZone: "America/New_York", expected: http.StatusBadRequest},
+	}
+	for _, tc := range zoneTests {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := models.Receipt{
+				Retailer:      "Safeway",
+				PurchaseDate:  tc.date,
+				PurchaseTime:  tc.time,
+				TimeZone:      tc.timeZone,
+				TimestampZone: tc.timestampZone,
+				Items: []models.Item{
+					{ShortDescription: "Ice Cream", Price: "5.99"},
+				},
+				Total: "5.99",
+			}
+
+			response := sendPostRequest(t, handler.ProcessReceipt, processEndpoint, receipt)
+
+			if response.Code != tc.expected {
+				t.Fatalf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestPurchaseTimeForms(t *testing.T) {
+	handler := createTestHandler()
+
+	tests := []struct {
+		name        string
+		date        string
+		time        string
+		purchasedAt string
+		timeZone    string
+		expected    int
+	}{
+		{name: "hours and minutes", date: testDate, time: "13:01", expected: http.StatusOK},
+		{name: "with seconds", date: testDate, time: "13:01:59", expected: http.StatusOK},
+		{name: "rfc 3339 with offset", purchasedAt: "2022-01-01T13:01:00-06:00", expected: http.StatusOK},
+		{name: "rfc 3339 in utc", purchasedAt: "2022-01-01T19:01:00Z", timeZone: "America/Chicago", expected: http.StatusOK},
+		{name: "both forms agree", date: testDate, time: "13:01", purchasedAt: "2022-01-01T13:01:00Z", expected: http.StatusOK},
+		{name: "both forms disagree", date: testDate, time: "13:01", purchasedAt: "2022-01-01T15:01:00Z", expected: http.StatusBadRequest},
+		{name: "not rfc 3339", purchasedAt: "01/01/2022 13:01", expected: http.StatusBadRequest},
+		{name: "bad seconds", date: testDate, time: "13:01:75", expected: http.StatusBadRequest},
+		{name: "no purchase time", expected: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := models.Receipt{
+				Retailer:     "Safeway",
+				PurchaseDate: tc.date,
+				PurchaseTime: tc.time,
+				PurchasedAt:  tc.purchasedAt,
+				TimeZone:     tc.timeZone,
+				Items: []models.Item{
+					{ShortDescription: "Ice Cream", Price: "5.99"},
+				},
+				Total: "5.99",
+			}
+
+			response := sendPostRequest(t, handler.ProcessReceipt, processEndpoint, receipt)
+
+			if response.Code != tc.expected {
+				t.Fatalf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+
+	t.Run("normalized on validation", func(t *testing.T) {
+		receipt := models.Receipt{
+			Retailer:    "Safeway",
+			PurchasedAt: "2022-01-01T20:30:15Z",
+			TimeZone:    "America/Chicago",
+			Items:       []models.Item{{ShortDescription: "Ice Cream", Price: "5.99"}},
+			Total:       "5.99",
+		}
+		if err := newTestReceiptService().ValidateReceipt(&receipt); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if receipt.PurchaseDate != "2022-01-01" || receipt.PurchaseTime != "14:30:15" {
+			t.Errorf("Expected store local 2022-01-01 14:30:15, got %s %s", receipt.PurchaseDate, receipt.PurchaseTime)
+		}
+		if receipt.PurchasedAt != "2022-01-01T14:30:15-06:00" {
+			t.Errorf("Unexpected purchasedAt %s", receipt.PurchasedAt)
+		}
+	})
+}
+
+func TestReceiptCharacters(t *testing.T) {
+	unicodeHandler := createTestHandler()
+	asciiHandler := NewReceiptHandler(newTestReceiptService(
+		services.WithValidationRules(models.ValidationRules{
+			Characters: models.CharacterPolicy{ASCIIOnly: true},
+		}),
+	))
+
+	tests := []struct {
+		name        string
+		retailer    string
+		description string
+		unicode     int
+		ascii       int
+	}{
+		{name: "accents", retailer: "Café Nero", description: "Crème brûlée", unicode: http.StatusOK, ascii: http.StatusBadRequest},
+		{name: "apostrophes", retailer: "M&M's", description: "Trader Joe’s Salsa", unicode: http.StatusOK, ascii: http.StatusOK},
+		{name: "store number", retailer: "7-Eleven #42", description: "Big Gulp 32 fl. oz.", unicode: http.StatusOK, ascii: http.StatusOK},
+		{name: "japanese", retailer: "ローソン", description: "おにぎり", unicode: http.StatusOK, ascii: http.StatusBadRequest},
+		{name: "greek", retailer: "Σκλαβενίτης", description: "Ψωμί", unicode: http.StatusOK, ascii: http.StatusBadRequest},
+		{name: "arabic digits", retailer: "متجر ٤٢", description: "خبز", unicode: http.StatusOK, ascii: http.StatusBadRequest},
+		{name: "markup", retailer: "<script>", description: "Item", unicode: http.StatusBadRequest, ascii: http.StatusBadRequest},
+		{name: "emoji", retailer: "Shop", description: "Pizza 🍕", unicode: http.StatusBadRequest, ascii: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		receipt := models.Receipt{
+			Retailer:     tc.retailer,
+			PurchaseDate: testDate,
+			PurchaseTime: testTime,
+			Items:        []models.Item{{ShortDescription: tc.description, Price: "5.99"}},
+			Total:        "5.99",
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			if response := sendPostRequest(t, unicodeHandler.ProcessReceipt, processEndpoint, receipt); response.Code != tc.unicode {
+				t.Errorf("Default policy: expected %d, got %d: %s", tc.unicode, response.Code, response.Body.String())
+			}
+			if response := sendPostRequest(t, asciiHandler.ProcessReceipt, processEndpoint, receipt); response.Code != tc.ascii {
+				t.Errorf("ASCII policy: expected %d, got %d: %s", tc.ascii, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestItemDetails(t *testing.T) {
+	handler := createTestHandler()
+
+	tests := []struct {
+		name     string
+		item     models.Item
+		expected int
+	}{
+		{name: "quantity and unit price", item: models.Item{ShortDescription: "Gatorade", Price: "4.50", Quantity: "2", UnitPrice: "2.25"}, expected: http.StatusOK},
+		{name: "unit price alone", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", UnitPrice: "2.25"}, expected: http.StatusOK},
+		{name: "sold by weight", item: models.Item{ShortDescription: "Bananas", Price: "0.87", Quantity: "1.452", UnitPrice: "0.60"}, expected: http.StatusOK},
+		{name: "product fields", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", SKU: "GAT-32-LL", UPC: "052000338775", Category: "Beverages"}, expected: http.StatusOK},
+		{name: "price does not match", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", Quantity: "2", UnitPrice: "2.25"}, expected: http.StatusBadRequest},
+		{name: "largest quantity", item: models.Item{ShortDescription: "Gatorade", Price: "10.00", Quantity: "1000", UnitPrice: "0.01"}, expected: http.StatusOK},
+		{name: "quantity above the limit", item: models.Item{ShortDescription: "Gatorade", Price: "10.01", Quantity: "1000.001"}, expected: http.StatusBadRequest},
+		{name: "zero quantity", item: models.Item{ShortDescription: "Gatorade", Price: "0.00", Quantity: "0"}, expected: http.StatusBadRequest},
+		{name: "malformed quantity", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", Quantity: "2x"}, expected: http.StatusBadRequest},
+		{name: "bad check digit", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", UPC: "052000338776"}, expected: http.StatusBadRequest},
+		{name: "sku with spaces", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", SKU: "GAT 32"}, expected: http.StatusBadRequest},
+		{name: "category markup", item: models.Item{ShortDescription: "Gatorade", Price: "2.25", Category: "<b>drinks</b>"}, expected: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: testDate,
+				PurchaseTime: testTime,
+				Items:        []models.Item{tc.item},
+				Total:        tc.item.Price,
+			}
+			if response := sendPostRequest(t, handler.ProcessReceipt, processEndpoint, receipt); response.Code != tc.expected {
+				t.Errorf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestReceiptAmounts(t *testing.T) {
+	handler := createTestHandler()
+
+	tests := []struct {
+		name     string
+		modify   func(*models.Receipt)
+		expected int
+	}{
+		{name: "no amounts", modify: func(r *models.Receipt) {}, expected: http.StatusOK},
+		{name: "reconciled", modify: func(r *models.Receipt) {
+			r.Subtotal, r.Tax, r.Tip, r.Total = "10.00", "0.80", "2.00", "11.80"
+			r.Discounts = []models.Discount{{Description: "Coupon", Amount: "1.00"}}
+		}, expected: http.StatusOK},
+		{name: "tax without subtotal", modify: func(r *models.Receipt) { r.Tax, r.Total = "0.80", "10.80" }, expected: http.StatusOK},
+		{name: "store card", modify: func(r *models.Receipt) {
+			r.Payment = &models.Payment{Method: models.PaymentStoreCard, Last4: "4242"}
+		}, expected: http.StatusOK},
+		{name: "does not reconcile", modify: func(r *models.Receipt) { r.Subtotal, r.Tax = "10.00", "0.80" }, expected: http.StatusBadRequest},
+		{name: "discount above subtotal", modify: func(r *models.Receipt) {
+			r.Subtotal, r.Total = "10.00", "0.00"
+			r.Discounts = []models.Discount{{Description: "Coupon", Amount: "11.00"}}
+		}, expected: http.StatusBadRequest},
+		{name: "discount without description", modify: func(r *models.Receipt) {
+			r.Discounts = []models.Discount{{Amount: "1.00"}}
+		}, expected: http.StatusBadRequest},
+		{name: "zero discount", modify: func(r *models.Receipt) {
+			r.Discounts = []models.Discount{{Description: "Coupon", Amount: "0.00"}}
+		}, expected: http.StatusBadRequest},
+		{name: "malformed tax", modify: func(r *models.Receipt) { r.Tax = "0.8" }, expected: http.StatusBadRequest},
+		{name: "unknown payment method", modify: func(r *models.Receipt) {
+			r.Payment = &models.Payment{Method: "barter"}
+		}, expected: http.StatusBadRequest},
+		{name: "full card number", modify: func(r *models.Receipt) {
+			r.Payment = &models.Payment{Method: models.PaymentCreditCard, Last4: "4242424242424242"}
+		}, expected: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: testDate,
+				PurchaseTime: testTime,
+				Items:        []models.Item{{ShortDescription: "Pepsi", Price: "10.00"}},
+				Total:        "10.00",
+			}
+			tc.modify(&receipt)
+			if response := sendPostRequest(t, handler.ProcessReceipt, processEndpoint, receipt); response.Code != tc.expected {
+				t.Errorf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetReceipt(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob"}, "bob-key")
+	r := mux.NewRouter()
+	SetupRoutes(r, newTestReceiptService(), WithAuthenticator(NewAPIKeyAuthenticator(clients)))
+
+	body, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "10.00", Quantity: "4", UnitPrice: "2.50"}},
+		Subtotal:     "10.00",
+		Tax:          "0.80",
+		Total:        "10.80",
+		Payment:      &models.Payment{Method: models.PaymentStoreCard, Last4: "4242"},
+	})
+	response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", body, nil)
+	var created models.ReceiptResponse
+	json.Unmarshal(response.Body.Bytes(), &created)
+
+	t.Run("owner", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/receipts/"+created.ID, "alice-key", nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		var detail models.ReceiptDetail
+		json.Unmarshal(response.Body.Bytes(), &detail)
+		if detail.ID != created.ID || detail.Status != models.StatusActive || detail.Points == 0 {
+			t.Errorf("Unexpected receipt %+v", detail)
+		}
+		receipt := detail.Receipt
+		if receipt.Tax != "0.80" || receipt.Subtotal != "10.00" || receipt.Payment == nil || receipt.Payment.Method != models.PaymentStoreCard {
+			t.Errorf("Amounts not returned, got %+v", receipt)
+		}
+		// returned as normalized during validation
+		if receipt.PurchaseTime != "13:01:00" || receipt.Items[0].Quantity != "4" {
+			t.Errorf("Unexpected stored receipt %+v", receipt)
+		}
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/receipts/"+created.ID, "bob-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("unknown receipt", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/receipts/unknown", "alice-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+}
+
+func TestListReceipts(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob"}, "bob-key")
+	clients.RegisterClient(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+	// pages are in submission order, so receipts need distinct times
+	clock := utils.NewFakeClock(testClock.Now())
+	r := mux.NewRouter()
+	SetupRoutes(r, newTestReceiptService(services.WithClock(clock)), WithAuthenticator(NewAPIKeyAuthenticator(clients)))
+
+	submit := func(apiKey, retailer string) {
+		clock.Advance(time.Minute)
+		body, _ := json.Marshal(models.Receipt{
+			Retailer:     retailer,
+			PurchaseDate: testDate,
+			PurchaseTime: testTime,
+			Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+			Total:        "1.25",
+		})
+		if response := authRequest(r, http.MethodPost, processEndpoint, apiKey, body, nil); response.Code != http.StatusOK {
+			t.Fatalf("Failed to submit receipt: %d", response.Code)
+		}
+	}
+	submit("alice-key", "Target")
+	submit("alice-key", "Walgreens")
+	submit("alice-key", "Walmart")
+	submit("bob-key", "Costco")
+
+	list := func(apiKey, query string) (int, models.ReceiptList) {
+		response := authRequest(r, http.MethodGet, "/receipts"+query, apiKey, nil, nil)
+		var page models.ReceiptList
+		json.Unmarshal(response.Body.Bytes(), &page)
+		return response.Code, page
+	}
+
+	tests := []struct {
+		name      string
+		apiKey    string
+		query     string
+		status    int
+		total     int
+		retailers []string
+	}{
+		{name: "own receipts", apiKey: "alice-key", status: http.StatusOK, total: 3, retailers: []string{"Target", "Walgreens", "Walmart"}},
+		{name: "first page", apiKey: "alice-key", query: "?limit=2", status: http.StatusOK, total: 3, retailers: []string{"Target", "Walgreens"}},
+		{name: "second page", apiKey: "alice-key", query: "?limit=2&offset=2", status: http.StatusOK, total: 3, retailers: []string{"Walmart"}},
+		{name: "admin sees everyone", apiKey: "ops-key", query: "?offset=3", status: http.StatusOK, total: 4, retailers: []string{"Costco"}},
+		{name: "by status", apiKey: "alice-key", query: "?status=pending_review", status: http.StatusOK, total: 0, retailers: []string{}},
+		{name: "limit too large", apiKey: "alice-key", query: "?limit=201", status: http.StatusBadRequest},
+		{name: "negative offset", apiKey: "alice-key", query: "?offset=-1", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, page := list(tc.apiKey, tc.query)
+			if code != tc.status {
+				t.Fatalf("Should get %d but got %d", tc.status, code)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			retailers := []string{}
+			for _, receipt := range page.Receipts {
+				retailers = append(retailers, receipt.Retailer)
+			}
+			if page.Total != tc.total || !reflect.DeepEqual(retailers, tc.retailers) {
+				t.Errorf("Expected %v of %d, got %v of %d", tc.retailers, tc.total, retailers, page.Total)
+			}
+		})
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	handler := createTestHandler()
+	text := "TARGET\n01/01/2022 1:01 PM\nMOUNTAIN DEW 12PK    6.49\nEMILS CHEESE PIZZA   12.25\nTOTAL               18.74\nVISA ************4242\n"
+
+	parse := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/receipts/parse"+query, strings.NewReader(body))
+		req.Header.Set(contentTypeHeader, "text/plain")
+		recorder := httptest.NewRecorder()
+		handler.ParseReceipt(recorder, req)
+		return recorder
+	}
+
+	t.Run("draft only", func(t *testing.T) {
+		response := parse("", text)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		var parsed models.ParseResponse
+		json.Unmarshal(response.Body.Bytes(), &parsed)
+		receipt := parsed.Draft.Receipt
+		if parsed.ID != "" || receipt.Retailer != "TARGET" || receipt.PurchaseTime != "13:01" || len(receipt.Items) != 2 || receipt.Total != "18.74" {
+			t.Errorf("Unexpected draft %+v", parsed)
+		}
+	})
+
+	t.Run("parse and process", func(t *testing.T) {
+		response := parse("?process=true", text)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		var parsed models.ParseResponse
+		json.Unmarshal(response.Body.Bytes(), &parsed)
+		if _, err := uuid.Parse(parsed.ID); err != nil {
+			t.Fatalf("Expected a receipt ID, got %q", parsed.ID)
+		}
+		// the draft is returned as parsed, not as normalized
+		if parsed.Draft.Receipt.PurchaseTime != "13:01" {
+			t.Errorf("Draft was normalized: %+v", parsed.Draft.Receipt)
+		}
+	})
+
+	t.Run("invalid draft", func(t *testing.T) {
+		response := parse("?process=true", "TARGET\nMOUNTAIN DEW 12PK 6.49\nTOTAL 6.49\n")
+		if response.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Should get 422 but got %d", response.Code)
+		}
+		var parsed models.ParseResponse
+		json.Unmarshal(response.Body.Bytes(), &parsed)
+		if parsed.Error == "" || parsed.ID != "" || len(parsed.Draft.Warnings) == 0 {
+			t.Errorf("Expected the error with the draft, got %+v", parsed)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if response := parse("", " \n"); response.Code != http.StatusBadRequest {
+			t.Errorf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		if response := parse("", strings.Repeat("GUM 1.00\n", maxParseBytes/9+1)); response.Code != http.StatusBadRequest {
+			t.Errorf("Should get 400 but got %d", response.Code)
+		}
+	})
 }
 
 func TestGetPoints(t *testing.T) {
-	storage := repository.NewInMemoryStorage()
-	service := services.NewReceiptService(storage)
-	handler := NewReceiptHandler(service)
+	handler := NewReceiptHandler(newTestReceiptService())
 
 	receipt := models.Receipt{
 		Retailer:     "Shop",
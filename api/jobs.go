@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+const headerLocation = "Location"
+
+// jobRetryAfter is the Retry-After, in seconds, sent when the job queue
+// cannot take a receipt
+const jobRetryAfter = "1"
+
+// JobHandler serves the status of receipts processed asynchronously
+type JobHandler struct {
+	jobs *services.JobService
+}
+
+func NewJobHandler(jobs *services.JobService) *JobHandler {
+	return &JobHandler{jobs: jobs}
+}
+
+// GetJob handles GET /jobs/{id}
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, found := h.jobs.GetJob(principalFromContext(r.Context()), mux.Vars(r)["id"])
+	if !found {
+		writeError(w, http.StatusNotFound, "No job found for that ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// submitJob answers POST /receipts/process?async=true, queueing a
+// validated receipt and pointing the caller at its job
+func (h *ReceiptHandler) submitJob(w http.ResponseWriter, r *http.Request, receipt models.Receipt) {
+	job, err := h.jobs.Submit(principalFromContext(r.Context()), receipt)
+	switch {
+	case errors.Is(err, services.ErrJobQueueFull), errors.Is(err, services.ErrJobsStopped):
+		utils.Logger.WithError(err).Warn("Receipt job refused")
+		w.Header().Set(headerRetryAfter, jobRetryAfter)
+		writeError(w, http.StatusServiceUnavailable, err.Error()+", retry later")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).Error("Failed to queue receipt")
+		writeError(w, http.StatusInternalServerError, "Server error processing receipt")
+		return
+	}
+
+	utils.Logger.WithField("job", job.ID).Info("Receipt queued for processing")
+	w.Header().Set(headerLocation, "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
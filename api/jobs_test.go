@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+func TestAsyncProcessing(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob"}, "bob-key")
+
+	receipts := newTestReceiptService()
+	jobs := services.NewJobService(repository.NewInMemoryJobStorage(), receipts, services.JobConfig{Workers: 2}, testClock)
+	jobs.Start()
+	defer jobs.Stop()
+
+	r := mux.NewRouter()
+	SetupRoutes(r, receipts, WithAuthenticator(NewAPIKeyAuthenticator(clients)), WithJobs(jobs))
+
+	receipt, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: testTime,
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	})
+
+	var location string
+	t.Run("accepted", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint+"?async=true", "alice-key", receipt, nil)
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("Should get 202 but got %d: %s", response.Code, response.Body.String())
+		}
+		var job models.Job
+		json.Unmarshal(response.Body.Bytes(), &job)
+		location = response.Header().Get("Location")
+		if job.Status != models.JobPending || location != "/jobs/"+job.ID {
+			t.Errorf("Expected a pending job at its Location, got %+v at %q", job, location)
+		}
+	})
+
+	t.Run("poll until done", func(t *testing.T) {
+		var job models.Job
+		deadline := time.Now().Add(5 * time.Second)
+		for job.Status != models.JobDone && time.Now().Before(deadline) {
+			response := authRequest(r, http.MethodGet, location, "alice-key", nil, nil)
+			if response.Code != http.StatusOK {
+				t.Fatalf("Should get 200 but got %d", response.Code)
+			}
+			json.Unmarshal(response.Body.Bytes(), &job)
+			time.Sleep(5 * time.Millisecond)
+		}
+		if job.Status != models.JobDone || job.Points == nil {
+			t.Fatalf("Expected the job to finish, got %+v", job)
+		}
+
+		response := authRequest(r, http.MethodGet, "/receipts/"+job.ReceiptID+"/points", "alice-key", nil, nil)
+		var points models.PointsResponse
+		json.Unmarshal(response.Body.Bytes(), &points)
+		if points.Points != *job.Points {
+			t.Errorf("Job has %d points, the receipt %d", *job.Points, points.Points)
+		}
+	})
+
+	t.Run("other clients cannot poll", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, location, "bob-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("invalid receipts are rejected at once", func(t *testing.T) {
+		invalid, _ := json.Marshal(models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2099-01-01",
+			PurchaseTime: testTime,
+			Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+			Total:        "6.49",
+		})
+		response := authRequest(r, http.MethodPost, processEndpoint+"?async=true", "alice-key", invalid, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("async=false waits", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint+"?async=false", "alice-key", receipt, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+	})
+}
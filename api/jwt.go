@@ -0,0 +1,244 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+const defaultScopeClaim = "scope"
+
+// JWTOptions configures bearer token validation. At least one of Secret
+// (HS256/384/512) or Keys (RS*/ES*/PS*, by kid) must be set.
+type JWTOptions struct {
+	Secret   []byte
+	Keys     map[string]crypto.PublicKey
+	Issuer   string
+	Audience string
+	// ScopeClaim names the claim holding scopes, either a space separated
+	// string or an array. Defaults to "scope".
+	ScopeClaim string
+	// ScopeMap translates claim values issued by our tools into API scopes.
+	// Values that are not mapped are passed through unchanged.
+	ScopeMap map[string]string
+	// Clock checks exp/nbf/iat, defaults to the system clock
+	Clock utils.Clock
+}
+
+// JWTAuthenticator accepts `Authorization: Bearer <jwt>` tokens issued by
+// our internal tools
+type JWTAuthenticator struct {
+	opts   JWTOptions
+	parser *jwt.Parser
+}
+
+func NewJWTAuthenticator(opts JWTOptions) (*JWTAuthenticator, error) {
+	if len(opts.Secret) == 0 && len(opts.Keys) == 0 {
+		return nil, errors.New("JWT authentication needs a shared secret or a JWKS")
+	}
+	if opts.ScopeClaim == "" {
+		opts.ScopeClaim = defaultScopeClaim
+	}
+
+	// only accept algorithms we hold keys for, so an RS256 public key can
+	// never be abused as an HMAC secret
+	var methods []string
+	if len(opts.Secret) > 0 {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if len(opts.Keys) > 0 {
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = utils.SystemClock{}
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(opts.Clock.Now),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+
+	return &JWTAuthenticator{
+		opts:   opts,
+		parser: jwt.NewParser(parserOpts...),
+	}, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (models.Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return models.Principal{}, ErrNoCredentials
+	}
+	raw, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return models.Principal{}, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(strings.TrimSpace(raw), claims, a.key); err != nil {
+		return models.Principal{}, fmt.Errorf("Invalid bearer token: %w", err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return models.Principal{}, errors.New("Invalid bearer token: missing subject")
+	}
+
+	return models.Principal{ClientID: models.PrincipalJWTPrefix + subject, Scopes: a.scopes(claims)}, nil
+}
+
+// key picks the verification key for a token based on its algorithm and kid
+func (a *JWTAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.opts.Secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, found := a.opts.Keys[kid]; found {
+		return key, nil
+	}
+	// a JWKS with a single key does not need tokens to name it
+	if kid == "" && len(a.opts.Keys) == 1 {
+		for _, key := range a.opts.Keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// scopes reads the scope claim and maps it onto API scopes
+func (a *JWTAuthenticator) scopes(claims jwt.MapClaims) []string {
+	var values []string
+	switch v := claims[a.opts.ScopeClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	scopes := make([]string, 0, len(values))
+	for _, value := range values {
+		if mapped, found := a.opts.ScopeMap[value]; found {
+			value = mapped
+		}
+		scopes = append(scopes, value)
+	}
+	return scopes
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads the public signing keys from a local JWKS file, keyed by kid.
+// Only RSA and EC signature keys are supported.
+func LoadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS %s: %w", path, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in %s: %w", jwk.Kid, path, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys in %s", path)
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+const jwtSecret = "internal-tools-secret"
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+func createJWTRouter(t *testing.T, rsaKey *rsa.PrivateKey) *mux.Router {
+	keys, err := LoadJWKS(writeJWKS(t, "tools-1", &rsaKey.PublicKey))
+	if err != nil {
+		t.Fatalf("Failed to load JWKS: %v", err)
+	}
+	jwtAuth, err := NewJWTAuthenticator(JWTOptions{
+		Secret:   []byte(jwtSecret),
+		Keys:     keys,
+		Issuer:   "tools",
+		ScopeMap: map[string]string{"ops": models.ScopeAdmin},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create JWT authenticator: %v", err)
+	}
+
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(),
+		WithAuthenticator(ChainAuthenticators(NewAPIKeyAuthenticator(clients), jwtAuth)),
+	)
+	return r
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	r := createJWTRouter(t, rsaKey)
+
+	receipt, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: testTime,
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+		Total:        "1.25",
+	})
+
+	claims := func(scope interface{}, expires time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":   "crm-sync",
+			"iss":   "tools",
+			"exp":   time.Now().Add(expires).Unix(),
+			"scope": scope,
+		}
+	}
+	hs256 := func(c jwt.MapClaims) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(jwtSecret))
+		return token
+	}
+	rs256 := func(c jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+		token.Header["kid"] = "tools-1"
+		signed, _ := token.SignedString(rsaKey)
+		return signed
+	}
+	wrongIssuer := claims("receipts:write", time.Hour)
+	wrongIssuer["iss"] = "someone-else"
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{name: "shared secret with write scope", token: hs256(claims("receipts:write", time.Hour)), expected: http.StatusOK},
+		{name: "jwks key with scope array", token: rs256(claims([]string{"receipts:read", "receipts:write"}, time.Hour)), expected: http.StatusOK},
+		{name: "mapped admin claim", token: rs256(claims("ops", time.Hour)), expected: http.StatusOK},
+		{name: "read scope only", token: hs256(claims("receipts:read", time.Hour)), expected: http.StatusForbidden},
+		{name: "expired token", token: hs256(claims("receipts:write", -time.Minute)), expected: http.StatusUnauthorized},
+		{name: "wrong issuer", token: hs256(wrongIssuer), expected: http.StatusUnauthorized},
+		{name: "wrong secret", token: func() string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims("receipts:write", time.Hour)).SignedString([]byte("guess"))
+			return token
+		}(), expected: http.StatusUnauthorized},
+		{name: "garbage", token: "not-a-jwt", expected: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			response := authRequest(r, http.MethodPost, processEndpoint, "", receipt,
+				map[string]string{"Authorization": "Bearer " + tc.token})
+			if response.Code != tc.expected {
+				t.Fatalf("Expected %d, got %d: %s", tc.expected, response.Code, response.Body.String())
+			}
+		})
+	}
+
+	t.Run("api keys still accepted", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+	})
+
+	t.Run("token subject named like a client", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil)
+		var created models.ReceiptResponse
+		json.Unmarshal(response.Body.Bytes(), &created)
+
+		impostor := claims("receipts:read", time.Hour)
+		impostor["sub"] = "alice"
+		response = authRequest(r, http.MethodGet, "/receipts/"+created.ID+"/points", "", nil,
+			map[string]string{"Authorization": "Bearer " + hs256(impostor)})
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+}
+
+func TestNewJWTAuthenticatorNeedsKeys(t *testing.T) {
+	if _, err := NewJWTAuthenticator(JWTOptions{}); err == nil {
+		t.Error("Expected error without secret or keys")
+	}
+}
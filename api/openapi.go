@@ -0,0 +1,230 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// openAPISpec is the API contract, compiled into the binary so the served
+// document always matches the handlers it was built with.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8" />
+  <title>Receipt Processor API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// the stock text/csv decoder rejects the whole body over one short row,
+// CSV imports report bad rows themselves so the spec only sees text
+func init() {
+	openapi3filter.RegisterBodyDecoder("text/csv", func(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (any, error) {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err}
+		}
+		return string(data), nil
+	})
+}
+
+// LoadOpenAPISpec parses and validates the embedded OpenAPI document
+func LoadOpenAPISpec() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ServeOpenAPISpec handles GET /openapi.json
+func ServeOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerContentType, contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// ServeSwaggerUI handles GET /docs with a Swagger UI page for the spec
+func ServeSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUIPage))
+}
+
+// maxValidatedBody is the largest response body kept for validation,
+// larger responses are passed through unchecked
+const maxValidatedBody = 1 << 20
+
+// SpecValidator checks requests and responses against the OpenAPI document.
+// Requests that break the contract are rejected with 400 before reaching a
+// handler. Responses are only checked when ValidateResponses is set, as it
+// copies each body, and those that break it are logged, since the client
+// has already been served by then.
+type SpecValidator struct {
+	router routers.Router
+	// ValidateResponses checks JSON responses up to maxValidatedBody, for
+	// development and tests
+	ValidateResponses bool
+}
+
+// NewSpecValidator builds a validator for the given document
+func NewSpecValidator(doc *openapi3.T) (*SpecValidator, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &SpecValidator{router: router}, nil
+}
+
+// Middleware returns a mux middleware that validates each request/response pair
+func (v *SpecValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := v.router.FindRoute(r)
+		if err != nil {
+			// not part of the documented API (spec, docs page)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    validationOptions(),
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			utils.Logger.WithError(err).Warn("Request does not match API specification")
+			writeError(w, http.StatusBadRequest, "Invalid request: "+specErrorReason(err))
+			return
+		}
+
+		if !v.ValidateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.skipped {
+			return
+		}
+
+		if err := validateResponse(r.Context(), input, recorder.status, recorder.Header(), recorder.body.Bytes()); err != nil {
+			utils.Logger.WithError(err).WithFields(logrus.Fields{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"status": recorder.status,
+			}).Error("Response does not match API specification")
+		}
+	})
+}
+
+func validationOptions() *openapi3filter.Options {
+	return &openapi3filter.Options{
+		IncludeResponseStatus: true,
+		MultiError:            false,
+		// credentials are checked by authMiddleware before validation runs
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+}
+
+func validateResponse(ctx context.Context, input *openapi3filter.RequestValidationInput, status int, header http.Header, body []byte) error {
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 status,
+		Header:                 header,
+		Options:                input.Options,
+	}
+	responseInput.SetBodyBytes(body)
+	return openapi3filter.ValidateResponse(ctx, responseInput)
+}
+
+// specErrorReason strips the schema dump kin-openapi appends to its errors
+func specErrorReason(err error) string {
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(requestErr.Err, &schemaErr) {
+			return schemaErr.Reason
+		}
+		if requestErr.Reason != "" {
+			return requestErr.Reason
+		}
+	}
+	return err.Error()
+}
+
+// responseRecorder passes the response through while keeping a copy of the
+// status and body for validation. A body that is not JSON or outgrows
+// maxValidatedBody is not kept, and the response is not validated.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	skipped bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	switch {
+	case rr.skipped:
+	case rr.body.Len() == 0 && !isJSON(rr.Header().Get(headerContentType)),
+		rr.body.Len()+len(b) > maxValidatedBody:
+		rr.skipped = true
+		rr.body = bytes.Buffer{}
+	default:
+		rr.body.Write(b)
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+// Flush sends what was written so far, so streamed responses still stream
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json"))
+}
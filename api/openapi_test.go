@@ -0,0 +1,598 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+// contractServer wires the real routes and checks every response against
+// the embedded spec, failing the test on any mismatch
+type contractServer struct {
+	t         *testing.T
+	router    *mux.Router
+	validator *SpecValidator
+	events    *services.EventBus
+}
+
+func newContractServer(t *testing.T) *contractServer {
+	spec, err := LoadOpenAPISpec()
+	if err != nil {
+		t.Fatalf("Embedded spec does not load: %v", err)
+	}
+	validator, err := NewSpecValidator(spec)
+	if err != nil {
+		t.Fatalf("Failed to build spec router: %v", err)
+	}
+
+	// the webhook workers, the event bus and the job workers are not
+	// started, so deliveries and jobs stay pending
+	webhooks := services.NewWebhookService(repository.NewInMemoryWebhookStorage(), services.WebhookConfig{}, testClock)
+	storage, events := newTestEventBus(webhooks.HandleEvent)
+	receipts := services.NewReceiptService(storage, services.WithClock(testClock), services.WithEventBus(events))
+	jobs := services.NewJobService(repository.NewInMemoryJobStorage(), receipts, services.JobConfig{QueueSize: 1}, testClock)
+	r := mux.NewRouter()
+	SetupRoutes(r, receipts,
+		WithAuthenticator(operatorAuthenticator{}),
+		WithRetailers(services.NewRetailerService(repository.NewInMemoryRetailerStorage())),
+		WithPromotions(services.NewPromotionService(repository.NewInMemoryPromotionStorage())),
+		WithWebhooks(webhooks),
+		WithJobs(jobs),
+		WithResponseValidation())
+
+	return &contractServer{t: t, router: r, validator: validator, events: events}
+}
+
+// operatorAuthenticator lets every request in as an admin, so the
+// contract tests reach the admin routes without credentials
+type operatorAuthenticator struct{}
+
+func (operatorAuthenticator) Authenticate(r *http.Request) (models.Principal, error) {
+	return models.Principal{ClientID: "ops", Scopes: []string{models.ScopeAdmin}}, nil
+}
+
+func (cs *contractServer) do(method, path string, body []byte) *httptest.ResponseRecorder {
+	cs.t.Helper()
+	return cs.send(method, path, jsonContentType, body)
+}
+
+// send is do with a request body that is not JSON
+func (cs *contractServer) send(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	cs.t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if body != nil {
+		req.Header.Set(contentTypeHeader, contentType)
+	}
+	recorder := httptest.NewRecorder()
+	cs.router.ServeHTTP(recorder, req)
+	// publish the request's events the way a running bus would
+	cs.events.Flush()
+
+	// validate the response against the spec with a fresh copy of the request
+	checkReq := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if body != nil {
+		checkReq.Header.Set(contentTypeHeader, contentType)
+	}
+	route, pathParams, err := cs.validator.router.FindRoute(checkReq)
+	if err != nil {
+		cs.t.Fatalf("%s %s is not documented in the spec: %v", method, path, err)
+	}
+	input := &openapi3filter.RequestValidationInput{
+		Request:    checkReq,
+		PathParams: pathParams,
+		Route:      route,
+		Options:    validationOptions(),
+	}
+	if err := validateResponse(checkReq.Context(), input, recorder.Code, recorder.Header(), recorder.Body.Bytes()); err != nil {
+		cs.t.Errorf("%s %s returned %d that does not match the spec: %v", method, path, recorder.Code, err)
+	}
+	return recorder
+}
+
+func TestHandlersConformToSpec(t *testing.T) {
+	cs := newContractServer(t)
+
+	validReceipt, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: testTime,
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+		},
+		Total: "6.49",
+	})
+
+	var id string
+	t.Run("process valid receipt", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, validReceipt)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+		var respData models.ReceiptResponse
+		json.Unmarshal(response.Body.Bytes(), &respData)
+		id = respData.ID
+	})
+
+	t.Run("process receipt with purchasedAt", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Target","purchasedAt":"2022-01-01T13:01:00-06:00","items":[{"shortDescription":"Pepsi","price":"1.25"}],"total":"1.25"}`))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("process receipt without purchase time", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Target","purchaseDate":"2022-01-01","items":[{"shortDescription":"Pepsi","price":"1.25"}],"total":"1.25"}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("process receipt with item quantities", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Gatorade","price":"4.50","quantity":"2","unitPrice":"2.25","sku":"GAT-32","upc":"052000338775","category":"beverages"}],"total":"4.50"}`))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("process receipt with mismatched unit price", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Gatorade","price":"4.00","quantity":"2","unitPrice":"2.25"}],"total":"4.00"}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("process receipt with tax and payment", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Pepsi","price":"10.00"}],"subtotal":"10.00","discounts":[{"description":"Coupon","amount":"1.00"}],"tax":"0.72","tip":"1.00","payment":{"method":"store_card","last4":"4242"},"total":"10.72"}`))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("process receipt with unknown payment method", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Pepsi","price":"10.00"}],"payment":{"method":"barter"},"total":"10.00"}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("process invalid json", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("process receipt breaking the schema", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint, []byte(`{"retailer":"Shop","items":[]}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get points", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/"+id+"/points", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("get points with breakdown", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/"+id+"/points?breakdown=true", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	var jobID string
+	t.Run("process async", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint+"?async=true", validReceipt)
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("Should get 202 Accepted but got %d", response.Code)
+		}
+		var job models.Job
+		json.Unmarshal(response.Body.Bytes(), &job)
+		jobID = job.ID
+	})
+
+	t.Run("process async with a full queue", func(t *testing.T) {
+		response := cs.do(http.MethodPost, processEndpoint+"?async=true", validReceipt)
+		if response.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Should get 503 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get job", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/jobs/"+jobID, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("get unknown job", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/jobs/unknown", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get receipt", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/"+id, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("get unknown receipt", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/non-exist-id", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get points for unknown receipt", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/non-exist-id/points", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("review queue", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/reviews", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("approve receipt that is not held", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/reviews/"+id+"/approve", nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create retailer", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/retailers", []byte(`{"id":"target","displayName":"Target","aliases":["Target Store"]}`))
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Should get 201 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create retailer with bad id", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/retailers", []byte(`{"id":"Not Valid","displayName":"Target"}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("list retailers", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/retailers", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("update retailer", func(t *testing.T) {
+		response := cs.do(http.MethodPut, "/admin/retailers/target", []byte(`{"displayName":"Target","timeZone":"America/Chicago"}`))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("delete retailer", func(t *testing.T) {
+		response := cs.do(http.MethodDelete, "/admin/retailers/target", nil)
+		if response.Code != http.StatusNoContent {
+			t.Fatalf("Should get 204 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get deleted retailer", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/retailers/target", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create promotion", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/promotions", []byte(`{"id":"double","name":"Double points","startsAt":"2022-01-01T00:00:00Z","endsAt":"2022-02-01T00:00:00Z","multiplier":2}`))
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Should get 201 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create promotion with multiplier and bonus", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/promotions", []byte(`{"id":"both","name":"Both","startsAt":"2022-01-01T00:00:00Z","endsAt":"2022-02-01T00:00:00Z","multiplier":2,"bonus":10}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("list promotions", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/promotions", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("update promotion", func(t *testing.T) {
+		response := cs.do(http.MethodPut, "/admin/promotions/double", []byte(`{"name":"Gatorade bonus","itemPattern":"(?i)gatorade","startsAt":"2022-01-01T00:00:00Z","endsAt":"2022-02-01T00:00:00Z","bonus":10,"perItem":true}`))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("delete promotion", func(t *testing.T) {
+		response := cs.do(http.MethodDelete, "/admin/promotions/double", nil)
+		if response.Code != http.StatusNoContent {
+			t.Fatalf("Should get 204 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get deleted promotion", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/promotions/double", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create webhook", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/webhooks", []byte(`{"id":"crm","url":"https://crm.example.com/hooks","events":["receipt.processed","receipt.approved"],"secret":"0123456789abcdef"}`))
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Should get 201 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create webhook with unknown event", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/webhooks", []byte(`{"id":"other","url":"https://crm.example.com/hooks","events":["receipt.lost"],"secret":"0123456789abcdef"}`))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("list webhooks", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/webhooks", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("update webhook", func(t *testing.T) {
+		response := cs.do(http.MethodPut, "/admin/webhooks/crm", []byte(`{"url":"https://crm.example.com/v2/hooks","events":["receipt.processed"],"secret":"fedcba9876543210"}`))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("webhook deliveries", func(t *testing.T) {
+		cs.do(http.MethodPost, processEndpoint, validReceipt)
+		response := cs.do(http.MethodGet, "/admin/webhooks/crm/deliveries", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+		var deliveries []models.WebhookDelivery
+		json.Unmarshal(response.Body.Bytes(), &deliveries)
+		if len(deliveries) != 1 {
+			t.Fatalf("Expected one delivery, got %+v", deliveries)
+		}
+
+		response = cs.do(http.MethodPost, "/admin/webhooks/deliveries/"+deliveries[0].ID+"/retry", nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("dead letters", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/webhooks/dead-letters", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("delivery pages", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/webhooks/crm/deliveries?limit=1", nil)
+		var deliveries []models.WebhookDelivery
+		json.Unmarshal(response.Body.Bytes(), &deliveries)
+		if response.Code != http.StatusOK || len(deliveries) != 1 {
+			t.Fatalf("Expected a page of one delivery, got %d %s", response.Code, response.Body.String())
+		}
+		response = cs.do(http.MethodGet, "/admin/webhooks/crm/deliveries?limit=1&after="+deliveries[0].ID, nil)
+		if response.Code != http.StatusOK || strings.TrimSpace(response.Body.String()) != "[]" {
+			t.Errorf("Expected an empty page after the last delivery, got %d %s", response.Code, response.Body.String())
+		}
+		if response := cs.do(http.MethodGet, "/admin/webhooks/dead-letters?after=unknown", nil); response.Code != http.StatusBadRequest {
+			t.Errorf("Should get 400 for an unknown cursor but got %d", response.Code)
+		}
+		if response := cs.do(http.MethodGet, "/admin/webhooks/dead-letters?limit=0", nil); response.Code != http.StatusBadRequest {
+			t.Errorf("Should get 400 for a zero limit but got %d", response.Code)
+		}
+	})
+
+	t.Run("retry unknown delivery", func(t *testing.T) {
+		response := cs.do(http.MethodPost, "/admin/webhooks/deliveries/unknown/retry", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("delete webhook", func(t *testing.T) {
+		response := cs.do(http.MethodDelete, "/admin/webhooks/crm", nil)
+		if response.Code != http.StatusNoContent {
+			t.Fatalf("Should get 204 but got %d", response.Code)
+		}
+	})
+
+	t.Run("get deleted webhook", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/webhooks/crm", nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("parse receipt text", func(t *testing.T) {
+		response := cs.send(http.MethodPost, "/receipts/parse", "text/plain", []byte("TARGET\n01/01/2022 13:01\nMOUNTAIN DEW 12PK 6.49\nTOTAL 6.49\n"))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("parse and process receipt text", func(t *testing.T) {
+		response := cs.send(http.MethodPost, "/receipts/parse?process=true", "text/plain", []byte("TARGET\n01/01/2022 13:01\nMOUNTAIN DEW 12PK 6.49\nTOTAL 6.49\n"))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("parse text that is not a valid receipt", func(t *testing.T) {
+		response := cs.send(http.MethodPost, "/receipts/parse?process=true", "text/plain", []byte("hello\n"))
+		if response.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Should get 422 but got %d", response.Code)
+		}
+	})
+
+	t.Run("parse empty text", func(t *testing.T) {
+		response := cs.send(http.MethodPost, "/receipts/parse", "text/plain", []byte("\n"))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("import receipts", func(t *testing.T) {
+		response := cs.send(http.MethodPost, "/receipts/import", "text/csv", []byte("key,retailer,purchaseDate,purchaseTime,total,shortDescription,price\nA1,Target,2022-01-01,13:01,6.49,Mountain Dew 12PK,6.49\nA2,Target,2022-01-01,,1.25,Pepsi,1.25\n"))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("import without the required columns", func(t *testing.T) {
+		response := cs.send(http.MethodPost, "/receipts/import", "text/csv", []byte("key,retailer\nA1,Target\n"))
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("list receipts", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts?status=active&limit=10", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("list receipts with a bad limit", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts?limit=0", nil)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("export receipts", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/export?format=csv", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+
+	t.Run("export in an unknown format", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/receipts/export?format=xlsx", nil)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("health", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/health", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", response.Code)
+		}
+	})
+}
+
+func TestServeOpenAPISpec(t *testing.T) {
+	r := mux.NewRouter()
+	SetupRoutes(r, newTestReceiptService())
+
+	t.Run("spec document", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", recorder.Code)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("Spec is not valid JSON: %v", err)
+		}
+		if doc["openapi"] != "3.0.3" {
+			t.Errorf("Unexpected openapi version %v", doc["openapi"])
+		}
+	})
+
+	t.Run("swagger ui", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Should get 200 OK but got %d", recorder.Code)
+		}
+		if !strings.Contains(recorder.Body.String(), "/openapi.json") {
+			t.Error("Docs page does not point at the spec")
+		}
+	})
+}
+
+func TestResponseRecorder(t *testing.T) {
+	t.Run("keeps a JSON body", func(t *testing.T) {
+		recorder := &responseRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+		recorder.Header().Set(headerContentType, contentTypeJSON)
+		recorder.Write([]byte(`{"points":`))
+		recorder.Write([]byte(`32}`))
+		if recorder.skipped || recorder.body.String() != `{"points":32}` {
+			t.Errorf("Expected the body kept, got skipped=%v %q", recorder.skipped, recorder.body.String())
+		}
+	})
+
+	t.Run("skips a body that is not JSON", func(t *testing.T) {
+		recorder := &responseRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+		recorder.Header().Set(headerContentType, "text/csv")
+		recorder.Write([]byte("id,points\n"))
+		if !recorder.skipped || recorder.body.Len() != 0 {
+			t.Errorf("Expected a CSV body to be skipped, got %q", recorder.body.String())
+		}
+	})
+
+	t.Run("skips a large body", func(t *testing.T) {
+		underlying := httptest.NewRecorder()
+		recorder := &responseRecorder{ResponseWriter: underlying, status: http.StatusOK}
+		recorder.Header().Set(headerContentType, contentTypeJSON)
+		chunk := bytes.Repeat([]byte(" "), maxValidatedBody/2+1)
+		recorder.Write(chunk)
+		recorder.Write(chunk)
+		if !recorder.skipped || recorder.body.Len() != 0 {
+			t.Errorf("Expected a body over %d bytes to be skipped", maxValidatedBody)
+		}
+		if underlying.Body.Len() != 2*len(chunk) {
+			t.Errorf("Expected the whole body passed through, got %d bytes", underlying.Body.Len())
+		}
+	})
+
+	t.Run("forwards flushes", func(t *testing.T) {
+		underlying := httptest.NewRecorder()
+		var w http.ResponseWriter = &responseRecorder{ResponseWriter: underlying, status: http.StatusOK}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected the recorder to be an http.Flusher")
+		}
+		flusher.Flush()
+		if !underlying.Flushed {
+			t.Error("Expected the flush to reach the underlying writer")
+		}
+	})
+}
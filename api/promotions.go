@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// PromotionHandler serves the admin endpoints for promotions
+type PromotionHandler struct {
+	promotions *services.PromotionService
+}
+
+func NewPromotionHandler(promotions *services.PromotionService) *PromotionHandler {
+	return &PromotionHandler{promotions: promotions}
+}
+
+// ListPromotions handles GET /admin/promotions
+func (h *PromotionHandler) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.promotions.ListPromotions()
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to list promotions")
+		writeError(w, http.StatusInternalServerError, "Server error listing promotions")
+		return
+	}
+	writeJSON(w, http.StatusOK, promotions)
+}
+
+// GetPromotion handles GET /admin/promotions/{id}
+func (h *PromotionHandler) GetPromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, found := h.promotions.GetPromotion(mux.Vars(r)["id"])
+	if !found {
+		writeError(w, http.StatusNotFound, "No promotion found for that ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, promotion)
+}
+
+// CreatePromotion handles POST /admin/promotions
+func (h *PromotionHandler) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, ok := decodePromotion(w, r, "")
+	if !ok {
+		return
+	}
+
+	err := h.promotions.CreatePromotion(promotion)
+	switch {
+	case errors.Is(err, services.ErrPromotionExists):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("promotion", promotion.ID).Error("Failed to create promotion")
+		writeError(w, http.StatusInternalServerError, "Server error saving promotion")
+		return
+	}
+	writeJSON(w, http.StatusCreated, promotion)
+}
+
+// UpdatePromotion handles PUT /admin/promotions/{id}, replacing the entry.
+// The ID in the path wins over one in the body.
+func (h *PromotionHandler) UpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, ok := decodePromotion(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	err := h.promotions.UpdatePromotion(promotion)
+	switch {
+	case errors.Is(err, repository.ErrPromotionNotFound):
+		writeError(w, http.StatusNotFound, "No promotion found for that ID")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("promotion", promotion.ID).Error("Failed to update promotion")
+		writeError(w, http.StatusInternalServerError, "Server error saving promotion")
+		return
+	}
+	writeJSON(w, http.StatusOK, promotion)
+}
+
+// DeletePromotion handles DELETE /admin/promotions/{id}
+func (h *PromotionHandler) DeletePromotion(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := h.promotions.DeletePromotion(id)
+	switch {
+	case errors.Is(err, repository.ErrPromotionNotFound):
+		writeError(w, http.StatusNotFound, "No promotion found for that ID")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("promotion", id).Error("Failed to delete promotion")
+		writeError(w, http.StatusInternalServerError, "Server error deleting promotion")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodePromotion reads and validates a promotion from the request body,
+// writing a 400 when it is unusable. A non-empty id replaces the body's.
+func decodePromotion(w http.ResponseWriter, r *http.Request, id string) (models.Promotion, bool) {
+	var promotion models.Promotion
+	if err := json.NewDecoder(r.Body).Decode(&promotion); err != nil {
+		utils.Logger.WithError(err).Warn("Invalid promotion JSON")
+		writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return promotion, false
+	}
+	if id != "" {
+		promotion.ID = id
+	}
+	if err := promotion.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return promotion, false
+	}
+	return promotion, true
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+func TestPromotions(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+
+	retailers := services.NewRetailerService(repository.NewInMemoryRetailerStorage())
+	retailers.CreateRetailer(models.Retailer{ID: "target", DisplayName: "Target"})
+	promotions := services.NewPromotionService(repository.NewInMemoryPromotionStorage())
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(services.WithRetailers(retailers), services.WithPromotions(promotions)),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+		WithRetailers(retailers),
+		WithPromotions(promotions),
+	)
+
+	double, _ := json.Marshal(models.Promotion{
+		ID:         "target-double",
+		Name:       "Double points at Target",
+		RetailerID: "target",
+		StartsAt:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:     time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+		Multiplier: 2,
+	})
+
+	t.Run("needs admin", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/promotions", "alice-key", double, nil)
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("Should get 403 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/promotions", "ops-key", double, nil)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Should get 201 but got %d: %s", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("create duplicate", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/promotions", "ops-key", double, nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("points are doubled", func(t *testing.T) {
+		receipt, _ := json.Marshal(models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: testDate,
+			PurchaseTime: testTime,
+			Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+			Total:        "6.49",
+		})
+		response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		var created models.ReceiptResponse
+		json.Unmarshal(response.Body.Bytes(), &created)
+
+		response = authRequest(r, http.MethodGet, "/receipts/"+created.ID+"/points", "alice-key", nil, nil)
+		var points models.PointsResponse
+		json.Unmarshal(response.Body.Bytes(), &points)
+		// 6 retailer + 6 odd day, doubled
+		if points.Points != 24 {
+			t.Errorf("Expected 24 points, got %d", points.Points)
+		}
+		if points.Breakdown != nil {
+			t.Errorf("Breakdown should only be sent on request, got %+v", points.Breakdown)
+		}
+
+		response = authRequest(r, http.MethodGet, "/receipts/"+created.ID+"/points?breakdown=true", "alice-key", nil, nil)
+		points = models.PointsResponse{}
+		json.Unmarshal(response.Body.Bytes(), &points)
+		if len(points.Breakdown) != 8 {
+			t.Fatalf("Expected 7 rules and a promotion, got %+v", points.Breakdown)
+		}
+		if last := points.Breakdown[7]; last.Rule != "promotion:target-double" || last.Points != 12 {
+			t.Errorf("Unexpected promotion line %+v", last)
+		}
+		if models.SumPoints(points.Breakdown) != points.Points {
+			t.Errorf("Breakdown does not add up to %d", points.Points)
+		}
+	})
+
+	t.Run("update unknown", func(t *testing.T) {
+		response := authRequest(r, http.MethodPut, "/admin/promotions/unknown", "ops-key", double, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		response := authRequest(r, http.MethodDelete, "/admin/promotions/target-double", "ops-key", nil, nil)
+		if response.Code != http.StatusNoContent {
+			t.Fatalf("Should get 204 but got %d", response.Code)
+		}
+		response = authRequest(r, http.MethodGet, "/admin/promotions", "ops-key", nil, nil)
+		var list []models.Promotion
+		json.Unmarshal(response.Body.Bytes(), &list)
+		if len(list) != 0 {
+			t.Fatalf("Expected no promotions, got %+v", list)
+		}
+	})
+}
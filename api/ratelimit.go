@@ -0,0 +1,68 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/ratelimit"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitLimit     = "X-RateLimit-Limit"
+	headerRateLimitRemaining = "X-RateLimit-Remaining"
+	headerRateLimitReset     = "X-RateLimit-Reset"
+)
+
+// rateLimit limits a route per caller: authenticated clients by id,
+// anonymous callers by IP. If the store fails the request is let through,
+// since a broken limiter should not take the API down with it.
+func rateLimit(store ratelimit.Store, route string, limit ratelimit.Limit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := route + "|" + rateLimitKey(r)
+
+		decision, err := store.Take(r.Context(), key, limit)
+		if err != nil {
+			utils.Logger.WithError(err).WithField("route", route).Error("Rate limit store failed, allowing request")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(headerRateLimitLimit, strconv.Itoa(decision.Limit))
+		w.Header().Set(headerRateLimitRemaining, strconv.Itoa(decision.Remaining))
+		w.Header().Set(headerRateLimitReset, ceilSeconds(decision.ResetAfter))
+
+		if !decision.Allowed {
+			utils.Logger.WithFields(logrus.Fields{
+				"route": route,
+				"key":   key,
+			}).Warn("Rate limit exceeded")
+			w.Header().Set(headerRetryAfter, ceilSeconds(decision.RetryAfter))
+			writeError(w, http.StatusTooManyRequests, "Too many requests, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func rateLimitKey(r *http.Request) string {
+	if principal := principalFromContext(r.Context()); principal.ClientID != "" {
+		return "client:" + principal.ClientID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ceilSeconds formats a duration as whole seconds, rounding up so clients
+// never retry too early
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/ratelimit"
+)
+
+func TestRateLimiting(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob"}, "bob-key")
+
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+		WithRateLimits(ratelimit.NewMemoryStore(testClock), map[string]ratelimit.Limit{
+			"POST /receipts/process": {Requests: 1, Period: time.Hour, Burst: 2},
+		}),
+	)
+
+	receipt, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: testDate,
+		PurchaseTime: testTime,
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+		Total:        "1.25",
+	})
+
+	for i := 0; i < 2; i++ {
+		response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Request %d within burst should get 200, got %d", i, response.Code)
+		}
+		if remaining := response.Header().Get(headerRateLimitRemaining); remaining != []string{"1", "0"}[i] {
+			t.Errorf("Request %d: unexpected remaining %q", i, remaining)
+		}
+	}
+
+	t.Run("over the limit", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil)
+		if response.Code != http.StatusTooManyRequests {
+			t.Fatalf("Should get 429 but got %d", response.Code)
+		}
+		if retry := response.Header().Get(headerRetryAfter); retry != "3600" {
+			t.Errorf("Expected Retry-After 3600, got %q", retry)
+		}
+		if limit := response.Header().Get(headerRateLimitLimit); limit != "2" {
+			t.Errorf("Expected limit 2, got %q", limit)
+		}
+	})
+
+	t.Run("other clients unaffected", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, processEndpoint, "bob-key", receipt, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+	})
+
+	t.Run("routes without a limit", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/receipts/unknown/points", "alice-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+		if response.Header().Get(headerRateLimitLimit) != "" {
+			t.Error("Unlimited route should not send rate limit headers")
+		}
+	})
+}
+
+func TestAuthRateLimiting(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+		WithRateLimits(ratelimit.NewMemoryStore(testClock), nil),
+		WithAuthRateLimit(ratelimit.Limit{Requests: 1, Period: time.Hour, Burst: 2}),
+	)
+
+	for i := 0; i < 2; i++ {
+		if response := authRequest(r, http.MethodGet, "/receipts/unknown/points", "guess", nil, nil); response.Code != http.StatusUnauthorized {
+			t.Fatalf("Guess %d should get 401, got %d", i, response.Code)
+		}
+	}
+
+	t.Run("failed attempts use up the limit", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/receipts/unknown/points", "alice-key", nil, nil)
+		if response.Code != http.StatusTooManyRequests {
+			t.Fatalf("Should get 429 but got %d", response.Code)
+		}
+	})
+
+	t.Run("other addresses unaffected", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/receipts/unknown/points", nil)
+		req.RemoteAddr = "198.51.100.7:4000"
+		req.Header.Set(headerAPIKey, "alice-key")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, req)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// RetailerHandler serves the admin endpoints for the retailer directory
+type RetailerHandler struct {
+	retailers *services.RetailerService
+}
+
+func NewRetailerHandler(retailers *services.RetailerService) *RetailerHandler {
+	return &RetailerHandler{retailers: retailers}
+}
+
+// ListRetailers handles GET /admin/retailers
+func (h *RetailerHandler) ListRetailers(w http.ResponseWriter, r *http.Request) {
+	retailers, err := h.retailers.ListRetailers()
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to list retailers")
+		writeError(w, http.StatusInternalServerError, "Server error listing retailers")
+		return
+	}
+	writeJSON(w, http.StatusOK, retailers)
+}
+
+// GetRetailer handles GET /admin/retailers/{id}
+func (h *RetailerHandler) GetRetailer(w http.ResponseWriter, r *http.Request) {
+	retailer, found := h.retailers.GetRetailer(mux.Vars(r)["id"])
+	if !found {
+		writeError(w, http.StatusNotFound, "No retailer found for that ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, retailer)
+}
+
+// CreateRetailer handles POST /admin/retailers
+func (h *RetailerHandler) CreateRetailer(w http.ResponseWriter, r *http.Request) {
+	retailer, ok := decodeRetailer(w, r, "")
+	if !ok {
+		return
+	}
+
+	err := h.retailers.CreateRetailer(retailer)
+	switch {
+	case errors.Is(err, services.ErrRetailerExists):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("retailer", retailer.ID).Error("Failed to create retailer")
+		writeError(w, http.StatusInternalServerError, "Server error saving retailer")
+		return
+	}
+	writeJSON(w, http.StatusCreated, retailer)
+}
+
+// UpdateRetailer handles PUT /admin/retailers/{id}, replacing the entry.
+// The ID in the path wins over one in the body.
+func (h *RetailerHandler) UpdateRetailer(w http.ResponseWriter, r *http.Request) {
+	retailer, ok := decodeRetailer(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	err := h.retailers.UpdateRetailer(retailer)
+	switch {
+	case errors.Is(err, repository.ErrRetailerNotFound):
+		writeError(w, http.StatusNotFound, "No retailer found for that ID")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("retailer", retailer.ID).Error("Failed to update retailer")
+		writeError(w, http.StatusInternalServerError, "Server error saving retailer")
+		return
+	}
+	writeJSON(w, http.StatusOK, retailer)
+}
+
+// DeleteRetailer handles DELETE /admin/retailers/{id}
+func (h *RetailerHandler) DeleteRetailer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := h.retailers.DeleteRetailer(id)
+	switch {
+	case errors.Is(err, repository.ErrRetailerNotFound):
+		writeError(w, http.StatusNotFound, "No retailer found for that ID")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("retailer", id).Error("Failed to delete retailer")
+		writeError(w, http.StatusInternalServerError, "Server error deleting retailer")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeRetailer reads and validates a retailer from the request body,
+// writing a 400 when it is unusable. A non-empty id replaces the body's.
+func decodeRetailer(w http.ResponseWriter, r *http.Request, id string) (models.Retailer, bool) {
+	var retailer models.Retailer
+	if err := json.NewDecoder(r.Body).Decode(&retailer); err != nil {
+		utils.Logger.WithError(err).Warn("Invalid retailer JSON")
+		writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return retailer, false
+	}
+	if id != "" {
+		retailer.ID = id
+	}
+	if err := retailer.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return retailer, false
+	}
+	return retailer, true
+}
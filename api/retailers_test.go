@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+func TestRetailerDirectory(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+
+	retailers := services.NewRetailerService(repository.NewInMemoryRetailerStorage())
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(
+			services.WithRetailers(retailers),
+			services.WithFraudDetector(services.NewFraudDetector(services.DefaultFraudConfig, testClock))),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+		WithRetailers(retailers),
+	)
+
+	target, _ := json.Marshal(models.Retailer{
+		ID:          "target",
+		DisplayName: "Target",
+		Patterns:    []string{`(?i)^target\b`},
+	})
+
+	t.Run("needs admin", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/retailers", "alice-key", target, nil)
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("Should get 403 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/retailers", "ops-key", target, nil)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Should get 201 but got %d: %s", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("create duplicate", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/retailers", "ops-key", target, nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create invalid", func(t *testing.T) {
+		body := []byte(`{"id":"walmart","displayName":"Walmart","patterns":["("]}`)
+		response := authRequest(r, http.MethodPost, "/admin/retailers", "ops-key", body, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		body := []byte(`{"displayName":"Target","aliases":["Tgt"],"patterns":["(?i)^target\\b"],"category":"general merchandise"}`)
+		response := authRequest(r, http.MethodPut, "/admin/retailers/target", "ops-key", body, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d: %s", response.Code, response.Body.String())
+		}
+
+		response = authRequest(r, http.MethodGet, "/admin/retailers/target", "ops-key", nil, nil)
+		var retailer models.Retailer
+		json.Unmarshal(response.Body.Bytes(), &retailer)
+		if retailer.Category != "general merchandise" || len(retailer.Aliases) != 1 {
+			t.Errorf("Update not stored, got %+v", retailer)
+		}
+	})
+
+	t.Run("update unknown", func(t *testing.T) {
+		response := authRequest(r, http.MethodPut, "/admin/retailers/costco", "ops-key", []byte(`{"displayName":"Costco"}`), nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+
+	t.Run("receipts are tagged", func(t *testing.T) {
+		// rule optimal so it lands in the review queue, where the tag shows
+		receipt, _ := json.Marshal(models.Receipt{
+			Retailer:     "TARGET Store 1234567890 ABCDEFGHIJKLMNOPQRST",
+			PurchaseDate: "2022-05-13",
+			PurchaseTime: "14:33",
+			Items: []models.Item{
+				{ShortDescription: "ABC", Price: "5.00"},
+				{ShortDescription: "DEFGHI", Price: "5.00"},
+			},
+			Total: "10.00",
+		})
+		if response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil); response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+
+		response := authRequest(r, http.MethodGet, "/admin/reviews", "ops-key", nil, nil)
+		var items []models.ReviewItem
+		json.Unmarshal(response.Body.Bytes(), &items)
+		if len(items) != 1 || items[0].RetailerID != "target" {
+			t.Fatalf("Expected a review item tagged target, got %+v", items)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/admin/retailers", "ops-key", nil, nil)
+		var list []models.Retailer
+		json.Unmarshal(response.Body.Bytes(), &list)
+		if len(list) != 1 || list[0].ID != "target" {
+			t.Fatalf("Unexpected directory %+v", list)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		response := authRequest(r, http.MethodDelete, "/admin/retailers/target", "ops-key", nil, nil)
+		if response.Code != http.StatusNoContent {
+			t.Fatalf("Should get 204 but got %d", response.Code)
+		}
+		response = authRequest(r, http.MethodGet, "/admin/retailers/target", "ops-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 after delete but got %d", response.Code)
+		}
+	})
+}
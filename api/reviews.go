@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// ListReviews handles GET /admin/reviews?limit=&offset=
+func (h *ReceiptHandler) ListReviews(w http.ResponseWriter, r *http.Request) {
+	offset, limit, ok := queryPage(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	items, err := h.service.PendingReviews(offset, limit)
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to list review queue")
+		writeError(w, http.StatusInternalServerError, "Server error listing reviews")
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// ApproveReview handles POST /admin/reviews/{id}/approve
+func (h *ReceiptHandler) ApproveReview(w http.ResponseWriter, r *http.Request) {
+	h.resolveReview(w, r, h.service.ApproveReceipt, models.StatusActive)
+}
+
+// RejectReview handles POST /admin/reviews/{id}/reject
+func (h *ReceiptHandler) RejectReview(w http.ResponseWriter, r *http.Request) {
+	h.resolveReview(w, r, h.service.RejectReceipt, models.StatusRejected)
+}
+
+func (h *ReceiptHandler) resolveReview(w http.ResponseWriter, r *http.Request, resolve func(string) error, status models.ReceiptStatus) {
+	id := mux.Vars(r)["id"]
+
+	err := resolve(id)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, http.StatusNotFound, "No receipt found for that ID")
+		return
+	case errors.Is(err, services.ErrNotPendingReview):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("id", id).Error("Failed to resolve review")
+		writeError(w, http.StatusInternalServerError, "Server error resolving review")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.ReviewResult{ID: id, Status: status})
+}
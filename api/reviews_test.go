@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+func TestFraudReviewQueue(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		newTestReceiptService(
+			services.WithFraudDetector(services.NewFraudDetector(services.DefaultFraudConfig, testClock))),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+	)
+
+	// hits almost every bonus rule at once, so the fraud checks hold it
+	held, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target Store 1234567890 ABCDEFGHIJKLMNOPQRST",
+		PurchaseDate: "2022-05-13",
+		PurchaseTime: "14:33",
+		Items: []models.Item{
+			{ShortDescription: "ABC", Price: "5.00"},
+			{ShortDescription: "DEFGHI", Price: "5.00"},
+		},
+		Total: "10.00",
+	})
+	response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", held, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Should get 200 but got %d", response.Code)
+	}
+	var processResp models.ReceiptResponse
+	json.Unmarshal(response.Body.Bytes(), &processResp)
+	id := processResp.ID
+
+	getPoints := func() models.PointsResponse {
+		response := authRequest(r, http.MethodGet, "/receipts/"+id+"/points", "alice-key", nil, nil)
+		var points models.PointsResponse
+		json.Unmarshal(response.Body.Bytes(), &points)
+		return points
+	}
+
+	t.Run("points withheld while pending", func(t *testing.T) {
+		points := getPoints()
+		if points.Points != 0 || points.Status != models.StatusPendingReview {
+			t.Errorf("Expected 0 points pending review, got %+v", points)
+		}
+	})
+
+	t.Run("queue needs admin", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/admin/reviews", "alice-key", nil, nil)
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("Should get 403 but got %d", response.Code)
+		}
+	})
+
+	t.Run("queue lists held receipt", func(t *testing.T) {
+		response := authRequest(r, http.MethodGet, "/admin/reviews", "ops-key", nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		var items []models.ReviewItem
+		json.Unmarshal(response.Body.Bytes(), &items)
+		if len(items) != 1 || items[0].ID != id || items[0].ClientID != "key:alice" {
+			t.Fatalf("Unexpected review queue %+v", items)
+		}
+		if !items[0].Fraud.Held || len(items[0].Fraud.Signals) == 0 {
+			t.Errorf("Review item should explain the hold, got %+v", items[0].Fraud)
+		}
+	})
+
+	t.Run("approve releases points", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/reviews/"+id+"/approve", "ops-key", nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+		if points := getPoints(); points.Points == 0 || points.Status != "" {
+			t.Errorf("Expected awarded points after approval, got %+v", points)
+		}
+	})
+
+	t.Run("cannot resolve twice", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/reviews/"+id+"/reject", "ops-key", nil, nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("unknown receipt", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/reviews/nope/approve", "ops-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+}
@@ -1,23 +1,268 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/ratelimit"
 	"github.com/ycChu711/receipt-processor/services"
 )
 
+// RouteOption customises SetupRoutes
+type RouteOption func(*routeConfig)
+
+type routeConfig struct {
+	authenticator Authenticator
+	limiter       ratelimit.Store
+	limits        map[string]ratelimit.Limit
+	authLimit     *ratelimit.Limit
+	retailers     *services.RetailerService
+	promotions    *services.PromotionService
+	webhooks      *services.WebhookService
+	jobs          *services.JobService
+	storageStats  func() models.StorageStats
+	// validateResponses checks responses against the spec
+	validateResponses bool
+}
+
+// WithAuthenticator requires every receipt endpoint to pass auth and hold
+// the route's scope. Without it the receipt endpoints are open, all
+// receipts belong to the anonymous caller and the admin endpoints refuse
+// everyone.
+func WithAuthenticator(auth Authenticator) RouteOption {
+	return func(c *routeConfig) {
+		c.authenticator = auth
+	}
+}
+
+// WithRateLimits limits requests per caller on the routes in limits, keyed
+// by method and path template, e.g. "POST /receipts/process"
+func WithRateLimits(store ratelimit.Store, limits map[string]ratelimit.Limit) RouteOption {
+	return func(c *routeConfig) {
+		c.limiter = store
+		c.limits = limits
+	}
+}
+
+// WithAuthRateLimit limits requests per IP on the authenticated routes
+// before credentials are checked, so failed attempts use up the limit too.
+// It takes effect along with WithRateLimits, whose store it shares.
+func WithAuthRateLimit(limit ratelimit.Limit) RouteOption {
+	return func(c *routeConfig) {
+		c.authLimit = &limit
+	}
+}
+
+// WithRetailers serves the admin endpoints for the retailer directory
+func WithRetailers(retailers *services.RetailerService) RouteOption {
+	return func(c *routeConfig) {
+		c.retailers = retailers
+	}
+}
+
+// WithPromotions serves the admin endpoints for promotions
+func WithPromotions(promotions *services.PromotionService) RouteOption {
+	return func(c *routeConfig) {
+		c.promotions = promotions
+	}
+}
+
+// WithWebhooks serves the admin endpoints for webhook subscriptions
+func WithWebhooks(webhooks *services.WebhookService) RouteOption {
+	return func(c *routeConfig) {
+		c.webhooks = webhooks
+	}
+}
+
+// WithJobs processes POST /receipts/process?async=true in the background
+// and serves GET /jobs/{id}
+func WithJobs(jobs *services.JobService) RouteOption {
+	return func(c *routeConfig) {
+		c.jobs = jobs
+	}
+}
+
+// WithStorageStats serves the storage's size and eviction counters at
+// GET /admin/storage
+func WithStorageStats(stats func() models.StorageStats) RouteOption {
+	return func(c *routeConfig) {
+		c.storageStats = stats
+	}
+}
+
+// WithResponseValidation checks JSON responses against the OpenAPI spec and
+// logs those that do not match. It copies every response body, so it is
+// meant for development and tests.
+func WithResponseValidation() RouteOption {
+	return func(c *routeConfig) {
+		c.validateResponses = true
+	}
+}
+
 // SetupRoutes registers all API endpoints
-func SetupRoutes(r *mux.Router, receiptService *services.ReceiptService) {
+func SetupRoutes(r *mux.Router, receiptService *services.ReceiptService, opts ...RouteOption) {
+	config := &routeConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
 	receiptHandler := NewReceiptHandler(receiptService)
+	receiptHandler.jobs = config.jobs
+
+	// the spec is embedded, so failing to load it is a build problem
+	spec, err := LoadOpenAPISpec()
+	if err != nil {
+		panic("invalid embedded OpenAPI spec: " + err.Error())
+	}
+	validator, err := NewSpecValidator(spec)
+	if err != nil {
+		panic("cannot route embedded OpenAPI spec: " + err.Error())
+	}
+	validator.ValidateResponses = config.validateResponses
 
-	r.HandleFunc("/receipts/process", receiptHandler.ProcessReceipt).Methods("POST")
-	r.HandleFunc("/receipts/{id}/points", receiptHandler.GetPoints).Methods("GET")
+	// public endpoints, registered first so the protected subrouter never sees them
+
+	// API contract and interactive docs
+	r.HandleFunc("/openapi.json", ServeOpenAPISpec).Methods("GET")
+	r.HandleFunc("/docs", ServeSwaggerUI).Methods("GET")
 
 	// healthCheck responds with a simple status for monitoring
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerContentType, "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}).Methods("GET")
 
+	// authenticated endpoints; auth and scope checks run before validation
+	// so callers without access learn nothing about the request schema
+	protected := r.NewRoute().Subrouter()
+
+	// handle registers a protected route behind its body size limit, the
+	// per-IP limit, auth, rate limit, the scope a caller needs to use it
+	// and spec validation, in that order. The body is capped first, as
+	// signature checks and validation read it whole. The per-IP limit runs
+	// before auth so callers guessing credentials are limited too.
+	handle := func(method, path, scope string, handler http.HandlerFunc) {
+		h := validator.Middleware(handler)
+		// the anonymous caller has no scopes, so admin routes stay closed
+		// when there is no auth
+		if config.authenticator != nil || scope == models.ScopeAdmin {
+			h = requireScope(scope, h)
+		}
+		if limit, found := config.limits[method+" "+path]; found && config.limiter != nil {
+			h = rateLimit(config.limiter, method+" "+path, limit, h)
+		}
+		if config.authenticator != nil {
+			h = authMiddleware(config.authenticator)(h)
+			if config.authLimit != nil && config.limiter != nil {
+				h = rateLimit(config.limiter, "auth", *config.authLimit, h)
+			}
+		}
+		limit, found := bodyLimits[method+" "+path]
+		if !found {
+			limit = defaultBodyLimit
+		}
+		protected.Handle(path, limitBody(limit, h)).Methods(method)
+	}
+
+	handle("GET", "/receipts", models.ScopeReceiptsRead, receiptHandler.ListReceipts)
+	handle("POST", "/receipts/process", models.ScopeReceiptsWrite, receiptHandler.ProcessReceipt)
+	handle("POST", "/receipts/parse", models.ScopeReceiptsWrite, receiptHandler.ParseReceipt)
+	handle("POST", "/receipts/import", models.ScopeReceiptsWrite, receiptHandler.ImportReceipts)
+	// before /receipts/{id}, which would match it first
+	handle("GET", "/receipts/export", models.ScopeReceiptsRead, receiptHandler.ExportReceipts)
+	handle("GET", "/receipts/{id}", models.ScopeReceiptsRead, receiptHandler.GetReceipt)
+	handle("GET", "/receipts/{id}/points", models.ScopeReceiptsRead, receiptHandler.GetPoints)
+
+	// asynchronous submissions
+	if config.jobs != nil {
+		jobHandler := NewJobHandler(config.jobs)
+		handle("GET", "/jobs/{id}", models.ScopeReceiptsRead, jobHandler.GetJob)
+	}
+
+	// fraud review queue
+	handle("GET", "/admin/reviews", models.ScopeAdmin, receiptHandler.ListReviews)
+	handle("POST", "/admin/reviews/{id}/approve", models.ScopeAdmin, receiptHandler.ApproveReview)
+	handle("POST", "/admin/reviews/{id}/reject", models.ScopeAdmin, receiptHandler.RejectReview)
+
+	// bounded storage metrics
+	if config.storageStats != nil {
+		handle("GET", "/admin/storage", models.ScopeAdmin, storageStatsHandler(config.storageStats))
+	}
+
+	// retailer directory
+	if config.retailers != nil {
+		retailerHandler := NewRetailerHandler(config.retailers)
+		handle("GET", "/admin/retailers", models.ScopeAdmin, retailerHandler.ListRetailers)
+		handle("POST", "/admin/retailers", models.ScopeAdmin, retailerHandler.CreateRetailer)
+		handle("GET", "/admin/retailers/{id}", models.ScopeAdmin, retailerHandler.GetRetailer)
+		handle("PUT", "/admin/retailers/{id}", models.ScopeAdmin, retailerHandler.UpdateRetailer)
+		handle("DELETE", "/admin/retailers/{id}", models.ScopeAdmin, retailerHandler.DeleteRetailer)
+	}
+
+	// promotions
+	if config.promotions != nil {
+		promotionHandler := NewPromotionHandler(config.promotions)
+		handle("GET", "/admin/promotions", models.ScopeAdmin, promotionHandler.ListPromotions)
+		handle("POST", "/admin/promotions", models.ScopeAdmin, promotionHandler.CreatePromotion)
+		handle("GET", "/admin/promotions/{id}", models.ScopeAdmin, promotionHandler.GetPromotion)
+		handle("PUT", "/admin/promotions/{id}", models.ScopeAdmin, promotionHandler.UpdatePromotion)
+		handle("DELETE", "/admin/promotions/{id}", models.ScopeAdmin, promotionHandler.DeletePromotion)
+	}
+
+	// webhook subscriptions and deliveries
+	if config.webhooks != nil {
+		webhookHandler := NewWebhookHandler(config.webhooks)
+		handle("GET", "/admin/webhooks", models.ScopeAdmin, webhookHandler.ListWebhooks)
+		handle("POST", "/admin/webhooks", models.ScopeAdmin, webhookHandler.CreateWebhook)
+		// before /admin/webhooks/{id}, which would match it first
+		handle("GET", "/admin/webhooks/dead-letters", models.ScopeAdmin, webhookHandler.ListDeadLetters)
+		handle("POST", "/admin/webhooks/deliveries/{id}/retry", models.ScopeAdmin, webhookHandler.RetryDelivery)
+		handle("GET", "/admin/webhooks/{id}", models.ScopeAdmin, webhookHandler.GetWebhook)
+		handle("PUT", "/admin/webhooks/{id}", models.ScopeAdmin, webhookHandler.UpdateWebhook)
+		handle("DELETE", "/admin/webhooks/{id}", models.ScopeAdmin, webhookHandler.DeleteWebhook)
+		handle("GET", "/admin/webhooks/{id}/deliveries", models.ScopeAdmin, webhookHandler.ListDeliveries)
+	}
+}
+
+// bodyLimit caps a route's request body, with the error sent when it is
+// larger
+type bodyLimit struct {
+	bytes   int64
+	message string
+}
+
+var defaultBodyLimit = bodyLimit{maxRequestBytes, "Request body is larger than 1 MiB"}
+
+// bodyLimits are the routes whose bodies are capped at another size
+var bodyLimits = map[string]bodyLimit{
+	"POST /receipts/parse":  {maxParseBytes, "Receipt text is larger than 64 KiB"},
+	"POST /receipts/import": {maxImportBytes, "CSV file is larger than 10 MiB"},
+}
+
+// limitBody reads the body up to the limit before anything else sees it,
+// and refuses larger ones without reading the rest
+func limitBody(limit bodyLimit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit.bytes {
+			writeError(w, http.StatusBadRequest, limit.message)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit.bytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusBadRequest, limit.message)
+				return
+			}
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
 }
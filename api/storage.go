@@ -0,0 +1,14 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// storageStatsHandler handles GET /admin/storage
+func storageStatsHandler(stats func() models.StorageStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, stats())
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+func TestBoundedStorageRoutes(t *testing.T) {
+	// a clock of its own, so expiring receipts does not move testClock
+	clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+	storage := repository.NewBoundedStorage(repository.BoundedConfig{MaxReceipts: 1, TTL: time.Hour, Clock: clock})
+	events := services.NewEventBus(storage, services.EventBusConfig{})
+	r := mux.NewRouter()
+	receipts := services.NewReceiptService(storage, services.WithClock(testClock), services.WithEventBus(events))
+	SetupRoutes(r, receipts, WithAuthenticator(operatorAuthenticator{}), WithStorageStats(storage.Stats))
+
+	// the contract server checks every answer against the spec
+	cs := newContractServer(t)
+	cs.router, cs.events = r, events
+
+	process := func() string {
+		t.Helper()
+		receipt, _ := json.Marshal(models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: testDate,
+			PurchaseTime: testTime,
+			Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+			Total:        "1.25",
+		})
+		response := cs.do(http.MethodPost, processEndpoint, receipt)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Expected 200 processing a receipt, got %d", response.Code)
+		}
+		var body models.ReceiptResponse
+		json.Unmarshal(response.Body.Bytes(), &body)
+		return body.ID
+	}
+
+	evicted := process()
+	kept := process()
+	clock.Advance(time.Hour)
+	expired := kept
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"evicted points", "/receipts/" + evicted + "/points", http.StatusGone},
+		{"expired points", "/receipts/" + expired + "/points", http.StatusGone},
+		{"expired receipt", "/receipts/" + expired, http.StatusGone},
+		{"never existed", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if response := cs.do(http.MethodGet, tc.path, nil); response.Code != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, response.Code, response.Body.String())
+			}
+		})
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		response := cs.do(http.MethodGet, "/admin/storage", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", response.Code)
+		}
+		var stats models.StorageStats
+		json.Unmarshal(response.Body.Bytes(), &stats)
+		if stats.Receipts != 0 || stats.Evicted != 1 || stats.Expired != 1 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// WebhookHandler serves the admin endpoints for webhook subscriptions and
+// their deliveries
+type WebhookHandler struct {
+	webhooks *services.WebhookService
+}
+
+func NewWebhookHandler(webhooks *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhooks: webhooks}
+}
+
+// ListWebhooks handles GET /admin/webhooks
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.webhooks.ListSubscriptions()
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to list webhooks")
+		writeError(w, http.StatusInternalServerError, "Server error listing webhooks")
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, subscriptions)
+}
+
+// GetWebhook handles GET /admin/webhooks/{id}
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	subscription, found := h.webhooks.GetSubscription(mux.Vars(r)["id"])
+	if !found {
+		writeError(w, http.StatusNotFound, "No webhook found for that ID")
+		return
+	}
+	subscription.Secret = ""
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+// CreateWebhook handles POST /admin/webhooks
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.decodeWebhook(w, r, "")
+	if !ok {
+		return
+	}
+
+	err := h.webhooks.CreateSubscription(subscription)
+	switch {
+	case errors.Is(err, services.ErrWebhookExists):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("webhook", subscription.ID).Error("Failed to create webhook")
+		writeError(w, http.StatusInternalServerError, "Server error saving webhook")
+		return
+	}
+	subscription.Secret = ""
+	writeJSON(w, http.StatusCreated, subscription)
+}
+
+// UpdateWebhook handles PUT /admin/webhooks/{id}, replacing the entry and
+// its secret. The ID in the path wins over one in the body.
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.decodeWebhook(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	err := h.webhooks.UpdateSubscription(subscription)
+	switch {
+	case errors.Is(err, repository.ErrWebhookNotFound):
+		writeError(w, http.StatusNotFound, "No webhook found for that ID")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("webhook", subscription.ID).Error("Failed to update webhook")
+		writeError(w, http.StatusInternalServerError, "Server error saving webhook")
+		return
+	}
+	subscription.Secret = ""
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+// DeleteWebhook handles DELETE /admin/webhooks/{id}
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := h.webhooks.DeleteSubscription(id)
+	switch {
+	case errors.Is(err, repository.ErrWebhookNotFound):
+		writeError(w, http.StatusNotFound, "No webhook found for that ID")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("webhook", id).Error("Failed to delete webhook")
+		writeError(w, http.StatusInternalServerError, "Server error deleting webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /admin/webhooks/{id}/deliveries?limit=&after=
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	after, limit, ok := queryCursor(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.webhooks.Deliveries(mux.Vars(r)["id"], after, limit)
+	switch {
+	case errors.Is(err, repository.ErrWebhookNotFound):
+		writeError(w, http.StatusNotFound, "No webhook found for that ID")
+		return
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		writeError(w, http.StatusBadRequest, "No webhook delivery found for after")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).Error("Failed to list webhook deliveries")
+		writeError(w, http.StatusInternalServerError, "Server error listing webhook deliveries")
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// ListDeadLetters handles GET /admin/webhooks/dead-letters?limit=&after=
+func (h *WebhookHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	after, limit, ok := queryCursor(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.webhooks.DeadLetters(after, limit)
+	switch {
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		writeError(w, http.StatusBadRequest, "No webhook delivery found for after")
+		return
+	case err != nil:
+		utils.Logger.WithError(err).Error("Failed to list dead webhook deliveries")
+		writeError(w, http.StatusInternalServerError, "Server error listing webhook deliveries")
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// RetryDelivery handles POST /admin/webhooks/deliveries/{id}/retry
+func (h *WebhookHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	delivery, err := h.webhooks.RetryDelivery(id)
+	switch {
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		writeError(w, http.StatusNotFound, "No webhook delivery found for that ID")
+		return
+	case errors.Is(err, services.ErrDeliveryNotDead):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		utils.Logger.WithError(err).WithField("delivery", id).Error("Failed to retry webhook delivery")
+		writeError(w, http.StatusInternalServerError, "Server error retrying webhook delivery")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+// decodeWebhook reads and validates a subscription from the request body,
+// writing a 400 when it is unusable. A non-empty id replaces the body's.
+func (h *WebhookHandler) decodeWebhook(w http.ResponseWriter, r *http.Request, id string) (models.WebhookSubscription, bool) {
+	var subscription models.WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		utils.Logger.WithError(err).Warn("Invalid webhook JSON")
+		writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return subscription, false
+	}
+	if id != "" {
+		subscription.ID = id
+	}
+	if err := h.webhooks.ValidateSubscription(subscription); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return subscription, false
+	}
+	return subscription, true
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+func TestWebhooks(t *testing.T) {
+	clients := newTestClientService()
+	clients.RegisterClient(models.Client{ID: "alice"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "ops", Scopes: []string{models.ScopeAdmin}}, "ops-key")
+
+	// the receiver is down until up is set
+	up := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-up:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	// the receiver listens on 127.0.0.1
+	webhooks := services.NewWebhookService(repository.NewInMemoryWebhookStorage(), services.WebhookConfig{
+		MaxAttempts:         1,
+		Backoff:             time.Millisecond,
+		AllowPrivateTargets: true,
+	}, testClock)
+	webhooks.Start()
+	defer webhooks.Stop()
+	storage, events := newTestEventBus(webhooks.HandleEvent)
+	events.Start()
+	defer events.Stop()
+
+	r := mux.NewRouter()
+	SetupRoutes(r,
+		services.NewReceiptService(storage, services.WithClock(testClock), services.WithEventBus(events)),
+		WithAuthenticator(NewAPIKeyAuthenticator(clients)),
+		WithWebhooks(webhooks),
+	)
+
+	crm, _ := json.Marshal(models.WebhookSubscription{
+		ID:     "crm",
+		URL:    receiver.URL,
+		Events: []models.WebhookEvent{models.EventReceiptProcessed},
+		Secret: "0123456789abcdef",
+	})
+
+	t.Run("needs admin", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/webhooks", "alice-key", crm, nil)
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("Should get 403 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/webhooks", "ops-key", crm, nil)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Should get 201 but got %d: %s", response.Code, response.Body.String())
+		}
+		if strings.Contains(response.Body.String(), "secret") {
+			t.Errorf("The secret should not be returned, got %s", response.Body.String())
+		}
+	})
+
+	t.Run("create duplicate", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/webhooks", "ops-key", crm, nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("create with a short secret", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/webhooks", "ops-key", []byte(`{"id":"short","url":"https://example.com","events":["receipt.processed"],"secret":"short"}`), nil)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("Should get 400 but got %d", response.Code)
+		}
+	})
+
+	var deliveryID string
+	t.Run("failed delivery is dead-lettered", func(t *testing.T) {
+		receipt, _ := json.Marshal(models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: testDate,
+			PurchaseTime: testTime,
+			Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+			Total:        "6.49",
+		})
+		if response := authRequest(r, http.MethodPost, processEndpoint, "alice-key", receipt, nil); response.Code != http.StatusOK {
+			t.Fatalf("Should get 200 but got %d", response.Code)
+		}
+
+		var dead []models.WebhookDelivery
+		deadline := time.Now().Add(5 * time.Second)
+		for len(dead) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+			response := authRequest(r, http.MethodGet, "/admin/webhooks/dead-letters", "ops-key", nil, nil)
+			json.Unmarshal(response.Body.Bytes(), &dead)
+		}
+		if len(dead) != 1 || dead[0].SubscriptionID != "crm" || dead[0].LastStatusCode != http.StatusBadGateway {
+			t.Fatalf("Expected one dead delivery to crm, got %+v", dead)
+		}
+		deliveryID = dead[0].ID
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		close(up)
+		response := authRequest(r, http.MethodPost, "/admin/webhooks/deliveries/"+deliveryID+"/retry", "ops-key", nil, nil)
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("Should get 202 but got %d: %s", response.Code, response.Body.String())
+		}
+
+		var history []models.WebhookDelivery
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			response = authRequest(r, http.MethodGet, "/admin/webhooks/crm/deliveries", "ops-key", nil, nil)
+			json.Unmarshal(response.Body.Bytes(), &history)
+			if len(history) == 1 && history[0].Status == models.DeliveryDelivered {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if len(history) != 1 || history[0].Status != models.DeliveryDelivered || history[0].LastStatusCode != http.StatusNoContent {
+			t.Fatalf("Expected the delivery to go through, got %+v", history)
+		}
+	})
+
+	t.Run("retry delivered", func(t *testing.T) {
+		response := authRequest(r, http.MethodPost, "/admin/webhooks/deliveries/"+deliveryID+"/retry", "ops-key", nil, nil)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("Should get 409 but got %d", response.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		response := authRequest(r, http.MethodDelete, "/admin/webhooks/crm", "ops-key", nil, nil)
+		if response.Code != http.StatusNoContent {
+			t.Fatalf("Should get 204 but got %d", response.Code)
+		}
+		response = authRequest(r, http.MethodGet, "/admin/webhooks/crm/deliveries", "ops-key", nil, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("Should get 404 but got %d", response.Code)
+		}
+	})
+}
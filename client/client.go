@@ -0,0 +1,187 @@
+// Package client is a Go client for the receipt-processor API. It sends
+// the same models the server uses, signs requests for clients with a
+// signing secret, and retries rate limited and failed requests.
+//
+//	c := client.New("http://localhost:8080", client.WithAPIKey(key))
+//	id, err := c.ProcessReceipt(ctx, receipt)
+//	points, err := c.GetPoints(ctx, id)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+// defaults for WithRetries
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 500 * time.Millisecond
+	// maxBackoff caps the doubling backoff, not a server's Retry-After
+	maxBackoff = 30 * time.Second
+)
+
+// maxErrorBytes bounds the error body read from a failed answer
+const maxErrorBytes = 64 << 10
+
+// Client sends requests to a receipt-processor server. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL       string
+	apiKey        string
+	signingSecret string
+	http          *http.Client
+	maxRetries    int
+	backoff       time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey sends key in the X-API-Key header
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithSigningSecret signs every request with the client's HMAC secret, for
+// clients the server requires signatures from
+func WithSigningSecret(secret string) Option {
+	return func(c *Client) {
+		c.signingSecret = secret
+	}
+}
+
+// WithHTTPClient sends requests with hc instead of http.DefaultClient, e.g.
+// for a timeout or a custom transport
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetries retries 429 and 5xx answers up to maxRetries times, waiting
+// backoff before the first retry and doubling it after each, or as long as
+// the server's Retry-After asks. Zero retries disables retrying.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		http:       http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Do sends a request to path, which may carry a query, and returns the 2xx
+// response for the caller to close. Other answers are returned as an
+// *Error once retries are spent. The body is kept so retries can resend it.
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, contentType, body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		apiErr := readError(resp)
+		if !apiErr.Temporary() || attempt >= c.maxRetries {
+			return nil, apiErr
+		}
+		if err := sleep(ctx, c.retryDelay(attempt, apiErr.RetryAfter)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	// signed on every attempt so a retry after a long wait is not stale
+	if c.signingSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", services.SignRequest(c.signingSecret, timestamp, method, req.URL.RequestURI(), body))
+	}
+	return c.http.Do(req)
+}
+
+// retryDelay doubles the backoff per attempt with jitter, so clients
+// throttled together do not retry together
+func (c *Client) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := c.backoff << attempt
+	if delay > maxBackoff || delay <= 0 {
+		delay = maxBackoff
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// call sends a JSON request and decodes the JSON answer into out
+func (c *Client) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	resp, err := c.Do(ctx, method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("Invalid answer from the server: %w", err)
+	}
+	return nil
+}
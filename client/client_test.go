@@ -0,0 +1,295 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/api"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/ratelimit"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var targetReceipt = models.Receipt{
+	Retailer:     "Target",
+	PurchaseDate: "2022-01-01",
+	PurchaseTime: "13:01",
+	Items: []models.Item{
+		{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+		{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+		{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+		{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+	},
+	Total: "35.35",
+}
+
+// newTestServer runs the real routes with alice, who signs her requests,
+// and bob, who may only read
+func newTestServer(t *testing.T, opts ...api.RouteOption) *httptest.Server {
+	t.Helper()
+	clients := services.NewClientService(repository.NewInMemoryClientStorage(), utils.SystemClock{})
+	clients.RegisterClient(models.Client{ID: "alice", SigningSecret: "alice-secret"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob", Scopes: []string{models.ScopeReceiptsRead}}, "bob-key")
+
+	r := mux.NewRouter()
+	opts = append([]api.RouteOption{api.WithAuthenticator(api.NewAPIKeyAuthenticator(clients))}, opts...)
+	api.SetupRoutes(r, services.NewReceiptService(repository.NewInMemoryStorage()), opts...)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newAlice(server *httptest.Server, opts ...Option) *Client {
+	opts = append([]Option{WithAPIKey("alice-key"), WithSigningSecret("alice-secret"), WithRetries(0, 0)}, opts...)
+	return New(server.URL, opts...)
+}
+
+func TestReceipts(t *testing.T) {
+	server := newTestServer(t)
+	c := newAlice(server)
+	ctx := context.Background()
+
+	id, err := c.ProcessReceipt(ctx, targetReceipt)
+	if err != nil || id == "" {
+		t.Fatalf("Expected an ID, got %q %v", id, err)
+	}
+
+	points, err := c.GetPoints(ctx, id)
+	if err != nil || points.Points != 28 || points.Breakdown != nil {
+		t.Errorf("Expected 28 points without a breakdown, got %+v %v", points, err)
+	}
+	points, err = c.GetPointsBreakdown(ctx, id)
+	if err != nil || models.SumPoints(points.Breakdown) != 28 {
+		t.Errorf("Expected a breakdown adding up to 28, got %+v %v", points, err)
+	}
+
+	detail, err := c.GetReceipt(ctx, id)
+	if err != nil || detail.ID != id || detail.Receipt.Retailer != "Target" || detail.Status != models.StatusActive {
+		t.Errorf("Unexpected receipt %+v %v", detail, err)
+	}
+
+	second, _ := c.ProcessReceipt(ctx, targetReceipt)
+	list, err := c.ListReceipts(ctx, ListOptions{Limit: 1, Offset: 1})
+	if err != nil || list.Total != 2 || len(list.Receipts) != 1 || list.Receipts[0].ID != second {
+		t.Errorf("Expected the second receipt on the second page, got %+v %v", list, err)
+	}
+	list, err = c.ListReceipts(ctx, ListOptions{Status: models.StatusRejected})
+	if err != nil || list.Total != 0 {
+		t.Errorf("Expected no rejected receipts, got %+v %v", list, err)
+	}
+
+	var csv bytes.Buffer
+	if err := c.ExportReceipts(ctx, &csv); err != nil || strings.Count(csv.String(), "\n") != 11 {
+		t.Errorf("Expected a header and 10 item rows, got %q %v", csv.String(), err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		call     func() error
+		sentinel error
+		status   int
+		message  string
+	}{
+		{
+			name: "unknown receipt",
+			call: func() error {
+				_, err := newAlice(server).GetPoints(ctx, "missing")
+				return err
+			},
+			sentinel: ErrNotFound,
+			status:   http.StatusNotFound,
+			message:  "No receipt found for that ID",
+		},
+		{
+			name: "invalid receipt",
+			call: func() error {
+				receipt := targetReceipt
+				receipt.PurchaseDate = "2999-01-01"
+				_, err := newAlice(server).ProcessReceipt(ctx, receipt)
+				return err
+			},
+			sentinel: ErrBadRequest,
+			status:   http.StatusBadRequest,
+			message:  "Invalid receipt",
+		},
+		{
+			name: "bad API key",
+			call: func() error {
+				_, err := New(server.URL, WithAPIKey("nope")).ListReceipts(ctx, ListOptions{})
+				return err
+			},
+			sentinel: ErrUnauthorized,
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name: "unsigned request",
+			call: func() error {
+				_, err := New(server.URL, WithAPIKey("alice-key")).ListReceipts(ctx, ListOptions{})
+				return err
+			},
+			sentinel: ErrUnauthorized,
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name: "missing scope",
+			call: func() error {
+				_, err := New(server.URL, WithAPIKey("bob-key")).ProcessReceipt(ctx, targetReceipt)
+				return err
+			},
+			sentinel: ErrForbidden,
+			status:   http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected an *Error, got %v", err)
+			}
+			if !errors.Is(err, tc.sentinel) || apiErr.StatusCode != tc.status {
+				t.Errorf("Expected %v with %d, got %v", tc.sentinel, tc.status, err)
+			}
+			if !strings.Contains(apiErr.Message, tc.message) {
+				t.Errorf("Expected the message to contain %q, got %q", tc.message, apiErr.Message)
+			}
+			if errors.Is(err, ErrServer) || apiErr.Temporary() {
+				t.Error("Expected a permanent error")
+			}
+		})
+	}
+}
+
+// flaky fails the first failures requests with status before passing them
+// on to next
+func flaky(status, failures int, next http.Handler) (http.Handler, *int32) {
+	var calls int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&calls, 1)) <= failures {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"try again"}`))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), &calls
+}
+
+func TestRetries(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	t.Run("5xx then success", func(t *testing.T) {
+		handler, calls := flaky(http.StatusServiceUnavailable, 2, server.Config.Handler)
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+
+		id, err := newAlice(proxy, WithRetries(3, time.Millisecond)).ProcessReceipt(ctx, targetReceipt)
+		if err != nil || id == "" {
+			t.Fatalf("Expected the third attempt to succeed, got %v", err)
+		}
+		if *calls != 3 {
+			t.Errorf("Expected 3 attempts, got %d", *calls)
+		}
+	})
+
+	t.Run("retries spent", func(t *testing.T) {
+		handler, calls := flaky(http.StatusInternalServerError, 10, server.Config.Handler)
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+
+		_, err := newAlice(proxy, WithRetries(2, time.Millisecond)).GetPoints(ctx, "any")
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || !errors.Is(err, ErrServer) || apiErr.Message != "try again" {
+			t.Errorf("Expected the last server error, got %v", err)
+		}
+		if *calls != 3 {
+			t.Errorf("Expected 3 attempts, got %d", *calls)
+		}
+	})
+
+	t.Run("not on client errors", func(t *testing.T) {
+		handler, calls := flaky(http.StatusBadRequest, 10, server.Config.Handler)
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+
+		newAlice(proxy, WithRetries(3, time.Millisecond)).GetPoints(ctx, "any")
+		if *calls != 1 {
+			t.Errorf("Expected 1 attempt, got %d", *calls)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		limits := map[string]ratelimit.Limit{"GET /receipts": {Requests: 1, Period: time.Minute, Burst: 1}}
+		limited := newTestServer(t, api.WithRateLimits(ratelimit.NewMemoryStore(utils.SystemClock{}), limits))
+		c := newAlice(limited)
+
+		if _, err := c.ListReceipts(ctx, ListOptions{}); err != nil {
+			t.Fatalf("Expected the first request through, got %v", err)
+		}
+		_, err := c.ListReceipts(ctx, ListOptions{})
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || !errors.Is(err, ErrRateLimited) || !apiErr.Temporary() {
+			t.Fatalf("Expected a rate limit error, got %v", err)
+		}
+		if apiErr.RetryAfter < 59*time.Second || apiErr.RetryAfter > time.Minute {
+			t.Errorf("Expected to retry in a minute, got %v", apiErr.RetryAfter)
+		}
+	})
+
+	t.Run("context cancelled while backing off", func(t *testing.T) {
+		handler, _ := flaky(http.StatusServiceUnavailable, 10, server.Config.Handler)
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := newAlice(proxy, WithRetries(5, time.Hour)).GetPoints(ctx, "any")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the deadline, got %v", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Error("Expected the backoff to stop with the context")
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	c := New("http://localhost", WithRetries(10, time.Second))
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{4, 16 * time.Second},
+		{5, maxBackoff},
+		{70, maxBackoff},
+	}
+	for _, tc := range tests {
+		delay := c.retryDelay(tc.attempt, 0)
+		if delay < tc.max/2 || delay > tc.max {
+			t.Errorf("Attempt %d: expected a delay between %v and %v, got %v", tc.attempt, tc.max/2, tc.max, delay)
+		}
+	}
+	if delay := c.retryDelay(0, time.Minute); delay != time.Minute {
+		t.Errorf("Expected Retry-After to win, got %v", delay)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// ProcessReceipt submits a receipt and returns its ID. The server validates
+// it, an invalid receipt fails with ErrBadRequest and the reason.
+func (c *Client) ProcessReceipt(ctx context.Context, receipt models.Receipt) (string, error) {
+	var created models.ReceiptResponse
+	if err := c.call(ctx, "POST", "/receipts/process", receipt, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// GetPoints returns the points awarded for a receipt
+func (c *Client) GetPoints(ctx context.Context, id string) (models.PointsResponse, error) {
+	var points models.PointsResponse
+	err := c.call(ctx, "GET", "/receipts/"+url.PathEscape(id)+"/points", nil, &points)
+	return points, err
+}
+
+// GetPointsBreakdown returns the points of a receipt with each rule's share
+func (c *Client) GetPointsBreakdown(ctx context.Context, id string) (models.PointsResponse, error) {
+	var points models.PointsResponse
+	err := c.call(ctx, "GET", "/receipts/"+url.PathEscape(id)+"/points?breakdown=true", nil, &points)
+	return points, err
+}
+
+// GetReceipt returns a submitted receipt with its points and status
+func (c *Client) GetReceipt(ctx context.Context, id string) (models.ReceiptDetail, error) {
+	var detail models.ReceiptDetail
+	err := c.call(ctx, "GET", "/receipts/"+url.PathEscape(id), nil, &detail)
+	return detail, err
+}
+
+// ListOptions filters and pages ListReceipts. Zero values leave the
+// server's defaults.
+type ListOptions struct {
+	Status models.ReceiptStatus
+	Limit  int
+	Offset int
+}
+
+// ListReceipts returns a page of the caller's receipts, oldest first
+func (c *Client) ListReceipts(ctx context.Context, opts ListOptions) (models.ReceiptList, error) {
+	query := url.Values{}
+	if opts.Status != "" {
+		query.Set("status", string(opts.Status))
+	}
+	if opts.Limit != 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset != 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	path := "/receipts"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var list models.ReceiptList
+	err := c.call(ctx, "GET", path, nil, &list)
+	return list, err
+}
+
+// ExportReceipts streams the caller's receipts as CSV to w. Failures are
+// only retried before the first byte is written.
+func (c *Client) ExportReceipts(ctx context.Context, w io.Writer) error {
+	resp, err := c.Do(ctx, "GET", "/receipts/export?format=csv", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+// maxLineBytes bounds one NDJSON receipt
+const maxLineBytes = 1 << 20
+
+type bulkJob struct {
+	line int
+	data []byte
+}
+
+type bulkResult struct {
+	line      int
+	breakdown []models.PointsLine
+	err       error
+}
+
+// bulkSummary describes the points of the valid receipts of a file
+type bulkSummary struct {
+	Receipts     int     `json:"receipts"`
+	Valid        int     `json:"valid"`
+	Invalid      int     `json:"invalid"`
+	TotalPoints  int64   `json:"totalPoints"`
+	MeanPoints   float64 `json:"meanPoints"`
+	MinPoints    int64   `json:"minPoints"`
+	MedianPoints int64   `json:"medianPoints"`
+	P90Points    int64   `json:"p90Points"`
+	MaxPoints    int64   `json:"maxPoints"`
+	// Rules adds up each rule's points over the valid receipts
+	Rules []models.PointsLine `json:"rules"`
+}
+
+// bulk scores every line of an NDJSON file on a pool of workers. Invalid
+// lines are reported on stderr in line order and make the exit code 1.
+func (c *cli) bulk(args []string) int {
+	flags := c.flagSet("bulk", "[-json] [-workers n] [-item-counting lines|units] [file]")
+	asJSON := flags.Bool("json", false, "print the summary as JSON")
+	workers := flags.Int("workers", runtime.NumCPU(), "receipts scored in parallel")
+	counting := itemCountingFlag(flags)
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	rules, err := pointsRules(*counting)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+	if *workers < 1 {
+		fmt.Fprintln(c.stderr, "-workers must be at least 1")
+		return exitUsage
+	}
+
+	in, err := c.input(files)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitFailed
+	}
+	defer in.Close()
+
+	jobs := make(chan bulkJob)
+	results := make(chan bulkResult)
+
+	var readErr error
+	go func() {
+		defer close(jobs)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+		for line := 1; scanner.Scan(); line++ {
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			// the scanner reuses its buffer
+			jobs <- bulkJob{line: line, data: append([]byte(nil), data...)}
+		}
+		readErr = scanner.Err()
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- scoreLine(job, rules)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var all []bulkResult
+	for result := range results {
+		all = append(all, result)
+	}
+	// set before jobs was closed, so safe to read once results is drained
+	if readErr != nil {
+		fmt.Fprintf(c.stderr, "Failed to read input: %v\n", readErr)
+		return exitFailed
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].line < all[j].line })
+
+	for _, result := range all {
+		if result.err != nil {
+			fmt.Fprintf(c.stderr, "line %d: %v\n", result.line, result.err)
+		}
+	}
+	summary := summarize(all)
+	if *asJSON {
+		writeJSON(c.stdout, summary)
+	} else {
+		c.printSummary(summary)
+	}
+
+	if summary.Invalid > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func scoreLine(job bulkJob, rules services.PointsRules) bulkResult {
+	var receipt models.Receipt
+	if err := json.Unmarshal(job.data, &receipt); err != nil {
+		return bulkResult{line: job.line, err: fmt.Errorf("Invalid receipt JSON: %w", err)}
+	}
+	if err := receipt.Validate(); err != nil {
+		return bulkResult{line: job.line, err: fmt.Errorf("Invalid receipt: %w", err)}
+	}
+	return bulkResult{line: job.line, breakdown: services.CalculateBreakdownWith(&receipt, rules)}
+}
+
+// summarize adds up results in line order, so rules are listed in the
+// order they first appear
+func summarize(results []bulkResult) bulkSummary {
+	summary := bulkSummary{Receipts: len(results), Rules: []models.PointsLine{}}
+	var points []int64
+	ruleIndex := map[string]int{}
+	for _, result := range results {
+		if result.err != nil {
+			summary.Invalid++
+			continue
+		}
+		summary.Valid++
+		for _, line := range result.breakdown {
+			i, found := ruleIndex[line.Rule]
+			if !found {
+				i = len(summary.Rules)
+				ruleIndex[line.Rule] = i
+				summary.Rules = append(summary.Rules, models.PointsLine{Rule: line.Rule})
+			}
+			summary.Rules[i].Points += line.Points
+		}
+		total := models.SumPoints(result.breakdown)
+		points = append(points, total)
+		summary.TotalPoints += total
+	}
+	if len(points) == 0 {
+		return summary
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	summary.MeanPoints = float64(summary.TotalPoints) / float64(len(points))
+	summary.MinPoints = points[0]
+	summary.MedianPoints = percentile(points, 50)
+	summary.P90Points = percentile(points, 90)
+	summary.MaxPoints = points[len(points)-1]
+	return summary
+}
+
+// percentile uses the nearest rank method on sorted values
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (c *cli) printSummary(summary bulkSummary) {
+	table := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "receipts\t%d\n", summary.Receipts)
+	fmt.Fprintf(table, "valid\t%d\n", summary.Valid)
+	fmt.Fprintf(table, "invalid\t%d\n", summary.Invalid)
+	if summary.Valid > 0 {
+		fmt.Fprintf(table, "total points\t%d\n", summary.TotalPoints)
+		fmt.Fprintf(table, "mean\t%.1f\n", summary.MeanPoints)
+		fmt.Fprintf(table, "min\t%d\n", summary.MinPoints)
+		fmt.Fprintf(table, "median\t%d\n", summary.MedianPoints)
+		fmt.Fprintf(table, "p90\t%d\n", summary.P90Points)
+		fmt.Fprintf(table, "max\t%d\n", summary.MaxPoints)
+		for _, rule := range summary.Rules {
+			fmt.Fprintf(table, "  %s\t%d\n", rule.Rule, rule.Points)
+		}
+	}
+	table.Flush()
+}
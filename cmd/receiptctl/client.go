@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/client"
+)
+
+// exit codes of the client commands, on top of exitFailed for requests the
+// server rejected, e.g. an invalid receipt
+const (
+	exitNotFound    = 3
+	exitAuth        = 4
+	exitRateLimited = 5
+	// exitServer is a 5xx answer or a server that could not be reached
+	exitServer = 6
+)
+
+const defaultServer = "http://localhost:8080"
+
+// connection holds the flags every client command shares. Credentials are
+// not flag defaults so -h does not print them.
+type connection struct {
+	server        *string
+	apiKey        *string
+	signingSecret *string
+	timeout       *time.Duration
+	retries       *int
+}
+
+func connectionFlags(flags *flag.FlagSet) *connection {
+	server := os.Getenv("RECEIPTCTL_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+	return &connection{
+		server:        flags.String("server", server, "server URL, or set RECEIPTCTL_SERVER"),
+		apiKey:        flags.String("api-key", "", "API key, or set RECEIPTCTL_API_KEY"),
+		signingSecret: flags.String("signing-secret", "", "secret to sign requests with, or set RECEIPTCTL_SIGNING_SECRET"),
+		timeout:       flags.Duration("timeout", 30*time.Second, "request timeout"),
+		retries:       flags.Int("retries", client.DefaultMaxRetries, "times to retry rate limited and failed requests"),
+	}
+}
+
+func (c *connection) newClient() *client.Client {
+	apiKey, secret := *c.apiKey, *c.signingSecret
+	if apiKey == "" {
+		apiKey = os.Getenv("RECEIPTCTL_API_KEY")
+	}
+	if secret == "" {
+		secret = os.Getenv("RECEIPTCTL_SIGNING_SECRET")
+	}
+	return client.New(*c.server,
+		client.WithAPIKey(apiKey),
+		client.WithSigningSecret(secret),
+		client.WithHTTPClient(&http.Client{Timeout: *c.timeout}),
+		client.WithRetries(*c.retries, client.DefaultBackoff),
+	)
+}
+
+// exitCode reports err on stderr and picks the exit code for it
+func (c *cli) exitCode(err error) int {
+	fmt.Fprintln(c.stderr, err)
+
+	var apiErr *client.Error
+	switch {
+	case !errors.As(err, &apiErr):
+		// transport errors: refused, timed out, bad URL
+		return exitServer
+	case errors.Is(err, client.ErrNotFound), errors.Is(err, client.ErrGone):
+		return exitNotFound
+	case errors.Is(err, client.ErrUnauthorized), errors.Is(err, client.ErrForbidden):
+		return exitAuth
+	case errors.Is(err, client.ErrRateLimited):
+		return exitRateLimited
+	case errors.Is(err, client.ErrServer):
+		return exitServer
+	}
+	return exitFailed
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ycChu711/receipt-processor/api"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// newTestServer runs the API with two clients, alice who signs her requests
+// and bob who only has read access
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	clients := services.NewClientService(repository.NewInMemoryClientStorage(), utils.SystemClock{})
+	clients.RegisterClient(models.Client{ID: "alice", SigningSecret: "alice-secret"}, "alice-key")
+	clients.RegisterClient(models.Client{ID: "bob", Scopes: []string{models.ScopeReceiptsRead}}, "bob-key")
+
+	r := mux.NewRouter()
+	receipts := services.NewReceiptService(repository.NewInMemoryStorage())
+	api.SetupRoutes(r, receipts, api.WithAuthenticator(api.NewAPIKeyAuthenticator(clients)))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t)
+	alice := []string{"-server", server, "-api-key", "alice-key", "-signing-secret", "alice-secret"}
+	as := func(args ...string) []string {
+		return append(args, alice...)
+	}
+
+	code, stdout, stderr := runCLI(targetReceipt, as("submit")...)
+	id := strings.TrimSpace(stdout)
+	if code != exitOK || id == "" {
+		t.Fatalf("Expected an ID, got %d %q %q", code, stdout, stderr)
+	}
+	runCLI(quantityReceipt, as("submit")...)
+
+	t.Run("points", func(t *testing.T) {
+		code, stdout, _ := runCLI("", as("points", id, "-breakdown")...)
+		if code != exitOK || !strings.Contains(stdout, "item_pairs") || !strings.HasSuffix(stdout, "total               28\n") {
+			t.Errorf("Unexpected output %d %q", code, stdout)
+		}
+
+		_, stdout, _ = runCLI("", as("points", "-json", id)...)
+		var points models.PointsResponse
+		if err := json.Unmarshal([]byte(stdout), &points); err != nil || points.Points != 28 || points.Breakdown != nil {
+			t.Errorf("Expected 28 points without a breakdown, got %q", stdout)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		code, stdout, _ := runCLI("", as("get", id)...)
+		if code != exitOK || !strings.Contains(stdout, "Target\n") || !strings.Contains(stdout, "  Emils Cheese Pizza ") {
+			t.Errorf("Unexpected output %d %q", code, stdout)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		code, stdout, _ := runCLI("", as("list")...)
+		if code != exitOK || !strings.HasPrefix(stdout, "ID") || !strings.HasSuffix(stdout, "2 of 2 receipts\n") {
+			t.Errorf("Unexpected output %d %q", code, stdout)
+		}
+
+		_, stdout, _ = runCLI("", as("list", "-all", "-limit", "1", "-json")...)
+		var list models.ReceiptList
+		if err := json.Unmarshal([]byte(stdout), &list); err != nil || list.Total != 2 || len(list.Receipts) != 2 {
+			t.Errorf("Expected both receipts over two pages, got %q", stdout)
+		}
+
+		_, stdout, _ = runCLI("", as("list", "-limit", "1")...)
+		if !strings.HasSuffix(stdout, "1 of 2 receipts\n") {
+			t.Errorf("Expected one page, got %q", stdout)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "receipts.csv")
+		if code, _, stderr := runCLI("", as("export", "-o", file)...); code != exitOK {
+			t.Fatalf("Expected the export to succeed, got %d %q", code, stderr)
+		}
+		data, _ := os.ReadFile(file)
+		rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		// a header and a row per item, 5 for Target and 1 for the Gatorades
+		if err != nil || len(rows) != 7 {
+			t.Errorf("Expected 7 CSV rows, got %d: %v", len(rows), err)
+		}
+	})
+
+	t.Run("credentials from the environment", func(t *testing.T) {
+		t.Setenv("RECEIPTCTL_SERVER", server)
+		t.Setenv("RECEIPTCTL_API_KEY", "alice-key")
+		t.Setenv("RECEIPTCTL_SIGNING_SECRET", "alice-secret")
+		if code, stdout, _ := runCLI("", "points", id); code != exitOK || !strings.Contains(stdout, "28") {
+			t.Errorf("Unexpected output %d %q", code, stdout)
+		}
+	})
+}
+
+func TestClientExitCodes(t *testing.T) {
+	server := newTestServer(t)
+	alice := []string{"-server", server, "-api-key", "alice-key", "-signing-secret", "alice-secret"}
+
+	tests := []struct {
+		name     string
+		stdin    string
+		args     []string
+		expected int
+	}{
+		{"unknown receipt", "", append([]string{"points", "missing"}, alice...), exitNotFound},
+		{"bad API key", "", []string{"list", "-server", server, "-api-key", "nope"}, exitAuth},
+		{"unsigned request", "", []string{"list", "-server", server, "-api-key", "alice-key"}, exitAuth},
+		{"missing scope", targetReceipt, []string{"submit", "-server", server, "-api-key", "bob-key"}, exitAuth},
+		{"invalid receipt", `{"retailer":"Target"}`, append([]string{"submit"}, alice...), exitFailed},
+		{"not JSON", "receipt", append([]string{"submit"}, alice...), exitFailed},
+		{"bad status filter", "", append([]string{"list", "-status", "lost"}, alice...), exitFailed},
+		{"missing ID", "", append([]string{"get"}, alice...), exitUsage},
+		{"unreachable server", "", []string{"list", "-server", "http://127.0.0.1:1", "-timeout", "1s"}, exitServer},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(tc.stdin, tc.args...)
+			if code != tc.expected {
+				t.Errorf("Expected exit code %d, got %d %q %q", tc.expected, code, stdout, stderr)
+			}
+			if tc.expected != exitUsage && stderr == "" {
+				t.Error("Expected the error on stderr")
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Command receiptctl validates and scores receipts offline, with the same
+// models and rules as the server, and talks to a running server.
+//
+//	receiptctl score [-json] [-item-counting lines|units] [file]
+//	receiptctl validate [file]
+//	receiptctl bulk [-json] [-workers n] [-item-counting lines|units] [file]
+//
+//	receiptctl submit [flags] [file]
+//	receiptctl points [-breakdown] [flags] <id>
+//	receiptctl get [flags] <id>
+//	receiptctl list [-status s] [-limit n] [-offset n] [-all] [flags]
+//	receiptctl export [-o file] [flags]
+//
+// score, validate and submit read one receipt JSON, bulk reads NDJSON with
+// one receipt per line. Input is read from file, or from stdin when file is
+// - or missing. The server commands take -server, -api-key and
+// -signing-secret, or RECEIPTCTL_SERVER, RECEIPTCTL_API_KEY and
+// RECEIPTCTL_SIGNING_SECRET, and -json to print the server's answer.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/services"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// exit codes
+const (
+	exitOK = 0
+	// exitFailed means a receipt was invalid or could not be read
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+const usage = `Usage: receiptctl <command> [flags] [file]
+
+Offline commands:
+  score     validate a receipt JSON and print its points and breakdown
+  validate  validate a receipt JSON
+  bulk      score an NDJSON file of receipts and print summary stats
+
+Server commands:
+  submit    submit a receipt JSON and print its ID
+  points    print the points of a receipt
+  get       print a receipt
+  list      list receipts
+  export    download receipts as CSV
+
+Input is read from file, or from stdin when file is - or missing.
+The server and API key are read from RECEIPTCTL_SERVER and
+RECEIPTCTL_API_KEY unless given as flags.
+Run receiptctl <command> -h for the command's flags.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli holds the streams a command reads and writes, so tests can run
+// commands in process
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	// the rules log every receipt at info level, which is noise here
+	utils.Logger.SetOutput(stderr)
+	utils.Logger.SetLevel(logrus.WarnLevel)
+
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "score":
+		return c.score(args[1:])
+	case "validate":
+		return c.validate(args[1:])
+	case "bulk":
+		return c.bulk(args[1:])
+	case "submit":
+		return c.submit(args[1:])
+	case "points":
+		return c.points(args[1:])
+	case "get":
+		return c.get(args[1:])
+	case "list":
+		return c.list(args[1:])
+	case "export":
+		return c.export(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// flagSet builds a subcommand's flags, parse errors and -h print its usage
+func (c *cli) flagSet(name, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: receiptctl %s %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses flags placed before, between or after the positional
+// arguments, which it returns
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// itemCountingFlag adds -item-counting, the ITEM_COUNTING server setting
+func itemCountingFlag(flags *flag.FlagSet) *string {
+	return flags.String("item-counting", string(services.CountLines), "how quantities count for rules 4 and 5, lines or units")
+}
+
+func pointsRules(counting string) (services.PointsRules, error) {
+	switch services.ItemCounting(counting) {
+	case services.CountLines, services.CountUnits:
+		return services.PointsRules{ItemCounting: services.ItemCounting(counting)}, nil
+	}
+	return services.PointsRules{}, fmt.Errorf("invalid -item-counting %q, expected lines or units", counting)
+}
+
+// input opens the file named by the only argument, or stdin
+func (c *cli) input(args []string) (io.ReadCloser, error) {
+	switch len(args) {
+	case 0:
+		return io.NopCloser(c.stdin), nil
+	case 1:
+		if args[0] == "-" {
+			return io.NopCloser(c.stdin), nil
+		}
+		return os.Open(args[0])
+	}
+	return nil, fmt.Errorf("expected one file, got %d arguments", len(args))
+}
+
+// readReceipt decodes and validates the single receipt of the input
+func (c *cli) readReceipt(args []string) (models.Receipt, error) {
+	var receipt models.Receipt
+	in, err := c.input(args)
+	if err != nil {
+		return receipt, err
+	}
+	defer in.Close()
+
+	if err := json.NewDecoder(in).Decode(&receipt); err != nil {
+		return receipt, fmt.Errorf("Invalid receipt JSON: %w", err)
+	}
+	if err := receipt.Validate(); err != nil {
+		return receipt, fmt.Errorf("Invalid receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+func writeJSON(w io.Writer, value interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// the Target receipt from the README, 28 points
+const targetReceipt = `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[` +
+	`{"shortDescription":"Mountain Dew 12PK","price":"6.49"},{"shortDescription":"Emils Cheese Pizza","price":"12.25"},` +
+	`{"shortDescription":"Knorr Creamy Chicken","price":"1.26"},{"shortDescription":"Doritos Nacho Cheese","price":"3.35"},` +
+	`{"shortDescription":"   Klarbrunn 12-PK 12 FL OZ  ","price":"12.00"}],"total":"35.35"}`
+
+// 4 Gatorades as one line, 109 points when quantities are counted and 99
+// when they are not
+const quantityReceipt = `{"retailer":"M&M Corner Market","purchaseDate":"2022-03-20","purchaseTime":"14:33","items":[` +
+	`{"shortDescription":"Gatorade","price":"9.00","quantity":"4","unitPrice":"2.25"}],"total":"9.00"}`
+
+func runCLI(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestScore(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		code, stdout, _ := runCLI(targetReceipt, "score")
+		if code != exitOK || !strings.Contains(stdout, "item_pairs          10\n") || !strings.HasSuffix(stdout, "total               28\n") {
+			t.Errorf("Unexpected output %d %q", code, stdout)
+		}
+	})
+
+	t.Run("json from a file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "receipt.json")
+		os.WriteFile(file, []byte(targetReceipt), 0o644)
+
+		code, stdout, _ := runCLI("", "score", "-json", file)
+		var points models.PointsResponse
+		if err := json.Unmarshal([]byte(stdout), &points); err != nil || code != exitOK {
+			t.Fatalf("Expected points JSON, got %d %q", code, stdout)
+		}
+		if points.Points != 28 || len(points.Breakdown) != 7 {
+			t.Errorf("Expected 28 points over 7 rules, got %+v", points)
+		}
+	})
+
+	t.Run("item counting", func(t *testing.T) {
+		_, lines, _ := runCLI(quantityReceipt, "score", "-json")
+		_, units, _ := runCLI(quantityReceipt, "score", "-json", "-item-counting", "units")
+		var linesPoints, unitsPoints models.PointsResponse
+		json.Unmarshal([]byte(lines), &linesPoints)
+		json.Unmarshal([]byte(units), &unitsPoints)
+		if linesPoints.Points != 99 || unitsPoints.Points != 109 {
+			t.Errorf("Expected 99 and 109 points, got %d and %d", linesPoints.Points, unitsPoints.Points)
+		}
+	})
+
+	t.Run("invalid receipt", func(t *testing.T) {
+		code, stdout, stderr := runCLI(`{"retailer":"Target"}`, "score")
+		if code != exitFailed || stdout != "" || !strings.Contains(stderr, "Invalid receipt") {
+			t.Errorf("Expected a validation error, got %d %q %q", code, stdout, stderr)
+		}
+	})
+
+	t.Run("unknown item counting", func(t *testing.T) {
+		if code, _, _ := runCLI(targetReceipt, "score", "-item-counting", "weight"); code != exitUsage {
+			t.Errorf("Expected a usage error, got %d", code)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{name: "valid", input: targetReceipt, expected: exitOK},
+		{name: "invalid", input: strings.Replace(targetReceipt, `"35.35"`, `"35.3"`, 1), expected: exitFailed},
+		{name: "not json", input: "retailer: Target", expected: exitFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, stdout, stderr := runCLI(tc.input, "validate", "-"); code != tc.expected {
+				t.Errorf("Expected exit %d, got %d %q %q", tc.expected, code, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestBulk(t *testing.T) {
+	input := strings.Join([]string{targetReceipt, "", quantityReceipt, `{"retailer":"Target"}`, targetReceipt, "not json"}, "\n")
+
+	code, stdout, stderr := runCLI(input, "bulk", "-json", "-workers", "3")
+	if code != exitFailed {
+		t.Errorf("Expected exit %d for the invalid lines, got %d", exitFailed, code)
+	}
+	// reported in line order whatever order the workers finish in
+	if !strings.HasPrefix(stderr, "line 4: Invalid receipt") || !strings.Contains(stderr, "\nline 6: Invalid receipt JSON") {
+		t.Errorf("Expected errors for lines 4 and 6, got %q", stderr)
+	}
+
+	var summary bulkSummary
+	if err := json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("Expected summary JSON, got %q", stdout)
+	}
+	if summary.Receipts != 5 || summary.Valid != 3 || summary.Invalid != 2 {
+		t.Errorf("Expected 3 of 5 receipts valid, got %+v", summary)
+	}
+	if summary.TotalPoints != 155 || summary.MinPoints != 28 || summary.MedianPoints != 28 || summary.MaxPoints != 99 {
+		t.Errorf("Unexpected points %+v", summary)
+	}
+	if len(summary.Rules) != 7 || summary.Rules[0] != (models.PointsLine{Rule: "retailer_name", Points: 26}) {
+		t.Errorf("Unexpected rule totals %+v", summary.Rules)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if code, _, _ := runCLI("", "frobnicate"); code != exitUsage {
+		t.Errorf("Expected a usage error for an unknown command, got %d", code)
+	}
+	if code, _, _ := runCLI(""); code != exitUsage {
+		t.Errorf("Expected a usage error without a command, got %d", code)
+	}
+	if code, _, _ := runCLI("", "score", "a.json", "b.json"); code != exitFailed {
+		t.Errorf("Expected an error for two files, got %d", code)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/client"
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// submit sends a receipt JSON to POST /receipts/process and prints its ID
+func (c *cli) submit(args []string) int {
+	flags := c.flagSet("submit", "[flags] [file]")
+	conn := connectionFlags(flags)
+	asJSON := flags.Bool("json", false, "print the server's answer as JSON")
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+
+	in, err := c.input(files)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitFailed
+	}
+	body, err := io.ReadAll(in)
+	in.Close()
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitFailed
+	}
+	// the server validates, so a receipt it rejects reports its reason
+	var receipt models.Receipt
+	if err := json.Unmarshal(body, &receipt); err != nil {
+		fmt.Fprintf(c.stderr, "Invalid receipt JSON: %v\n", err)
+		return exitFailed
+	}
+
+	id, err := conn.newClient().ProcessReceipt(context.Background(), receipt)
+	if err != nil {
+		return c.exitCode(err)
+	}
+	if *asJSON {
+		writeJSON(c.stdout, models.ReceiptResponse{ID: id})
+	} else {
+		fmt.Fprintln(c.stdout, id)
+	}
+	return exitOK
+}
+
+// points prints GET /receipts/{id}/points, with the breakdown when asked
+func (c *cli) points(args []string) int {
+	flags := c.flagSet("points", "[flags] <id>")
+	conn := connectionFlags(flags)
+	asJSON := flags.Bool("json", false, "print the server's answer as JSON")
+	breakdown := flags.Bool("breakdown", false, "include each rule's points")
+	id, ok := c.idArg(flags, args)
+	if !ok {
+		return exitUsage
+	}
+
+	receipts := conn.newClient()
+	get := receipts.GetPoints
+	if *breakdown {
+		get = receipts.GetPointsBreakdown
+	}
+	points, err := get(context.Background(), id)
+	if err != nil {
+		return c.exitCode(err)
+	}
+	if *asJSON {
+		writeJSON(c.stdout, points)
+		return exitOK
+	}
+
+	table := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	for _, line := range points.Breakdown {
+		fmt.Fprintf(table, "%s\t%d\n", line.Rule, line.Points)
+	}
+	fmt.Fprintf(table, "total\t%d\n", points.Points)
+	if points.Status != "" {
+		fmt.Fprintf(table, "status\t%s\n", points.Status)
+	}
+	table.Flush()
+	return exitOK
+}
+
+// get prints a stored receipt from GET /receipts/{id}
+func (c *cli) get(args []string) int {
+	flags := c.flagSet("get", "[flags] <id>")
+	conn := connectionFlags(flags)
+	asJSON := flags.Bool("json", false, "print the server's answer as JSON")
+	id, ok := c.idArg(flags, args)
+	if !ok {
+		return exitUsage
+	}
+
+	detail, err := conn.newClient().GetReceipt(context.Background(), id)
+	if err != nil {
+		return c.exitCode(err)
+	}
+	if *asJSON {
+		writeJSON(c.stdout, detail)
+		return exitOK
+	}
+
+	receipt := detail.Receipt
+	retailer := receipt.Retailer
+	if detail.RetailerID != "" {
+		retailer += " (" + detail.RetailerID + ")"
+	}
+	table := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "id\t%s\n", detail.ID)
+	fmt.Fprintf(table, "retailer\t%s\n", retailer)
+	fmt.Fprintf(table, "purchased\t%s %s\n", receipt.PurchaseDate, receipt.PurchaseTime)
+	fmt.Fprintf(table, "total\t%s\n", receipt.Total)
+	fmt.Fprintf(table, "points\t%d\n", detail.Points)
+	fmt.Fprintf(table, "status\t%s\n", detail.Status)
+	fmt.Fprintf(table, "submitted\t%s\n", detail.SubmittedAt.Format(time.RFC3339))
+	fmt.Fprintf(table, "items\t\n")
+	for _, item := range receipt.Items {
+		fmt.Fprintf(table, "  %s\t%s\n", item.ShortDescription, item.Price)
+	}
+	table.Flush()
+	return exitOK
+}
+
+// list prints a page of GET /receipts, or every page with -all
+func (c *cli) list(args []string) int {
+	flags := c.flagSet("list", "[flags]")
+	conn := connectionFlags(flags)
+	asJSON := flags.Bool("json", false, "print the server's answer as JSON")
+	status := flags.String("status", "", "only list receipts with this status")
+	limit := flags.Int("limit", 50, "receipts per page")
+	offset := flags.Int("offset", 0, "receipts to skip")
+	all := flags.Bool("all", false, "fetch every page from -offset on")
+	if rest, err := parseArgs(flags, args); err != nil || len(rest) > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	receipts := conn.newClient()
+	var list models.ReceiptList
+	for {
+		page, err := receipts.ListReceipts(context.Background(), client.ListOptions{
+			Status: models.ReceiptStatus(*status),
+			Limit:  *limit,
+			Offset: *offset + len(list.Receipts),
+		})
+		if err != nil {
+			return c.exitCode(err)
+		}
+		list.Receipts = append(list.Receipts, page.Receipts...)
+		list.Total = page.Total
+		if !*all || len(page.Receipts) == 0 || *offset+len(list.Receipts) >= page.Total {
+			break
+		}
+	}
+	if list.Receipts == nil {
+		list.Receipts = []models.ReceiptSummary{}
+	}
+
+	if *asJSON {
+		writeJSON(c.stdout, list)
+		return exitOK
+	}
+	table := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSUBMITTED\tRETAILER\tTOTAL\tPOINTS\tSTATUS")
+	for _, receipt := range list.Receipts {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%s\n", receipt.ID, receipt.SubmittedAt.Format(time.RFC3339),
+			receipt.Retailer, receipt.Total, receipt.Points, receipt.Status)
+	}
+	table.Flush()
+	fmt.Fprintf(c.stdout, "%d of %d receipts\n", len(list.Receipts), list.Total)
+	return exitOK
+}
+
+// export saves GET /receipts/export as CSV, to stdout or a file
+func (c *cli) export(args []string) int {
+	flags := c.flagSet("export", "[flags]")
+	conn := connectionFlags(flags)
+	output := flags.String("o", "", "write the CSV to this file instead of stdout")
+	if rest, err := parseArgs(flags, args); err != nil || len(rest) > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	out := c.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			return exitFailed
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := conn.newClient().ExportReceipts(context.Background(), out); err != nil {
+		return c.exitCode(err)
+	}
+	return exitOK
+}
+
+// idArg parses the flags around the single receipt ID argument
+func (c *cli) idArg(flags *flag.FlagSet, args []string) (string, bool) {
+	rest, err := parseArgs(flags, args)
+	if err != nil {
+		return "", false
+	}
+	if len(rest) != 1 || rest[0] == "" {
+		flags.Usage()
+		return "", false
+	}
+	return rest[0], true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/services"
+)
+
+// score prints the points of one receipt with the share of each rule
+func (c *cli) score(args []string) int {
+	flags := c.flagSet("score", "[-json] [-item-counting lines|units] [file]")
+	asJSON := flags.Bool("json", false, "print the points as the server's points response")
+	counting := itemCountingFlag(flags)
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	rules, err := pointsRules(*counting)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitUsage
+	}
+
+	receipt, err := c.readReceipt(files)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitFailed
+	}
+
+	breakdown := services.CalculateBreakdownWith(&receipt, rules)
+	points := models.PointsResponse{Points: models.SumPoints(breakdown), Breakdown: breakdown}
+	if *asJSON {
+		writeJSON(c.stdout, points)
+		return exitOK
+	}
+
+	table := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	for _, line := range points.Breakdown {
+		fmt.Fprintf(table, "%s\t%d\n", line.Rule, line.Points)
+	}
+	fmt.Fprintf(table, "total\t%d\n", points.Points)
+	table.Flush()
+	return exitOK
+}
+
+// validate reports whether a receipt would be accepted
+func (c *cli) validate(args []string) int {
+	flags := c.flagSet("validate", "[file]")
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+
+	if _, err := c.readReceipt(files); err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitFailed
+	}
+	fmt.Fprintln(c.stdout, "Receipt is valid")
+	return exitOK
+}
@@ -0,0 +1,508 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/ratelimit"
+	"github.com/ycChu711/receipt-processor/receiptcsv"
+)
+
+// Config holds the service settings, read from the environment so the same
+// image runs unchanged under docker-compose and in tests
+type Config struct {
+	Port    string
+	Clients []ClientKey
+	JWT     JWTConfig
+	// RateLimits maps "METHOD /path/template" to its per-caller limit
+	RateLimits map[string]ratelimit.Limit
+	Fraud      FraudConfig
+	Webhooks   WebhookConfig
+	Jobs       JobConfig
+	// AuthRateLimit limits each IP before its credentials are checked, so
+	// failed attempts count too. Zero Requests turns it off.
+	AuthRateLimit ratelimit.Limit
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// on shutdown, queued jobs are always drained
+	ShutdownTimeout time.Duration
+	// ReceiptMaxAge rejects older purchases, zero means no limit
+	ReceiptMaxAge time.Duration
+	// ReceiptClockSkew is how far in the future a purchase may appear
+	ReceiptClockSkew time.Duration
+	// ReceiptASCIIOnly rejects non-ASCII letters in names and descriptions
+	ReceiptASCIIOnly bool
+	// ReceiptPunctuation lists the symbols allowed in names and descriptions
+	ReceiptPunctuation string
+	// ItemCounting is how item quantities feed the points rules,
+	// lines or units
+	ItemCounting string
+	// RetailersFile seeds the retailer directory from a JSON array
+	RetailersFile string
+	// DataDir keeps receipts on disk, empty keeps them only in memory
+	DataDir          string
+	SnapshotInterval time.Duration
+	// StorageShards splits receipts over that many locked maps, 0 keeps
+	// them in one
+	StorageShards int
+	StorageLimits StorageLimits
+	// Database keeps receipts in PostgreSQL when its URL is set
+	Database DatabaseConfig
+	// CSVMapping names the columns of CSV imports and exports
+	CSVMapping receiptcsv.Mapping
+	// ValidateResponses logs responses that do not match the API spec
+	ValidateResponses bool
+}
+
+// FraudConfig controls fraud scoring of submitted receipts
+type FraudConfig struct {
+	Enabled        bool
+	HoldThreshold  float64
+	MaxTotal       string
+	VelocityLimit  int
+	VelocityWindow time.Duration
+	// DuplicateWindow is how long a receipt is remembered for the
+	// duplicate checks
+	DuplicateWindow time.Duration
+}
+
+// WebhookConfig controls delivery of webhook notifications
+type WebhookConfig struct {
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	Timeout     time.Duration
+	// AllowLocal lets webhooks reach loopback, private and link-local
+	// addresses
+	AllowLocal bool
+	// Retention is how long delivered and dead deliveries are kept
+	Retention time.Duration
+}
+
+// JobConfig sizes the pool for ?async=true receipt submissions
+type JobConfig struct {
+	Workers   int
+	QueueSize int
+	// Retention is how long finished jobs can still be polled
+	Retention time.Duration
+}
+
+// StorageLimits bounds the receipts kept in memory. Zero values are not
+// enforced, and with none set receipts are kept until the process stops.
+type StorageLimits struct {
+	MaxReceipts int
+	MaxBytes    int64
+	TTL         time.Duration
+	// Eviction is "lru" or "oldest"
+	Eviction string
+	// ColdDir keeps evicted receipts on disk, empty drops them
+	ColdDir string
+}
+
+// Enabled reports whether any limit is set
+func (l StorageLimits) Enabled() bool {
+	return l.MaxReceipts > 0 || l.MaxBytes > 0 || l.TTL > 0
+}
+
+// DatabaseConfig connects to PostgreSQL. Zero pool settings keep the
+// driver defaults.
+type DatabaseConfig struct {
+	URL             string
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	QueryTimeout    time.Duration
+}
+
+// DefaultRateLimits protects receipt submission, parsing and imports when
+// RATE_LIMITS is unset. An import can hold thousands of receipts, so it is
+// limited far more tightly.
+const DefaultRateLimits = "POST /receipts/process=10/s:20, POST /receipts/parse=10/s:20, POST /receipts/import=1/m:3"
+
+// DefaultAuthRateLimit is the per-IP limit on authenticated routes when
+// AUTH_RATE_LIMIT is unset, loose enough for clients sharing an address
+const DefaultAuthRateLimit = "50/s:100"
+
+// ClientKey is an API client provisioned at startup
+type ClientKey struct {
+	ClientID      string
+	APIKey        string
+	Scopes        []string
+	SigningSecret string
+}
+
+// JWTConfig enables bearer token auth when Secret or JWKSFile is set
+type JWTConfig struct {
+	Secret     string
+	JWKSFile   string
+	Issuer     string
+	Audience   string
+	ScopeClaim string
+	ScopeMap   map[string]string
+}
+
+// Enabled reports whether any JWT verification key is configured
+func (c JWTConfig) Enabled() bool {
+	return c.Secret != "" || c.JWKSFile != ""
+}
+
+// Load reads the configuration from environment variables
+//
+//	PORT                 listen port (default 8080)
+//	API_KEYS             comma separated client:key[:scope|scope...]
+//	API_SIGNING_SECRETS  comma separated client:secret, requires signed requests
+//	JWT_SECRET           shared secret for HS256/384/512 tokens
+//	JWT_JWKS_FILE        local JWKS file with RSA/EC public keys
+//	JWT_ISSUER           required iss claim, if set
+//	JWT_AUDIENCE         required aud claim, if set
+//	JWT_SCOPE_CLAIM      claim holding scopes (default scope)
+//	JWT_SCOPE_MAP        comma separated claim=scope translations
+//	RATE_LIMITS          comma separated route=requests/period[:burst], or none
+//	AUTH_RATE_LIMIT      requests/period[:burst] per IP before credentials are checked, or none (default 50/s:100)
+//	FRAUD_ENABLED        score receipts for fraud (default false)
+//	FRAUD_HOLD_THRESHOLD score from 0 to 1 at which points are held (default 0.7)
+//	FRAUD_MAX_TOTAL      largest plausible receipt total (default 5000.00)
+//	FRAUD_VELOCITY       submissions per client before flagging, count/window (default 30/1h)
+//	FRAUD_DUPLICATE_TTL  how long submitted receipts are remembered to flag duplicates (default 720h)
+//	RECEIPT_MAX_AGE      oldest purchase accepted, as a duration or 0 for no limit (default 87600h)
+//	RECEIPT_CLOCK_SKEW   how far in the future a purchase may appear (default 24h)
+//	RECEIPT_ASCII_ONLY   only accept ASCII letters in names and descriptions (default false)
+//	RECEIPT_PUNCTUATION  symbols allowed in names and descriptions (default -&'’.#)
+//	ITEM_COUNTING        lines scores every item line once, units counts item quantities (default lines)
+//	RETAILERS_FILE       JSON array of retailers loaded into the directory at startup
+//	CSV_COLUMNS          comma separated field=column renames for CSV import and export
+//	WEBHOOK_WORKERS      webhook deliveries sent at the same time (default 4)
+//	WEBHOOK_MAX_ATTEMPTS tries before a delivery is dead-lettered (default 8)
+//	WEBHOOK_BACKOFF      wait before the first retry, doubled after each failure (default 10s)
+//	WEBHOOK_TIMEOUT      how long one delivery attempt may take (default 10s)
+//	WEBHOOK_ALLOW_LOCAL  deliver to loopback, private and link-local addresses (default false)
+//	WEBHOOK_RETENTION    how long delivered and dead webhook deliveries are kept (default 168h)
+//	JOB_WORKERS          receipts processed at the same time for async submissions (default 4)
+//	JOB_QUEUE_SIZE       async submissions waiting for a worker before 503s (default 100)
+//	JOB_RETENTION        how long a finished job can still be polled (default 1h)
+//	VALIDATE_RESPONSES   log JSON responses that do not match the API spec, for development (default false)
+//	SHUTDOWN_TIMEOUT     how long in-flight requests may take on shutdown (default 30s)
+//	DATA_DIR             directory for the receipt write-ahead log, snapshots and webhooks, unset keeps them in memory only
+//	SNAPSHOT_INTERVAL    how often receipts are snapshotted to DATA_DIR (default 5m)
+//	STORAGE_SHARDS       in-memory receipt shards for concurrent writes, unset keeps one map; not with DATA_DIR
+//	STORAGE_MAX_RECEIPTS receipts kept in memory before evicting, unset for no limit
+//	STORAGE_MAX_BYTES    JSON encoded bytes of receipts kept in memory before evicting, unset for no limit
+//	RECEIPT_TTL          how long receipts are kept after they are stored, unset keeps them
+//	STORAGE_EVICTION     which receipt is evicted first, lru or oldest (default lru)
+//	COLD_DIR             directory evicted receipts are moved to, unset drops them
+//	DATABASE_URL         PostgreSQL URL to keep receipts and webhooks in; not with DATA_DIR, STORAGE_SHARDS or storage limits
+//	DB_MAX_CONNS         largest number of database connections (default the larger of 4 and the CPU count)
+//	DB_MIN_CONNS         database connections kept open when idle (default 0)
+//	DB_MAX_CONN_LIFETIME how long a database connection is reused (default 1h)
+//	DB_MAX_CONN_IDLE     how long an idle database connection is kept (default 30m)
+//	DB_QUERY_TIMEOUT     how long one storage call may take (default 5s)
+func Load() (*Config, error) {
+	cfg := &Config{
+		Port:          getEnv("PORT", "8080"),
+		RetailersFile: os.Getenv("RETAILERS_FILE"),
+		DataDir:       os.Getenv("DATA_DIR"),
+		JWT: JWTConfig{
+			Secret:     os.Getenv("JWT_SECRET"),
+			JWKSFile:   os.Getenv("JWT_JWKS_FILE"),
+			Issuer:     os.Getenv("JWT_ISSUER"),
+			Audience:   os.Getenv("JWT_AUDIENCE"),
+			ScopeClaim: getEnv("JWT_SCOPE_CLAIM", "scope"),
+		},
+	}
+
+	scopeMap, err := parsePairs("JWT_SCOPE_MAP", os.Getenv("JWT_SCOPE_MAP"), "=")
+	if err != nil {
+		return nil, err
+	}
+	cfg.JWT.ScopeMap = scopeMap
+
+	rateLimits, err := parseRateLimits(getEnv("RATE_LIMITS", DefaultRateLimits))
+	if err != nil {
+		return nil, err
+	}
+	cfg.RateLimits = rateLimits
+	if authLimit := getEnv("AUTH_RATE_LIMIT", DefaultAuthRateLimit); !strings.EqualFold(strings.TrimSpace(authLimit), "none") {
+		if cfg.AuthRateLimit, err = ratelimit.ParseLimit(authLimit); err != nil {
+			return nil, fmt.Errorf("invalid AUTH_RATE_LIMIT %q: %w", authLimit, err)
+		}
+	}
+
+	fraud, err := loadFraudConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Fraud = fraud
+
+	webhooks, err := loadWebhookConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Webhooks = webhooks
+
+	if cfg.Jobs.Workers, err = strconv.Atoi(getEnv("JOB_WORKERS", "4")); err != nil || cfg.Jobs.Workers <= 0 {
+		return nil, fmt.Errorf("invalid JOB_WORKERS %q", os.Getenv("JOB_WORKERS"))
+	}
+	if cfg.Jobs.QueueSize, err = strconv.Atoi(getEnv("JOB_QUEUE_SIZE", "100")); err != nil || cfg.Jobs.QueueSize <= 0 {
+		return nil, fmt.Errorf("invalid JOB_QUEUE_SIZE %q", os.Getenv("JOB_QUEUE_SIZE"))
+	}
+	if cfg.Jobs.Retention, err = time.ParseDuration(getEnv("JOB_RETENTION", "1h")); err != nil || cfg.Jobs.Retention <= 0 {
+		return nil, fmt.Errorf("invalid JOB_RETENTION %q", os.Getenv("JOB_RETENTION"))
+	}
+	if cfg.SnapshotInterval, err = time.ParseDuration(getEnv("SNAPSHOT_INTERVAL", "5m")); err != nil || cfg.SnapshotInterval <= 0 {
+		return nil, fmt.Errorf("invalid SNAPSHOT_INTERVAL %q", os.Getenv("SNAPSHOT_INTERVAL"))
+	}
+	if cfg.StorageShards, err = strconv.Atoi(getEnv("STORAGE_SHARDS", "0")); err != nil || cfg.StorageShards < 0 {
+		return nil, fmt.Errorf("invalid STORAGE_SHARDS %q", os.Getenv("STORAGE_SHARDS"))
+	}
+	if cfg.StorageShards > 0 && cfg.DataDir != "" {
+		return nil, fmt.Errorf("STORAGE_SHARDS cannot be used with DATA_DIR")
+	}
+	if cfg.StorageLimits, err = loadStorageLimits(); err != nil {
+		return nil, err
+	}
+	if cfg.StorageLimits.Enabled() && (cfg.DataDir != "" || cfg.StorageShards > 0) {
+		return nil, fmt.Errorf("storage limits cannot be used with DATA_DIR or STORAGE_SHARDS")
+	}
+	if cfg.Database, err = loadDatabaseConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.Database.URL != "" && (cfg.DataDir != "" || cfg.StorageShards > 0 || cfg.StorageLimits.Enabled()) {
+		return nil, fmt.Errorf("DATABASE_URL cannot be used with DATA_DIR, STORAGE_SHARDS or storage limits")
+	}
+	if cfg.ShutdownTimeout, err = time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "30s")); err != nil || cfg.ShutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q", os.Getenv("SHUTDOWN_TIMEOUT"))
+	}
+
+	if cfg.ReceiptMaxAge, err = time.ParseDuration(getEnv("RECEIPT_MAX_AGE", "87600h")); err != nil || cfg.ReceiptMaxAge < 0 {
+		return nil, fmt.Errorf("invalid RECEIPT_MAX_AGE %q", os.Getenv("RECEIPT_MAX_AGE"))
+	}
+	if cfg.ReceiptClockSkew, err = time.ParseDuration(getEnv("RECEIPT_CLOCK_SKEW", "24h")); err != nil || cfg.ReceiptClockSkew < 0 {
+		return nil, fmt.Errorf("invalid RECEIPT_CLOCK_SKEW %q", os.Getenv("RECEIPT_CLOCK_SKEW"))
+	}
+	if cfg.ReceiptASCIIOnly, err = strconv.ParseBool(getEnv("RECEIPT_ASCII_ONLY", "false")); err != nil {
+		return nil, fmt.Errorf("invalid RECEIPT_ASCII_ONLY: %w", err)
+	}
+	cfg.ReceiptPunctuation = os.Getenv("RECEIPT_PUNCTUATION")
+	if cfg.ValidateResponses, err = strconv.ParseBool(getEnv("VALIDATE_RESPONSES", "false")); err != nil {
+		return nil, fmt.Errorf("invalid VALIDATE_RESPONSES: %w", err)
+	}
+	switch cfg.ItemCounting = getEnv("ITEM_COUNTING", "lines"); cfg.ItemCounting {
+	case "lines", "units":
+	default:
+		return nil, fmt.Errorf("invalid ITEM_COUNTING %q, expected lines or units", cfg.ItemCounting)
+	}
+
+	csvColumns, err := parsePairs("CSV_COLUMNS", os.Getenv("CSV_COLUMNS"), "=")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CSVMapping, err = receiptcsv.NewMapping(csvColumns); err != nil {
+		return nil, fmt.Errorf("invalid CSV_COLUMNS: %w", err)
+	}
+
+	clients, err := parseAPIKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	if err := applySigningSecrets(clients, os.Getenv("API_SIGNING_SECRETS")); err != nil {
+		return nil, err
+	}
+	cfg.Clients = clients
+
+	return cfg, nil
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func parseAPIKeys(value string) ([]ClientKey, error) {
+	var clients []ClientKey
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid API_KEYS entry %q, expected client:key[:scopes]", entry)
+		}
+		client := ClientKey{ClientID: parts[0], APIKey: parts[1]}
+		if len(parts) == 3 && parts[2] != "" {
+			client.Scopes = strings.Split(parts[2], "|")
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func applySigningSecrets(clients []ClientKey, value string) error {
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid API_SIGNING_SECRETS entry %q, expected client:secret", entry)
+		}
+		found := false
+		for i := range clients {
+			if clients[i].ClientID == parts[0] {
+				clients[i].SigningSecret = parts[1]
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("signing secret given for unknown client " + parts[0])
+		}
+	}
+	return nil
+}
+
+func loadFraudConfig() (FraudConfig, error) {
+	fraud := FraudConfig{MaxTotal: getEnv("FRAUD_MAX_TOTAL", "5000.00")}
+
+	var err error
+	if fraud.Enabled, err = strconv.ParseBool(getEnv("FRAUD_ENABLED", "false")); err != nil {
+		return fraud, fmt.Errorf("invalid FRAUD_ENABLED: %w", err)
+	}
+	fraud.HoldThreshold, err = strconv.ParseFloat(getEnv("FRAUD_HOLD_THRESHOLD", "0.7"), 64)
+	if err != nil || fraud.HoldThreshold <= 0 || fraud.HoldThreshold > 1 {
+		return fraud, errors.New("FRAUD_HOLD_THRESHOLD must be a number in (0, 1]")
+	}
+
+	velocity := getEnv("FRAUD_VELOCITY", "30/1h")
+	count, window, found := strings.Cut(velocity, "/")
+	if fraud.VelocityLimit, err = strconv.Atoi(count); !found || err != nil || fraud.VelocityLimit <= 0 {
+		return fraud, fmt.Errorf("invalid FRAUD_VELOCITY %q, expected count/window", velocity)
+	}
+	if fraud.VelocityWindow, err = time.ParseDuration(window); err != nil || fraud.VelocityWindow <= 0 {
+		return fraud, fmt.Errorf("invalid FRAUD_VELOCITY %q, expected count/window", velocity)
+	}
+	if fraud.DuplicateWindow, err = time.ParseDuration(getEnv("FRAUD_DUPLICATE_TTL", "720h")); err != nil || fraud.DuplicateWindow <= 0 {
+		return fraud, fmt.Errorf("invalid FRAUD_DUPLICATE_TTL %q", os.Getenv("FRAUD_DUPLICATE_TTL"))
+	}
+	return fraud, nil
+}
+
+func loadWebhookConfig() (WebhookConfig, error) {
+	var webhooks WebhookConfig
+
+	var err error
+	if webhooks.Workers, err = strconv.Atoi(getEnv("WEBHOOK_WORKERS", "4")); err != nil || webhooks.Workers <= 0 {
+		return webhooks, fmt.Errorf("invalid WEBHOOK_WORKERS %q", os.Getenv("WEBHOOK_WORKERS"))
+	}
+	if webhooks.MaxAttempts, err = strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "8")); err != nil || webhooks.MaxAttempts <= 0 {
+		return webhooks, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS %q", os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	}
+	if webhooks.Backoff, err = time.ParseDuration(getEnv("WEBHOOK_BACKOFF", "10s")); err != nil || webhooks.Backoff <= 0 {
+		return webhooks, fmt.Errorf("invalid WEBHOOK_BACKOFF %q", os.Getenv("WEBHOOK_BACKOFF"))
+	}
+	if webhooks.Timeout, err = time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s")); err != nil || webhooks.Timeout <= 0 {
+		return webhooks, fmt.Errorf("invalid WEBHOOK_TIMEOUT %q", os.Getenv("WEBHOOK_TIMEOUT"))
+	}
+	if webhooks.AllowLocal, err = strconv.ParseBool(getEnv("WEBHOOK_ALLOW_LOCAL", "false")); err != nil {
+		return webhooks, fmt.Errorf("invalid WEBHOOK_ALLOW_LOCAL: %w", err)
+	}
+	if webhooks.Retention, err = time.ParseDuration(getEnv("WEBHOOK_RETENTION", "168h")); err != nil || webhooks.Retention <= 0 {
+		return webhooks, fmt.Errorf("invalid WEBHOOK_RETENTION %q", os.Getenv("WEBHOOK_RETENTION"))
+	}
+	return webhooks, nil
+}
+
+func loadStorageLimits() (StorageLimits, error) {
+	limits := StorageLimits{
+		Eviction: getEnv("STORAGE_EVICTION", "lru"),
+		ColdDir:  os.Getenv("COLD_DIR"),
+	}
+
+	var err error
+	if limits.MaxReceipts, err = strconv.Atoi(getEnv("STORAGE_MAX_RECEIPTS", "0")); err != nil || limits.MaxReceipts < 0 {
+		return limits, fmt.Errorf("invalid STORAGE_MAX_RECEIPTS %q", os.Getenv("STORAGE_MAX_RECEIPTS"))
+	}
+	if limits.MaxBytes, err = strconv.ParseInt(getEnv("STORAGE_MAX_BYTES", "0"), 10, 64); err != nil || limits.MaxBytes < 0 {
+		return limits, fmt.Errorf("invalid STORAGE_MAX_BYTES %q", os.Getenv("STORAGE_MAX_BYTES"))
+	}
+	if limits.TTL, err = time.ParseDuration(getEnv("RECEIPT_TTL", "0s")); err != nil || limits.TTL < 0 {
+		return limits, fmt.Errorf("invalid RECEIPT_TTL %q", os.Getenv("RECEIPT_TTL"))
+	}
+	if limits.Eviction != "lru" && limits.Eviction != "oldest" {
+		return limits, fmt.Errorf("invalid STORAGE_EVICTION %q, expected lru or oldest", limits.Eviction)
+	}
+	if limits.ColdDir != "" && limits.MaxReceipts == 0 && limits.MaxBytes == 0 {
+		return limits, fmt.Errorf("COLD_DIR needs STORAGE_MAX_RECEIPTS or STORAGE_MAX_BYTES")
+	}
+	return limits, nil
+}
+
+func loadDatabaseConfig() (DatabaseConfig, error) {
+	database := DatabaseConfig{URL: os.Getenv("DATABASE_URL")}
+
+	var err error
+	var conns int64
+	if conns, err = strconv.ParseInt(getEnv("DB_MAX_CONNS", "0"), 10, 32); err != nil || conns < 0 {
+		return database, fmt.Errorf("invalid DB_MAX_CONNS %q", os.Getenv("DB_MAX_CONNS"))
+	}
+	database.MaxConns = int32(conns)
+	if conns, err = strconv.ParseInt(getEnv("DB_MIN_CONNS", "0"), 10, 32); err != nil || conns < 0 {
+		return database, fmt.Errorf("invalid DB_MIN_CONNS %q", os.Getenv("DB_MIN_CONNS"))
+	}
+	database.MinConns = int32(conns)
+	if database.MaxConns > 0 && database.MinConns > database.MaxConns {
+		return database, fmt.Errorf("DB_MIN_CONNS cannot be more than DB_MAX_CONNS")
+	}
+	if database.MaxConnLifetime, err = time.ParseDuration(getEnv("DB_MAX_CONN_LIFETIME", "0s")); err != nil || database.MaxConnLifetime < 0 {
+		return database, fmt.Errorf("invalid DB_MAX_CONN_LIFETIME %q", os.Getenv("DB_MAX_CONN_LIFETIME"))
+	}
+	if database.MaxConnIdleTime, err = time.ParseDuration(getEnv("DB_MAX_CONN_IDLE", "0s")); err != nil || database.MaxConnIdleTime < 0 {
+		return database, fmt.Errorf("invalid DB_MAX_CONN_IDLE %q", os.Getenv("DB_MAX_CONN_IDLE"))
+	}
+	if database.QueryTimeout, err = time.ParseDuration(getEnv("DB_QUERY_TIMEOUT", "5s")); err != nil || database.QueryTimeout <= 0 {
+		return database, fmt.Errorf("invalid DB_QUERY_TIMEOUT %q", os.Getenv("DB_QUERY_TIMEOUT"))
+	}
+	return database, nil
+}
+
+func parseRateLimits(value string) (map[string]ratelimit.Limit, error) {
+	limits := map[string]ratelimit.Limit{}
+	if strings.EqualFold(strings.TrimSpace(value), "none") {
+		return limits, nil
+	}
+
+	routes, err := parsePairs("RATE_LIMITS", value, "=")
+	if err != nil {
+		return nil, err
+	}
+	for route, spec := range routes {
+		limit, err := ratelimit.ParseLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMITS %s: %w", route, err)
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+// parsePairs reads a comma separated list of key<sep>value pairs
+func parsePairs(name, value, sep string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, entry := range splitList(value) {
+		key, val, found := strings.Cut(entry, sep)
+		if !found || key == "" || val == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, expected key%svalue", name, entry, sep)
+		}
+		pairs[key] = val
+	}
+	return pairs, nil
+}
+
+// splitList splits a comma separated value, dropping blank entries
+func splitList(value string) []string {
+	var out []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/ycChu711/receipt-processor/receiptcsv"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("API_KEYS", "")
+		t.Setenv("API_SIGNING_SECRETS", "")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.Port != "8080" {
+			t.Errorf("Expected default port 8080, got %s", cfg.Port)
+		}
+		if len(cfg.Clients) != 0 {
+			t.Errorf("Expected no clients, got %d", len(cfg.Clients))
+		}
+		for _, route := range []string{"POST /receipts/process", "POST /receipts/parse", "POST /receipts/import"} {
+			if _, found := cfg.RateLimits[route]; !found {
+				t.Errorf("%s should be rate limited by default", route)
+			}
+		}
+	})
+
+	t.Run("rate limits", func(t *testing.T) {
+		t.Setenv("RATE_LIMITS", "POST /receipts/process=5/s, GET /receipts/{id}/points=100/1m:10")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if limit := cfg.RateLimits["GET /receipts/{id}/points"]; limit.Requests != 100 || limit.Burst != 10 {
+			t.Errorf("Unexpected limit %+v", limit)
+		}
+
+		t.Setenv("RATE_LIMITS", "none")
+		cfg, _ = Load()
+		if len(cfg.RateLimits) != 0 {
+			t.Errorf("Expected rate limiting disabled, got %v", cfg.RateLimits)
+		}
+
+		t.Setenv("RATE_LIMITS", "POST /receipts/process=lots")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for malformed limit")
+		}
+	})
+
+	t.Run("auth rate limit", func(t *testing.T) {
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.AuthRateLimit.Requests != 50 || cfg.AuthRateLimit.Burst != 100 {
+			t.Errorf("Expected the default 50/s:100, got %+v", cfg.AuthRateLimit)
+		}
+
+		t.Setenv("AUTH_RATE_LIMIT", "none")
+		if cfg, _ = Load(); cfg.AuthRateLimit.Requests != 0 {
+			t.Errorf("Expected the per-IP limit off, got %+v", cfg.AuthRateLimit)
+		}
+
+		t.Setenv("AUTH_RATE_LIMIT", "lots")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for malformed limit")
+		}
+	})
+
+	t.Run("api keys with scopes and secrets", func(t *testing.T) {
+		t.Setenv("API_KEYS", "pos:key-1, backoffice:key-2:admin|receipts:read")
+		t.Setenv("API_SIGNING_SECRETS", "pos:s3cret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(cfg.Clients) != 2 {
+			t.Fatalf("Expected 2 clients, got %d", len(cfg.Clients))
+		}
+		if cfg.Clients[0].SigningSecret != "s3cret" {
+			t.Errorf("Signing secret not applied to pos client")
+		}
+		if len(cfg.Clients[1].Scopes) != 2 || cfg.Clients[1].Scopes[0] != "admin" {
+			t.Errorf("Unexpected scopes %v", cfg.Clients[1].Scopes)
+		}
+	})
+
+	t.Run("jwt settings", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "shh")
+		t.Setenv("JWT_SCOPE_MAP", "ops=admin, writer=receipts:write")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !cfg.JWT.Enabled() {
+			t.Error("JWT should be enabled with a secret")
+		}
+		if cfg.JWT.ScopeClaim != "scope" {
+			t.Errorf("Expected default scope claim, got %s", cfg.JWT.ScopeClaim)
+		}
+		if cfg.JWT.ScopeMap["writer"] != "receipts:write" {
+			t.Errorf("Unexpected scope map %v", cfg.JWT.ScopeMap)
+		}
+	})
+
+	t.Run("fraud settings", func(t *testing.T) {
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.Fraud.Enabled || cfg.Fraud.DuplicateWindow.Hours() != 720 {
+			t.Errorf("Expected fraud scoring off by default, got %+v", cfg.Fraud)
+		}
+
+		t.Setenv("FRAUD_ENABLED", "true")
+		t.Setenv("FRAUD_HOLD_THRESHOLD", "0.5")
+		t.Setenv("FRAUD_VELOCITY", "5/10m")
+		t.Setenv("FRAUD_DUPLICATE_TTL", "48h")
+
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !cfg.Fraud.Enabled || cfg.Fraud.HoldThreshold != 0.5 {
+			t.Errorf("Unexpected fraud config %+v", cfg.Fraud)
+		}
+		if cfg.Fraud.VelocityLimit != 5 || cfg.Fraud.VelocityWindow.Minutes() != 10 || cfg.Fraud.DuplicateWindow.Hours() != 48 {
+			t.Errorf("Unexpected velocity %d/%s", cfg.Fraud.VelocityLimit, cfg.Fraud.VelocityWindow)
+		}
+
+		t.Setenv("FRAUD_HOLD_THRESHOLD", "2")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for threshold above 1")
+		}
+	})
+
+	t.Run("webhook settings", func(t *testing.T) {
+		t.Setenv("WEBHOOK_WORKERS", "2")
+		t.Setenv("WEBHOOK_BACKOFF", "1m")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.Webhooks.Workers != 2 || cfg.Webhooks.MaxAttempts != 8 || cfg.Webhooks.Backoff.Minutes() != 1 || cfg.Webhooks.AllowLocal {
+			t.Errorf("Unexpected webhook config %+v", cfg.Webhooks)
+		}
+
+		t.Setenv("WEBHOOK_MAX_ATTEMPTS", "0")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for zero attempts")
+		}
+	})
+
+	t.Run("storage limits", func(t *testing.T) {
+		t.Setenv("STORAGE_MAX_RECEIPTS", "1000")
+		t.Setenv("RECEIPT_TTL", "720h")
+		t.Setenv("STORAGE_EVICTION", "oldest")
+		t.Setenv("COLD_DIR", "/var/lib/receipts/cold")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		limits := cfg.StorageLimits
+		if !limits.Enabled() || limits.MaxReceipts != 1000 || limits.TTL.Hours() != 720 || limits.Eviction != "oldest" || limits.ColdDir != "/var/lib/receipts/cold" {
+			t.Errorf("Unexpected storage limits %+v", limits)
+		}
+
+		t.Setenv("STORAGE_SHARDS", "8")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for limits with shards")
+		}
+		t.Setenv("STORAGE_SHARDS", "")
+
+		t.Setenv("STORAGE_EVICTION", "random")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for an unknown eviction policy")
+		}
+		t.Setenv("STORAGE_EVICTION", "")
+
+		t.Setenv("STORAGE_MAX_RECEIPTS", "")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for a cold directory without a size limit")
+		}
+	})
+
+	t.Run("database", func(t *testing.T) {
+		t.Setenv("DATABASE_URL", "postgres://receipts@db:5432/receipts")
+		t.Setenv("DB_MAX_CONNS", "20")
+		t.Setenv("DB_MIN_CONNS", "2")
+		t.Setenv("DB_MAX_CONN_IDLE", "5m")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		db := cfg.Database
+		if db.URL != "postgres://receipts@db:5432/receipts" || db.MaxConns != 20 || db.MinConns != 2 || db.MaxConnIdleTime.Minutes() != 5 || db.MaxConnLifetime != 0 || db.QueryTimeout.Seconds() != 5 {
+			t.Errorf("Unexpected database config %+v", db)
+		}
+
+		t.Setenv("DATA_DIR", t.TempDir())
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for a database with a data directory")
+		}
+		t.Setenv("DATA_DIR", "")
+
+		t.Setenv("DB_MIN_CONNS", "30")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for more idle than open connections")
+		}
+		t.Setenv("DB_MIN_CONNS", "")
+
+		t.Setenv("DB_QUERY_TIMEOUT", "0s")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for a zero query timeout")
+		}
+	})
+
+	t.Run("job settings", func(t *testing.T) {
+		t.Setenv("JOB_QUEUE_SIZE", "10")
+		t.Setenv("JOB_RETENTION", "24h")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.Jobs.Workers != 4 || cfg.Jobs.QueueSize != 10 || cfg.Jobs.Retention.Hours() != 24 || cfg.ShutdownTimeout.Seconds() != 30 {
+			t.Errorf("Unexpected job config %+v, shutdown %s", cfg.Jobs, cfg.ShutdownTimeout)
+		}
+		if cfg.DataDir != "" || cfg.SnapshotInterval.Minutes() != 5 || cfg.StorageShards != 0 {
+			t.Errorf("Unexpected storage config %q every %s, %d shards", cfg.DataDir, cfg.SnapshotInterval, cfg.StorageShards)
+		}
+
+		if cfg.StorageLimits.Enabled() || cfg.StorageLimits.Eviction != "lru" {
+			t.Errorf("Unexpected storage limits %+v", cfg.StorageLimits)
+		}
+
+		t.Setenv("STORAGE_SHARDS", "16")
+		t.Setenv("DATA_DIR", t.TempDir())
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for shards with a data directory")
+		}
+		t.Setenv("DATA_DIR", "")
+
+		t.Setenv("JOB_WORKERS", "none")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for non-numeric workers")
+		}
+	})
+
+	t.Run("purchase date bounds", func(t *testing.T) {
+		t.Setenv("RECEIPT_MAX_AGE", "720h")
+		t.Setenv("RECEIPT_CLOCK_SKEW", "15h")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.ReceiptMaxAge.Hours() != 720 || cfg.ReceiptClockSkew.Hours() != 15 {
+			t.Errorf("Unexpected bounds %s/%s", cfg.ReceiptMaxAge, cfg.ReceiptClockSkew)
+		}
+
+		t.Setenv("RECEIPT_MAX_AGE", "-1h")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for negative max age")
+		}
+	})
+
+	t.Run("character policy", func(t *testing.T) {
+		t.Setenv("RECEIPT_ASCII_ONLY", "true")
+		t.Setenv("RECEIPT_PUNCTUATION", "-&")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !cfg.ReceiptASCIIOnly || cfg.ReceiptPunctuation != "-&" {
+			t.Errorf("Unexpected policy %v %q", cfg.ReceiptASCIIOnly, cfg.ReceiptPunctuation)
+		}
+
+		t.Setenv("RECEIPT_ASCII_ONLY", "maybe")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for malformed RECEIPT_ASCII_ONLY")
+		}
+	})
+
+	t.Run("item counting", func(t *testing.T) {
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.ItemCounting != "lines" {
+			t.Errorf("Expected lines by default, got %q", cfg.ItemCounting)
+		}
+
+		t.Setenv("ITEM_COUNTING", "units")
+		if cfg, err = Load(); err != nil || cfg.ItemCounting != "units" {
+			t.Errorf("Expected units, got %+v, %v", cfg, err)
+		}
+
+		t.Setenv("ITEM_COUNTING", "weight")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for unknown ITEM_COUNTING")
+		}
+	})
+
+	t.Run("csv columns", func(t *testing.T) {
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.CSVMapping[receiptcsv.FieldKey] != "key" {
+			t.Errorf("Expected columns named after fields, got %v", cfg.CSVMapping)
+		}
+
+		t.Setenv("CSV_COLUMNS", "key=Order ID, retailer=Store")
+		if cfg, err = Load(); err != nil || cfg.CSVMapping[receiptcsv.FieldKey] != "Order ID" || cfg.CSVMapping[receiptcsv.FieldRetailer] != "Store" {
+			t.Errorf("Expected renamed columns, got %+v, %v", cfg, err)
+		}
+
+		t.Setenv("CSV_COLUMNS", "store=Retailer")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for an unknown field")
+		}
+
+		t.Setenv("CSV_COLUMNS", "key=total")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for a column used twice")
+		}
+	})
+
+	t.Run("malformed api key", func(t *testing.T) {
+		t.Setenv("API_KEYS", "no-key")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for entry without key")
+		}
+	})
+
+	t.Run("secret for unknown client", func(t *testing.T) {
+		t.Setenv("API_KEYS", "pos:key-1")
+		t.Setenv("API_SIGNING_SECRETS", "other:secret")
+		if _, err := Load(); err == nil {
+			t.Error("Expected error for unknown client")
+		}
+	})
+}
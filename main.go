@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/ycChu711/receipt-processor/api"
+	"github.com/ycChu711/receipt-processor/config"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/ratelimit"
 	"github.com/ycChu711/receipt-processor/repository"
 	"github.com/ycChu711/receipt-processor/services"
 	"github.com/ycChu711/receipt-processor/utils"
@@ -14,20 +25,255 @@ func main() {
 	utils.InitLogger()
 	utils.Logger.Info("Starting receipt processor service...")
 
+	cfg, err := config.Load()
+	if err != nil {
+		utils.Logger.WithError(err).Fatal("Invalid configuration")
+	}
+
 	// create router
 	r := mux.NewRouter()
 
-	// create storage and service
-	storage := repository.NewInMemoryStorage()
-	receiptService := services.NewReceiptService(storage)
+	clock := utils.SystemClock{}
+
+	// retailer directory, optionally seeded from a file
+	retailerService := services.NewRetailerService(repository.NewInMemoryRetailerStorage())
+	if cfg.RetailersFile != "" {
+		if err := loadRetailers(retailerService, cfg.RetailersFile); err != nil {
+			utils.Logger.WithError(err).Fatal("Failed to load retailers")
+		}
+	}
+
+	promotionService := services.NewPromotionService(repository.NewInMemoryPromotionStorage())
+
+	// create storage and service, on disk when DATA_DIR is set, sharded
+	// when STORAGE_SHARDS is and bounded when a storage limit is. Webhooks
+	// are kept on disk or in the database along with the receipts.
+	var storage repository.ReceiptStorage
+	var storageStats func() models.StorageStats
+	var webhookStorage repository.WebhookStorage = repository.NewInMemoryWebhookStorage()
+	switch {
+	case cfg.DataDir != "":
+		persistent, err := repository.OpenInMemoryStorage(repository.PersistenceConfig{
+			Dir:              cfg.DataDir,
+			SnapshotInterval: cfg.SnapshotInterval,
+		})
+		if err != nil {
+			utils.Logger.WithError(err).Fatal("Failed to load receipts")
+		}
+		// deferred before the job queue and event bus, so it closes after them
+		defer persistent.Close()
+		storage = persistent
+		webhookStorage, err = repository.OpenFileWebhookStorage(filepath.Join(cfg.DataDir, "webhooks"))
+		if err != nil {
+			utils.Logger.WithError(err).Fatal("Failed to load webhooks")
+		}
+	case cfg.Database.URL != "":
+		postgres, err := repository.OpenPostgresStorage(context.Background(), repository.PostgresConfig{
+			URL:             cfg.Database.URL,
+			MaxConns:        cfg.Database.MaxConns,
+			MinConns:        cfg.Database.MinConns,
+			MaxConnLifetime: cfg.Database.MaxConnLifetime,
+			MaxConnIdleTime: cfg.Database.MaxConnIdleTime,
+			QueryTimeout:    cfg.Database.QueryTimeout,
+		})
+		if err != nil {
+			utils.Logger.WithError(err).Fatal("Failed to open the receipt database")
+		}
+		defer postgres.Close()
+		storage = postgres
+		webhookStorage = postgres.Webhooks()
+	case cfg.StorageShards > 0:
+		storage = repository.NewShardedStorage(cfg.StorageShards)
+	case cfg.StorageLimits.Enabled():
+		limits := repository.BoundedConfig{
+			MaxReceipts: cfg.StorageLimits.MaxReceipts,
+			MaxBytes:    cfg.StorageLimits.MaxBytes,
+			TTL:         cfg.StorageLimits.TTL,
+			Policy:      repository.EvictionPolicy(cfg.StorageLimits.Eviction),
+			Clock:       clock,
+		}
+		if cfg.StorageLimits.ColdDir != "" {
+			cold, err := repository.NewFileColdStore(cfg.StorageLimits.ColdDir)
+			if err != nil {
+				utils.Logger.WithError(err).Fatal("Failed to open cold receipt store")
+			}
+			limits.Cold = cold
+		}
+		bounded := repository.NewBoundedStorage(limits)
+		defer bounded.Close()
+		storage = bounded
+		storageStats = bounded.Stats
+	default:
+		storage = repository.NewInMemoryStorage()
+	}
+
+	// webhook deliveries run in the background, off the request path
+	webhookService := services.NewWebhookService(webhookStorage, services.WebhookConfig{
+		Workers:             cfg.Webhooks.Workers,
+		MaxAttempts:         cfg.Webhooks.MaxAttempts,
+		Backoff:             cfg.Webhooks.Backoff,
+		Timeout:             cfg.Webhooks.Timeout,
+		AllowPrivateTargets: cfg.Webhooks.AllowLocal,
+		Retention:           cfg.Webhooks.Retention,
+	}, clock)
+	webhookService.Start()
+	defer webhookService.Stop()
+
+	// receipt events are written to the storage's outbox and relayed to
+	// the subscribers from there
+	eventBus := services.NewEventBus(storage, services.EventBusConfig{})
+	eventBus.Subscribe(webhookService.HandleEvent)
+	eventBus.Start()
+	defer eventBus.Stop()
+
+	serviceOpts := []services.ServiceOption{
+		services.WithClock(clock),
+		services.WithEventBus(eventBus),
+		services.WithRetailers(retailerService),
+		services.WithPromotions(promotionService),
+		services.WithValidationRules(models.ValidationRules{
+			MaxAge:    cfg.ReceiptMaxAge,
+			ClockSkew: cfg.ReceiptClockSkew,
+			Characters: models.CharacterPolicy{
+				ASCIIOnly:   cfg.ReceiptASCIIOnly,
+				Punctuation: cfg.ReceiptPunctuation,
+			},
+		}),
+		services.WithPointsRules(services.PointsRules{
+			ItemCounting: services.ItemCounting(cfg.ItemCounting),
+		}),
+		services.WithCSVMapping(cfg.CSVMapping),
+	}
+	if cfg.Fraud.Enabled {
+		maxTotal, err := models.ParseCents(cfg.Fraud.MaxTotal)
+		if err != nil {
+			utils.Logger.WithError(err).Fatal("Invalid FRAUD_MAX_TOTAL")
+		}
+		serviceOpts = append(serviceOpts, services.WithFraudDetector(services.NewFraudDetector(services.FraudConfig{
+			HoldThreshold:   cfg.Fraud.HoldThreshold,
+			MaxTotalCents:   maxTotal,
+			VelocityLimit:   cfg.Fraud.VelocityLimit,
+			VelocityWindow:  cfg.Fraud.VelocityWindow,
+			DuplicateWindow: cfg.Fraud.DuplicateWindow,
+		}, clock)))
+	}
+	receiptService := services.NewReceiptService(storage, serviceOpts...)
+
+	// ?async=true submissions, drained before the event bus stops
+	jobService := services.NewJobService(repository.NewInMemoryJobStorage(), receiptService, services.JobConfig{
+		Workers:   cfg.Jobs.Workers,
+		QueueSize: cfg.Jobs.QueueSize,
+		Retention: cfg.Jobs.Retention,
+	}, clock)
+	jobService.Start()
+	defer jobService.Stop()
+
+	// register API clients, keys are only kept hashed
+	var authenticators []api.Authenticator
+	if len(cfg.Clients) > 0 {
+		clientService := services.NewClientService(repository.NewInMemoryClientStorage(), clock)
+		for _, c := range cfg.Clients {
+			client := models.Client{ID: c.ClientID, Scopes: c.Scopes, SigningSecret: c.SigningSecret}
+			if err := clientService.RegisterClient(client, c.APIKey); err != nil {
+				utils.Logger.WithError(err).Fatal("Failed to register API client")
+			}
+		}
+		authenticators = append(authenticators, api.NewAPIKeyAuthenticator(clientService))
+		utils.Logger.WithField("clients", len(cfg.Clients)).Info("API key authentication enabled")
+	}
+
+	// bearer tokens from internal tools
+	if cfg.JWT.Enabled() {
+		jwtAuth, err := newJWTAuthenticator(cfg.JWT)
+		if err != nil {
+			utils.Logger.WithError(err).Fatal("Invalid JWT configuration")
+		}
+		authenticators = append(authenticators, jwtAuth)
+		utils.Logger.Info("JWT authentication enabled")
+	}
+
+	routeOpts := []api.RouteOption{
+		api.WithRateLimits(ratelimit.NewMemoryStore(clock), cfg.RateLimits),
+		api.WithRetailers(retailerService),
+		api.WithPromotions(promotionService),
+		api.WithWebhooks(webhookService),
+		api.WithJobs(jobService),
+	}
+	if cfg.AuthRateLimit.Requests > 0 {
+		routeOpts = append(routeOpts, api.WithAuthRateLimit(cfg.AuthRateLimit))
+	}
+	if storageStats != nil {
+		routeOpts = append(routeOpts, api.WithStorageStats(storageStats))
+	}
+	if cfg.ValidateResponses {
+		routeOpts = append(routeOpts, api.WithResponseValidation())
+	}
+	if len(authenticators) > 0 {
+		routeOpts = append(routeOpts, api.WithAuthenticator(api.ChainAuthenticators(authenticators...)))
+	} else {
+		utils.Logger.Warn("No API keys or JWT keys configured, receipt endpoints are open to anyone and admin endpoints are closed")
+	}
 
 	// setup api routes
-	api.SetupRoutes(r, receiptService)
+	api.SetupRoutes(r, receiptService, routeOpts...)
 	utils.Logger.Info("API Routes configured")
 
 	// Start server
-	utils.Logger.Info("Server starting on port 8080...")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		utils.Logger.WithError(err).Fatal("Server failed to start")
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		utils.Logger.Infof("Server starting on port %s...", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.Logger.WithError(err).Fatal("Server failed to start")
+		}
+	}()
+
+	// stop taking requests, then the deferred Stops drain the job queue,
+	// the outbox and the webhook workers in that order, and the storage
+	// takes a last snapshot
+	<-ctx.Done()
+	utils.Logger.Info("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		utils.Logger.WithError(err).Error("In-flight requests did not finish")
+	}
+}
+
+func newJWTAuthenticator(cfg config.JWTConfig) (*api.JWTAuthenticator, error) {
+	opts := api.JWTOptions{
+		Clock:      utils.SystemClock{},
+		Secret:     []byte(cfg.Secret),
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		ScopeClaim: cfg.ScopeClaim,
+		ScopeMap:   cfg.ScopeMap,
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := api.LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.Keys = keys
+	}
+	return api.NewJWTAuthenticator(opts)
+}
+
+func loadRetailers(retailers *services.RetailerService, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entries []models.Retailer
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid retailers file %s: %w", path, err)
+	}
+	for _, retailer := range entries {
+		if err := retailers.CreateRetailer(retailer); err != nil {
+			return fmt.Errorf("retailer %q: %w", retailer.ID, err)
+		}
 	}
+	utils.Logger.WithField("retailers", len(entries)).Info("Retailer directory loaded")
+	return nil
 }
@@ -0,0 +1,51 @@
+package models
+
+// Scopes granted to API clients
+const (
+	ScopeReceiptsRead  = "receipts:read"
+	ScopeReceiptsWrite = "receipts:write"
+	ScopeAdmin         = "admin"
+)
+
+// DefaultClientScopes are given to API key clients configured without any
+var DefaultClientScopes = []string{ScopeReceiptsRead, ScopeReceiptsWrite}
+
+// Client is an API consumer. Only a hash of the API key is kept; the
+// signing secret has to stay readable to verify HMAC signatures.
+type Client struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name,omitempty"`
+	APIKeyHash    string   `json:"-"`
+	Scopes        []string `json:"scopes,omitempty"`
+	SigningSecret string   `json:"-"`
+}
+
+// Principal IDs start with how the caller authenticated, so an API key
+// client and a token subject of the same name own different receipts
+const (
+	PrincipalKeyPrefix = "key:"
+	PrincipalJWTPrefix = "jwt:"
+)
+
+// Principal is the authenticated caller of a request. The zero value is an
+// anonymous caller with no scopes, used when authentication is disabled.
+type Principal struct {
+	ClientID string
+	Scopes   []string
+}
+
+// HasScope reports whether the principal was granted scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the principal may act under scope. Admins are
+// allowed everything.
+func (p Principal) Allows(scope string) bool {
+	return p.HasScope(scope) || p.HasScope(ScopeAdmin)
+}
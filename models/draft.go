@@ -0,0 +1,23 @@
+package models
+
+// ReceiptDraft is a receipt read from text, e.g. by OCR, that has not been
+// validated yet
+type ReceiptDraft struct {
+	Receipt Receipt `json:"receipt"`
+	// Confidence maps receipt fields to how sure the parser is of them,
+	// from 0 for not found to 1
+	Confidence map[string]float64 `json:"confidence"`
+	// ItemConfidence has one entry per item in Receipt.Items
+	ItemConfidence []float64 `json:"itemConfidence"`
+	Warnings       []string  `json:"warnings,omitempty"`
+	// UnparsedLines are lines with an amount the parser could not place
+	UnparsedLines []string `json:"unparsedLines,omitempty"`
+}
+
+// ParseResponse answers POST /receipts/parse. ID is set when the draft was
+// processed, Error when processing was asked for but the draft is invalid.
+type ParseResponse struct {
+	Draft ReceiptDraft `json:"draft"`
+	ID    string       `json:"id,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// ReceiptEventType names a change to a stored receipt
+type ReceiptEventType string
+
+const (
+	// ReceiptProcessed follows every stored receipt, including ones held
+	// for fraud review
+	ReceiptProcessed ReceiptEventType = "ReceiptProcessed"
+	// ReceiptVoided means the receipt will never award points, today when
+	// a fraud review rejects it
+	ReceiptVoided ReceiptEventType = "ReceiptVoided"
+	// PointsAdjusted means the awarded points changed after processing,
+	// today when a fraud review releases held points
+	PointsAdjusted ReceiptEventType = "PointsAdjusted"
+)
+
+// reasons given on ReceiptVoided and PointsAdjusted events
+const (
+	ReasonReviewApproved = "review_approved"
+	ReasonReviewRejected = "review_rejected"
+)
+
+// ReceiptEvent is written to the outbox with the receipt change it
+// describes and published on the event bus once it is stored
+type ReceiptEvent struct {
+	ID         string           `json:"id"`
+	Type       ReceiptEventType `json:"type"`
+	ReceiptID  string           `json:"receiptId"`
+	ClientID   string           `json:"clientId,omitempty"`
+	OccurredAt time.Time        `json:"occurredAt"`
+	// Receipt is the receipt as stored by the change
+	Receipt ReceiptSummary `json:"receipt"`
+	// PreviousPoints is what the receipt awarded before a PointsAdjusted
+	PreviousPoints int64  `json:"previousPoints,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
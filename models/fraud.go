@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// FraudSignal is one heuristic that fired for a receipt
+type FraudSignal struct {
+	Rule   string  `json:"rule"`
+	Score  float64 `json:"score"`
+	Detail string  `json:"detail"`
+}
+
+// FraudAssessment is the combined fraud score of a receipt, between 0 and 1
+type FraudAssessment struct {
+	Score   float64       `json:"score"`
+	Signals []FraudSignal `json:"signals,omitempty"`
+	Held    bool          `json:"held"`
+}
+
+// ReviewItem is a held receipt as shown in the review queue
+type ReviewItem struct {
+	ID          string          `json:"id"`
+	ClientID    string          `json:"clientId,omitempty"`
+	RetailerID  string          `json:"retailerId,omitempty"`
+	Receipt     Receipt         `json:"receipt"`
+	Points      int64           `json:"points"`
+	Fraud       FraudAssessment `json:"fraud"`
+	SubmittedAt time.Time       `json:"submittedAt"`
+}
+
+// ReviewResult is the outcome of approving or rejecting a held receipt
+type ReviewResult struct {
+	ID     string        `json:"id"`
+	Status ReceiptStatus `json:"status"`
+}
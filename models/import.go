@@ -0,0 +1,26 @@
+package models
+
+// ImportResult answers POST /receipts/import. Receipts lists what was
+// processed, Errors what was not.
+type ImportResult struct {
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Receipts []ImportedReceipt `json:"receipts"`
+	Errors   []ImportError     `json:"errors,omitempty"`
+}
+
+// ImportedReceipt ties a receipt key from the file to the ID it was
+// processed under
+type ImportedReceipt struct {
+	Key  string `json:"key"`
+	ID   string `json:"id"`
+	Rows []int  `json:"rows"`
+}
+
+// ImportError is a problem with one or more rows of an import. Rows are
+// numbered as in a spreadsheet, the header is row 1.
+type ImportError struct {
+	Rows  []int  `json:"rows"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// quantityScale stores quantities in thousandths, three decimal places
+// cover goods sold by weight
+const quantityScale = 1000
+
+// MaxItemQuantity caps the units on one line. Units can count as items
+// for the points rules, so it is kept to what a till would ring up.
+const MaxItemQuantity = 1000
+
+const maxCategoryLength = 64
+
+var (
+	quantityRegex = regexp.MustCompile(`^\d+(\.\d{1,3})?$`)
+	skuRegex      = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,63}$`)
+	upcRegex      = regexp.MustCompile(`^(\d{8}|\d{12,14})$`)
+)
+
+// ParseQuantity converts a quantity like "2" or "1.25" into thousandths. An
+// empty quantity is one unit.
+func ParseQuantity(quantity string) (int64, error) {
+	if quantity == "" {
+		return quantityScale, nil
+	}
+	if !quantityRegex.MatchString(quantity) {
+		return 0, errors.New("Invalid item quantity format")
+	}
+
+	whole, fraction, _ := strings.Cut(quantity, ".")
+	units, err := strconv.ParseInt(whole, 10, 64)
+	thousandths, _ := strconv.ParseInt((fraction + "000")[:3], 10, 64)
+	value := units*quantityScale + thousandths
+	if err != nil || value > MaxItemQuantity*quantityScale {
+		return 0, fmt.Errorf("Item quantity cannot be above %d", MaxItemQuantity)
+	}
+	if value == 0 {
+		return 0, errors.New("Item quantity must be above zero")
+	}
+	return value, nil
+}
+
+// Units is the number of items the line counts as: the quantity when it is
+// a whole number, otherwise 1 as goods sold by weight are a single item.
+// Call on validated items only.
+func (i Item) Units() int64 {
+	quantity, err := ParseQuantity(i.Quantity)
+	if err != nil || quantity%quantityScale != 0 {
+		return 1
+	}
+	return quantity / quantityScale
+}
+
+// LinePrice is quantity times unitPrice in cents, rounded half up to the
+// cent
+func LinePrice(quantity, unitPrice string) (int64, error) {
+	units, err := ParseQuantity(quantity)
+	if err != nil {
+		return 0, err
+	}
+	cents, err := ParseCents(unitPrice)
+	if err != nil {
+		return 0, errors.New("Invalid item unit price format")
+	}
+	return (units*cents + quantityScale/2) / quantityScale, nil
+}
+
+// validateItemDetails checks the optional quantity, pricing and product
+// fields of an item whose description and price are already valid
+func validateItemDetails(item Item, policy CharacterPolicy) error {
+	if _, err := ParseQuantity(item.Quantity); err != nil {
+		return err
+	}
+
+	if item.UnitPrice != "" {
+		expected, err := LinePrice(item.Quantity, item.UnitPrice)
+		if err != nil {
+			return err
+		}
+		if price, _ := ParseCents(item.Price); price != expected {
+			return fmt.Errorf("Item price %s does not match quantity %s at %s each", item.Price, quantityOrOne(item.Quantity), item.UnitPrice)
+		}
+	}
+
+	if item.SKU != "" && !skuRegex.MatchString(item.SKU) {
+		return errors.New("Invalid item SKU")
+	}
+	if item.UPC != "" && !ValidUPC(item.UPC) {
+		return errors.New("Invalid item UPC")
+	}
+
+	if item.Category != "" {
+		if utf8.RuneCountInString(item.Category) > maxCategoryLength {
+			return fmt.Errorf("Item category is longer than %d characters", maxCategoryLength)
+		}
+		if err := policy.check(item.Category); err != nil {
+			return fmt.Errorf("Item category contains an %v", err)
+		}
+	}
+	return nil
+}
+
+func quantityOrOne(quantity string) string {
+	if quantity == "" {
+		return "1"
+	}
+	return quantity
+}
+
+// ValidUPC checks the length and the GS1 check digit: from the right, the
+// digits before the check digit are weighted 3, 1, 3, ...
+func ValidUPC(upc string) bool {
+	if !upcRegex.MatchString(upc) {
+		return false
+	}
+
+	sum := 0
+	for i := len(upc) - 2; i >= 0; i-- {
+		digit := int(upc[i] - '0')
+		if (len(upc)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(upc[len(upc)-1]-'0')
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// JobStatus tracks a receipt submitted with ?async=true
+type JobStatus string
+
+const (
+	JobPending    JobStatus = "pending"
+	JobProcessing JobStatus = "processing"
+	JobDone       JobStatus = "done"
+	JobFailed     JobStatus = "failed"
+)
+
+// Job is an asynchronous receipt submission. ReceiptID and Points are set
+// once it is done, Error once it failed.
+type Job struct {
+	ID     string    `json:"id"`
+	Status JobStatus `json:"status"`
+	// ClientID owns the job, only it and admins can poll it
+	ClientID  string `json:"-"`
+	ReceiptID string `json:"receiptId,omitempty"`
+	// Points is what the receipt awards, 0 while it is held for review
+	Points     *int64     `json:"points,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseCents converts an amount like "12.34" into cents, avoiding the
+// rounding errors of summing float dollars
+func ParseCents(amount string) (int64, error) {
+	dollars, cents, found := strings.Cut(amount, ".")
+	if !found || len(cents) != 2 {
+		return 0, errors.New("Amount must have exactly two decimal places")
+	}
+	// ParseInt takes a sign, which would read "-0.50" as 50 cents
+	if !isDigits(dollars) || !isDigits(cents) {
+		return 0, errors.New("Invalid amount")
+	}
+	d, err := strconv.ParseInt(dollars, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid amount")
+	}
+	c, err := strconv.ParseInt(cents, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid amount")
+	}
+	if d > (math.MaxInt64-c)/100 {
+		return 0, errors.New("Amount is too large")
+	}
+	return d*100 + c, nil
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// FormatCents is the inverse of ParseCents for amounts that are not
+// negative
+func FormatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,42 @@
+package models
+
+import "testing"
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		amount   string
+		expected int64
+		wantErr  bool
+	}{
+		{amount: "12.34", expected: 1234},
+		{amount: "0.05", expected: 5},
+		{amount: "92233720368547758.07", expected: 9223372036854775807},
+		{amount: "92233720368547758.08", wantErr: true},
+		{amount: "100000000000000000.00", wantErr: true},
+		{amount: "-0.50", wantErr: true},
+		{amount: "-1.00", wantErr: true},
+		{amount: "+1.00", wantErr: true},
+		{amount: "1.+5", wantErr: true},
+		{amount: "1.5", wantErr: true},
+		{amount: ".50", wantErr: true},
+		{amount: "12", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.amount, func(t *testing.T) {
+			cents, err := ParseCents(tc.amount)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %d", tc.amount, cents)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cents != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, cents)
+			}
+		})
+	}
+}
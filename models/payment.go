@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PaymentMethod is how a receipt was paid
+type PaymentMethod string
+
+const (
+	PaymentCash       PaymentMethod = "cash"
+	PaymentCreditCard PaymentMethod = "credit_card"
+	PaymentDebitCard  PaymentMethod = "debit_card"
+	// PaymentStoreCard is the retailer's own card
+	PaymentStoreCard    PaymentMethod = "store_card"
+	PaymentGiftCard     PaymentMethod = "gift_card"
+	PaymentMobileWallet PaymentMethod = "mobile_wallet"
+	PaymentOther        PaymentMethod = "other"
+)
+
+// PaymentMethods lists every accepted payment method
+var PaymentMethods = []PaymentMethod{
+	PaymentCash, PaymentCreditCard, PaymentDebitCard, PaymentStoreCard,
+	PaymentGiftCard, PaymentMobileWallet, PaymentOther,
+}
+
+// Valid reports whether m is one of PaymentMethods
+func (m PaymentMethod) Valid() bool {
+	for _, method := range PaymentMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Payment describes how a receipt was paid
+type Payment struct {
+	Method PaymentMethod `json:"method"`
+	// Last4 are the last four digits of the card, never the full number
+	Last4 string `json:"last4,omitempty"`
+}
+
+// Discount is a coupon or markdown taken off the subtotal
+type Discount struct {
+	Description string `json:"description"`
+	Amount      string `json:"amount"`
+}
+
+var last4Regex = regexp.MustCompile(`^\d{4}$`)
+
+// AdjustmentCents is what lies between the items and the total: tax and
+// tip minus discounts, in cents. Call on validated receipts only.
+func (r *Receipt) AdjustmentCents() int64 {
+	var cents int64
+	for _, amount := range []string{r.Tax, r.Tip} {
+		if amount != "" {
+			value, _ := ParseCents(amount)
+			cents += value
+		}
+	}
+	for _, discount := range r.Discounts {
+		value, _ := ParseCents(discount.Amount)
+		cents -= value
+	}
+	return cents
+}
+
+// validateAmounts checks the optional amounts and payment, and that they
+// reconcile with the already validated total when a subtotal is sent
+func (r *Receipt) validateAmounts(policy CharacterPolicy) error {
+	for _, field := range []struct{ name, amount string }{
+		{"Subtotal", r.Subtotal},
+		{"Tax", r.Tax},
+		{"Tip", r.Tip},
+	} {
+		if field.amount == "" {
+			continue
+		}
+		if _, err := ParseCents(field.amount); err != nil {
+			return fmt.Errorf("Invalid %s format", strings.ToLower(field.name))
+		}
+	}
+
+	for _, discount := range r.Discounts {
+		if strings.TrimSpace(discount.Description) == "" {
+			return errors.New("Discount description is required")
+		}
+		if err := policy.check(discount.Description); err != nil {
+			return fmt.Errorf("Discount description contains an %v", err)
+		}
+		amount, err := ParseCents(discount.Amount)
+		if err != nil {
+			return errors.New("Invalid discount amount format")
+		}
+		if amount == 0 {
+			return errors.New("Discount amount must be above zero")
+		}
+	}
+
+	if r.Payment != nil {
+		if !r.Payment.Method.Valid() {
+			return fmt.Errorf("Unknown payment method %q", r.Payment.Method)
+		}
+		if r.Payment.Last4 != "" && !last4Regex.MatchString(r.Payment.Last4) {
+			return errors.New("Payment last4 must be four digits")
+		}
+	}
+
+	if r.Subtotal == "" {
+		return nil
+	}
+	subtotal, _ := ParseCents(r.Subtotal)
+	total, _ := ParseCents(r.Total)
+	expected := subtotal + r.AdjustmentCents()
+	if expected < 0 {
+		return errors.New("Discounts cannot exceed the subtotal")
+	}
+	if expected != total {
+		return fmt.Errorf("Total %s does not match subtotal, discounts, tax and tip, expected %d.%02d", r.Total, expected/100, expected%100)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var promotionIDRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Promotion awards extra points on top of the seven base rules while it
+// runs. It is either a Multiplier of the base points or a flat Bonus.
+//
+// Stacking: bonuses from every matching promotion add up, but only the
+// largest matching multiplier applies and multipliers never compound. When
+// an Exclusive promotion matches, the exclusive promotion worth the most
+// points applies alone.
+type Promotion struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// RetailerID limits the promotion to one directory retailer, empty
+	// matches every receipt
+	RetailerID string `json:"retailerId,omitempty"`
+	// ItemPattern is a regular expression one of the item descriptions
+	// has to match, empty matches every receipt
+	ItemPattern string `json:"itemPattern,omitempty"`
+	// PaymentMethod limits the promotion to receipts paid this way, e.g.
+	// store_card for a store card bonus
+	PaymentMethod PaymentMethod `json:"paymentMethod,omitempty"`
+	// StartsAt and EndsAt bound the purchase time, EndsAt is exclusive
+	StartsAt time.Time `json:"startsAt"`
+	EndsAt   time.Time `json:"endsAt"`
+	// Multiplier scales the base points, e.g. 2 for double points
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// Bonus is a flat number of points
+	Bonus int64 `json:"bonus,omitempty"`
+	// PerItem awards Bonus for every item matching ItemPattern instead of
+	// once per receipt
+	PerItem   bool `json:"perItem,omitempty"`
+	Exclusive bool `json:"exclusive,omitempty"`
+}
+
+// Validate checks the promotion can be stored and evaluated
+func (p *Promotion) Validate() error {
+	if !promotionIDRegex.MatchString(p.ID) {
+		return errors.New("Promotion id must be lowercase letters, digits and dashes")
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("Promotion name is required")
+	}
+	if p.StartsAt.IsZero() || p.EndsAt.IsZero() {
+		return errors.New("Promotion needs a start and an end")
+	}
+	if !p.EndsAt.After(p.StartsAt) {
+		return errors.New("Promotion must end after it starts")
+	}
+
+	switch {
+	case p.Multiplier != 0 && p.Bonus != 0:
+		return errors.New("Promotion is either a multiplier or a bonus, not both")
+	case p.Multiplier != 0:
+		if p.Multiplier <= 1 {
+			return errors.New("Promotion multiplier must be above 1")
+		}
+		if p.PerItem {
+			return errors.New("Only bonus promotions can be awarded per item")
+		}
+	case p.Bonus <= 0:
+		return errors.New("Promotion needs a multiplier or a positive bonus")
+	}
+
+	if p.PaymentMethod != "" && !p.PaymentMethod.Valid() {
+		return fmt.Errorf("Unknown payment method %q", p.PaymentMethod)
+	}
+	if p.PerItem && p.ItemPattern == "" {
+		return errors.New("Per item promotions need an item pattern")
+	}
+	if p.ItemPattern != "" {
+		if _, err := regexp.Compile(p.ItemPattern); err != nil {
+			return fmt.Errorf("Invalid item pattern %q", p.ItemPattern)
+		}
+	}
+	return nil
+}
+
+// Active reports whether a purchase at the given time falls in the window
+func (p *Promotion) Active(purchased time.Time) bool {
+	return !purchased.Before(p.StartsAt) && purchased.Before(p.EndsAt)
+}
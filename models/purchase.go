@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Canonical layouts of a normalized receipt. purchaseTime is also accepted
+// without seconds.
+const (
+	PurchaseDateLayout = "2006-01-02"
+	PurchaseTimeLayout = "15:04:05"
+	shortTimeLayout    = "15:04"
+)
+
+// PurchaseTimestamp is the moment of purchase in the store's local time.
+// Normalized receipts return the value parsed during validation.
+func (r *Receipt) PurchaseTimestamp() (time.Time, error) {
+	if !r.purchased.IsZero() {
+		return r.purchased, nil
+	}
+	return r.parsePurchaseTime()
+}
+
+// Normalize parses whichever purchase time form the receipt was sent in and
+// rewrites it canonically in the store's zone: purchaseDate as YYYY-MM-DD,
+// purchaseTime as HH:MM:SS and purchasedAt as RFC 3339.
+func (r *Receipt) Normalize() error {
+	purchased, err := r.parsePurchaseTime()
+	if err != nil {
+		return err
+	}
+
+	// a bare purchasedAt names its zone only through the offset
+	if r.TimeZone == "" && r.PurchasedAt != "" {
+		r.TimeZone = purchased.Format("Z07:00")
+	}
+	r.TimestampZone = ""
+	r.PurchaseDate = purchased.Format(PurchaseDateLayout)
+	r.PurchaseTime = purchased.Format(PurchaseTimeLayout)
+	r.PurchasedAt = purchased.Format(time.RFC3339)
+	r.purchased = purchased
+	return nil
+}
+
+func (r *Receipt) parsePurchaseTime() (time.Time, error) {
+	store, err := LoadZone(r.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if r.PurchasedAt != "" {
+		return r.parsePurchasedAt(store)
+	}
+
+	recorded := store
+	if r.TimestampZone != "" {
+		if recorded, err = LoadZone(r.TimestampZone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	purchased, err := parseWallClock(r.PurchaseDate, r.PurchaseTime, recorded)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return purchased.In(store), nil
+}
+
+func (r *Receipt) parsePurchasedAt(store *time.Location) (time.Time, error) {
+	if r.TimestampZone != "" {
+		return time.Time{}, errors.New("timestampZone cannot be combined with purchasedAt, which carries its own offset")
+	}
+	purchased, err := time.Parse(time.RFC3339, r.PurchasedAt)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid purchasedAt, should be an RFC 3339 timestamp")
+	}
+
+	if r.TimeZone != "" {
+		purchased = purchased.In(store)
+	} else {
+		// pin the offset, time.Parse would swap in the server's zone when
+		// the offsets happen to match
+		_, offset := purchased.Zone()
+		purchased = purchased.In(time.FixedZone(purchased.Format("Z07:00"), offset))
+	}
+
+	// POS systems that send both forms must agree with themselves
+	if r.PurchaseDate != "" || r.PurchaseTime != "" {
+		printed, err := parseWallClock(r.PurchaseDate, r.PurchaseTime, purchased.Location())
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !printed.Equal(purchased) {
+			return time.Time{}, errors.New("purchasedAt does not match purchaseDate and purchaseTime")
+		}
+	}
+	return purchased, nil
+}
+
+// parseWallClock reads a printed date and time as wall clock time in loc
+func parseWallClock(date, clock string, loc *time.Location) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, errors.New("Purchase date is required")
+	}
+	day, err := time.Parse(PurchaseDateLayout, date)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid purchase date format, should be YYYY-MM-DD")
+	}
+
+	if clock == "" {
+		return time.Time{}, errors.New("Purchase time is required")
+	}
+	at, err := time.Parse(PurchaseTimeLayout, clock)
+	if err != nil {
+		if at, err = time.Parse(shortTimeLayout, clock); err != nil {
+			return time.Time{}, errors.New("Invalid purchase time format, should be HH:MM or HH:MM:SS")
+		}
+	}
+
+	purchased := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), at.Second(), 0, loc)
+	// time.Date moves wall clock times that fall in a daylight saving gap
+	// instead of failing, so a changed hour means the time never existed
+	if purchased.Hour() != at.Hour() || purchased.Minute() != at.Minute() {
+		return time.Time{}, fmt.Errorf("Purchase time %s does not exist in %s", clock, loc)
+	}
+	return purchased, nil
+}
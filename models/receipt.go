@@ -1,27 +1,145 @@
 package models
 
+import "time"
+
 type Receipt struct {
 	Retailer     string `json:"retailer"`
 	PurchaseDate string `json:"purchaseDate"`
+	// PurchaseTime is HH:MM or HH:MM:SS
 	PurchaseTime string `json:"purchaseTime"`
-	Items        []Item `json:"items"`
-	Total        string `json:"total"`
+	// PurchasedAt is an RFC 3339 timestamp that can replace PurchaseDate
+	// and PurchaseTime
+	PurchasedAt string `json:"purchasedAt,omitempty"`
+	Items       []Item `json:"items"`
+	Total       string `json:"total"`
+	// Subtotal, Tax, Discounts and Tip are optional. When Subtotal is sent
+	// they have to reconcile: Subtotal - Discounts + Tax + Tip = Total.
+	Subtotal  string     `json:"subtotal,omitempty"`
+	Tax       string     `json:"tax,omitempty"`
+	Discounts []Discount `json:"discounts,omitempty"`
+	Tip       string     `json:"tip,omitempty"`
+	Payment   *Payment   `json:"payment,omitempty"`
+	// TimeZone is the store's zone, an IANA name or UTC offset. Time based
+	// rules are evaluated in it. Empty means the receipt is naive local time.
+	TimeZone string `json:"timeZone,omitempty"`
+	// TimestampZone is the zone PurchaseDate/PurchaseTime were recorded in,
+	// for retailers that send UTC. Defaults to TimeZone.
+	TimestampZone string `json:"timestampZone,omitempty"`
+
+	// purchased is set by Normalize
+	purchased time.Time
 }
 
 type Item struct {
 	ShortDescription string `json:"shortDescription"`
-	Price            string `json:"price"`
+	// Price is what was paid for the whole line
+	Price string `json:"price"`
+	// Quantity is the number of units on the line, up to three decimal
+	// places for goods sold by weight. Empty means 1.
+	Quantity string `json:"quantity,omitempty"`
+	// UnitPrice is the price of one unit, Price has to equal Quantity
+	// times UnitPrice rounded to the cent
+	UnitPrice string `json:"unitPrice,omitempty"`
+	SKU       string `json:"sku,omitempty"`
+	// UPC is a GTIN-8, UPC-A, EAN-13 or GTIN-14 barcode number
+	UPC      string `json:"upc,omitempty"`
+	Category string `json:"category,omitempty"`
 }
 
 type ReceiptResponse struct {
 	ID string `json:"id"`
 }
 
+// PointsResponse carries the awarded points. Status is only set when the
+// points are not (yet) awarded, e.g. while the receipt is held for review.
 type PointsResponse struct {
-	Points int64 `json:"points"`
+	Points int64         `json:"points"`
+	Status ReceiptStatus `json:"status,omitempty"`
+	// Breakdown is only sent when asked for
+	Breakdown []PointsLine `json:"breakdown,omitempty"`
 }
 
+// PointsLine is one rule's or promotion's share of a receipt's points
+type PointsLine struct {
+	Rule   string `json:"rule"`
+	Points int64  `json:"points"`
+}
+
+// SumPoints adds up a breakdown
+func SumPoints(lines []PointsLine) int64 {
+	var total int64
+	for _, line := range lines {
+		total += line.Points
+	}
+	return total
+}
+
+// ReceiptStatus tracks whether a receipt's points are awarded
+type ReceiptStatus string
+
+const (
+	StatusActive        ReceiptStatus = "active"
+	StatusPendingReview ReceiptStatus = "pending_review"
+	StatusRejected      ReceiptStatus = "rejected"
+)
+
 type ReceiptWithPoints struct {
+	ID      string
 	Receipt Receipt
 	Points  int64
+	// Breakdown lists the base rules and promotions that make up Points
+	Breakdown []PointsLine
+	ClientID  string
+	// RetailerID is the directory retailer the name matched, if any
+	RetailerID  string
+	Status      ReceiptStatus
+	Fraud       FraudAssessment
+	SubmittedAt time.Time
+}
+
+// ReceiptDetail is a stored receipt as returned by GET /receipts/{id}
+type ReceiptDetail struct {
+	ID          string        `json:"id"`
+	Receipt     Receipt       `json:"receipt"`
+	Points      int64         `json:"points"`
+	Status      ReceiptStatus `json:"status"`
+	RetailerID  string        `json:"retailerId,omitempty"`
+	SubmittedAt time.Time     `json:"submittedAt"`
+}
+
+// ReceiptSummary is one receipt in a GET /receipts page
+type ReceiptSummary struct {
+	ID           string        `json:"id"`
+	Retailer     string        `json:"retailer"`
+	PurchaseDate string        `json:"purchaseDate"`
+	Total        string        `json:"total"`
+	Points       int64         `json:"points"`
+	Status       ReceiptStatus `json:"status"`
+	RetailerID   string        `json:"retailerId,omitempty"`
+	SubmittedAt  time.Time     `json:"submittedAt"`
+}
+
+// ReceiptList is a page of GET /receipts. Total counts the receipts on
+// every page.
+type ReceiptList struct {
+	Receipts []ReceiptSummary `json:"receipts"`
+	Total    int              `json:"total"`
+}
+
+// CurrentStatus is Status, with records saved without one, from before the
+// review queue, reported as active
+func (r ReceiptWithPoints) CurrentStatus() ReceiptStatus {
+	if r.Status == "" {
+		return StatusActive
+	}
+	return r.Status
+}
+
+// AwardedPoints is what the client is credited: nothing while the receipt
+// is held or after it was rejected
+func (r ReceiptWithPoints) AwardedPoints() int64 {
+	if r.Status != StatusActive && r.Status != "" {
+		return 0
+	}
+	return r.Points
 }
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var retailerIDRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Retailer is an entry in the retailer directory. Receipts whose retailer
+// name matches it are tagged with its ID, so "Target", "TARGET" and
+// "Target Store #1234" count as the same retailer.
+type Retailer struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	// Aliases match retailer names ignoring case, spacing and punctuation
+	Aliases []string `json:"aliases,omitempty"`
+	// Patterns are regular expressions matched against the name as printed,
+	// e.g. `(?i)^target\b`
+	Patterns []string `json:"patterns,omitempty"`
+	Category string   `json:"category,omitempty"`
+	// TimeZone is used for receipts from this retailer that do not send one
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Validate checks the entry can be stored and matched
+func (r *Retailer) Validate() error {
+	if !retailerIDRegex.MatchString(r.ID) {
+		return errors.New("Retailer id must be lowercase letters, digits and dashes")
+	}
+	if strings.TrimSpace(r.DisplayName) == "" {
+		return errors.New("Retailer display name is required")
+	}
+	for _, alias := range r.Aliases {
+		if RetailerKey(alias) == "" {
+			return fmt.Errorf("Retailer alias %q has no letters or digits", alias)
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid retailer pattern %q", pattern)
+		}
+	}
+	if _, err := LoadZone(r.TimeZone); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetailerKey reduces a retailer name to lowercase letters and digits
+// separated by single spaces, the form aliases are compared in
+func RetailerKey(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(NormalizeText(name)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,14 @@
+package models
+
+// StorageStats reports how a bounded receipt store is doing. Evicted
+// counts receipts pushed out of memory, Spilled the ones of those still
+// readable from the cold store.
+type StorageStats struct {
+	Receipts      int    `json:"receipts"`
+	Bytes         int64  `json:"bytes"`
+	Evicted       uint64 `json:"evicted"`
+	Spilled       uint64 `json:"spilled"`
+	SpillFailures uint64 `json:"spillFailures"`
+	Expired       uint64 `json:"expired"`
+	ColdHits      uint64 `json:"coldHits"`
+}
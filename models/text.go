@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultPunctuation covers names like "Trader Joe's", "M&M Corner Market"
+// and "7-Eleven #42"
+const DefaultPunctuation = "-&'’.#"
+
+// CharacterPolicy decides which characters retailer names and item
+// descriptions may contain. Text is NFC normalized before it is checked.
+// The zero value accepts letters and digits in any script.
+type CharacterPolicy struct {
+	// ASCIIOnly rejects letters and digits outside ASCII
+	ASCIIOnly bool
+	// Punctuation lists the symbols allowed besides letters, digits, spaces
+	// and underscores, DefaultPunctuation when empty
+	Punctuation string
+}
+
+func (p CharacterPolicy) allows(r rune) bool {
+	punctuation := p.Punctuation
+	if punctuation == "" {
+		punctuation = DefaultPunctuation
+	}
+
+	switch {
+	case unicode.IsSpace(r), r == '_', strings.ContainsRune(punctuation, r):
+		return true
+	case r < utf8.RuneSelf:
+		return isASCIIAlphanumeric(r)
+	case p.ASCIIOnly:
+		return false
+	}
+	// marks cover accents that have no precomposed form
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// check reports the first character the policy rejects
+func (p CharacterPolicy) check(text string) error {
+	for _, r := range text {
+		if !p.allows(r) {
+			return fmt.Errorf("invalid character %q", r)
+		}
+	}
+	return nil
+}
+
+// Clean replaces the characters the policy rejects with spaces and
+// collapses runs of whitespace, for text from sources like OCR. It reports
+// whether anything was replaced.
+func (p CharacterPolicy) Clean(text string) (string, bool) {
+	replaced := false
+	cleaned := strings.Map(func(r rune) rune {
+		if p.allows(r) {
+			return r
+		}
+		replaced = true
+		return ' '
+	}, NormalizeText(text))
+	return strings.Join(strings.Fields(cleaned), " "), replaced
+}
+
+// NormalizeText puts text in Unicode NFC, so an "é" typed as "e" plus a
+// combining accent is one character like the precomposed form
+func NormalizeText(text string) string {
+	return norm.NFC.String(text)
+}
+
+// CountAlphanumeric counts the letters and digits in text, in any script
+func CountAlphanumeric(text string) int {
+	count := 0
+	for _, r := range NormalizeText(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// TextLength is the number of characters in text once trimmed and NFC
+// normalized
+func TextLength(text string) int {
+	return utf8.RuneCountInString(strings.TrimSpace(NormalizeText(text)))
+}
+
+func isASCIIAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// the runtime image ships without a zoneinfo database
+	_ "time/tzdata"
+)
+
+var utcOffsetRegex = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// LoadZone resolves a receipt time zone: an IANA name such as
+// "America/Chicago", a fixed UTC offset such as "+05:30", or "UTC"/"Z".
+// An empty name is UTC, which keeps naive receipts behaving as before.
+func LoadZone(name string) (*time.Location, error) {
+	switch strings.ToUpper(name) {
+	case "", "UTC", "Z":
+		return time.UTC, nil
+	}
+
+	if match := utcOffsetRegex.FindStringSubmatch(name); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("Invalid UTC offset %q", name)
+		}
+		offset := hours*3600 + minutes*60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+
+	// time.LoadLocation also accepts "Local", which would tie points to
+	// whatever zone the server happens to run in
+	if name == "Local" {
+		return nil, fmt.Errorf("Unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown time zone %q", name)
+	}
+	return loc, nil
+}
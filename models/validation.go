@@ -2,60 +2,112 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/ycChu711/receipt-processor/utils"
 )
 
+// ValidationRules bound what Validate accepts beyond the field formats
+type ValidationRules struct {
+	Clock utils.Clock
+	// MaxAge rejects purchases older than this, zero means no limit
+	MaxAge time.Duration
+	// ClockSkew is how far in the future a purchase may appear. Purchase
+	// times are store local and compared as UTC, so this has to cover the
+	// largest UTC offset (+14h) on top of any real clock drift.
+	ClockSkew time.Duration
+	// Characters allowed in retailer names and item descriptions
+	Characters CharacterPolicy
+}
+
+// DefaultValidationRules accept purchases from the last ten years
+var DefaultValidationRules = ValidationRules{
+	Clock:     utils.SystemClock{},
+	MaxAge:    10 * 365 * 24 * time.Hour,
+	ClockSkew: 24 * time.Hour,
+}
+
+// Validate checks the receipt against DefaultValidationRules
 func (r *Receipt) Validate() error {
+	return r.ValidateWith(DefaultValidationRules)
+}
+
+// ValidateWith checks the receipt fields and that the purchase date falls
+// within the bounds set by rules. Text and the purchase time are normalized
+// in place.
+func (r *Receipt) ValidateWith(rules ValidationRules) error {
+
+	r.normalizeText()
+
+	if err := validateRetailer(r.Retailer, rules.Characters); err != nil {
+		return err
+	}
 
-	if err := validateRetailer(r.Retailer); err != nil {
+	// rewrites the purchase time fields, rules only read the parsed value
+	if err := r.Normalize(); err != nil {
+		return err
+	}
+	if err := validateDateBounds(r.purchased, rules); err != nil {
 		return err
 	}
 
-	if err := validateDateTime(r.PurchaseDate, r.PurchaseTime); err != nil {
+	if err := validateItems(r.Items, rules.Characters); err != nil {
 		return err
 	}
 
-	if err := validateItems(r.Items); err != nil {
+	if err := validateTotal(r.Total); err != nil {
 		return err
 	}
 
-	return validateTotal(r.Total)
+	return r.validateAmounts(rules.Characters)
+
+}
 
+// normalizeText puts names, descriptions and categories in NFC so the
+// character policy and the rules see one form of each character
+func (r *Receipt) normalizeText() {
+	r.Retailer = NormalizeText(r.Retailer)
+	for i := range r.Items {
+		r.Items[i].ShortDescription = NormalizeText(r.Items[i].ShortDescription)
+		r.Items[i].Category = NormalizeText(r.Items[i].Category)
+	}
+	for i := range r.Discounts {
+		r.Discounts[i].Description = NormalizeText(r.Discounts[i].Description)
+	}
 }
 
-func validateRetailer(retailer string) error {
+func validateRetailer(retailer string, policy CharacterPolicy) error {
 	if strings.TrimSpace(retailer) == "" {
 		return errors.New("Retailer is required")
 	}
-	retailerRegex := regexp.MustCompile(`^[\w\s\-&]+$`)
-	if !retailerRegex.MatchString(retailer) {
-		return errors.New("Retailer must be alphanumeric")
+	if err := policy.check(retailer); err != nil {
+		return fmt.Errorf("Retailer contains an %v", err)
 	}
 	return nil
 }
 
-func validateDateTime(date, purchaseTime string) error {
-
-	if date == "" {
-		return errors.New("Purchase date is required")
-	}
-	if _, err := time.Parse("2006-01-02", date); err != nil {
-		return errors.New("Invalid purchase date format, should be YYYY-MM-DD")
+// validateDateBounds compares instants, so a purchase with a known zone is
+// held to the exact clock and naive ones lean on ClockSkew
+func validateDateBounds(purchased time.Time, rules ValidationRules) error {
+	clock := rules.Clock
+	if clock == nil {
+		clock = utils.SystemClock{}
 	}
+	now := clock.Now().UTC()
 
-	if purchaseTime == "" {
-		return errors.New("Purchase time is required")
+	if purchased.After(now.Add(rules.ClockSkew)) {
+		return errors.New("Purchase date cannot be in the future")
 	}
-	if _, err := time.Parse("15:04", purchaseTime); err != nil {
-		return errors.New("Invalid purchase time format, should be HH:MM")
+	if rules.MaxAge > 0 && purchased.Before(now.Add(-rules.MaxAge)) {
+		return errors.New("Purchase date is too old")
 	}
-
 	return nil
 }
 
-func validateItems(items []Item) error {
+func validateItems(items []Item, policy CharacterPolicy) error {
 	if len(items) == 0 {
 		return errors.New("Need at least one item")
 	}
@@ -65,9 +117,8 @@ func validateItems(items []Item) error {
 			return errors.New("Item description is required")
 		}
 
-		descRegex := regexp.MustCompile(`^[\w\s\-&]+$`)
-		if !descRegex.MatchString(item.ShortDescription) {
-			return errors.New("Item short description contains invalid characters")
+		if err := policy.check(item.ShortDescription); err != nil {
+			return fmt.Errorf("Item short description contains an %v", err)
 		}
 
 		if item.Price == "" {
@@ -78,6 +129,10 @@ func validateItems(items []Item) error {
 		if !priceRegex.MatchString(item.Price) {
 			return errors.New("Invalid item price format")
 		}
+
+		if err := validateItemDetails(item, policy); err != nil {
+			return err
+		}
 	}
 	return nil
 }
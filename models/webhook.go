@@ -0,0 +1,154 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var webhookIDRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// MinWebhookSecretLength keeps webhook secrets long enough that signatures
+// cannot be guessed
+const MinWebhookSecretLength = 16
+
+// WebhookEvent names a receipt state change subscribers can be told about
+type WebhookEvent string
+
+const (
+	// EventReceiptProcessed is sent for every stored receipt, including
+	// ones held for fraud review
+	EventReceiptProcessed WebhookEvent = "receipt.processed"
+	EventReceiptApproved  WebhookEvent = "receipt.approved"
+	EventReceiptRejected  WebhookEvent = "receipt.rejected"
+)
+
+// WebhookEvents lists every event a subscription can ask for
+var WebhookEvents = []WebhookEvent{EventReceiptProcessed, EventReceiptApproved, EventReceiptRejected}
+
+// WebhookSubscription sends the Events it lists to URL, signed with Secret
+type WebhookSubscription struct {
+	ID     string         `json:"id"`
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+	// Secret is only ever read from requests, responses leave it out
+	Secret string `json:"secret,omitempty"`
+}
+
+// Validate checks the subscription can be stored and delivered to, and
+// that its URL does not name a loopback, private or link-local host
+func (s *WebhookSubscription) Validate() error {
+	if err := s.ValidateFields(); err != nil {
+		return err
+	}
+	target, _ := url.Parse(s.URL)
+	host := strings.ToLower(target.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("Webhook url must not point at a private address")
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicAddress(ip) {
+		return errors.New("Webhook url must not point at a private address")
+	}
+	return nil
+}
+
+// ValidateFields is Validate without the check on where the URL points,
+// for receivers on a private network
+func (s *WebhookSubscription) ValidateFields() error {
+	if !webhookIDRegex.MatchString(s.ID) {
+		return errors.New("Webhook id must be lowercase letters, digits and dashes")
+	}
+	// GET /admin/webhooks/dead-letters would hide it
+	if s.ID == "dead-letters" {
+		return errors.New("Webhook id dead-letters is reserved")
+	}
+	target, err := url.Parse(s.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("Webhook url must be an absolute http or https URL")
+	}
+	if len(s.Events) == 0 {
+		return errors.New("Webhook needs at least one event")
+	}
+	for _, event := range s.Events {
+		if !event.Valid() {
+			return errors.New("Unknown webhook event " + string(event))
+		}
+	}
+	if len(s.Secret) < MinWebhookSecretLength {
+		return errors.New("Webhook secret must be at least 16 characters")
+	}
+	return nil
+}
+
+// PublicAddress reports whether ip may receive webhooks. Loopback,
+// private, link-local, multicast and unspecified addresses may not, as
+// they reach the service's own host and network.
+func PublicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// Wants reports whether the subscription asked for event
+func (s *WebhookSubscription) Wants(event WebhookEvent) bool {
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether e is a known event
+func (e WebhookEvent) Valid() bool {
+	for _, known := range WebhookEvents {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the JSON body posted to a subscription
+type WebhookPayload struct {
+	// ID identifies the delivery, receivers can use it to drop repeats
+	ID         string       `json:"id"`
+	Event      WebhookEvent `json:"event"`
+	OccurredAt time.Time    `json:"occurredAt"`
+	// ClientID is the API client that submitted the receipt
+	ClientID string         `json:"clientId,omitempty"`
+	Receipt  ReceiptSummary `json:"receipt"`
+}
+
+// DeliveryStatus tracks a webhook delivery through its retries
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryDead means every attempt failed, the delivery is on the
+	// dead-letter list until it is retried by hand
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is one event sent to one subscription
+type WebhookDelivery struct {
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscriptionId"`
+	Event          WebhookEvent   `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	// Payload is the exact body sent on every attempt
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+	// LastStatusCode is the receiver's answer to the last attempt, zero
+	// when it could not be reached
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
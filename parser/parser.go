@@ -0,0 +1,526 @@
+// Package parser turns plain text receipts, such as OCR output, into draft
+// receipts with a confidence for every field. It understands the common
+// layout of a retailer header, a date and time line, item lines ending in
+// a price, and subtotal, tax and total lines. Drafts still go through
+// validation before they are processed.
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// Field names used as keys of ReceiptDraft.Confidence
+const (
+	FieldRetailer     = "retailer"
+	FieldPurchaseDate = "purchaseDate"
+	FieldPurchaseTime = "purchaseTime"
+	FieldItems        = "items"
+	FieldTotal        = "total"
+	FieldSubtotal     = "subtotal"
+	FieldTax          = "tax"
+	FieldTip          = "tip"
+	FieldDiscounts    = "discounts"
+	FieldPayment      = "payment"
+)
+
+// minSKULength keeps numbers in descriptions, like "KLARBRUNN 12-PK 12",
+// from being taken for product codes
+const minSKULength = 6
+
+const months = `(JAN|FEB|MAR|APR|MAY|JUN|JUL|AUG|SEP|OCT|NOV|DEC)[A-Z]*\.?`
+
+var (
+	// an amount closing a line, negative with a leading or trailing minus,
+	// optionally followed by a tax flag like "T" or "FN"
+	amountRegex    = regexp.MustCompile(`(?i)(?:^|\s)(-)?\$?\s?(\d{1,3}(?:,\d{3})+\.\d{2}|\d+\.\d{2})(-)?(?:\s+[A-Z]{1,2})?$`)
+	separatorRegex = regexp.MustCompile(`^[-=*_~.#\s]+$`)
+
+	isoDateRegex      = regexp.MustCompile(`\b(\d{4})[-/.](\d{2})[-/.](\d{2})\b`)
+	slashDateRegex    = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{4}|\d{2})\b`)
+	monthDateRegex    = regexp.MustCompile(`(?i)\b` + months + `\s+(\d{1,2}),?\s+(\d{4})\b`)
+	dayMonthDateRegex = regexp.MustCompile(`(?i)\b(\d{1,2})\s+` + months + `,?\s+(\d{4})\b`)
+	timeRegex         = regexp.MustCompile(`(?i)\b(\d{1,2}):(\d{2})(?::(\d{2}))?(?:\s*([AP])\.?M\b\.?)?`)
+
+	// a tax flag like "FN" printed in its own column before the price
+	flagRegex = regexp.MustCompile(`\s{2,}[A-Z]{1,2}$`)
+	// "2 X GATORADE" and "2 @ GATORADE"
+	quantityPrefixRegex = regexp.MustCompile(`(?i)^(\d{1,4})\s*[X@]\s+(.+)$`)
+	// "GATORADE 2 @ 2.25" and "BANANAS 1.452 LB @ 0.60/LB"
+	inlineUnitRegex = regexp.MustCompile(`(?i)\s+(\d+(?:\.\d{1,3})?)\s*(?:LBS?|KG|EA)?\s*@\s*\$?(\d+\.\d{2})(?:\s*/\s*(?:LBS?|KG|EA))?$`)
+	// a quantity line below its item, "2 @ 2.25" or "1.452 LB @ 0.60 /LB"
+	quantityLineRegex = regexp.MustCompile(`(?i)^(\d+(?:\.\d{1,3})?)\s*(?:LBS?|KG|EA)?\s*@\s*\$?(\d+\.\d{2})(?:\s*/\s*(?:LBS?|KG|EA))?$`)
+	last4Regex        = regexp.MustCompile(`(?i)(?:\*+|X{2,}|ENDING IN\s*|#)\s*(\d{4})\b`)
+)
+
+// paymentKeywords map receipt wording to payment methods, checked in order
+var paymentKeywords = []struct {
+	phrase string
+	method models.PaymentMethod
+}{
+	{"STORE CARD", models.PaymentStoreCard},
+	{"GIFT CARD", models.PaymentGiftCard},
+	{"APPLE PAY", models.PaymentMobileWallet},
+	{"GOOGLE PAY", models.PaymentMobileWallet},
+	{"SAMSUNG PAY", models.PaymentMobileWallet},
+	{"DEBIT", models.PaymentDebitCard},
+	{"VISA", models.PaymentCreditCard},
+	{"MASTERCARD", models.PaymentCreditCard},
+	{"AMEX", models.PaymentCreditCard},
+	{"AMERICAN EXPRESS", models.PaymentCreditCard},
+	{"DISCOVER", models.PaymentCreditCard},
+	{"CREDIT", models.PaymentCreditCard},
+	{"CASH", models.PaymentCash},
+}
+
+// Parser reads receipt text. Descriptions are cleaned with its character
+// policy so they pass validation.
+type Parser struct {
+	policy models.CharacterPolicy
+}
+
+func New(policy models.CharacterPolicy) *Parser {
+	return &Parser{policy: policy}
+}
+
+// Parse reads a receipt from text. It never fails: fields it cannot find
+// are left empty with a confidence of 0 and a warning.
+func (p *Parser) Parse(text string) models.ReceiptDraft {
+	s := &parseState{
+		parser: p,
+		draft: models.ReceiptDraft{
+			Receipt:        models.Receipt{Items: []models.Item{}},
+			Confidence:     map[string]float64{},
+			ItemConfidence: []float64{},
+		},
+	}
+	for _, line := range strings.Split(text, "\n") {
+		s.line(strings.TrimSpace(line))
+	}
+	s.finish()
+	return s.draft
+}
+
+// parseState is one pass over a receipt's lines
+type parseState struct {
+	parser *Parser
+	draft  models.ReceiptDraft
+
+	contentLines int
+	subtotal     int64
+	tax          int64
+	tip          int64
+	total        int64
+	foundTotal   bool
+}
+
+func (s *parseState) line(line string) {
+	if line == "" || separatorRegex.MatchString(line) {
+		return
+	}
+	s.contentLines++
+
+	if m := quantityLineRegex.FindStringSubmatch(line); m != nil {
+		s.quantityLine(line, m[1], m[2])
+		return
+	}
+	if loc := amountRegex.FindStringSubmatchIndex(line); loc != nil {
+		m := amountRegex.FindStringSubmatch(line)
+		cents, _ := models.ParseCents(strings.ReplaceAll(m[2], ",", ""))
+		s.amountLine(line, strings.TrimSpace(line[:loc[0]]), cents, m[1] != "" || m[3] != "")
+		return
+	}
+	if s.dateTime(line) {
+		return
+	}
+	if s.draft.Receipt.Retailer == "" && len(s.draft.Receipt.Items) == 0 {
+		s.retailer(line)
+		return
+	}
+	s.payment(line)
+}
+
+// retailer takes the first line with a letter as the retailer name
+func (s *parseState) retailer(line string) {
+	if !strings.ContainsFunc(line, unicode.IsLetter) {
+		return
+	}
+
+	confidence := 0.9
+	if s.contentLines > 1 {
+		confidence = 0.6
+	}
+	if upper := strings.ToUpper(line); strings.HasPrefix(upper, "WELCOME TO ") {
+		line = line[len("WELCOME TO "):]
+		confidence -= 0.1
+	}
+	name, replaced := s.parser.policy.Clean(line)
+	if replaced {
+		confidence -= 0.1
+	}
+	s.draft.Receipt.Retailer = name
+	s.draft.Confidence[FieldRetailer] = confidence
+}
+
+// dateTime picks up the first date and time, reporting whether the line
+// held either
+func (s *parseState) dateTime(line string) bool {
+	receipt := &s.draft.Receipt
+
+	date, dateConfidence, dateFound := parseDate(line)
+	if dateFound && receipt.PurchaseDate == "" {
+		receipt.PurchaseDate = date
+		s.draft.Confidence[FieldPurchaseDate] = dateConfidence
+	}
+
+	clock, timeConfidence, timeFound := parseTime(line)
+	if timeFound {
+		// a time next to the date beats one found elsewhere, e.g. in the
+		// store's opening hours
+		if !dateFound {
+			timeConfidence -= 0.2
+		}
+		if timeConfidence > s.draft.Confidence[FieldPurchaseTime] {
+			receipt.PurchaseTime = clock
+			s.draft.Confidence[FieldPurchaseTime] = timeConfidence
+		}
+	}
+	return dateFound || timeFound
+}
+
+// parseDate returns a date in the line as YYYY-MM-DD
+func parseDate(line string) (string, float64, bool) {
+	if m := isoDateRegex.FindStringSubmatch(line); m != nil {
+		return formatDate(atoi(m[1]), atoi(m[2]), atoi(m[3]), 0.95)
+	}
+	if m := monthDateRegex.FindStringSubmatch(line); m != nil {
+		return formatDate(atoi(m[3]), monthNumber(m[1]), atoi(m[2]), 0.9)
+	}
+	if m := dayMonthDateRegex.FindStringSubmatch(line); m != nil {
+		return formatDate(atoi(m[3]), monthNumber(m[2]), atoi(m[1]), 0.9)
+	}
+	if m := slashDateRegex.FindStringSubmatch(line); m != nil {
+		first, second, year := atoi(m[1]), atoi(m[2]), atoi(m[3])
+		confidence := 0.85
+		if len(m[3]) == 2 {
+			year += 2000
+			confidence = 0.75
+		}
+		// US month first, unless that cannot be a month
+		if first > 12 && second <= 12 {
+			return formatDate(year, second, first, confidence-0.15)
+		}
+		return formatDate(year, first, second, confidence)
+	}
+	return "", 0, false
+}
+
+func formatDate(year, month, day int, confidence float64) (string, float64, bool) {
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return "", 0, false
+	}
+	return date.Format(models.PurchaseDateLayout), confidence, true
+}
+
+func monthNumber(name string) int {
+	return strings.Index("JANFEBMARAPRMAYJUNJULAUGSEPOCTNOVDEC", strings.ToUpper(name[:3]))/3 + 1
+}
+
+// parseTime returns a time in the line as HH:MM, or HH:MM:SS when the
+// receipt printed seconds
+func parseTime(line string) (string, float64, bool) {
+	m := timeRegex.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, false
+	}
+
+	hour, minute := atoi(m[1]), atoi(m[2])
+	confidence := 0.9
+	if meridiem := strings.ToUpper(m[4]); meridiem != "" {
+		if hour < 1 || hour > 12 {
+			return "", 0, false
+		}
+		hour %= 12
+		if meridiem == "P" {
+			hour += 12
+		}
+		confidence = 0.95
+	}
+	if hour > 23 || minute > 59 {
+		return "", 0, false
+	}
+
+	if m[3] != "" {
+		if atoi(m[3]) > 59 {
+			return "", 0, false
+		}
+		return fmt.Sprintf("%02d:%02d:%s", hour, minute, m[3]), 0.95, true
+	}
+	return fmt.Sprintf("%02d:%02d", hour, minute), confidence, true
+}
+
+// amountLine sorts a line ending in an amount into a receipt field
+func (s *parseState) amountLine(line, label string, cents int64, negative bool) {
+	receipt := &s.draft.Receipt
+	switch {
+	case label == "":
+		s.unparsed(line)
+	case hasPhrase(label, "CHANGE", "TENDERED", "TEND"):
+		// money handed over or back, not part of the purchase
+		s.payment(label)
+	case hasPhrase(label, "SAVINGS", "YOU SAVED", "SAVED", "SUGGESTED"):
+		// summaries of discounts already listed, and tip suggestions
+	case hasPhrase(label, "TIP", "GRATUITY"):
+		s.tip += cents
+		receipt.Tip = models.FormatCents(s.tip)
+		s.draft.Confidence[FieldTip] = 0.9
+	case hasPhrase(label, "SUBTOTAL", "SUB TOTAL"):
+		s.subtotal = cents
+		receipt.Subtotal = models.FormatCents(cents)
+		s.draft.Confidence[FieldSubtotal] = 0.9
+	case hasPhrase(label, "TAX", "SALES TAX", "VAT", "GST", "HST", "PST"):
+		// several tax lines add up, e.g. GST and PST
+		s.tax += cents
+		receipt.Tax = models.FormatCents(s.tax)
+		s.draft.Confidence[FieldTax] = 0.9
+	case hasPhrase(label, "TOTAL", "BALANCE DUE", "AMOUNT DUE", "BALANCE"):
+		// the last total wins, restaurants print another after the tip
+		s.total = cents
+		s.foundTotal = true
+		receipt.Total = models.FormatCents(cents)
+		s.draft.Confidence[FieldTotal] = 0.95
+		if !hasPhrase(label, "TOTAL") {
+			s.draft.Confidence[FieldTotal] = 0.85
+		}
+	case s.payment(label):
+	case negative || hasPhrase(label, "COUPON", "DISCOUNT", "PROMO", "MARKDOWN"):
+		s.discount(line, label, cents)
+	default:
+		s.item(line, label, cents)
+	}
+}
+
+func (s *parseState) discount(line, label string, cents int64) {
+	description, _ := s.parser.policy.Clean(label)
+	if description == "" || cents == 0 {
+		s.unparsed(line)
+		return
+	}
+	s.draft.Receipt.Discounts = append(s.draft.Receipt.Discounts, models.Discount{
+		Description: description,
+		Amount:      models.FormatCents(cents),
+	})
+	s.draft.Confidence[FieldDiscounts] = 0.8
+}
+
+// item reads an item line, taking a quantity, unit price and leading UPC
+// or SKU off the description
+func (s *parseState) item(line, label string, cents int64) {
+	item := models.Item{Price: models.FormatCents(cents)}
+
+	if loc := inlineUnitRegex.FindStringSubmatchIndex(label); loc != nil {
+		item.Quantity, item.UnitPrice = label[loc[2]:loc[3]], label[loc[4]:loc[5]]
+		label = label[:loc[0]]
+	}
+	if m := quantityPrefixRegex.FindStringSubmatch(label); m != nil && item.Quantity == "" {
+		item.Quantity, label = m[1], m[2]
+	}
+	label = flagRegex.ReplaceAllString(label, "")
+	// product codes are printed before or after the description
+	if fields := strings.Fields(label); len(fields) > 1 {
+		for _, code := range []string{fields[0], fields[len(fields)-1]} {
+			if !isDigits(code) || (!models.ValidUPC(code) && len(code) < minSKULength) {
+				continue
+			}
+			if models.ValidUPC(code) {
+				item.UPC = code
+			} else {
+				item.SKU = code
+			}
+			label = strings.Replace(label, code, "", 1)
+			break
+		}
+	}
+
+	description, replaced := s.parser.policy.Clean(label)
+	if !strings.ContainsFunc(description, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		s.unparsed(line)
+		return
+	}
+	item.ShortDescription = description
+
+	confidence := 0.9
+	if replaced {
+		confidence = 0.75
+	}
+	if models.TextLength(description) < 3 {
+		confidence = 0.6
+	}
+	if item.UnitPrice != "" && !pricesMatch(item) {
+		s.warn("Item %q costs %s, not %s at %s each, dropped the unit price", description, item.Price, item.Quantity, item.UnitPrice)
+		item.UnitPrice = ""
+		confidence -= 0.2
+	}
+
+	s.draft.Receipt.Items = append(s.draft.Receipt.Items, item)
+	s.draft.ItemConfidence = append(s.draft.ItemConfidence, confidence)
+}
+
+// quantityLine applies a "2 @ 2.25" line to the item above it
+func (s *parseState) quantityLine(line, quantity, unitPrice string) {
+	items := s.draft.Receipt.Items
+	if len(items) == 0 || items[len(items)-1].Quantity != "" {
+		s.unparsed(line)
+		return
+	}
+
+	last := len(items) - 1
+	item := items[last]
+	item.Quantity, item.UnitPrice = quantity, unitPrice
+	if !pricesMatch(item) {
+		s.warn("Item %q costs %s, not %s at %s each", item.ShortDescription, item.Price, quantity, unitPrice)
+		s.unparsed(line)
+		return
+	}
+	items[last] = item
+	// the price was read twice
+	if s.draft.ItemConfidence[last] < 0.95 {
+		s.draft.ItemConfidence[last] = 0.95
+	}
+}
+
+// payment records the payment method and card digits named in the text,
+// reporting whether there were any
+func (s *parseState) payment(text string) bool {
+	var method models.PaymentMethod
+	for _, keyword := range paymentKeywords {
+		if hasPhrase(text, keyword.phrase) {
+			method = keyword.method
+			break
+		}
+	}
+	if method == "" {
+		return false
+	}
+
+	receipt := &s.draft.Receipt
+	if receipt.Payment == nil {
+		receipt.Payment = &models.Payment{Method: method}
+		s.draft.Confidence[FieldPayment] = 0.8
+	}
+	if m := last4Regex.FindStringSubmatch(text); m != nil && receipt.Payment.Last4 == "" && receipt.Payment.Method == method {
+		receipt.Payment.Last4 = m[1]
+		s.draft.Confidence[FieldPayment] = 0.9
+	}
+	return true
+}
+
+// finish fills in what the lines left open and scores how well the
+// amounts agree
+func (s *parseState) finish() {
+	receipt := &s.draft.Receipt
+	confidence := s.draft.Confidence
+
+	if receipt.Retailer == "" {
+		confidence[FieldRetailer] = 0
+		s.warn("No retailer name found")
+	}
+	if receipt.PurchaseDate == "" {
+		confidence[FieldPurchaseDate] = 0
+		s.warn("No purchase date found")
+	}
+	if receipt.PurchaseTime == "" {
+		confidence[FieldPurchaseTime] = 0
+		s.warn("No purchase time found")
+	}
+	if len(receipt.Items) == 0 {
+		confidence[FieldItems] = 0
+		s.warn("No items found")
+	}
+
+	var itemSum int64
+	for _, item := range receipt.Items {
+		price, _ := models.ParseCents(item.Price)
+		itemSum += price
+	}
+	adjustments := receipt.AdjustmentCents()
+
+	if !s.foundTotal {
+		confidence[FieldTotal] = 0
+		if len(receipt.Items) > 0 {
+			s.total = itemSum + adjustments
+			receipt.Total = models.FormatCents(s.total)
+			confidence[FieldTotal] = 0.4
+			s.warn("No total line found, used the item sum")
+		} else {
+			s.warn("No total found")
+		}
+	}
+
+	if len(receipt.Items) > 0 {
+		expected, against := s.total-adjustments, "total"
+		if receipt.Subtotal != "" {
+			expected, against = s.subtotal, "subtotal"
+		}
+		confidence[FieldItems] = 0.95
+		switch {
+		case !s.foundTotal:
+			// nothing to check the items against
+			confidence[FieldItems] = 0.6
+		case itemSum != expected:
+			confidence[FieldItems] = 0.5
+			s.warn("Items add up to %s, %s is %s", models.FormatCents(itemSum), against, models.FormatCents(expected))
+		}
+	}
+	if receipt.Subtotal != "" && s.subtotal+adjustments != s.total {
+		confidence[FieldSubtotal] = 0.5
+		s.warn("Subtotal, discounts, tax and tip do not add up to the total %s", receipt.Total)
+	}
+}
+
+func (s *parseState) unparsed(line string) {
+	s.draft.UnparsedLines = append(s.draft.UnparsedLines, line)
+}
+
+func (s *parseState) warn(format string, args ...interface{}) {
+	s.draft.Warnings = append(s.draft.Warnings, fmt.Sprintf(format, args...))
+}
+
+// hasPhrase reports whether text contains one of the phrases as whole
+// words, ignoring case and punctuation, so "SUB-TOTAL" has "SUB TOTAL"
+// and "CASHEWS" does not have "CASH"
+func hasPhrase(text string, phrases ...string) bool {
+	words := strings.FieldsFunc(strings.ToUpper(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	normalized := " " + strings.Join(words, " ") + " "
+	for _, phrase := range phrases {
+		if strings.Contains(normalized, " "+phrase+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func pricesMatch(item models.Item) bool {
+	expected, err := models.LinePrice(item.Quantity, item.UnitPrice)
+	price, _ := models.ParseCents(item.Price)
+	return err == nil && price == expected
+}
+
+func isDigits(text string) bool {
+	return text != "" && strings.IndexFunc(text, func(r rune) bool { return r < '0' || r > '9' }) < 0
+}
+
+// atoi is only called on regex matched digits
+func atoi(digits string) int {
+	n, _ := strconv.Atoi(digits)
+	return n
+}
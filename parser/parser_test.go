@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected drafts in testdata")
+
+// TestParseCorpus parses every testdata/*.txt receipt and compares the
+// draft with the .json file next to it. Run with -update after a deliberate
+// change and review the diff.
+func TestParseCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No fixture receipts found: %v", err)
+	}
+
+	parser := New(models.CharacterPolicy{})
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		t.Run(name, func(t *testing.T) {
+			text, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.MarshalIndent(parser.Parse(string(text)), "", "  ")
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(file, ".txt") + ".json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Missing expected draft, run with -update: %v", err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("Draft differs from %s, got:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// drafts of well printed receipts should go through validation unchanged
+func TestParsedReceiptsValidate(t *testing.T) {
+	rules := models.DefaultValidationRules
+	rules.Clock = utils.NewFakeClock(time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		file  string
+		valid bool
+	}{
+		{file: "target.txt", valid: true},
+		{file: "mm-corner-market.txt", valid: true},
+		{file: "cafe-lumiere.txt", valid: true},
+		{file: "walmart.txt", valid: true},
+		// no purchase time
+		{file: "ocr-noise.txt", valid: false},
+	}
+
+	parser := New(models.CharacterPolicy{})
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			text, err := os.ReadFile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			receipt := parser.Parse(string(text)).Receipt
+			if err := receipt.ValidateWith(rules); (err == nil) != tc.valid {
+				t.Errorf("Expected valid=%v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		check func(*testing.T, models.ReceiptDraft)
+	}{
+		{
+			name: "day first date",
+			text: "Shop\n14/03/2022 18:05\nBread 2.00\nTotal 2.00",
+			check: func(t *testing.T, d models.ReceiptDraft) {
+				if d.Receipt.PurchaseDate != "2022-03-14" || d.Confidence[FieldPurchaseDate] >= 0.85 {
+					t.Errorf("Expected a less certain 2022-03-14, got %s at %.2f", d.Receipt.PurchaseDate, d.Confidence[FieldPurchaseDate])
+				}
+			},
+		},
+		{
+			name: "time away from the date",
+			text: "Shop\nOpen 9:00 AM\n2022-03-14\nBread 2.00\nTotal 2.00",
+			check: func(t *testing.T, d models.ReceiptDraft) {
+				if d.Receipt.PurchaseTime != "09:00" || d.Confidence[FieldPurchaseTime] > 0.8 {
+					t.Errorf("Expected an uncertain 09:00, got %s at %.2f", d.Receipt.PurchaseTime, d.Confidence[FieldPurchaseTime])
+				}
+			},
+		},
+		{
+			name: "items do not add up",
+			text: "Shop\n2022-03-14 10:00\nBread 2.00\nMilk 1.00\nTotal 5.00",
+			check: func(t *testing.T, d models.ReceiptDraft) {
+				if d.Confidence[FieldItems] != 0.5 || len(d.Warnings) != 1 {
+					t.Errorf("Expected a mismatch warning, got %.2f %v", d.Confidence[FieldItems], d.Warnings)
+				}
+			},
+		},
+		{
+			name: "unit price that does not match",
+			text: "Shop\n2022-03-14 10:00\nGatorade 2 @ 2.25 5.00\nTotal 5.00",
+			check: func(t *testing.T, d models.ReceiptDraft) {
+				if item := d.Receipt.Items[0]; item.Quantity != "2" || item.UnitPrice != "" {
+					t.Errorf("Expected the unit price dropped, got %+v", item)
+				}
+			},
+		},
+		{
+			name: "cashews are not a payment",
+			text: "Shop\n2022-03-14 10:00\nCashews 4.00\nTotal 4.00",
+			check: func(t *testing.T, d models.ReceiptDraft) {
+				if len(d.Receipt.Items) != 1 || d.Receipt.Payment != nil {
+					t.Errorf("Expected one item and no payment, got %+v", d.Receipt)
+				}
+			},
+		},
+		{
+			name: "empty text",
+			text: "",
+			check: func(t *testing.T, d models.ReceiptDraft) {
+				if d.Confidence[FieldRetailer] != 0 || d.Confidence[FieldTotal] != 0 || len(d.Warnings) != 5 {
+					t.Errorf("Expected every field missing, got %+v", d)
+				}
+			},
+		},
+	}
+
+	parser := New(models.CharacterPolicy{})
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.check(t, parser.Parse(tc.text))
+		})
+	}
+}
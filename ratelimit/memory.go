@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// sweepInterval is how often idle buckets are dropped from memory
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in process. Limits are per instance, so a
+// deployment with N replicas allows N times the configured rate.
+type MemoryStore struct {
+	buckets   map[string]*bucket
+	mutex     *sync.Mutex
+	clock     utils.Clock
+	lastSweep time.Time
+}
+
+func NewMemoryStore(clock utils.Clock) *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		mutex:   &sync.Mutex{},
+		clock:   clock,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Decision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	s.sweep(now)
+
+	b, found := s.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(limit.burst()), updated: now}
+		s.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+// sweep drops buckets that have refilled completely, since a fresh bucket
+// behaves the same. Must be called with the lock held.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package ratelimit implements token bucket rate limiting behind a Store
+// interface, so limits can be kept in process or in a store shared by
+// several instances.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Period on average, with bursts of up to Burst
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// rate returns the refill rate in tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// burst falls back to Requests when no explicit burst was given
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s:%d", l.Requests, l.Period, l.burst())
+}
+
+// Decision is the outcome of taking a token
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next token, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Store takes tokens from the bucket identified by key. Implementations
+// backed by a shared store let several instances enforce one limit.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// bucket is the state of one token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled completely
+	full time.Time
+}
+
+// take refills the bucket for the time elapsed since its last update and
+// tries to remove one token
+func (b *bucket) take(limit Limit, now time.Time) Decision {
+	rate := limit.rate()
+	burst := float64(limit.burst())
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.updated = now
+
+	decision := Decision{Limit: limit.burst()}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.ResetAfter = secondsToDuration((burst - b.tokens) / rate)
+	b.full = now.Add(decision.ResetAfter)
+	return decision
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+// ParseLimit reads a limit written as requests/period[:burst], where period
+// is a Go duration or one of s, m, h. For example "10/s:20" or "100/1m".
+func ParseLimit(value string) (Limit, error) {
+	rateSpec, burstSpec, hasBurst := strings.Cut(strings.TrimSpace(value), ":")
+	requestsSpec, periodSpec, found := strings.Cut(rateSpec, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected requests/period[:burst]", value)
+	}
+
+	requests, err := strconv.Atoi(requestsSpec)
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", value)
+	}
+
+	var period time.Duration
+	switch periodSpec {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(periodSpec)
+		if err != nil || period <= 0 {
+			return Limit{}, fmt.Errorf("invalid period in rate limit %q", value)
+		}
+	}
+
+	limit := Limit{Requests: requests, Period: period}
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burstSpec)
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid burst in rate limit %q", value)
+		}
+	}
+	return limit, nil
+}
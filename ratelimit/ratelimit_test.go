@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	clock := utils.NewFakeClock(time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryStore(clock)
+
+	limit := Limit{Requests: 1, Period: time.Second, Burst: 3}
+	take := func(key string) Decision {
+		decision, err := store.Take(context.Background(), key, limit)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return decision
+	}
+
+	// the burst is available straight away
+	for i := 0; i < 3; i++ {
+		if d := take("client-a"); !d.Allowed || d.Remaining != 2-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 2-i, d)
+		}
+	}
+
+	denied := take("client-a")
+	if denied.Allowed {
+		t.Fatal("Request over the burst should be denied")
+	}
+	if denied.RetryAfter != time.Second {
+		t.Errorf("Expected retry after 1s, got %s", denied.RetryAfter)
+	}
+	if denied.ResetAfter != 3*time.Second {
+		t.Errorf("Expected reset after 3s, got %s", denied.ResetAfter)
+	}
+
+	// other keys have their own bucket
+	if d := take("client-b"); !d.Allowed {
+		t.Error("Another client should not be limited")
+	}
+
+	// one token comes back per second
+	clock.Advance(time.Second)
+	if d := take("client-a"); !d.Allowed {
+		t.Error("Token should have refilled after a second")
+	}
+	if d := take("client-a"); d.Allowed {
+		t.Error("Only one token should have refilled")
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	clock := utils.NewFakeClock(time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryStore(clock)
+
+	limit := Limit{Requests: 10, Period: time.Second}
+	store.Take(context.Background(), "idle", limit)
+
+	clock.Advance(2 * sweepInterval)
+	store.Take(context.Background(), "active", limit)
+
+	if _, found := store.buckets["idle"]; found {
+		t.Error("Idle bucket should have been swept")
+	}
+	if _, found := store.buckets["active"]; !found {
+		t.Error("Active bucket should be kept")
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Limit
+		wantErr  bool
+	}{
+		{value: "10/s", expected: Limit{Requests: 10, Period: time.Second}},
+		{value: "100/1m:20", expected: Limit{Requests: 100, Period: time.Minute, Burst: 20}},
+		{value: "5/h:5", expected: Limit{Requests: 5, Period: time.Hour, Burst: 5}},
+		{value: "10", wantErr: true},
+		{value: "0/s", wantErr: true},
+		{value: "10/fortnight", wantErr: true},
+		{value: "10/s:x", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			limit, err := ParseLimit(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if limit != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, limit)
+			}
+		})
+	}
+}
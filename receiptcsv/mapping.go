@@ -0,0 +1,124 @@
+// Package receiptcsv reads and writes receipts as CSV with one row per
+// item. The receipt fields repeat on every row and rows are grouped into
+// receipts by a key column.
+package receiptcsv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a receipt, item or stored record value held in a column
+type Field string
+
+const (
+	// FieldKey groups rows into receipts. Exports write the receipt ID.
+	FieldKey Field = "key"
+
+	// stored record fields, written on export and ignored on import
+	FieldStatus      Field = "status"
+	FieldPoints      Field = "points"
+	FieldSubmittedAt Field = "submittedAt"
+	FieldRetailerID  Field = "retailerId"
+
+	FieldRetailer      Field = "retailer"
+	FieldPurchaseDate  Field = "purchaseDate"
+	FieldPurchaseTime  Field = "purchaseTime"
+	FieldPurchasedAt   Field = "purchasedAt"
+	FieldTimeZone      Field = "timeZone"
+	FieldSubtotal      Field = "subtotal"
+	FieldDiscount      Field = "discount"
+	FieldTax           Field = "tax"
+	FieldTip           Field = "tip"
+	FieldTotal         Field = "total"
+	FieldPaymentMethod Field = "paymentMethod"
+	FieldPaymentLast4  Field = "paymentLast4"
+
+	FieldDescription Field = "shortDescription"
+	FieldPrice       Field = "price"
+	FieldQuantity    Field = "quantity"
+	FieldUnitPrice   Field = "unitPrice"
+	FieldSKU         Field = "sku"
+	FieldUPC         Field = "upc"
+	FieldCategory    Field = "category"
+)
+
+// Fields lists every field in export column order
+var Fields = []Field{
+	FieldKey, FieldStatus, FieldPoints, FieldSubmittedAt, FieldRetailerID,
+	FieldRetailer, FieldPurchaseDate, FieldPurchaseTime, FieldPurchasedAt, FieldTimeZone,
+	FieldSubtotal, FieldDiscount, FieldTax, FieldTip, FieldTotal, FieldPaymentMethod, FieldPaymentLast4,
+	FieldDescription, FieldPrice, FieldQuantity, FieldUnitPrice, FieldSKU, FieldUPC, FieldCategory,
+}
+
+// requiredFields have to be columns of an imported file
+var requiredFields = []Field{FieldKey, FieldRetailer, FieldTotal, FieldDescription, FieldPrice}
+
+// Mapping names the column that holds each field
+type Mapping map[Field]string
+
+// DefaultMapping names every column after its field
+func DefaultMapping() Mapping {
+	mapping := Mapping{}
+	for _, field := range Fields {
+		mapping[field] = string(field)
+	}
+	return mapping
+}
+
+// NewMapping renames the columns of DefaultMapping, overrides maps field
+// names to column headers, e.g. {"key": "Order ID"}
+func NewMapping(overrides map[string]string) (Mapping, error) {
+	mapping := DefaultMapping()
+	for name, header := range overrides {
+		field := Field(name)
+		if _, known := mapping[field]; !known {
+			return nil, fmt.Errorf("unknown CSV field %q", name)
+		}
+		mapping[field] = strings.TrimSpace(header)
+	}
+
+	seen := map[string]Field{}
+	for _, field := range Fields {
+		header := strings.ToLower(mapping[field])
+		if header == "" {
+			return nil, fmt.Errorf("CSV field %s has no column name", field)
+		}
+		if other, taken := seen[header]; taken {
+			return nil, fmt.Errorf("CSV fields %s and %s both use column %q", other, field, mapping[field])
+		}
+		seen[header] = field
+	}
+	return mapping, nil
+}
+
+// columns finds each mapped field in a header row, ignoring case and
+// surrounding space
+func (m Mapping) columns(header []string) (map[Field]int, error) {
+	index := map[string]int{}
+	for i, name := range header {
+		// spreadsheets often save with a byte order mark
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	columns := map[Field]int{}
+	for field, name := range m {
+		if i, found := index[strings.ToLower(name)]; found {
+			columns[field] = i
+		}
+	}
+
+	var missing []string
+	for _, field := range requiredFields {
+		if _, found := columns[field]; !found {
+			missing = append(missing, m[field])
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("CSV header is missing the %s columns", strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
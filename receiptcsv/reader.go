@@ -0,0 +1,169 @@
+package receiptcsv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// Receipt is a receipt built from the rows sharing a key. It is not
+// validated.
+type Receipt struct {
+	Key     string
+	Rows    []int
+	Receipt models.Receipt
+	// Errors are rows that could not be read. A receipt with errors should
+	// not be processed.
+	Errors []models.ImportError
+
+	discount      string
+	paymentMethod string
+	last4         string
+}
+
+// Read reads receipts from a CSV file with one row per item, in order of
+// each key's first row. Receipt fields are taken from the first row that
+// has them, later rows of the same receipt may leave them empty but not
+// contradict them. Rows without a key are returned as errors. The error is
+// set when the file as a whole cannot be read.
+func Read(r io.Reader, mapping Mapping) ([]*Receipt, []models.ImportError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid CSV header: %w", err)
+	}
+	columns, err := mapping.columns(header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var receipts []*Receipt
+	var rowErrors []models.ImportError
+	byKey := map[string]*Receipt{}
+
+	// the header is row 1
+	for rowNumber := 2; ; rowNumber++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			rowErrors = append(rowErrors, models.ImportError{
+				Rows:  []int{rowNumber},
+				Error: fmt.Sprintf("Row has %d columns, the header has %d", len(record), len(header)),
+			})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid CSV at row %d: %w", rowNumber, err)
+		}
+
+		line := row{record: record, columns: columns, mapping: mapping}
+		key := line.get(FieldKey)
+		if key == "" {
+			rowErrors = append(rowErrors, models.ImportError{
+				Rows:  []int{rowNumber},
+				Error: fmt.Sprintf("Row has no %s", mapping[FieldKey]),
+			})
+			continue
+		}
+
+		receipt, found := byKey[key]
+		if !found {
+			receipt = &Receipt{Key: key}
+			byKey[key] = receipt
+			receipts = append(receipts, receipt)
+		}
+		receipt.Rows = append(receipt.Rows, rowNumber)
+		if err := receipt.add(line); err != nil {
+			receipt.Errors = append(receipt.Errors, models.ImportError{Rows: []int{rowNumber}, Key: key, Error: err.Error()})
+		}
+	}
+
+	for _, receipt := range receipts {
+		receipt.finish()
+	}
+	return receipts, rowErrors, nil
+}
+
+type row struct {
+	record  []string
+	columns map[Field]int
+	mapping Mapping
+}
+
+// get returns the trimmed value of a field, empty when the file has no
+// column for it
+func (r row) get(field Field) string {
+	i, found := r.columns[field]
+	if !found {
+		return ""
+	}
+	return unguardFormula(strings.TrimSpace(r.record[i]))
+}
+
+// add merges one row into the receipt, every row with an item description
+// or price adds an item
+func (r *Receipt) add(line row) error {
+	receipt := &r.Receipt
+	for _, f := range []struct {
+		field Field
+		value *string
+	}{
+		{FieldRetailer, &receipt.Retailer},
+		{FieldPurchaseDate, &receipt.PurchaseDate},
+		{FieldPurchaseTime, &receipt.PurchaseTime},
+		{FieldPurchasedAt, &receipt.PurchasedAt},
+		{FieldTimeZone, &receipt.TimeZone},
+		{FieldSubtotal, &receipt.Subtotal},
+		{FieldDiscount, &r.discount},
+		{FieldTax, &receipt.Tax},
+		{FieldTip, &receipt.Tip},
+		{FieldTotal, &receipt.Total},
+		{FieldPaymentMethod, &r.paymentMethod},
+		{FieldPaymentLast4, &r.last4},
+	} {
+		value := line.get(f.field)
+		if value == "" || value == *f.value {
+			continue
+		}
+		if *f.value != "" {
+			return fmt.Errorf("%s %q does not match %q from an earlier row", line.mapping[f.field], value, *f.value)
+		}
+		*f.value = value
+	}
+
+	item := models.Item{
+		ShortDescription: line.get(FieldDescription),
+		Price:            line.get(FieldPrice),
+		Quantity:         line.get(FieldQuantity),
+		UnitPrice:        line.get(FieldUnitPrice),
+		SKU:              line.get(FieldSKU),
+		UPC:              line.get(FieldUPC),
+		Category:         line.get(FieldCategory),
+	}
+	if item.ShortDescription != "" || item.Price != "" {
+		receipt.Items = append(receipt.Items, item)
+	}
+	return nil
+}
+
+// finish sets the fields that do not map to a single receipt string. A
+// file holds one discount amount per receipt.
+func (r *Receipt) finish() {
+	if r.discount != "" {
+		r.Receipt.Discounts = []models.Discount{{Description: "Discount", Amount: r.discount}}
+	}
+	if r.paymentMethod != "" || r.last4 != "" {
+		r.Receipt.Payment = &models.Payment{Method: models.PaymentMethod(r.paymentMethod), Last4: r.last4}
+	}
+}
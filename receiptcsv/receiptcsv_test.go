@@ -0,0 +1,147 @@
+package receiptcsv
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+func TestRead(t *testing.T) {
+	t.Run("groups rows by key", func(t *testing.T) {
+		file := "key,retailer,purchaseDate,purchaseTime,total,shortDescription,price,quantity,unitPrice,paymentMethod\n" +
+			"A1,Target,2022-01-01,13:01,8.74,Mountain Dew 12PK,6.49,,,store_card\n" +
+			"B7,Walgreens,2022-01-02,08:13,2.65,Pepsi,1.25,,,\n" +
+			"A1,,,,,Pepsi,2.25,3,0.75,\n" +
+			"B7,Walgreens,2022-01-02,08:13,2.65,Dasani,1.40,,,\n"
+
+		receipts, rowErrors, err := Read(strings.NewReader(file), DefaultMapping())
+		if err != nil || len(rowErrors) > 0 {
+			t.Fatalf("Unexpected errors %v %v", err, rowErrors)
+		}
+		if len(receipts) != 2 || receipts[0].Key != "A1" || receipts[1].Key != "B7" {
+			t.Fatalf("Expected A1 then B7, got %+v", receipts)
+		}
+
+		a1 := receipts[0]
+		expected := models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "8.74",
+			Items: []models.Item{
+				{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+				{ShortDescription: "Pepsi", Price: "2.25", Quantity: "3", UnitPrice: "0.75"},
+			},
+			Payment: &models.Payment{Method: models.PaymentStoreCard},
+		}
+		if !reflect.DeepEqual(a1.Receipt, expected) || !reflect.DeepEqual(a1.Rows, []int{2, 4}) {
+			t.Errorf("Expected %+v on rows 2 and 4, got %+v on %v", expected, a1.Receipt, a1.Rows)
+		}
+	})
+
+	t.Run("row errors", func(t *testing.T) {
+		file := "key,retailer,total,shortDescription,price\n" +
+			"A1,Target,6.49,Mountain Dew 12PK,6.49\n" +
+			"A1,Walmart,6.49,Pepsi,1.25\n" +
+			",Target,1.00,Gum,1.00\n" +
+			"C3,Target,1.00\n"
+
+		receipts, rowErrors, err := Read(strings.NewReader(file), DefaultMapping())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(rowErrors) != 2 || rowErrors[0].Rows[0] != 4 || rowErrors[1].Rows[0] != 5 {
+			t.Errorf("Expected errors on rows 4 and 5, got %+v", rowErrors)
+		}
+		if len(receipts) != 1 || len(receipts[0].Errors) != 1 || receipts[0].Errors[0].Rows[0] != 3 {
+			t.Errorf("Expected the conflicting retailer on row 3, got %+v", receipts)
+		}
+	})
+
+	t.Run("renamed columns", func(t *testing.T) {
+		mapping, err := NewMapping(map[string]string{"key": "Order ID", "retailer": "Store", "shortDescription": "Item"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// header names match ignoring case, a byte order mark and spacing
+		file := "\ufefforder id, STORE ,total,item,price,notes\nA1,Target,6.49,Mountain Dew 12PK,6.49,ignored\n"
+
+		receipts, _, err := Read(strings.NewReader(file), mapping)
+		if err != nil || len(receipts) != 1 || receipts[0].Receipt.Retailer != "Target" || receipts[0].Receipt.Items[0].ShortDescription != "Mountain Dew 12PK" {
+			t.Errorf("Unexpected result %+v, %v", receipts, err)
+		}
+	})
+
+	t.Run("missing columns", func(t *testing.T) {
+		_, _, err := Read(strings.NewReader("key,retailer,total\nA1,Target,6.49\n"), DefaultMapping())
+		if err == nil || !strings.Contains(err.Error(), "shortDescription, price") {
+			t.Errorf("Expected the missing columns named, got %v", err)
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		if _, _, err := Read(strings.NewReader(""), DefaultMapping()); err == nil {
+			t.Error("Expected error for an empty file")
+		}
+	})
+}
+
+func TestExportImportsUnchanged(t *testing.T) {
+	record := models.ReceiptWithPoints{
+		ID: "adb6b560-0eef-42bc-9d16-df48f30e89b2",
+		Receipt: models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01:00",
+			TimeZone:     "America/Chicago",
+			Items: []models.Item{
+				{ShortDescription: "-Mountain Dew 12PK", Price: "6.49", UPC: "012000809941"},
+				{ShortDescription: "Pepsi, Diet", Price: "2.25", Quantity: "3", UnitPrice: "0.75", Category: "=drinks"},
+			},
+			Subtotal:  "8.74",
+			Discounts: []models.Discount{{Description: "Coupon", Amount: "1.00"}, {Description: "Member", Amount: "0.50"}},
+			Tax:       "0.60",
+			Total:     "7.84",
+			Payment:   &models.Payment{Method: models.PaymentCreditCard, Last4: "4242"},
+		},
+		Points:      28,
+		Status:      models.StatusPendingReview,
+		SubmittedAt: time.Date(2022, 1, 1, 19, 5, 0, 0, time.UTC),
+	}
+
+	var out bytes.Buffer
+	writer := NewWriter(&out, DefaultMapping())
+	if err := writer.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two item rows, got %q", out.String())
+	}
+	// held receipts are exported without points, and text a spreadsheet
+	// would run is quoted
+	if !strings.HasPrefix(lines[1], record.ID+",pending_review,0,2022-01-01T19:05:00Z,,Target,") || !strings.Contains(lines[1], ",'-Mountain Dew 12PK,") || !strings.Contains(lines[2], ",'=drinks") {
+		t.Errorf("Unexpected rows %q", lines[1:])
+	}
+
+	receipts, rowErrors, err := Read(&out, DefaultMapping())
+	if err != nil || len(rowErrors) > 0 || len(receipts) != 1 {
+		t.Fatalf("Export does not import: %v %v %+v", err, rowErrors, receipts)
+	}
+	expected := record.Receipt
+	expected.Discounts = []models.Discount{{Description: "Discount", Amount: "1.50"}}
+	if got := receipts[0]; got.Key != record.ID || !reflect.DeepEqual(got.Receipt, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got.Receipt)
+	}
+}
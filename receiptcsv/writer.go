@@ -0,0 +1,115 @@
+package receiptcsv
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// Writer writes stored receipts as CSV, one row per item
+type Writer struct {
+	csv     *csv.Writer
+	mapping Mapping
+}
+
+// NewWriter writes to w with the columns named by mapping, in Fields order
+func NewWriter(w io.Writer, mapping Mapping) *Writer {
+	return &Writer{csv: csv.NewWriter(w), mapping: mapping}
+}
+
+// WriteHeader writes the column names
+func (w *Writer) WriteHeader() error {
+	header := make([]string, len(Fields))
+	for i, field := range Fields {
+		header[i] = w.mapping[field]
+	}
+	return w.csv.Write(header)
+}
+
+// Write writes a row for each item of the record. Rows are buffered, call
+// Flush when done.
+func (w *Writer) Write(record models.ReceiptWithPoints) error {
+	receipt := record.Receipt
+
+	var discount string
+	if len(receipt.Discounts) > 0 {
+		var cents int64
+		for _, d := range receipt.Discounts {
+			amount, _ := models.ParseCents(d.Amount)
+			cents += amount
+		}
+		discount = models.FormatCents(cents)
+	}
+	var method, last4 string
+	if receipt.Payment != nil {
+		method, last4 = string(receipt.Payment.Method), receipt.Payment.Last4
+	}
+
+	values := map[Field]string{
+		FieldKey:           record.ID,
+		FieldStatus:        string(record.CurrentStatus()),
+		FieldPoints:        strconv.FormatInt(record.AwardedPoints(), 10),
+		FieldSubmittedAt:   record.SubmittedAt.UTC().Format(time.RFC3339),
+		FieldRetailerID:    record.RetailerID,
+		FieldRetailer:      guardFormula(receipt.Retailer),
+		FieldPurchaseDate:  receipt.PurchaseDate,
+		FieldPurchaseTime:  receipt.PurchaseTime,
+		FieldPurchasedAt:   receipt.PurchasedAt,
+		FieldTimeZone:      receipt.TimeZone,
+		FieldSubtotal:      receipt.Subtotal,
+		FieldDiscount:      discount,
+		FieldTax:           receipt.Tax,
+		FieldTip:           receipt.Tip,
+		FieldTotal:         receipt.Total,
+		FieldPaymentMethod: method,
+		FieldPaymentLast4:  last4,
+	}
+
+	row := make([]string, len(Fields))
+	for _, item := range receipt.Items {
+		values[FieldDescription] = guardFormula(item.ShortDescription)
+		values[FieldPrice] = item.Price
+		values[FieldQuantity] = item.Quantity
+		values[FieldUnitPrice] = item.UnitPrice
+		values[FieldSKU] = item.SKU
+		values[FieldUPC] = item.UPC
+		values[FieldCategory] = guardFormula(item.Category)
+
+		for i, field := range Fields {
+			row[i] = values[field]
+		}
+		if err := w.csv.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered rows
+func (w *Writer) Flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// formulaPrefixes start a formula when a spreadsheet opens the file
+const formulaPrefixes = "=+-@"
+
+// guardFormula quotes text a spreadsheet would run as a formula
+func guardFormula(text string) string {
+	if text != "" && strings.ContainsRune(formulaPrefixes, rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// unguardFormula undoes guardFormula so exports import unchanged
+func unguardFormula(text string) string {
+	if len(text) > 1 && text[0] == '\'' && strings.ContainsRune(formulaPrefixes, rune(text[1])) {
+		return text[1:]
+	}
+	return text
+}
@@ -0,0 +1,597 @@
+package repository
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// EvictionPolicy picks the receipt BoundedStorage drops when it is full
+type EvictionPolicy string
+
+const (
+	// EvictLRU drops the receipt read or written least recently
+	EvictLRU EvictionPolicy = "lru"
+	// EvictOldest drops the receipt stored first
+	EvictOldest EvictionPolicy = "oldest"
+)
+
+// RemovalReason says why a storage no longer has a receipt
+type RemovalReason string
+
+const (
+	RemovedExpired RemovalReason = "expired"
+	// RemovedEvicted is a receipt dropped to stay within the limits that
+	// could not be kept in a cold store
+	RemovedEvicted RemovalReason = "evicted"
+)
+
+// Removal records a receipt a storage dropped on its own
+type Removal struct {
+	Reason RemovalReason
+	// ClientID owned the receipt, so only it and admins learn it existed
+	ClientID string
+	At       time.Time
+}
+
+// RemovalTracker is implemented by storages that drop receipts on their
+// own, so a lookup miss can be told apart from an ID that never existed
+type RemovalTracker interface {
+	Removed(id string) (Removal, bool)
+}
+
+// DefaultMaxTombstones is used when BoundedConfig leaves it at zero
+const DefaultMaxTombstones = 100000
+
+// DefaultColdSweepInterval is used when BoundedConfig leaves it at zero
+const DefaultColdSweepInterval = 10 * time.Minute
+
+// BoundedConfig limits a BoundedStorage. Zero limits are not enforced.
+type BoundedConfig struct {
+	MaxReceipts int
+	// MaxBytes caps the receipts' total JSON encoded size
+	MaxBytes int64
+	// TTL drops receipts that long after they were first stored
+	TTL    time.Duration
+	Policy EvictionPolicy
+	// Cold keeps evicted receipts readable, nil drops them
+	Cold ColdStore
+	// MaxTombstones caps how many removed IDs are remembered, the oldest
+	// are forgotten first
+	MaxTombstones int
+	// ColdSweepInterval is how often expired receipts are deleted from the
+	// cold store, when there is one and a TTL
+	ColdSweepInterval time.Duration
+	Clock             utils.Clock
+}
+
+// BoundedStorage keeps receipts in memory within a count and size limit,
+// evicting by policy once either is passed and dropping receipts past
+// their TTL. Evicted receipts go to the cold store when there is one and
+// are still read, updated and listed from there, more slowly. Expired
+// receipts are gone, but remembered so lookups can say so. Close stops
+// the cold store sweeps.
+//
+// Listings filter and sort cold receipts by an index kept in memory, and
+// only read the cold store for the receipts on the page.
+type BoundedStorage struct {
+	config   BoundedConfig
+	receipts map[string]*boundedEntry
+	// byAge is in the order receipts expire in, which is the order they
+	// were first stored. byUse is least recently used first.
+	byAge *list.List
+	byUse *list.List
+	bytes int64
+	// tombstones are removed receipts, forgotten in tombstoneOrder
+	tombstones     map[string]Removal
+	tombstoneOrder *list.List
+	outbox         []models.ReceiptEvent
+	stats          models.StorageStats
+	// reads move receipts in byUse, so every access takes the write lock
+	mutex *sync.Mutex
+	// publishing is held by PublishEvents, which claims the whole outbox
+	publishing sync.Mutex
+	// cold indexes the receipts in the cold store that are not also in
+	// memory. It is loaded from the cold store the first time it is needed,
+	// as the store may hold receipts from before a restart.
+	cold       map[string]coldEntry
+	coldLoaded bool
+	done       chan struct{}
+	sweeper    sync.WaitGroup
+	close      sync.Once
+}
+
+// coldEntry is what the cold index keeps of a receipt: the fields
+// filters and the listing order use, and when it expires
+type coldEntry struct {
+	record    models.ReceiptWithPoints
+	expiresAt time.Time
+}
+
+func newColdEntry(receipt ColdReceipt) coldEntry {
+	return coldEntry{
+		record: models.ReceiptWithPoints{
+			ID:          receipt.Record.ID,
+			ClientID:    receipt.Record.ClientID,
+			Status:      receipt.Record.Status,
+			SubmittedAt: receipt.Record.SubmittedAt,
+		},
+		expiresAt: receipt.ExpiresAt,
+	}
+}
+
+type boundedEntry struct {
+	record    models.ReceiptWithPoints
+	size      int64
+	expiresAt time.Time
+	age       *list.Element
+	use       *list.Element
+}
+
+func NewBoundedStorage(config BoundedConfig) *BoundedStorage {
+	if config.Policy == "" {
+		config.Policy = EvictLRU
+	}
+	if config.MaxTombstones <= 0 {
+		config.MaxTombstones = DefaultMaxTombstones
+	}
+	if config.ColdSweepInterval <= 0 {
+		config.ColdSweepInterval = DefaultColdSweepInterval
+	}
+	if config.Clock == nil {
+		config.Clock = utils.SystemClock{}
+	}
+	s := &BoundedStorage{
+		config:         config,
+		receipts:       map[string]*boundedEntry{},
+		byAge:          list.New(),
+		byUse:          list.New(),
+		tombstones:     map[string]Removal{},
+		tombstoneOrder: list.New(),
+		cold:           map[string]coldEntry{},
+		mutex:          &sync.Mutex{},
+		done:           make(chan struct{}),
+	}
+
+	if config.Cold != nil && config.TTL > 0 {
+		s.sweeper.Add(1)
+		go func() {
+			defer s.sweeper.Done()
+			ticker := time.NewTicker(config.ColdSweepInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.done:
+					return
+				case <-ticker.C:
+					if err := s.SweepCold(); err != nil {
+						utils.Logger.WithError(err).Error("Failed to sweep cold receipts")
+					}
+				}
+			}
+		}()
+	}
+	return s
+}
+
+// Close stops the cold store sweeps
+func (s *BoundedStorage) Close() error {
+	s.close.Do(func() {
+		close(s.done)
+		s.sweeper.Wait()
+	})
+	return nil
+}
+
+func (s *BoundedStorage) SaveReceipt(id string, record models.ReceiptWithPoints, events ...models.ReceiptEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.config.Clock.Now()
+	s.expire(now)
+	if entry, found := s.receipts[id]; found {
+		s.drop(id, entry)
+	}
+	if _, cold := s.cold[id]; cold {
+		s.deleteCold(id)
+	}
+
+	record.ID = id
+	var expiresAt time.Time
+	if s.config.TTL > 0 {
+		expiresAt = now.Add(s.config.TTL)
+	}
+	s.insert(record, expiresAt)
+	s.outbox = append(s.outbox, events...)
+	s.evict(now)
+	return nil
+}
+
+func (s *BoundedStorage) GetReceipt(id string) (models.ReceiptWithPoints, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.config.Clock.Now()
+	s.expire(now)
+	if entry, found := s.receipts[id]; found {
+		s.byUse.MoveToBack(entry.use)
+		return entry.record, true
+	}
+
+	receipt, found := s.getCold(id, now)
+	if found {
+		s.stats.ColdHits++
+	}
+	return receipt.Record, found
+}
+
+func (s *BoundedStorage) GetPoints(id string) (int64, bool) {
+	record, found := s.GetReceipt(id)
+	return record.Points, found
+}
+
+// UpdateReceipt moves a receipt read from the cold store back into memory
+func (s *BoundedStorage) UpdateReceipt(id string, update func(*models.ReceiptWithPoints) ([]models.ReceiptEvent, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.config.Clock.Now()
+	s.expire(now)
+
+	var record models.ReceiptWithPoints
+	var expiresAt time.Time
+	entry, hot := s.receipts[id]
+	if hot {
+		record, expiresAt = entry.record, entry.expiresAt
+	} else {
+		receipt, found := s.getCold(id, now)
+		if !found {
+			return ErrNotFound
+		}
+		record, expiresAt = receipt.Record, receipt.ExpiresAt
+	}
+
+	events, err := update(&record)
+	if err != nil {
+		return err
+	}
+	record.ID = id
+
+	if hot {
+		// keep its place in byAge, it expires when it would have
+		s.bytes -= entry.size
+		entry.record = record
+		entry.size = encodedSize(record)
+		s.bytes += entry.size
+		s.byUse.MoveToBack(entry.use)
+	} else {
+		s.insert(record, expiresAt)
+		s.deleteCold(id)
+	}
+	s.outbox = append(s.outbox, events...)
+	s.evict(now)
+	return nil
+}
+
+// ListReceipts lists receipts in memory and in the cold store. Only the
+// cold receipts on the page are read from the store, holding the lock.
+// Listing does not count as a use for EvictLRU.
+func (s *BoundedStorage) ListReceipts(filter ReceiptFilter) ([]models.ReceiptWithPoints, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.config.Clock.Now()
+	records, err := s.matching(filter, now)
+	if err != nil {
+		return nil, err
+	}
+	records = filter.page(records)
+
+	// cold receipts are matched from their index entries, which only hold
+	// the listing fields
+	page := records[:0]
+	for _, record := range records {
+		if _, hot := s.receipts[record.ID]; hot {
+			page = append(page, record)
+			continue
+		}
+		if receipt, found := s.getCold(record.ID, now); found {
+			page = append(page, receipt.Record)
+		}
+	}
+	return page, nil
+}
+
+// CountReceipts counts cold receipts from the index without reading them
+func (s *BoundedStorage) CountReceipts(filter ReceiptFilter) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records, err := s.matching(filter, s.config.Clock.Now())
+	return len(records), err
+}
+
+// matching returns the receipts in memory that match filter, and the index
+// entries of the cold receipts that do, unsorted. Expired cold receipts
+// found on the way are deleted.
+func (s *BoundedStorage) matching(filter ReceiptFilter, now time.Time) ([]models.ReceiptWithPoints, error) {
+	s.expire(now)
+	if err := s.loadCold(); err != nil {
+		return nil, err
+	}
+
+	var records []models.ReceiptWithPoints
+	for _, entry := range s.receipts {
+		if filter.matches(entry.record) {
+			records = append(records, entry.record)
+		}
+	}
+	for id, entry := range s.cold {
+		switch {
+		case expired(entry.expiresAt, now):
+			s.expireCold(id, entry.record.ClientID, now)
+		case filter.matches(entry.record):
+			records = append(records, entry.record)
+		}
+	}
+	return records, nil
+}
+
+// SweepCold deletes the receipts in the cold store that have expired,
+// finding them in the index
+func (s *BoundedStorage) SweepCold() error {
+	if s.config.Cold == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.loadCold(); err != nil {
+		return err
+	}
+	now := s.config.Clock.Now()
+	for id, entry := range s.cold {
+		if expired(entry.expiresAt, now) {
+			s.expireCold(id, entry.record.ClientID, now)
+		}
+	}
+	return nil
+}
+
+// loadCold indexes the receipts already in the cold store, once. Receipts
+// also in memory are left out, the copy in memory is the current one.
+func (s *BoundedStorage) loadCold() error {
+	if s.config.Cold == nil || s.coldLoaded {
+		return nil
+	}
+	receipts, err := s.config.Cold.List()
+	if err != nil {
+		return err
+	}
+	for _, receipt := range receipts {
+		if _, hot := s.receipts[receipt.Record.ID]; !hot {
+			s.cold[receipt.Record.ID] = newColdEntry(receipt)
+		}
+	}
+	s.coldLoaded = true
+	return nil
+}
+
+func (s *BoundedStorage) PendingEvents(limit int) ([]models.ReceiptEvent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if limit > len(s.outbox) {
+		limit = len(s.outbox)
+	}
+	return append([]models.ReceiptEvent(nil), s.outbox[:limit]...), nil
+}
+
+func (s *BoundedStorage) MarkPublished(ids ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	published := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		published[id] = true
+	}
+	pending := s.outbox[:0]
+	for _, event := range s.outbox {
+		if !published[event.ID] {
+			pending = append(pending, event)
+		}
+	}
+	clear(s.outbox[len(pending):])
+	s.outbox = pending
+	return nil
+}
+
+func (s *BoundedStorage) PublishEvents(limit int, publish func([]models.ReceiptEvent) error) (int, error) {
+	return publishPending(&s.publishing, s, limit, publish)
+}
+
+// Removed reports why a receipt that is no longer stored was dropped
+func (s *BoundedStorage) Removed(id string) (Removal, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(s.config.Clock.Now())
+	removal, found := s.tombstones[id]
+	return removal, found
+}
+
+// Stats returns the current size and the eviction counters
+func (s *BoundedStorage) Stats() models.StorageStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := s.stats
+	stats.Receipts = len(s.receipts)
+	stats.Bytes = s.bytes
+	return stats
+}
+
+func (s *BoundedStorage) insert(record models.ReceiptWithPoints, expiresAt time.Time) {
+	entry := &boundedEntry{
+		record:    record,
+		size:      encodedSize(record),
+		expiresAt: expiresAt,
+	}
+	entry.age = s.insertByExpiry(record.ID, expiresAt)
+	entry.use = s.byUse.PushBack(record.ID)
+	s.receipts[record.ID] = entry
+	s.bytes += entry.size
+	s.forget(record.ID)
+}
+
+// insertByExpiry adds id to byAge after every receipt expiring no later.
+// New receipts go at the back, but one moved back from the cold store
+// goes in front of the receipts stored after it.
+func (s *BoundedStorage) insertByExpiry(id string, expiresAt time.Time) *list.Element {
+	for e := s.byAge.Back(); e != nil; e = e.Prev() {
+		if !expiresBefore(expiresAt, s.receipts[e.Value.(string)].expiresAt) {
+			return s.byAge.InsertAfter(id, e)
+		}
+	}
+	return s.byAge.PushFront(id)
+}
+
+// drop removes a receipt from memory
+func (s *BoundedStorage) drop(id string, entry *boundedEntry) {
+	s.byAge.Remove(entry.age)
+	s.byUse.Remove(entry.use)
+	s.bytes -= entry.size
+	delete(s.receipts, id)
+}
+
+// expire drops every receipt past its TTL, which are at the front of byAge
+func (s *BoundedStorage) expire(now time.Time) {
+	for front := s.byAge.Front(); front != nil; front = s.byAge.Front() {
+		id := front.Value.(string)
+		entry := s.receipts[id]
+		if !expired(entry.expiresAt, now) {
+			return
+		}
+		s.drop(id, entry)
+		s.remember(id, Removal{Reason: RemovedExpired, ClientID: entry.record.ClientID, At: now})
+		s.stats.Expired++
+	}
+}
+
+// evict drops receipts by policy until both limits hold, moving them to
+// the cold store when there is one
+func (s *BoundedStorage) evict(now time.Time) {
+	for len(s.receipts) > 0 && s.overLimit() {
+		victims := s.byUse
+		if s.config.Policy == EvictOldest {
+			victims = s.byAge
+		}
+		id := victims.Front().Value.(string)
+		entry := s.receipts[id]
+		s.drop(id, entry)
+		s.stats.Evicted++
+
+		if s.config.Cold != nil {
+			receipt := ColdReceipt{Record: entry.record, ExpiresAt: entry.expiresAt}
+			err := s.config.Cold.Put(receipt)
+			if err == nil {
+				s.cold[id] = newColdEntry(receipt)
+				s.stats.Spilled++
+				continue
+			}
+			s.stats.SpillFailures++
+			utils.Logger.WithError(err).WithField("id", id).Error("Failed to spill evicted receipt")
+		}
+		s.remember(id, Removal{Reason: RemovedEvicted, ClientID: entry.record.ClientID, At: now})
+	}
+}
+
+func (s *BoundedStorage) overLimit() bool {
+	return (s.config.MaxReceipts > 0 && len(s.receipts) > s.config.MaxReceipts) ||
+		(s.config.MaxBytes > 0 && s.bytes > s.config.MaxBytes)
+}
+
+// getCold reads a receipt from the cold store, dropping it there if it
+// has expired
+func (s *BoundedStorage) getCold(id string, now time.Time) (ColdReceipt, bool) {
+	if s.config.Cold == nil {
+		return ColdReceipt{}, false
+	}
+	receipt, found, err := s.config.Cold.Get(id)
+	if err != nil {
+		utils.Logger.WithError(err).WithField("id", id).Error("Failed to read cold receipt")
+		return ColdReceipt{}, false
+	}
+	if !found {
+		delete(s.cold, id)
+		return ColdReceipt{}, false
+	}
+	if expired(receipt.ExpiresAt, now) {
+		s.expireCold(id, receipt.Record.ClientID, now)
+		return ColdReceipt{}, false
+	}
+	return receipt, true
+}
+
+// expireCold drops a cold receipt past its TTL
+func (s *BoundedStorage) expireCold(id, clientID string, now time.Time) {
+	s.deleteCold(id)
+	s.remember(id, Removal{Reason: RemovedExpired, ClientID: clientID, At: now})
+	s.stats.Expired++
+}
+
+// deleteCold removes a receipt from the cold store and its index. A copy
+// left behind is shadowed by the one in memory, so failing is only logged.
+func (s *BoundedStorage) deleteCold(id string) {
+	delete(s.cold, id)
+	if err := s.config.Cold.Delete(id); err != nil {
+		utils.Logger.WithError(err).WithField("id", id).Warn("Failed to delete cold receipt")
+	}
+}
+
+// remember adds a tombstone, forgetting the oldest past MaxTombstones
+func (s *BoundedStorage) remember(id string, removal Removal) {
+	s.forget(id)
+	s.tombstones[id] = removal
+	s.tombstoneOrder.PushBack(id)
+	for len(s.tombstones) > s.config.MaxTombstones {
+		oldest := s.tombstoneOrder.Remove(s.tombstoneOrder.Front()).(string)
+		delete(s.tombstones, oldest)
+	}
+}
+
+// forget drops the tombstone of a receipt that is stored again
+func (s *BoundedStorage) forget(id string) {
+	if _, found := s.tombstones[id]; !found {
+		return
+	}
+	delete(s.tombstones, id)
+	for e := s.tombstoneOrder.Front(); e != nil; e = e.Next() {
+		if e.Value.(string) == id {
+			s.tombstoneOrder.Remove(e)
+			return
+		}
+	}
+}
+
+func expired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// expiresBefore reports whether a expires before b, where zero is never
+func expiresBefore(a, b time.Time) bool {
+	return !a.IsZero() && (b.IsZero() || a.Before(b))
+}
+
+// encodedSize approximates a receipt's memory by its JSON size
+func encodedSize(record models.ReceiptWithPoints) int64 {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+func newColdStore(t *testing.T) *FileColdStore {
+	t.Helper()
+	cold, err := NewFileColdStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open cold store: %v", err)
+	}
+	return cold
+}
+
+// expectRemoved fails unless id was dropped for reason
+func expectRemoved(t *testing.T, s *BoundedStorage, id string, reason RemovalReason) {
+	t.Helper()
+	if _, found := s.GetReceipt(id); found {
+		t.Errorf("Expected %s to be gone", id)
+	}
+	if removal, found := s.Removed(id); !found || removal.Reason != reason || removal.ClientID != "alice" {
+		t.Errorf("Expected %s to be %s, got %+v (found %v)", id, reason, removal, found)
+	}
+}
+
+// countingColdStore counts the reads from a cold store
+type countingColdStore struct {
+	ColdStore
+	gets, lists int
+}
+
+func (s *countingColdStore) Get(id string) (ColdReceipt, bool, error) {
+	s.gets++
+	return s.ColdStore.Get(id)
+}
+
+func (s *countingColdStore) List() ([]ColdReceipt, error) {
+	s.lists++
+	return s.ColdStore.List()
+}
+
+func TestBoundedStorage(t *testing.T) {
+	t.Run("lru eviction", func(t *testing.T) {
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 2})
+		s.SaveReceipt("a", testRecord(1))
+		s.SaveReceipt("b", testRecord(2))
+		s.GetReceipt("a")
+		s.SaveReceipt("c", testRecord(3))
+
+		expectRemoved(t, s, "b", RemovedEvicted)
+		for _, id := range []string{"a", "c"} {
+			if _, found := s.GetReceipt(id); !found {
+				t.Errorf("Expected %s to be kept", id)
+			}
+		}
+		if stats := s.Stats(); stats.Receipts != 2 || stats.Evicted != 1 || stats.Spilled != 0 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("oldest eviction", func(t *testing.T) {
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 2, Policy: EvictOldest})
+		s.SaveReceipt("a", testRecord(1))
+		s.SaveReceipt("b", testRecord(2))
+		s.GetReceipt("a")
+		s.SaveReceipt("c", testRecord(3))
+
+		expectRemoved(t, s, "a", RemovedEvicted)
+		if _, found := s.GetReceipt("b"); !found {
+			t.Error("Expected b to be kept")
+		}
+	})
+
+	t.Run("byte limit", func(t *testing.T) {
+		stored := testRecord(1)
+		stored.ID = "a"
+		size := encodedSize(stored)
+		s := NewBoundedStorage(BoundedConfig{MaxBytes: 3 * size})
+		for _, id := range []string{"a", "b", "c", "d"} {
+			s.SaveReceipt(id, testRecord(1))
+		}
+		// the IDs are all one byte, so every record has the same size
+		if stats := s.Stats(); stats.Receipts != 3 || stats.Bytes > 3*size || stats.Evicted != 1 {
+			t.Errorf("Expected 3 receipts within %d bytes, got %+v", 3*size, stats)
+		}
+		expectRemoved(t, s, "a", RemovedEvicted)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+		s := NewBoundedStorage(BoundedConfig{TTL: time.Hour, Clock: clock})
+		s.SaveReceipt("a", testRecord(1))
+		clock.Advance(30 * time.Minute)
+		s.SaveReceipt("b", testRecord(2))
+		// updates do not extend the TTL
+		s.UpdateReceipt("a", func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+			record.Points = 10
+			return nil, nil
+		})
+
+		clock.Advance(30 * time.Minute)
+		expectRemoved(t, s, "a", RemovedExpired)
+		if points, found := s.GetPoints("b"); !found || points != 2 {
+			t.Errorf("Expected b to be kept, got %d (found %v)", points, found)
+		}
+		if _, found := s.Removed("never"); found {
+			t.Error("Expected an unknown ID not to be reported as removed")
+		}
+		if err := s.UpdateReceipt("a", nil); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound updating an expired receipt, got %v", err)
+		}
+
+		// storing the ID again forgets it was removed
+		s.SaveReceipt("a", testRecord(3))
+		if _, found := s.Removed("a"); found {
+			t.Error("Expected a stored receipt not to be reported as removed")
+		}
+		if stats := s.Stats(); stats.Expired != 1 || stats.Receipts != 2 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("spill to cold store", func(t *testing.T) {
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, Cold: newColdStore(t)})
+		s.SaveReceipt("a", testRecord(1))
+		s.SaveReceipt("b", testRecord(2))
+
+		if points, found := s.GetPoints("a"); !found || points != 1 {
+			t.Errorf("Expected a from the cold store, got %d (found %v)", points, found)
+		}
+		if _, found := s.Removed("a"); found {
+			t.Error("Expected a spilled receipt not to be reported as removed")
+		}
+		if records, _ := s.ListReceipts(ReceiptFilter{}); len(records) != 2 {
+			t.Errorf("Expected both receipts listed, got %d", len(records))
+		}
+
+		// updating brings a back and spills b
+		err := s.UpdateReceipt("a", func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+			record.Status = models.StatusRejected
+			return []models.ReceiptEvent{{ID: "e1"}}, nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if record, _ := s.GetReceipt("a"); record.Status != models.StatusRejected {
+			t.Errorf("Expected the update to be kept, got %+v", record)
+		}
+		if records, _ := s.ListReceipts(ReceiptFilter{Status: models.StatusRejected}); len(records) != 1 {
+			t.Errorf("Expected one rejected receipt, got %d", len(records))
+		}
+		if pending, _ := s.PendingEvents(10); len(pending) != 1 {
+			t.Errorf("Expected the update's event, got %+v", pending)
+		}
+		if stats := s.Stats(); stats.Receipts != 1 || stats.Evicted != 2 || stats.Spilled != 2 || stats.ColdHits != 1 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("cold receipts expire", func(t *testing.T) {
+		clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+		cold := newColdStore(t)
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, TTL: time.Hour, Cold: cold, Clock: clock})
+		s.SaveReceipt("a", testRecord(1))
+		clock.Advance(time.Minute)
+		s.SaveReceipt("b", testRecord(2))
+
+		clock.Advance(59 * time.Minute)
+		if records, _ := s.ListReceipts(ReceiptFilter{}); len(records) != 1 {
+			t.Errorf("Expected the expired cold receipt not to be listed, got %d", len(records))
+		}
+		expectRemoved(t, s, "a", RemovedExpired)
+		if _, found, _ := cold.Get("a"); found {
+			t.Error("Expected the expired receipt to be deleted from the cold store")
+		}
+	})
+
+	t.Run("cold receipts moved back expire in order", func(t *testing.T) {
+		clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 2, TTL: time.Hour, Cold: newColdStore(t), Clock: clock})
+		defer s.Close()
+		for _, id := range []string{"a", "b", "c"} {
+			s.SaveReceipt(id, testRecord(1))
+			clock.Advance(time.Minute)
+		}
+		// a comes back from the cold store behind c, which expires after it
+		s.UpdateReceipt("a", func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+			record.Points = 2
+			return nil, nil
+		})
+
+		clock.Advance(58 * time.Minute)
+		expectRemoved(t, s, "a", RemovedExpired)
+		if _, found := s.GetReceipt("c"); !found {
+			t.Error("Expected c to be kept until its TTL")
+		}
+	})
+
+	t.Run("cold store sweep", func(t *testing.T) {
+		clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+		cold := newColdStore(t)
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, TTL: time.Hour, Cold: cold, Clock: clock})
+		defer s.Close()
+		s.SaveReceipt("a", testRecord(1))
+		clock.Advance(time.Minute)
+		s.SaveReceipt("b", testRecord(2))
+
+		clock.Advance(59 * time.Minute)
+		if err := s.SweepCold(); err != nil {
+			t.Fatalf("Sweep failed: %v", err)
+		}
+		if _, found, _ := cold.Get("a"); found {
+			t.Error("Expected the sweep to delete the expired receipt")
+		}
+		if stats := s.Stats(); stats.Expired != 1 {
+			t.Errorf("Expected 1 expired receipt, got %+v", stats)
+		}
+		expectRemoved(t, s, "a", RemovedExpired)
+	})
+
+	t.Run("listing reads only the page from the cold store", func(t *testing.T) {
+		clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+		cold := &countingColdStore{ColdStore: newColdStore(t)}
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, Cold: cold, Clock: clock})
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			record := testRecord(1)
+			record.SubmittedAt = clock.Now()
+			s.SaveReceipt(id, record)
+			clock.Advance(time.Minute)
+		}
+
+		if count, err := s.CountReceipts(ReceiptFilter{ClientID: "alice"}); err != nil || count != 5 {
+			t.Errorf("Expected 5 receipts counted, got %d, %v", count, err)
+		}
+		records, err := s.ListReceipts(ReceiptFilter{After: &ReceiptCursor{SubmittedAt: clock.Now().Add(-5 * time.Minute), ID: "a"}, Limit: 2})
+		if err != nil || len(records) != 2 || records[0].ID != "b" || records[1].ID != "c" || records[1].Points != 1 {
+			t.Errorf("Expected b and c in full, got %+v, %v", records, err)
+		}
+		// the store is listed once, to load the index
+		if cold.lists != 1 || cold.gets != 2 {
+			t.Errorf("Expected one listing and the page's 2 receipts read, got %d lists and %d gets", cold.lists, cold.gets)
+		}
+
+		// a new storage indexes what the store already holds
+		reopened := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, Cold: cold, Clock: clock})
+		if count, _ := reopened.CountReceipts(ReceiptFilter{}); count != 4 {
+			t.Errorf("Expected the 4 spilled receipts counted, got %d", count)
+		}
+	})
+
+	t.Run("failed spill", func(t *testing.T) {
+		cold := newColdStore(t)
+		os.RemoveAll(cold.dir)
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, Cold: cold})
+		s.SaveReceipt("a", testRecord(1))
+		s.SaveReceipt("b", testRecord(2))
+
+		expectRemoved(t, s, "a", RemovedEvicted)
+		if stats := s.Stats(); stats.SpillFailures != 1 || stats.Spilled != 0 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("tombstone limit", func(t *testing.T) {
+		s := NewBoundedStorage(BoundedConfig{MaxReceipts: 1, MaxTombstones: 2})
+		for _, id := range []string{"a", "b", "c", "d"} {
+			s.SaveReceipt(id, testRecord(1))
+		}
+		if _, found := s.Removed("a"); found {
+			t.Error("Expected the oldest tombstone to be forgotten")
+		}
+		for _, id := range []string{"b", "c"} {
+			if _, found := s.Removed(id); !found {
+				t.Errorf("Expected %s to be remembered", id)
+			}
+		}
+	})
+}
+
+func TestFileColdStore(t *testing.T) {
+	cold := newColdStore(t)
+	record := testRecord(5)
+	// IDs are hashed, so path characters are harmless
+	record.ID = "../../escape"
+	if err := cold.Put(ColdReceipt{Record: record}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	receipt, found, err := cold.Get(record.ID)
+	if err != nil || !found || receipt.Record.Points != 5 {
+		t.Fatalf("Expected the receipt back, got %+v (found %v, err %v)", receipt, found, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(cold.dir, "*"+coldFileSuffix))
+	if len(files) != 1 {
+		t.Fatalf("Expected one file in the store, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	data[len(data)-2] ^= 0xff
+	os.WriteFile(files[0], data, 0o644)
+	if _, _, err := cold.Get(record.ID); !errors.Is(err, ErrCorruptColdReceipt) {
+		t.Errorf("Expected ErrCorruptColdReceipt, got %v", err)
+	}
+
+	if err := cold.Delete(record.ID); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if err := cold.Delete(record.ID); err != nil {
+		t.Errorf("Expected deleting twice to succeed, got %v", err)
+	}
+	if receipts, _ := cold.List(); len(receipts) != 0 {
+		t.Errorf("Expected an empty store, got %d receipts", len(receipts))
+	}
+	if entries, _ := os.ReadDir(cold.dir); len(entries) != 0 || strings.Contains(cold.path(record.ID), "..") {
+		t.Errorf("Expected no files left, got %v", entries)
+	}
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// ClientStorage keeps API clients, looked up by the hash of their key
+type ClientStorage interface {
+	SaveClient(client models.Client) error
+	GetClient(id string) (models.Client, bool)
+	GetClientByKeyHash(hash string) (models.Client, bool)
+}
+
+type InMemoryClientStorage struct {
+	clients   map[string]models.Client
+	keyHashes map[string]string
+	mutex     *sync.RWMutex
+}
+
+func NewInMemoryClientStorage() *InMemoryClientStorage {
+	return &InMemoryClientStorage{
+		clients:   map[string]models.Client{},
+		keyHashes: map[string]string{},
+		mutex:     &sync.RWMutex{},
+	}
+}
+
+func (s *InMemoryClientStorage) SaveClient(client models.Client) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// drop the old key when a client is re-keyed
+	if old, found := s.clients[client.ID]; found {
+		delete(s.keyHashes, old.APIKeyHash)
+	}
+	s.clients[client.ID] = client
+	s.keyHashes[client.APIKeyHash] = client.ID
+	return nil
+}
+
+func (s *InMemoryClientStorage) GetClient(id string) (models.Client, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	client, found := s.clients[id]
+	return client, found
+}
+
+func (s *InMemoryClientStorage) GetClientByKeyHash(hash string) (models.Client, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	id, found := s.keyHashes[hash]
+	if !found {
+		return models.Client{}, false
+	}
+	return s.clients[id], true
+}
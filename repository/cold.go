@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// ErrCorruptColdReceipt is a cold store file that fails its checksum
+var ErrCorruptColdReceipt = errors.New("cold receipt is corrupt")
+
+const coldFileSuffix = ".rec"
+
+// ColdReceipt is a receipt evicted from memory, with the time it expires,
+// zero for never
+type ColdReceipt struct {
+	Record    models.ReceiptWithPoints `json:"record"`
+	ExpiresAt time.Time                `json:"expiresAt"`
+}
+
+// ColdStore keeps receipts BoundedStorage evicts so they can still be read
+type ColdStore interface {
+	Put(receipt ColdReceipt) error
+	Get(id string) (ColdReceipt, bool, error)
+	Delete(id string) error
+	List() ([]ColdReceipt, error)
+}
+
+// FileColdStore keeps each receipt in its own file in a directory, framed
+// and checksummed like the write-ahead log
+type FileColdStore struct {
+	dir string
+}
+
+func NewFileColdStore(dir string) (*FileColdStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileColdStore{dir: dir}, nil
+}
+
+// path names files by a hash of the ID, so any ID is a safe file name
+func (s *FileColdStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+coldFileSuffix)
+}
+
+// Put writes the receipt to a temporary file and renames it into place, so
+// a crash leaves either the old copy or the new one
+func (s *FileColdStore) Put(receipt ColdReceipt) error {
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	var frame bytes.Buffer
+	if err := writeFrame(&frame, payload); err != nil {
+		return err
+	}
+
+	path := s.path(receipt.Record.ID)
+	tmp, err := os.CreateTemp(s.dir, "put-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(frame.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileColdStore) Get(id string) (ColdReceipt, bool, error) {
+	receipt, err := s.read(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ColdReceipt{}, false, nil
+	}
+	if err != nil {
+		return ColdReceipt{}, false, err
+	}
+	return receipt, true, nil
+}
+
+func (s *FileColdStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List reads every receipt in the store
+func (s *FileColdStore) List() ([]ColdReceipt, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var receipts []ColdReceipt
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), coldFileSuffix) {
+			continue
+		}
+		receipt, err := s.read(filepath.Join(s.dir, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// deleted since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+func (s *FileColdStore) read(path string) (ColdReceipt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ColdReceipt{}, err
+	}
+	payload, size, err := readFrame(data)
+	if err != nil || size != len(data) {
+		return ColdReceipt{}, fmt.Errorf("%w: %s", ErrCorruptColdReceipt, path)
+	}
+	var receipt ColdReceipt
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		return ColdReceipt{}, fmt.Errorf("%w: %s", ErrCorruptColdReceipt, path)
+	}
+	return receipt, nil
+}
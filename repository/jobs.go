@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// JobStorage keeps asynchronous receipt jobs
+type JobStorage interface {
+	// SaveJob inserts or replaces the job with the same ID
+	SaveJob(job models.Job) error
+	GetJob(id string) (models.Job, bool)
+	DeleteJob(id string) error
+	// DeleteFinishedBefore drops jobs that finished before cutoff and
+	// returns how many it dropped
+	DeleteFinishedBefore(cutoff time.Time) (int, error)
+}
+
+type InMemoryJobStorage struct {
+	jobs  map[string]models.Job
+	mutex *sync.RWMutex
+}
+
+func NewInMemoryJobStorage() *InMemoryJobStorage {
+	return &InMemoryJobStorage{
+		jobs:  map[string]models.Job{},
+		mutex: &sync.RWMutex{},
+	}
+}
+
+func (s *InMemoryJobStorage) SaveJob(job models.Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *InMemoryJobStorage) GetJob(id string) (models.Job, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	job, found := s.jobs[id]
+	return job, found
+}
+
+func (s *InMemoryJobStorage) DeleteJob(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *InMemoryJobStorage) DeleteFinishedBefore(cutoff time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted := 0
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,77 @@
+-- Receipts with their items, discounts, points breakdown and fraud signals
+-- in tables of their own. Amounts are kept as the text that was sent, so a
+-- stored receipt reads back exactly as it was submitted.
+
+CREATE TABLE receipts (
+    id             text PRIMARY KEY,
+    client_id      text NOT NULL,
+    retailer       text NOT NULL,
+    retailer_id    text NOT NULL DEFAULT '',
+    purchase_date  text NOT NULL,
+    purchase_time  text NOT NULL,
+    purchased_at   text NOT NULL DEFAULT '',
+    time_zone      text NOT NULL DEFAULT '',
+    timestamp_zone text NOT NULL DEFAULT '',
+    total          text NOT NULL,
+    subtotal       text NOT NULL DEFAULT '',
+    tax            text NOT NULL DEFAULT '',
+    tip            text NOT NULL DEFAULT '',
+    -- payment_method is NULL when the receipt has no payment
+    payment_method text,
+    payment_last4  text NOT NULL DEFAULT '',
+    points         bigint NOT NULL,
+    status         text NOT NULL,
+    fraud_score    double precision NOT NULL DEFAULT 0,
+    fraud_held     boolean NOT NULL DEFAULT false,
+    submitted_at   timestamptz NOT NULL
+);
+
+CREATE INDEX receipts_by_client ON receipts (client_id, submitted_at, id);
+CREATE INDEX receipts_by_status ON receipts (status, submitted_at, id);
+
+CREATE TABLE receipt_items (
+    receipt_id        text NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+    position          integer NOT NULL,
+    short_description text NOT NULL,
+    price             text NOT NULL,
+    quantity          text NOT NULL DEFAULT '',
+    unit_price        text NOT NULL DEFAULT '',
+    sku               text NOT NULL DEFAULT '',
+    upc               text NOT NULL DEFAULT '',
+    category          text NOT NULL DEFAULT '',
+    PRIMARY KEY (receipt_id, position)
+);
+
+CREATE TABLE receipt_discounts (
+    receipt_id  text NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+    position    integer NOT NULL,
+    description text NOT NULL,
+    amount      text NOT NULL,
+    PRIMARY KEY (receipt_id, position)
+);
+
+-- the rules and promotions that make up receipts.points
+CREATE TABLE receipt_points (
+    receipt_id text NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+    position   integer NOT NULL,
+    rule       text NOT NULL,
+    points     bigint NOT NULL,
+    PRIMARY KEY (receipt_id, position)
+);
+
+CREATE TABLE receipt_fraud_signals (
+    receipt_id text NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+    position   integer NOT NULL,
+    rule       text NOT NULL,
+    score      double precision NOT NULL,
+    detail     text NOT NULL,
+    PRIMARY KEY (receipt_id, position)
+);
+
+-- events are written in the receipt's transaction and deleted once the
+-- event bus has published them
+CREATE TABLE receipt_outbox (
+    seq   bigserial PRIMARY KEY,
+    id    text NOT NULL UNIQUE,
+    event jsonb NOT NULL
+);
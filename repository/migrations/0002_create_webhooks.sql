@@ -0,0 +1,19 @@
+-- Webhook subscriptions and their deliveries, kept as the JSON the service
+-- works with. json rather than jsonb keeps a delivery's payload byte for
+-- byte, so every attempt sends and signs the same body.
+
+CREATE TABLE webhook_subscriptions (
+    id           text PRIMARY KEY,
+    subscription json NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id              text PRIMARY KEY,
+    subscription_id text NOT NULL,
+    status          text NOT NULL,
+    created_at      timestamptz NOT NULL,
+    delivery        json NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_by_subscription ON webhook_deliveries (subscription_id, created_at, id);
+CREATE INDEX webhook_deliveries_by_status ON webhook_deliveries (status, created_at, id);
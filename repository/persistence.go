@@ -0,0 +1,509 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var (
+	// ErrCorruptLog means an entry in the middle of the write-ahead log is
+	// damaged. Only a torn entry at the very end is repaired on its own.
+	ErrCorruptLog = errors.New("Write-ahead log is corrupt")
+	// ErrCorruptSnapshot means the snapshot does not match its checksum
+	ErrCorruptSnapshot = errors.New("Snapshot is corrupt")
+	ErrStorageClosed   = errors.New("Storage is closed")
+)
+
+// DefaultSnapshotInterval is used when PersistenceConfig leaves it at zero
+const DefaultSnapshotInterval = 5 * time.Minute
+
+const (
+	snapshotFile    = "snapshot.dat"
+	segmentPattern  = "wal-*.log"
+	segmentNameForm = "wal-%020d.log"
+)
+
+// PersistenceConfig makes an InMemoryStorage durable
+type PersistenceConfig struct {
+	// Dir holds the snapshot and the write-ahead log. It is created if
+	// missing and must not be shared by two running processes.
+	Dir string
+	// SnapshotInterval is how often the map is written out so the log can
+	// be dropped
+	SnapshotInterval time.Duration
+}
+
+// walEntry is one change in the write-ahead log. Puts carry the record as
+// stored after the change, so replaying one twice does no harm.
+type walEntry struct {
+	Seq       uint64                    `json:"seq"`
+	Op        string                    `json:"op"`
+	Record    *models.ReceiptWithPoints `json:"record,omitempty"`
+	Events    []models.ReceiptEvent     `json:"events,omitempty"`
+	Published []string                  `json:"published,omitempty"`
+}
+
+const (
+	opPut       = "put"
+	opPublished = "published"
+)
+
+// snapshot is the whole store as of entry Seq
+type snapshot struct {
+	Seq      uint64                     `json:"seq"`
+	Receipts []models.ReceiptWithPoints `json:"receipts"`
+	Outbox   []models.ReceiptEvent      `json:"outbox"`
+}
+
+// snapshotHeader is the first frame of a snapshot file. The receipts and
+// then the outbox events follow it one frame each, so no frame grows with
+// the store. A header without a version is an older snapshot that holds
+// the whole store in that one frame.
+type snapshotHeader struct {
+	Version int    `json:"version"`
+	Seq     uint64 `json:"seq"`
+	Records int    `json:"records"`
+	Events  int    `json:"events"`
+}
+
+const snapshotVersion = 2
+
+// persistence is the on-disk side of a durable InMemoryStorage. Its log
+// fields are guarded by the storage's mutex.
+type persistence struct {
+	dir     string
+	segment *os.File
+	// seq is the last entry written
+	seq uint64
+	// err stops all writes once the log may hold a partial entry
+	err error
+
+	// snapshotting guards snapshotSeq, the last entry in the snapshot
+	snapshotting sync.Mutex
+	snapshotSeq  uint64
+	done         chan struct{}
+	snapshotter  sync.WaitGroup
+	close        sync.Once
+}
+
+// OpenInMemoryStorage loads the store kept in config.Dir and logs every
+// change to it from then on. Every write is fsynced to the write-ahead log
+// before it is applied to the map, and the map is snapshotted every
+// SnapshotInterval. Close takes a last snapshot.
+func OpenInMemoryStorage(config PersistenceConfig) (*InMemoryStorage, error) {
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := NewInMemoryStorage()
+	p := &persistence{dir: config.Dir, done: make(chan struct{})}
+	if err := s.replay(p); err != nil {
+		return nil, err
+	}
+
+	segment, err := p.openSegment(p.seq + 1)
+	if err != nil {
+		return nil, err
+	}
+	p.segment = segment
+	s.persist = p
+
+	p.snapshotter.Add(1)
+	go func() {
+		defer p.snapshotter.Done()
+		ticker := time.NewTicker(config.SnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				if err := s.Snapshot(); err != nil {
+					utils.Logger.WithError(err).Error("Failed to snapshot receipts")
+				}
+			}
+		}
+	}()
+
+	utils.Logger.WithFields(logrus.Fields{
+		"dir":      config.Dir,
+		"receipts": len(s.receiptsWithPoints),
+		"seq":      p.seq,
+	}).Info("Receipt storage loaded")
+	return s, nil
+}
+
+// Snapshot writes the whole map to disk and drops the log it replaces.
+// Writes only wait while the map is copied. Without persistence it does
+// nothing.
+func (s *InMemoryStorage) Snapshot() error {
+	p := s.persist
+	if p == nil {
+		return nil
+	}
+	p.snapshotting.Lock()
+	defer p.snapshotting.Unlock()
+
+	// copy the map and start a new log segment in one step, so the
+	// snapshot holds exactly the entries before the new segment
+	s.mutex.Lock()
+	if p.err != nil {
+		s.mutex.Unlock()
+		return p.err
+	}
+	if p.seq == p.snapshotSeq {
+		s.mutex.Unlock()
+		return nil
+	}
+	snap := snapshot{
+		Seq:      p.seq,
+		Receipts: make([]models.ReceiptWithPoints, 0, len(s.receiptsWithPoints)),
+		Outbox:   append([]models.ReceiptEvent{}, s.outbox...),
+	}
+	for _, record := range s.receiptsWithPoints {
+		snap.Receipts = append(snap.Receipts, record)
+	}
+	segment, err := p.openSegment(p.seq + 1)
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	p.segment.Close()
+	p.segment = segment
+	s.mutex.Unlock()
+
+	if err := p.writeSnapshot(snap); err != nil {
+		return err
+	}
+	p.snapshotSeq = snap.Seq
+	return p.removeSegmentsBefore(snap.Seq + 1)
+}
+
+// Close takes a last snapshot and closes the log. Writes after Close fail
+// with ErrStorageClosed. Without persistence it does nothing.
+func (s *InMemoryStorage) Close() error {
+	p := s.persist
+	if p == nil {
+		return nil
+	}
+
+	var err error
+	p.close.Do(func() {
+		close(p.done)
+		p.snapshotter.Wait()
+		err = s.Snapshot()
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if p.err == nil {
+			p.err = ErrStorageClosed
+		}
+		if closeErr := p.segment.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// log appends entry to the write-ahead log and fsyncs it. The caller holds
+// the storage's write lock and applies the change only if this succeeds.
+func (s *InMemoryStorage) log(entry walEntry) error {
+	p := s.persist
+	if p == nil {
+		return nil
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	entry.Seq = p.seq + 1
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFrame(p.segment, payload); err != nil {
+		p.err = fmt.Errorf("write-ahead log failed, storage is read-only: %w", err)
+		return p.err
+	}
+	if err := p.segment.Sync(); err != nil {
+		p.err = fmt.Errorf("write-ahead log failed, storage is read-only: %w", err)
+		return p.err
+	}
+	p.seq = entry.Seq
+	return nil
+}
+
+// apply makes the change an entry describes
+func (s *InMemoryStorage) apply(entry walEntry) {
+	switch entry.Op {
+	case opPut:
+		s.receiptsWithPoints[entry.Record.ID] = *entry.Record
+		s.outbox = append(s.outbox, entry.Events...)
+	case opPublished:
+		s.dropEvents(entry.Published)
+	}
+}
+
+// replay loads the snapshot and the log entries after it
+func (s *InMemoryStorage) replay(p *persistence) error {
+	data, err := os.ReadFile(filepath.Join(p.dir, snapshotFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		snap, err := readSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrCorruptSnapshot, filepath.Join(p.dir, snapshotFile), err)
+		}
+		for _, record := range snap.Receipts {
+			s.receiptsWithPoints[record.ID] = record
+		}
+		s.outbox = snap.Outbox
+		p.seq, p.snapshotSeq = snap.Seq, snap.Seq
+	}
+
+	segments, err := p.segments()
+	if err != nil {
+		return err
+	}
+	for i, path := range segments {
+		if err := s.replaySegment(p, path, i == len(segments)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSnapshot decodes a snapshot file, which must end with its last frame
+func readSnapshot(data []byte) (snapshot, error) {
+	var snap snapshot
+	payload, size, err := readFrame(data)
+	if err != nil {
+		return snap, err
+	}
+	var header snapshotHeader
+	if err := json.Unmarshal(payload, &header); err != nil {
+		return snap, err
+	}
+	if header.Version == 0 {
+		if size != len(data) {
+			return snap, errors.New("data after the snapshot")
+		}
+		err := json.Unmarshal(payload, &snap)
+		return snap, err
+	}
+	if header.Version != snapshotVersion {
+		return snap, fmt.Errorf("unknown snapshot version %d", header.Version)
+	}
+
+	snap.Seq = header.Seq
+	snap.Receipts = make([]models.ReceiptWithPoints, header.Records)
+	snap.Outbox = make([]models.ReceiptEvent, header.Events)
+	offset := size
+	next := func(v any) error {
+		payload, size, err := readFrame(data[offset:])
+		if err != nil {
+			return err
+		}
+		offset += size
+		return json.Unmarshal(payload, v)
+	}
+	for i := range snap.Receipts {
+		if err := next(&snap.Receipts[i]); err != nil {
+			return snap, err
+		}
+	}
+	for i := range snap.Outbox {
+		if err := next(&snap.Outbox[i]); err != nil {
+			return snap, err
+		}
+	}
+	if offset != len(data) {
+		return snap, errors.New("data after the snapshot")
+	}
+	return snap, nil
+}
+
+// replaySegment applies the entries of one log file. A damaged entry at
+// the end of the last file is the write a crash interrupted, it was never
+// acknowledged and is cut off. Damage anywhere else is ErrCorruptLog.
+func (s *InMemoryStorage) replaySegment(p *persistence, path string, last bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for offset < len(data) {
+		payload, size, err := readFrame(data[offset:])
+		if err != nil {
+			if last && isTornTail(data[offset:], size, err) {
+				utils.Logger.WithFields(logrus.Fields{
+					"file":   path,
+					"offset": offset,
+					"bytes":  len(data) - offset,
+				}).Warn("Dropping torn entry at the end of the write-ahead log")
+				return truncate(path, int64(offset))
+			}
+			return fmt.Errorf("%w: %s at offset %d: %v", ErrCorruptLog, path, offset, err)
+		}
+
+		var entry walEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return fmt.Errorf("%w: %s at offset %d: %v", ErrCorruptLog, path, offset, err)
+		}
+		switch {
+		case entry.Seq <= p.seq:
+			// already in the snapshot
+		case entry.Seq != p.seq+1:
+			return fmt.Errorf("%w: %s skips from entry %d to %d", ErrCorruptLog, path, p.seq, entry.Seq)
+		default:
+			s.apply(entry)
+			p.seq = entry.Seq
+		}
+		offset += size
+	}
+	return nil
+}
+
+// isTornTail reports whether a bad frame is what an interrupted append
+// leaves: cut short by the end of the file, or followed by nothing but the
+// zeros a file system may fill an unfinished write with
+func isTornTail(rest []byte, size int, err error) bool {
+	if errors.Is(err, errTornFrame) {
+		return true
+	}
+	end := size
+	if end == 0 {
+		end = frameHeaderSize
+	}
+	return len(bytes.Trim(rest[end:], "\x00")) == 0
+}
+
+func truncate(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// segments lists the log files oldest first
+func (p *persistence) segments() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(p.dir, segmentPattern))
+	if err != nil {
+		return nil, err
+	}
+	// zero padded, so names sort by their first entry
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// openSegment opens the log file whose first entry is seq
+func (p *persistence) openSegment(seq uint64) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(p.dir, fmt.Sprintf(segmentNameForm, seq)), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncDir(p.dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeSnapshot replaces the snapshot file atomically
+func (p *persistence) writeSnapshot(snap snapshot) error {
+	tmp := filepath.Join(p.dir, snapshotFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := encodeSnapshot(f, snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(p.dir, snapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(p.dir)
+}
+
+// encodeSnapshot writes the header frame and then one frame per receipt
+// and per outbox event
+func encodeSnapshot(f io.Writer, snap snapshot) error {
+	w := bufio.NewWriter(f)
+	put := func(v any) error {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return writeFrame(w, payload)
+	}
+	header := snapshotHeader{
+		Version: snapshotVersion,
+		Seq:     snap.Seq,
+		Records: len(snap.Receipts),
+		Events:  len(snap.Outbox),
+	}
+	if err := put(header); err != nil {
+		return err
+	}
+	for _, record := range snap.Receipts {
+		if err := put(record); err != nil {
+			return err
+		}
+	}
+	for _, event := range snap.Outbox {
+		if err := put(event); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// removeSegmentsBefore deletes the log files the snapshot made redundant
+func (p *persistence) removeSegmentsBefore(seq uint64) error {
+	current := filepath.Join(p.dir, fmt.Sprintf(segmentNameForm, seq))
+	segments, err := p.segments()
+	if err != nil {
+		return err
+	}
+	for _, path := range segments {
+		if path >= current {
+			break
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return syncDir(p.dir)
+}
@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// writerDirEnv makes TestWriterProcess run as the process the kill test
+// kills
+const writerDirEnv = "RECEIPT_WAL_WRITER_DIR"
+
+func openTestStorage(t *testing.T, dir string) *InMemoryStorage {
+	t.Helper()
+	s, err := OpenInMemoryStorage(PersistenceConfig{Dir: dir, SnapshotInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	return s
+}
+
+// crash stops s without the snapshot Close would take, like a killed
+// process
+func crash(s *InMemoryStorage) {
+	close(s.persist.done)
+	s.persist.snapshotter.Wait()
+	s.persist.segment.Close()
+}
+
+func testRecord(points int64) models.ReceiptWithPoints {
+	return models.ReceiptWithPoints{
+		Receipt:     models.Receipt{Retailer: "Target", Total: "1.25", Items: []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}}},
+		Points:      points,
+		ClientID:    "alice",
+		Status:      models.StatusActive,
+		SubmittedAt: time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func withID(id string, record models.ReceiptWithPoints) models.ReceiptWithPoints {
+	record.ID = id
+	return record
+}
+
+// lastSegment is the newest log file in dir
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	segments, _ := filepath.Glob(filepath.Join(dir, segmentPattern))
+	if len(segments) == 0 {
+		t.Fatal("No log segments")
+	}
+	return segments[len(segments)-1]
+}
+
+func TestPersistence(t *testing.T) {
+	t.Run("replays snapshot and log", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		s.SaveReceipt("r1", testRecord(10), models.ReceiptEvent{ID: "e1", Type: models.ReceiptProcessed})
+		s.SaveReceipt("r2", testRecord(20), models.ReceiptEvent{ID: "e2", Type: models.ReceiptProcessed})
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		s.SaveReceipt("r3", testRecord(30), models.ReceiptEvent{ID: "e3", Type: models.ReceiptProcessed})
+		s.UpdateReceipt("r1", func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+			record.Status = models.StatusRejected
+			return nil, nil
+		})
+		s.MarkPublished("e1", "e3")
+		crash(s)
+
+		s = openTestStorage(t, dir)
+		defer s.Close()
+		records, _ := s.ListReceipts(ReceiptFilter{})
+		if len(records) != 3 {
+			t.Fatalf("Expected 3 receipts, got %d", len(records))
+		}
+		if record, _ := s.GetReceipt("r1"); record.Status != models.StatusRejected || record.Receipt.Items[0].Price != "1.25" {
+			t.Errorf("Expected r1 rejected with its items, got %+v", record)
+		}
+		if points, _ := s.GetPoints("r3"); points != 30 {
+			t.Errorf("Expected r3 to have 30 points, got %d", points)
+		}
+		if pending, _ := s.PendingEvents(10); len(pending) != 1 || pending[0].ID != "e2" {
+			t.Errorf("Expected only e2 left in the outbox, got %+v", pending)
+		}
+	})
+
+	t.Run("close snapshots and drops the log", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		for i := 0; i < 5; i++ {
+			s.SaveReceipt(fmt.Sprintf("r%d", i), testRecord(int64(i)))
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if err := s.SaveReceipt("late", testRecord(1)); !errors.Is(err, ErrStorageClosed) {
+			t.Errorf("Expected ErrStorageClosed after Close, got %v", err)
+		}
+		if info, _ := os.Stat(lastSegment(t, dir)); info.Size() != 0 {
+			t.Errorf("Expected an empty log after the final snapshot, got %d bytes", info.Size())
+		}
+
+		s = openTestStorage(t, dir)
+		defer s.Close()
+		if records, _ := s.ListReceipts(ReceiptFilter{}); len(records) != 5 {
+			t.Errorf("Expected 5 receipts from the snapshot, got %d", len(records))
+		}
+	})
+
+	t.Run("torn tail is dropped", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		s.SaveReceipt("r1", testRecord(10))
+		segment := lastSegment(t, dir)
+		info, _ := os.Stat(segment)
+		firstEntry := info.Size()
+		s.SaveReceipt("r2", testRecord(20))
+		crash(s)
+		intact, _ := os.ReadFile(segment)
+
+		// cut the second entry at every byte, and replace its tail with zeros
+		for cut := firstEntry + 1; cut < int64(len(intact)); cut++ {
+			for _, torn := range [][]byte{intact[:cut], append(append([]byte{}, intact[:cut]...), make([]byte, int64(len(intact))-cut)...)} {
+				os.WriteFile(segment, torn, 0o644)
+
+				s := openTestStorage(t, dir)
+				if _, found := s.GetReceipt("r1"); !found {
+					t.Fatalf("Cut at %d: lost the complete entry", cut)
+				}
+				if _, found := s.GetReceipt("r2"); found {
+					t.Fatalf("Cut at %d: kept the torn entry", cut)
+				}
+				// the log was repaired, so new writes are readable after it
+				s.SaveReceipt("r3", testRecord(30))
+				crash(s)
+
+				s = openTestStorage(t, dir)
+				if _, found := s.GetReceipt("r3"); !found {
+					t.Fatalf("Cut at %d: lost the write after the repair", cut)
+				}
+				crash(s)
+
+				// back to the two entry log for the next cut
+				for _, path := range laterSegments(t, dir) {
+					os.Remove(path)
+				}
+			}
+		}
+	})
+
+	t.Run("damaged entry mid-log", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		s.SaveReceipt("r1", testRecord(10))
+		s.SaveReceipt("r2", testRecord(20))
+		crash(s)
+
+		segment := lastSegment(t, dir)
+		data, _ := os.ReadFile(segment)
+		data[frameHeaderSize+5] ^= 0xff
+		os.WriteFile(segment, data, 0o644)
+
+		if _, err := OpenInMemoryStorage(PersistenceConfig{Dir: dir}); !errors.Is(err, ErrCorruptLog) {
+			t.Errorf("Expected ErrCorruptLog, got %v", err)
+		}
+	})
+
+	t.Run("missing entries", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		s.SaveReceipt("r1", testRecord(10))
+		s.Snapshot()
+		s.SaveReceipt("r2", testRecord(20))
+		crash(s)
+
+		// without the snapshot, the log starts at entry 2
+		os.Remove(filepath.Join(dir, snapshotFile))
+		if _, err := OpenInMemoryStorage(PersistenceConfig{Dir: dir}); !errors.Is(err, ErrCorruptLog) {
+			t.Errorf("Expected ErrCorruptLog, got %v", err)
+		}
+	})
+
+	t.Run("snapshot larger than a frame", func(t *testing.T) {
+		defer func(size int) { maxFrameSize = size }(maxFrameSize)
+		maxFrameSize = 4 << 10
+
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		for i := 0; i < 100; i++ {
+			s.SaveReceipt(fmt.Sprintf("r%d", i), testRecord(int64(i)), models.ReceiptEvent{ID: fmt.Sprintf("e%d", i), Type: models.ReceiptProcessed})
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if info, _ := os.Stat(filepath.Join(dir, snapshotFile)); info.Size() <= int64(maxFrameSize) {
+			t.Fatalf("Expected a snapshot over %d bytes, got %d", maxFrameSize, info.Size())
+		}
+
+		s = openTestStorage(t, dir)
+		defer s.Close()
+		if count, _ := s.CountReceipts(ReceiptFilter{}); count != 100 {
+			t.Errorf("Expected 100 receipts from the snapshot, got %d", count)
+		}
+		if pending, _ := s.PendingEvents(1000); len(pending) != 100 {
+			t.Errorf("Expected 100 events in the outbox, got %d", len(pending))
+		}
+	})
+
+	t.Run("reads a single-frame snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		payload, _ := json.Marshal(snapshot{Seq: 1, Receipts: []models.ReceiptWithPoints{withID("r1", testRecord(10))}})
+		f, _ := os.Create(filepath.Join(dir, snapshotFile))
+		writeFrame(f, payload)
+		f.Close()
+
+		s := openTestStorage(t, dir)
+		defer s.Close()
+		if points, ok := s.GetPoints("r1"); !ok || points != 10 {
+			t.Errorf("Expected r1 with 10 points, got %d", points)
+		}
+	})
+
+	t.Run("damaged snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestStorage(t, dir)
+		s.SaveReceipt("r1", testRecord(10))
+		s.Close()
+
+		path := filepath.Join(dir, snapshotFile)
+		data, _ := os.ReadFile(path)
+		data[len(data)-2] ^= 0xff
+		os.WriteFile(path, data, 0o644)
+
+		if _, err := OpenInMemoryStorage(PersistenceConfig{Dir: dir}); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("Expected ErrCorruptSnapshot, got %v", err)
+		}
+	})
+}
+
+// laterSegments lists the log segments after the first one
+func laterSegments(t *testing.T, dir string) []string {
+	t.Helper()
+	segments, err := filepath.Glob(filepath.Join(dir, segmentPattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments[1:]
+}
+
+// TestPersistenceSurvivesKill kills a process that is writing and
+// snapshotting as fast as it can, and checks every write it acknowledged
+// is there after a restart
+func TestPersistenceSurvivesKill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts and kills writer processes")
+	}
+
+	dir := t.TempDir()
+	acknowledged := map[string]bool{}
+	for round := 0; round < 3; round++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestWriterProcess$")
+		cmd.Env = append(os.Environ(), writerDirEnv+"="+dir)
+		stdout, _ := cmd.StdoutPipe()
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Failed to start writer: %v", err)
+		}
+
+		lines := bufio.NewScanner(stdout)
+		killAfter := 200 + round*150
+		for count := 0; lines.Scan(); count++ {
+			if id, found := strings.CutPrefix(lines.Text(), "saved "); found {
+				acknowledged[id] = true
+			}
+			if count == killAfter {
+				cmd.Process.Kill()
+			}
+		}
+		cmd.Wait()
+
+		s, err := OpenInMemoryStorage(PersistenceConfig{Dir: dir, SnapshotInterval: time.Hour})
+		if err != nil {
+			t.Fatalf("Round %d: storage did not open after the kill: %v", round, err)
+		}
+		for id := range acknowledged {
+			if _, found := s.GetReceipt(id); !found {
+				t.Errorf("Round %d: acknowledged receipt %s was lost", round, id)
+			}
+		}
+		crash(s)
+	}
+	if len(acknowledged) == 0 {
+		t.Fatal("The writer acknowledged nothing")
+	}
+}
+
+// TestWriterProcess is the process TestPersistenceSurvivesKill kills. It
+// only runs when started by it.
+func TestWriterProcess(t *testing.T) {
+	dir := os.Getenv(writerDirEnv)
+	if dir == "" {
+		t.Skip("only runs as the writer of TestPersistenceSurvivesKill")
+	}
+
+	s, err := OpenInMemoryStorage(PersistenceConfig{Dir: dir, SnapshotInterval: 2 * time.Millisecond})
+	if err != nil {
+		fmt.Println("open failed:", err)
+		os.Exit(1)
+	}
+	for i := 0; ; i++ {
+		id := fmt.Sprintf("%d-%d", os.Getpid(), i)
+		if err := s.SaveReceipt(id, testRecord(int64(i))); err != nil {
+			fmt.Println("save failed:", err)
+			os.Exit(1)
+		}
+		fmt.Println("saved " + id)
+	}
+}
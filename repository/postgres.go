@@ -0,0 +1,419 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// DefaultQueryTimeout is used when PostgresConfig leaves it at zero
+const DefaultQueryTimeout = 5 * time.Second
+
+// PostgresConfig connects a PostgresStorage. Zero pool settings keep the
+// pgxpool defaults.
+type PostgresConfig struct {
+	// URL is a postgres:// URL or a key=value connection string
+	URL             string
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// QueryTimeout bounds each storage call, including its transaction
+	QueryTimeout time.Duration
+}
+
+// PostgresStorage keeps receipts in PostgreSQL. A write and the events it
+// adds to the outbox are one transaction, and updates lock the receipt's
+// row, so replicas can share the database.
+type PostgresStorage struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+// querier runs statements on the pool or inside a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	SendBatch(ctx context.Context, batch *pgx.Batch) pgx.BatchResults
+}
+
+// OpenPostgresStorage connects to the database and applies the migrations
+// it has not seen yet
+func OpenPostgresStorage(ctx context.Context, config PostgresConfig) (*PostgresStorage, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %w", err)
+	}
+	if config.MaxConns > 0 {
+		poolConfig.MaxConns = config.MaxConns
+	}
+	if config.MinConns > 0 {
+		poolConfig.MinConns = config.MinConns
+	}
+	if config.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = config.MaxConnLifetime
+	}
+	if config.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = config.MaxConnIdleTime
+	}
+	if config.QueryTimeout <= 0 {
+		config.QueryTimeout = DefaultQueryTimeout
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("cannot reach the database: %w", err)
+	}
+	if err := migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &PostgresStorage{pool: pool, timeout: config.QueryTimeout}, nil
+}
+
+// Close waits for the calls in flight and closes the pool
+func (s *PostgresStorage) Close() {
+	s.pool.Close()
+}
+
+func (s *PostgresStorage) withTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *PostgresStorage) SaveReceipt(id string, record models.ReceiptWithPoints, events ...models.ReceiptEvent) error {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	record.ID = id
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return writeReceipt(ctx, tx, record, events)
+	})
+}
+
+// GetReceipt reports a receipt that cannot be read as not found, after
+// logging why
+func (s *PostgresStorage) GetReceipt(id string) (models.ReceiptWithPoints, bool) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	records, err := loadReceipts(ctx, s.pool, selectReceipts+" WHERE id = $1", id)
+	if err != nil {
+		utils.Logger.WithError(err).WithField("id", id).Error("Failed to read receipt")
+		return models.ReceiptWithPoints{}, false
+	}
+	if len(records) == 0 {
+		return models.ReceiptWithPoints{}, false
+	}
+	return records[0], true
+}
+
+func (s *PostgresStorage) GetPoints(id string) (int64, bool) {
+	record, found := s.GetReceipt(id)
+	return record.Points, found
+}
+
+// UpdateReceipt holds the receipt's row lock while update runs, so
+// concurrent updates from any replica apply one after another
+func (s *PostgresStorage) UpdateReceipt(id string, update func(*models.ReceiptWithPoints) ([]models.ReceiptEvent, error)) error {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		records, err := loadReceipts(ctx, tx, selectReceipts+" WHERE id = $1 FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return ErrNotFound
+		}
+
+		record := records[0]
+		events, err := update(&record)
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		return writeReceipt(ctx, tx, record, events)
+	})
+}
+
+// ListReceipts pages in the query, so only the page's rows are read
+func (s *PostgresStorage) ListReceipts(filter ReceiptFilter) ([]models.ReceiptWithPoints, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	where, args := filterClause(filter)
+	query := selectReceipts + where + " ORDER BY submitted_at, id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	return loadReceipts(ctx, s.pool, query, args...)
+}
+
+func (s *PostgresStorage) CountReceipts(filter ReceiptFilter) (int, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	where, args := filterClause(filter)
+	var count int
+	err := s.pool.QueryRow(ctx, "SELECT count(*) FROM receipts"+where, args...).Scan(&count)
+	return count, err
+}
+
+// filterClause is the WHERE clause selecting the filter's receipts, with
+// its arguments
+func filterClause(filter ReceiptFilter) (string, []any) {
+	var conditions []string
+	var args []any
+	if filter.Status != "" {
+		args = append(args, string(filter.Status))
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if filter.ClientID != "" {
+		args = append(args, filter.ClientID)
+		conditions = append(conditions, fmt.Sprintf("client_id = $%d", len(args)))
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.SubmittedAt, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(submitted_at, id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// PendingEvents reads events without claiming them, so another instance
+// may publish them meanwhile. The relay uses PublishEvents.
+func (s *PostgresStorage) PendingEvents(limit int) ([]models.ReceiptEvent, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	rows, _ := s.pool.Query(ctx, "SELECT event FROM receipt_outbox ORDER BY seq LIMIT $1", limit)
+	return pgx.CollectRows(rows, scanEvent)
+}
+
+// PublishEvents locks up to limit events, oldest first, hands them to
+// publish and deletes them in the same transaction. Rows locked by another
+// instance are skipped, so every event is published by one instance, but
+// instances publishing at once each get a different share of the events.
+// publish runs inside the query timeout and should only queue the events.
+func (s *PostgresStorage) PublishEvents(limit int, publish func([]models.ReceiptEvent) error) (int, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	var published int
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		rows, _ := tx.Query(ctx, "SELECT event FROM receipt_outbox ORDER BY seq LIMIT $1 FOR UPDATE SKIP LOCKED", limit)
+		events, err := pgx.CollectRows(rows, scanEvent)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		if err := publish(events); err != nil {
+			return err
+		}
+
+		ids := make([]string, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM receipt_outbox WHERE id = ANY($1)", ids); err != nil {
+			return err
+		}
+		published = len(events)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, nil
+}
+
+func scanEvent(row pgx.CollectableRow) (models.ReceiptEvent, error) {
+	var data []byte
+	var event models.ReceiptEvent
+	if err := row.Scan(&data); err != nil {
+		return event, err
+	}
+	return event, json.Unmarshal(data, &event)
+}
+
+func (s *PostgresStorage) MarkPublished(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, "DELETE FROM receipt_outbox WHERE id = ANY($1)", ids)
+	return err
+}
+
+// selectReceipts reads the receipts table in the column order scanReceipt
+// expects
+const selectReceipts = `SELECT id, client_id, retailer, retailer_id,
+	purchase_date, purchase_time, purchased_at, time_zone, timestamp_zone,
+	total, subtotal, tax, tip, payment_method, payment_last4,
+	points, status, fraud_score, fraud_held, submitted_at
+	FROM receipts`
+
+func scanReceipt(row pgx.Row) (models.ReceiptWithPoints, error) {
+	var record models.ReceiptWithPoints
+	var paymentMethod *string
+	var paymentLast4 string
+	receipt := &record.Receipt
+	err := row.Scan(&record.ID, &record.ClientID, &receipt.Retailer, &record.RetailerID,
+		&receipt.PurchaseDate, &receipt.PurchaseTime, &receipt.PurchasedAt, &receipt.TimeZone, &receipt.TimestampZone,
+		&receipt.Total, &receipt.Subtotal, &receipt.Tax, &receipt.Tip, &paymentMethod, &paymentLast4,
+		&record.Points, &record.Status, &record.Fraud.Score, &record.Fraud.Held, &record.SubmittedAt)
+	if err != nil {
+		return record, err
+	}
+	if paymentMethod != nil {
+		receipt.Payment = &models.Payment{Method: models.PaymentMethod(*paymentMethod), Last4: paymentLast4}
+	}
+	record.SubmittedAt = record.SubmittedAt.UTC()
+	return record, nil
+}
+
+// loadReceipts runs a selectReceipts query and fills in the rows of the
+// other tables, in one more round trip
+func loadReceipts(ctx context.Context, q querier, query string, args ...any) ([]models.ReceiptWithPoints, error) {
+	rows, _ := q.Query(ctx, query, args...)
+	records, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ReceiptWithPoints, error) {
+		return scanReceipt(row)
+	})
+	if err != nil || len(records) == 0 {
+		return records, err
+	}
+
+	ids := make([]string, len(records))
+	byID := make(map[string]*models.ReceiptWithPoints, len(records))
+	for i := range records {
+		ids[i] = records[i].ID
+		byID[records[i].ID] = &records[i]
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(`SELECT receipt_id, short_description, price, quantity, unit_price, sku, upc, category
+		FROM receipt_items WHERE receipt_id = ANY($1) ORDER BY receipt_id, position`, ids).Query(func(rows pgx.Rows) error {
+		var receiptID string
+		var item models.Item
+		_, err := pgx.ForEachRow(rows, []any{&receiptID, &item.ShortDescription, &item.Price, &item.Quantity, &item.UnitPrice, &item.SKU, &item.UPC, &item.Category}, func() error {
+			byID[receiptID].Receipt.Items = append(byID[receiptID].Receipt.Items, item)
+			return nil
+		})
+		return err
+	})
+	batch.Queue(`SELECT receipt_id, description, amount
+		FROM receipt_discounts WHERE receipt_id = ANY($1) ORDER BY receipt_id, position`, ids).Query(func(rows pgx.Rows) error {
+		var receiptID string
+		var discount models.Discount
+		_, err := pgx.ForEachRow(rows, []any{&receiptID, &discount.Description, &discount.Amount}, func() error {
+			byID[receiptID].Receipt.Discounts = append(byID[receiptID].Receipt.Discounts, discount)
+			return nil
+		})
+		return err
+	})
+	batch.Queue(`SELECT receipt_id, rule, points
+		FROM receipt_points WHERE receipt_id = ANY($1) ORDER BY receipt_id, position`, ids).Query(func(rows pgx.Rows) error {
+		var receiptID string
+		var line models.PointsLine
+		_, err := pgx.ForEachRow(rows, []any{&receiptID, &line.Rule, &line.Points}, func() error {
+			byID[receiptID].Breakdown = append(byID[receiptID].Breakdown, line)
+			return nil
+		})
+		return err
+	})
+	batch.Queue(`SELECT receipt_id, rule, score, detail
+		FROM receipt_fraud_signals WHERE receipt_id = ANY($1) ORDER BY receipt_id, position`, ids).Query(func(rows pgx.Rows) error {
+		var receiptID string
+		var signal models.FraudSignal
+		_, err := pgx.ForEachRow(rows, []any{&receiptID, &signal.Rule, &signal.Score, &signal.Detail}, func() error {
+			byID[receiptID].Fraud.Signals = append(byID[receiptID].Fraud.Signals, signal)
+			return nil
+		})
+		return err
+	})
+	if err := q.SendBatch(ctx, batch).Close(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// writeReceipt inserts or replaces a receipt with the rows of its other
+// tables, and adds events to the outbox, in one round trip
+func writeReceipt(ctx context.Context, tx pgx.Tx, record models.ReceiptWithPoints, events []models.ReceiptEvent) error {
+	receipt := record.Receipt
+	var paymentMethod *string
+	var paymentLast4 string
+	if receipt.Payment != nil {
+		method := string(receipt.Payment.Method)
+		paymentMethod, paymentLast4 = &method, receipt.Payment.Last4
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(`INSERT INTO receipts (id, client_id, retailer, retailer_id,
+		purchase_date, purchase_time, purchased_at, time_zone, timestamp_zone,
+		total, subtotal, tax, tip, payment_method, payment_last4,
+		points, status, fraud_score, fraud_held, submitted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		ON CONFLICT (id) DO UPDATE SET
+			client_id = EXCLUDED.client_id, retailer = EXCLUDED.retailer, retailer_id = EXCLUDED.retailer_id,
+			purchase_date = EXCLUDED.purchase_date, purchase_time = EXCLUDED.purchase_time,
+			purchased_at = EXCLUDED.purchased_at, time_zone = EXCLUDED.time_zone, timestamp_zone = EXCLUDED.timestamp_zone,
+			total = EXCLUDED.total, subtotal = EXCLUDED.subtotal, tax = EXCLUDED.tax, tip = EXCLUDED.tip,
+			payment_method = EXCLUDED.payment_method, payment_last4 = EXCLUDED.payment_last4,
+			points = EXCLUDED.points, status = EXCLUDED.status,
+			fraud_score = EXCLUDED.fraud_score, fraud_held = EXCLUDED.fraud_held, submitted_at = EXCLUDED.submitted_at`,
+		record.ID, record.ClientID, receipt.Retailer, record.RetailerID,
+		receipt.PurchaseDate, receipt.PurchaseTime, receipt.PurchasedAt, receipt.TimeZone, receipt.TimestampZone,
+		receipt.Total, receipt.Subtotal, receipt.Tax, receipt.Tip, paymentMethod, paymentLast4,
+		record.Points, string(record.Status), record.Fraud.Score, record.Fraud.Held, record.SubmittedAt)
+
+	// the other tables are rewritten whole
+	for _, table := range []string{"receipt_items", "receipt_discounts", "receipt_points", "receipt_fraud_signals"} {
+		batch.Queue("DELETE FROM "+table+" WHERE receipt_id = $1", record.ID)
+	}
+	for i, item := range receipt.Items {
+		batch.Queue(`INSERT INTO receipt_items (receipt_id, position, short_description, price, quantity, unit_price, sku, upc, category)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			record.ID, i, item.ShortDescription, item.Price, item.Quantity, item.UnitPrice, item.SKU, item.UPC, item.Category)
+	}
+	for i, discount := range receipt.Discounts {
+		batch.Queue("INSERT INTO receipt_discounts (receipt_id, position, description, amount) VALUES ($1, $2, $3, $4)",
+			record.ID, i, discount.Description, discount.Amount)
+	}
+	for i, line := range record.Breakdown {
+		batch.Queue("INSERT INTO receipt_points (receipt_id, position, rule, points) VALUES ($1, $2, $3, $4)",
+			record.ID, i, line.Rule, line.Points)
+	}
+	for i, signal := range record.Fraud.Signals {
+		batch.Queue("INSERT INTO receipt_fraud_signals (receipt_id, position, rule, score, detail) VALUES ($1, $2, $3, $4, $5)",
+			record.ID, i, signal.Rule, signal.Score, signal.Detail)
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		batch.Queue("INSERT INTO receipt_outbox (id, event) VALUES ($1, $2)", event.ID, data)
+	}
+	return tx.SendBatch(ctx, batch).Close()
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// PostgresWebhookStorage keeps webhook subscriptions and deliveries in the
+// receipts' database, so replicas share them and pending retries survive a
+// restart
+type PostgresWebhookStorage struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+// Webhooks is webhook storage on the same pool and query timeout
+func (s *PostgresStorage) Webhooks() *PostgresWebhookStorage {
+	return &PostgresWebhookStorage{pool: s.pool, timeout: s.timeout}
+}
+
+func (s *PostgresWebhookStorage) withTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *PostgresWebhookStorage) SaveSubscription(subscription models.WebhookSubscription) error {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	_, err = s.pool.Exec(ctx, `INSERT INTO webhook_subscriptions (id, subscription) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET subscription = EXCLUDED.subscription`, subscription.ID, data)
+	return err
+}
+
+// GetSubscription reports a subscription that cannot be read as not
+// found, after logging why
+func (s *PostgresWebhookStorage) GetSubscription(id string) (models.WebhookSubscription, bool) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	rows, _ := s.pool.Query(ctx, "SELECT subscription FROM webhook_subscriptions WHERE id = $1", id)
+	subscriptions, err := pgx.CollectRows(rows, scanJSON[models.WebhookSubscription])
+	if err != nil {
+		utils.Logger.WithError(err).WithField("webhook", id).Error("Failed to read webhook")
+		return models.WebhookSubscription{}, false
+	}
+	if len(subscriptions) == 0 {
+		return models.WebhookSubscription{}, false
+	}
+	return subscriptions[0], true
+}
+
+func (s *PostgresWebhookStorage) ListSubscriptions() ([]models.WebhookSubscription, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	rows, _ := s.pool.Query(ctx, "SELECT subscription FROM webhook_subscriptions ORDER BY id")
+	return pgx.CollectRows(rows, scanJSON[models.WebhookSubscription])
+}
+
+func (s *PostgresWebhookStorage) DeleteSubscription(id string) error {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *PostgresWebhookStorage) SaveDelivery(delivery models.WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	_, err = s.pool.Exec(ctx, `INSERT INTO webhook_deliveries (id, subscription_id, status, created_at, delivery)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			subscription_id = EXCLUDED.subscription_id, status = EXCLUDED.status,
+			created_at = EXCLUDED.created_at, delivery = EXCLUDED.delivery`,
+		delivery.ID, delivery.SubscriptionID, string(delivery.Status), delivery.CreatedAt, data)
+	return err
+}
+
+// GetDelivery reports a delivery that cannot be read as not found, after
+// logging why
+func (s *PostgresWebhookStorage) GetDelivery(id string) (models.WebhookDelivery, bool) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	rows, _ := s.pool.Query(ctx, "SELECT delivery FROM webhook_deliveries WHERE id = $1", id)
+	deliveries, err := pgx.CollectRows(rows, scanJSON[models.WebhookDelivery])
+	if err != nil {
+		utils.Logger.WithError(err).WithField("delivery", id).Error("Failed to read webhook delivery")
+		return models.WebhookDelivery{}, false
+	}
+	if len(deliveries) == 0 {
+		return models.WebhookDelivery{}, false
+	}
+	return deliveries[0], true
+}
+
+// ListDeliveries pages in the query, so only the page's rows are read
+func (s *PostgresWebhookStorage) ListDeliveries(filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	var conditions []string
+	var args []any
+	if filter.SubscriptionID != "" {
+		args = append(args, filter.SubscriptionID)
+		conditions = append(conditions, fmt.Sprintf("subscription_id = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, string(filter.Status))
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.CreatedAt, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+	query := "SELECT delivery FROM webhook_deliveries"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, _ := s.pool.Query(ctx, query, args...)
+	return pgx.CollectRows(rows, scanJSON[models.WebhookDelivery])
+}
+
+func (s *PostgresWebhookStorage) DeleteDeliveriesBefore(cutoff time.Time) (int, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx, "DELETE FROM webhook_deliveries WHERE status <> $1 AND created_at < $2",
+		string(models.DeliveryPending), cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// scanJSON reads a row holding one JSON column into a T
+func scanJSON[T any](row pgx.CollectableRow) (T, error) {
+	var data []byte
+	var value T
+	if err := row.Scan(&data); err != nil {
+		return value, err
+	}
+	return value, json.Unmarshal(data, &value)
+}
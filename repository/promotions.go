@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+var ErrPromotionNotFound = errors.New("Promotion not found")
+
+// PromotionStorage keeps marketing promotions
+type PromotionStorage interface {
+	// SavePromotion inserts or replaces the promotion with the same ID
+	SavePromotion(promotion models.Promotion) error
+	GetPromotion(id string) (models.Promotion, bool)
+	// ListPromotions returns every promotion ordered by ID
+	ListPromotions() ([]models.Promotion, error)
+	DeletePromotion(id string) error
+}
+
+type InMemoryPromotionStorage struct {
+	promotions map[string]models.Promotion
+	mutex      *sync.RWMutex
+}
+
+func NewInMemoryPromotionStorage() *InMemoryPromotionStorage {
+	return &InMemoryPromotionStorage{
+		promotions: map[string]models.Promotion{},
+		mutex:      &sync.RWMutex{},
+	}
+}
+
+func (s *InMemoryPromotionStorage) SavePromotion(promotion models.Promotion) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.promotions[promotion.ID] = promotion
+	return nil
+}
+
+func (s *InMemoryPromotionStorage) GetPromotion(id string) (models.Promotion, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	promotion, found := s.promotions[id]
+	return promotion, found
+}
+
+func (s *InMemoryPromotionStorage) ListPromotions() ([]models.Promotion, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	promotions := make([]models.Promotion, 0, len(s.promotions))
+	for _, promotion := range s.promotions {
+		promotions = append(promotions, promotion)
+	}
+	sort.Slice(promotions, func(i, j int) bool {
+		return promotions[i].ID < promotions[j].ID
+	})
+	return promotions, nil
+}
+
+func (s *InMemoryPromotionStorage) DeletePromotion(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.promotions[id]; !found {
+		return ErrPromotionNotFound
+	}
+	delete(s.promotions, id)
+	return nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+var ErrRetailerNotFound = errors.New("Retailer not found")
+
+// RetailerStorage keeps the retailer directory
+type RetailerStorage interface {
+	// SaveRetailer inserts or replaces the retailer with the same ID
+	SaveRetailer(retailer models.Retailer) error
+	GetRetailer(id string) (models.Retailer, bool)
+	// ListRetailers returns every retailer ordered by ID
+	ListRetailers() ([]models.Retailer, error)
+	DeleteRetailer(id string) error
+}
+
+type InMemoryRetailerStorage struct {
+	retailers map[string]models.Retailer
+	mutex     *sync.RWMutex
+}
+
+func NewInMemoryRetailerStorage() *InMemoryRetailerStorage {
+	return &InMemoryRetailerStorage{
+		retailers: map[string]models.Retailer{},
+		mutex:     &sync.RWMutex{},
+	}
+}
+
+func (s *InMemoryRetailerStorage) SaveRetailer(retailer models.Retailer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.retailers[retailer.ID] = retailer
+	return nil
+}
+
+func (s *InMemoryRetailerStorage) GetRetailer(id string) (models.Retailer, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	retailer, found := s.retailers[id]
+	return retailer, found
+}
+
+func (s *InMemoryRetailerStorage) ListRetailers() ([]models.Retailer, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	retailers := make([]models.Retailer, 0, len(s.retailers))
+	for _, retailer := range s.retailers {
+		retailers = append(retailers, retailer)
+	}
+	sort.Slice(retailers, func(i, j int) bool {
+		return retailers[i].ID < retailers[j].ID
+	})
+	return retailers, nil
+}
+
+func (s *InMemoryRetailerStorage) DeleteRetailer(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.retailers[id]; !found {
+		return ErrRetailerNotFound
+	}
+	delete(s.retailers, id)
+	return nil
+}
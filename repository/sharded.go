@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// DefaultShardCount is used when NewShardedStorage is given no count
+const DefaultShardCount = 32
+
+// ShardedStorage spreads receipts over shards picked by a hash of their
+// ID, each with its own lock, so writers only wait for writers of the
+// same shard. Events go to the outbox of the receipt's shard in the same
+// write and are numbered from one counter, so PendingEvents returns them
+// in write order for each receipt.
+type ShardedStorage struct {
+	shards []*receiptShard
+	// eventSeq numbers events across shards
+	eventSeq atomic.Uint64
+	// publishing is held by PublishEvents, which claims every outbox
+	publishing sync.Mutex
+}
+
+type receiptShard struct {
+	receipts map[string]models.ReceiptWithPoints
+	outbox   []sequencedEvent
+	mutex    sync.RWMutex
+}
+
+type sequencedEvent struct {
+	seq   uint64
+	event models.ReceiptEvent
+}
+
+func NewShardedStorage(shards int) *ShardedStorage {
+	if shards <= 0 {
+		shards = DefaultShardCount
+	}
+	s := &ShardedStorage{shards: make([]*receiptShard, shards)}
+	for i := range s.shards {
+		s.shards[i] = &receiptShard{receipts: map[string]models.ReceiptWithPoints{}}
+	}
+	return s
+}
+
+func (s *ShardedStorage) shard(id string) *receiptShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// appendEvents numbers events and adds them to the shard's outbox. The
+// caller holds the shard's write lock.
+func (s *ShardedStorage) appendEvents(shard *receiptShard, events []models.ReceiptEvent) {
+	for _, event := range events {
+		shard.outbox = append(shard.outbox, sequencedEvent{seq: s.eventSeq.Add(1), event: event})
+	}
+}
+
+func (s *ShardedStorage) SaveReceipt(id string, record models.ReceiptWithPoints, events ...models.ReceiptEvent) error {
+	shard := s.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	record.ID = id
+	shard.receipts[id] = record
+	s.appendEvents(shard, events)
+	return nil
+}
+
+func (s *ShardedStorage) GetReceipt(id string) (models.ReceiptWithPoints, bool) {
+	shard := s.shard(id)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	record, found := shard.receipts[id]
+	return record, found
+}
+
+func (s *ShardedStorage) GetPoints(id string) (int64, bool) {
+	record, found := s.GetReceipt(id)
+	return record.Points, found
+}
+
+func (s *ShardedStorage) UpdateReceipt(id string, update func(*models.ReceiptWithPoints) ([]models.ReceiptEvent, error)) error {
+	shard := s.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	record, found := shard.receipts[id]
+	if !found {
+		return ErrNotFound
+	}
+	events, err := update(&record)
+	if err != nil {
+		return err
+	}
+	record.ID = id
+	shard.receipts[id] = record
+	s.appendEvents(shard, events)
+	return nil
+}
+
+// ListReceipts reads the shards one after another, so writes made during
+// the call may or may not be included
+func (s *ShardedStorage) ListReceipts(filter ReceiptFilter) ([]models.ReceiptWithPoints, error) {
+	var records []models.ReceiptWithPoints
+	for _, shard := range s.shards {
+		shard.mutex.RLock()
+		for _, record := range shard.receipts {
+			if filter.matches(record) {
+				records = append(records, record)
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	return filter.page(records), nil
+}
+
+func (s *ShardedStorage) CountReceipts(filter ReceiptFilter) (int, error) {
+	count := 0
+	for _, shard := range s.shards {
+		shard.mutex.RLock()
+		for _, record := range shard.receipts {
+			if filter.matches(record) {
+				count++
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	return count, nil
+}
+
+func (s *ShardedStorage) PendingEvents(limit int) ([]models.ReceiptEvent, error) {
+	var pending []sequencedEvent
+	for _, shard := range s.shards {
+		shard.mutex.RLock()
+		// each outbox is already in order, so only its first limit count
+		n := min(limit, len(shard.outbox))
+		pending = append(pending, shard.outbox[:n]...)
+		shard.mutex.RUnlock()
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].seq < pending[j].seq
+	})
+
+	events := make([]models.ReceiptEvent, 0, min(limit, len(pending)))
+	for i := 0; i < len(pending) && i < limit; i++ {
+		events = append(events, pending[i].event)
+	}
+	return events, nil
+}
+
+func (s *ShardedStorage) PublishEvents(limit int, publish func([]models.ReceiptEvent) error) (int, error) {
+	return publishPending(&s.publishing, s, limit, publish)
+}
+
+func (s *ShardedStorage) MarkPublished(ids ...string) error {
+	published := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		published[id] = true
+	}
+	for _, shard := range s.shards {
+		shard.mutex.Lock()
+		kept := shard.outbox[:0]
+		for _, pending := range shard.outbox {
+			if !published[pending.event.ID] {
+				kept = append(kept, pending)
+			}
+		}
+		clear(shard.outbox[len(kept):])
+		shard.outbox = kept
+		shard.mutex.Unlock()
+	}
+	return nil
+}
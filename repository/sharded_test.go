@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// TestStorageConcurrency mixes every kind of access from many goroutines.
+// It is meant to run with -race.
+func TestStorageConcurrency(t *testing.T) {
+	const writers, perWriter = 8, 200
+
+	for _, impl := range testStorages {
+		t.Run(impl.name, func(t *testing.T) {
+			s := impl.open()
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						id := fmt.Sprintf("w%d-%d", w, i)
+						s.SaveReceipt(id, testRecord(1), models.ReceiptEvent{ID: id + "-saved", ReceiptID: id})
+						s.UpdateReceipt(id, func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+							record.Points++
+							return []models.ReceiptEvent{{ID: id + "-updated", ReceiptID: id}}, nil
+						})
+						s.GetPoints(id)
+						if i%50 == 0 {
+							s.ListReceipts(ReceiptFilter{ClientID: "alice"})
+						}
+					}
+				}(w)
+			}
+
+			// the publisher runs until the writers are done
+			done := make(chan struct{})
+			var published atomic.Int64
+			var publisher sync.WaitGroup
+			publisher.Add(1)
+			go func() {
+				defer publisher.Done()
+				for {
+					// checked before reading, so the last read sees every write
+					finished := false
+					select {
+					case <-done:
+						finished = true
+					default:
+					}
+
+					events, _ := s.PendingEvents(50)
+					checkReceiptOrder(t, events)
+					ids := make([]string, len(events))
+					for i, event := range events {
+						ids[i] = event.ID
+					}
+					s.MarkPublished(ids...)
+					published.Add(int64(len(ids)))
+					if len(events) == 0 {
+						if finished {
+							return
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}()
+			wg.Wait()
+			close(done)
+			publisher.Wait()
+
+			records, _ := s.ListReceipts(ReceiptFilter{})
+			if len(records) != writers*perWriter {
+				t.Errorf("Expected %d receipts, got %d", writers*perWriter, len(records))
+			}
+			for _, record := range records {
+				if record.Points != 2 {
+					t.Fatalf("Expected every receipt updated once, %s has %d points", record.ID, record.Points)
+				}
+			}
+			if published.Load() != 2*writers*perWriter {
+				t.Errorf("Expected %d events published, got %d", 2*writers*perWriter, published.Load())
+			}
+		})
+	}
+}
+
+// checkReceiptOrder fails if a receipt's update event comes before its save
+// event in one batch
+func checkReceiptOrder(t *testing.T, events []models.ReceiptEvent) {
+	t.Helper()
+	updated := map[string]bool{}
+	for _, event := range events {
+		if event.ID == event.ReceiptID+"-updated" {
+			updated[event.ReceiptID] = true
+		} else if updated[event.ReceiptID] {
+			t.Errorf("Receipt %s: update event published before its save event", event.ReceiptID)
+		}
+	}
+}
+
+// BenchmarkStorage compares the stores under parallel load with different
+// shares of writes. Reads hit receipts that were saved up front.
+func BenchmarkStorage(b *testing.B) {
+	const preloaded = 10000
+	loads := []struct {
+		name          string
+		writesPercent int
+	}{
+		{"reads", 0},
+		{"writes=10%", 10},
+		{"writes=50%", 50},
+		{"writes", 100},
+	}
+
+	for _, impl := range testStorages {
+		for _, load := range loads {
+			b.Run(impl.name+"/"+load.name, func(b *testing.B) {
+				s := impl.open()
+				record := testRecord(1)
+				for i := 0; i < preloaded; i++ {
+					s.SaveReceipt(strconv.Itoa(i), record)
+				}
+				var next atomic.Int64
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					rng := rand.New(rand.NewSource(next.Add(1)))
+					for pb.Next() {
+						if rng.Intn(100) < load.writesPercent {
+							s.SaveReceipt("b"+strconv.FormatInt(next.Add(1), 10), record)
+						} else {
+							s.GetReceipt(strconv.Itoa(rng.Intn(preloaded)))
+						}
+					}
+				})
+			})
+		}
+	}
+}
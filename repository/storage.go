@@ -1,19 +1,139 @@
 package repository
 
 import (
+	"container/heap"
+	"errors"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ycChu711/receipt-processor/models"
 )
 
+var ErrNotFound = errors.New("Receipt not found")
+
 type ReceiptStorage interface {
-	SaveReceipt(id string, receipt models.Receipt, points int64) error
+	// SaveReceipt stores the record and appends events to the outbox in the
+	// same write, so either both are kept or neither is
+	SaveReceipt(id string, record models.ReceiptWithPoints, events ...models.ReceiptEvent) error
+	GetReceipt(id string) (models.ReceiptWithPoints, bool)
 	GetPoints(id string) (int64, bool)
+	// UpdateReceipt applies update to the stored record atomically and
+	// appends the events it returns to the outbox. If update returns an
+	// error nothing is written.
+	UpdateReceipt(id string, update func(*models.ReceiptWithPoints) ([]models.ReceiptEvent, error)) error
+	// ListReceipts returns the filter's page of matching receipts, oldest
+	// submission first
+	ListReceipts(filter ReceiptFilter) ([]models.ReceiptWithPoints, error)
+	// CountReceipts counts every matching receipt, ignoring the page
+	CountReceipts(filter ReceiptFilter) (int, error)
+	Outbox
+}
+
+// Outbox holds receipt events until the event bus has published them
+type Outbox interface {
+	// PendingEvents returns up to limit unpublished events, oldest first,
+	// without claiming them
+	PendingEvents(limit int) ([]models.ReceiptEvent, error)
+	// MarkPublished drops published events from the outbox
+	MarkPublished(ids ...string) error
+	// PublishEvents claims up to limit unpublished events, oldest first,
+	// hands them to publish and drops them once it returns nil. Claimed
+	// events are never handed to another caller, even one in another
+	// process sharing the storage, so relays can run side by side. It
+	// returns how many events were published.
+	PublishEvents(limit int, publish func([]models.ReceiptEvent) error) (int, error)
+}
+
+// publishPending implements PublishEvents for storages kept in one
+// process, where holding claim while publishing is enough to keep
+// concurrent callers from getting the same events
+func publishPending(claim *sync.Mutex, outbox Outbox, limit int, publish func([]models.ReceiptEvent) error) (int, error) {
+	claim.Lock()
+	defer claim.Unlock()
+
+	events, err := outbox.PendingEvents(limit)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	if err := publish(events); err != nil {
+		return 0, err
+	}
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	if err := outbox.MarkPublished(ids...); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// ReceiptFilter selects receipts for ListReceipts; empty fields match all
+type ReceiptFilter struct {
+	Status   models.ReceiptStatus
+	ClientID string
+	// After only matches receipts listed after the cursor, so a caller
+	// paging through every receipt neither skips nor repeats one when
+	// receipts are added or removed meanwhile
+	After *ReceiptCursor
+	// Offset receipts are skipped and at most Limit returned, a zero Limit
+	// returns the rest. CountReceipts ignores both.
+	Offset int
+	Limit  int
+}
+
+// ReceiptCursor is a position in the listing order, oldest submission
+// first and then by ID
+type ReceiptCursor struct {
+	SubmittedAt time.Time
+	ID          string
+}
+
+// CursorAfter is the cursor that continues a listing after record
+func CursorAfter(record models.ReceiptWithPoints) *ReceiptCursor {
+	return &ReceiptCursor{SubmittedAt: record.SubmittedAt, ID: record.ID}
+}
+
+func (f ReceiptFilter) matches(record models.ReceiptWithPoints) bool {
+	if f.Status != "" && record.Status != f.Status {
+		return false
+	}
+	if f.ClientID != "" && record.ClientID != f.ClientID {
+		return false
+	}
+	if f.After != nil && !listedBefore(f.After.SubmittedAt, f.After.ID, record) {
+		return false
+	}
+	return true
+}
+
+// page sorts matching records oldest submission first and cuts out the
+// filter's page. Only the records up to the end of the page are sorted.
+func (f ReceiptFilter) page(records []models.ReceiptWithPoints) []models.ReceiptWithPoints {
+	if f.Limit > 0 && f.Offset+f.Limit < len(records) {
+		records = earliest(records, f.Offset+f.Limit)
+	}
+	sortBySubmission(records)
+	if f.Offset >= len(records) {
+		return nil
+	}
+	records = records[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(records) {
+		records = records[:f.Limit]
+	}
+	return records
 }
 
 type InMemoryStorage struct {
 	receiptsWithPoints map[string]models.ReceiptWithPoints
-	mutex              *sync.RWMutex
+	// outbox shares the receipts' mutex, which makes the writes atomic
+	outbox []models.ReceiptEvent
+	mutex  *sync.RWMutex
+	// publishing is held by PublishEvents, which claims the whole outbox
+	publishing sync.Mutex
+	// persist is set by OpenInMemoryStorage, nil keeps everything in memory
+	persist *persistence
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
@@ -23,26 +143,28 @@ func NewInMemoryStorage() *InMemoryStorage {
 	}
 }
 
-func (s *InMemoryStorage) SaveReceipt(id string, receipt models.Receipt, points int64) error {
+func (s *InMemoryStorage) SaveReceipt(id string, record models.ReceiptWithPoints, events ...models.ReceiptEvent) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.receiptsWithPoints[id] = models.ReceiptWithPoints{
-		Receipt: receipt,
-		Points:  points,
+	record.ID = id
+	entry := walEntry{Op: opPut, Record: &record, Events: events}
+	if err := s.log(entry); err != nil {
+		return err
 	}
+	s.apply(entry)
 	return nil
 }
 
-func (s *InMemoryStorage) GetReceipt(id string) (models.Receipt, bool) {
+func (s *InMemoryStorage) GetReceipt(id string) (models.ReceiptWithPoints, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	receiptWithPoints, found := s.receiptsWithPoints[id]
 	if !found {
-		return models.Receipt{}, false
+		return models.ReceiptWithPoints{}, false
 	}
-	return receiptWithPoints.Receipt, true
+	return receiptWithPoints, true
 }
 
 func (s *InMemoryStorage) GetPoints(id string) (int64, bool) {
@@ -55,3 +177,141 @@ func (s *InMemoryStorage) GetPoints(id string) (int64, bool) {
 	}
 	return receiptWithPoints.Points, true
 }
+
+func (s *InMemoryStorage) UpdateReceipt(id string, update func(*models.ReceiptWithPoints) ([]models.ReceiptEvent, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, found := s.receiptsWithPoints[id]
+	if !found {
+		return ErrNotFound
+	}
+	events, err := update(&record)
+	if err != nil {
+		return err
+	}
+	record.ID = id
+	entry := walEntry{Op: opPut, Record: &record, Events: events}
+	if err := s.log(entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+	return nil
+}
+
+func (s *InMemoryStorage) ListReceipts(filter ReceiptFilter) ([]models.ReceiptWithPoints, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var records []models.ReceiptWithPoints
+	for _, record := range s.receiptsWithPoints {
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	return filter.page(records), nil
+}
+
+func (s *InMemoryStorage) CountReceipts(filter ReceiptFilter) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := 0
+	for _, record := range s.receiptsWithPoints {
+		if filter.matches(record) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func sortBySubmission(records []models.ReceiptWithPoints) {
+	sort.Slice(records, func(i, j int) bool {
+		return listedBefore(records[i].SubmittedAt, records[i].ID, records[j])
+	})
+}
+
+// listedBefore reports whether a receipt submitted at submittedAt with id
+// is listed before record
+func listedBefore(submittedAt time.Time, id string, record models.ReceiptWithPoints) bool {
+	if !submittedAt.Equal(record.SubmittedAt) {
+		return submittedAt.Before(record.SubmittedAt)
+	}
+	return id < record.ID
+}
+
+// earliest returns the first n records in listing order, unsorted. It
+// keeps them in a heap with the last one on top, so a long list is not
+// sorted for one page.
+func earliest(records []models.ReceiptWithPoints, n int) []models.ReceiptWithPoints {
+	h := make(latestFirst, 0, n)
+	for _, record := range records {
+		switch {
+		case len(h) < n:
+			heap.Push(&h, record)
+		case listedBefore(record.SubmittedAt, record.ID, h[0]):
+			h[0] = record
+			heap.Fix(&h, 0)
+		}
+	}
+	return h
+}
+
+// latestFirst is a heap of receipts with the one listed last on top
+type latestFirst []models.ReceiptWithPoints
+
+func (h latestFirst) Len() int { return len(h) }
+func (h latestFirst) Less(i, j int) bool {
+	return listedBefore(h[j].SubmittedAt, h[j].ID, h[i])
+}
+func (h latestFirst) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *latestFirst) Push(x any)   { *h = append(*h, x.(models.ReceiptWithPoints)) }
+func (h *latestFirst) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+func (s *InMemoryStorage) PendingEvents(limit int) ([]models.ReceiptEvent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if limit > len(s.outbox) {
+		limit = len(s.outbox)
+	}
+	return append([]models.ReceiptEvent(nil), s.outbox[:limit]...), nil
+}
+
+func (s *InMemoryStorage) MarkPublished(ids ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := walEntry{Op: opPublished, Published: ids}
+	if err := s.log(entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+	return nil
+}
+
+func (s *InMemoryStorage) PublishEvents(limit int, publish func([]models.ReceiptEvent) error) (int, error) {
+	return publishPending(&s.publishing, s, limit, publish)
+}
+
+// dropEvents removes published events from the outbox
+func (s *InMemoryStorage) dropEvents(ids []string) {
+	published := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		published[id] = true
+	}
+	pending := s.outbox[:0]
+	for _, event := range s.outbox {
+		if !published[event.ID] {
+			pending = append(pending, event)
+		}
+	}
+	// clear the tail so published events can be collected
+	clear(s.outbox[len(pending):])
+	s.outbox = pending
+}
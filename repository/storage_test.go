@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// testStorages are the ReceiptStorage implementations every storage test
+// runs against
+var testStorages = []struct {
+	name string
+	open func() ReceiptStorage
+}{
+	{"in-memory", func() ReceiptStorage { return NewInMemoryStorage() }},
+	{"sharded", func() ReceiptStorage { return NewShardedStorage(8) }},
+	{"bounded", func() ReceiptStorage { return NewBoundedStorage(BoundedConfig{}) }},
+}
+
+func TestReceiptStorage(t *testing.T) {
+	for _, impl := range testStorages {
+		t.Run(impl.name, func(t *testing.T) {
+			testReceiptStorage(t, impl.open)
+		})
+	}
+}
+
+func testReceiptStorage(t *testing.T, open func() ReceiptStorage) {
+	t.Run("save and get", func(t *testing.T) {
+		s := open()
+		s.SaveReceipt("r1", testRecord(10))
+		record, found := s.GetReceipt("r1")
+		if !found || record.ID != "r1" || record.Points != 10 {
+			t.Errorf("Expected r1 with 10 points, got %+v (found %v)", record, found)
+		}
+		if points, found := s.GetPoints("r1"); !found || points != 10 {
+			t.Errorf("Expected 10 points, got %d (found %v)", points, found)
+		}
+		if _, found := s.GetReceipt("missing"); found {
+			t.Error("Expected a missing receipt not to be found")
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		s := open()
+		s.SaveReceipt("r1", testRecord(10))
+		err := s.UpdateReceipt("r1", func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+			record.Points = 20
+			return []models.ReceiptEvent{{ID: "e1", Type: models.PointsAdjusted}}, nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if points, _ := s.GetPoints("r1"); points != 20 {
+			t.Errorf("Expected 20 points, got %d", points)
+		}
+		if pending, _ := s.PendingEvents(10); len(pending) != 1 || pending[0].ID != "e1" {
+			t.Errorf("Expected the update's event, got %+v", pending)
+		}
+
+		failed := errors.New("rejected")
+		err = s.UpdateReceipt("r1", func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+			record.Points = 30
+			return []models.ReceiptEvent{{ID: "e2"}}, failed
+		})
+		if err != failed {
+			t.Errorf("Expected the update's error, got %v", err)
+		}
+		if points, _ := s.GetPoints("r1"); points != 20 {
+			t.Errorf("Expected a failed update to write nothing, got %d points", points)
+		}
+		if pending, _ := s.PendingEvents(10); len(pending) != 1 {
+			t.Errorf("Expected a failed update to add no events, got %+v", pending)
+		}
+
+		if err := s.UpdateReceipt("missing", nil); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		s := open()
+		start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 20; i++ {
+			record := testRecord(int64(i))
+			// saved newest first, so the order comes from the sort
+			record.SubmittedAt = start.Add(time.Duration(20-i) * time.Minute)
+			if i%2 == 1 {
+				record.ClientID = "bob"
+				record.Status = models.StatusRejected
+			}
+			s.SaveReceipt(fmt.Sprintf("r%02d", i), record)
+		}
+
+		records, _ := s.ListReceipts(ReceiptFilter{})
+		if len(records) != 20 {
+			t.Fatalf("Expected 20 receipts, got %d", len(records))
+		}
+		for i := 1; i < len(records); i++ {
+			if records[i].SubmittedAt.Before(records[i-1].SubmittedAt) {
+				t.Fatalf("Expected oldest first, got %s before %s", records[i-1].ID, records[i].ID)
+			}
+		}
+		if records, _ := s.ListReceipts(ReceiptFilter{ClientID: "bob"}); len(records) != 10 {
+			t.Errorf("Expected 10 receipts for bob, got %d", len(records))
+		}
+		if records, _ := s.ListReceipts(ReceiptFilter{ClientID: "alice", Status: models.StatusRejected}); len(records) != 0 {
+			t.Errorf("Expected no rejected receipts for alice, got %d", len(records))
+		}
+
+		// bob's receipts, oldest first, are r19, r17, r15, ...
+		page, _ := s.ListReceipts(ReceiptFilter{ClientID: "bob", Offset: 2, Limit: 3})
+		var ids []string
+		for _, record := range page {
+			ids = append(ids, record.ID)
+		}
+		if fmt.Sprint(ids) != "[r15 r13 r11]" {
+			t.Errorf("Expected page [r15 r13 r11], got %v", ids)
+		}
+		if records, _ := s.ListReceipts(ReceiptFilter{ClientID: "bob", Offset: 10}); len(records) != 0 {
+			t.Errorf("Expected no receipts past the end, got %d", len(records))
+		}
+		if total, _ := s.CountReceipts(ReceiptFilter{ClientID: "bob", Offset: 2, Limit: 3}); total != 10 {
+			t.Errorf("Expected 10 receipts counted for bob, got %d", total)
+		}
+
+		// a cursor continues after the last receipt of a page
+		first, _ := s.ListReceipts(ReceiptFilter{ClientID: "bob", Limit: 3})
+		next, _ := s.ListReceipts(ReceiptFilter{ClientID: "bob", After: CursorAfter(first[len(first)-1]), Limit: 3})
+		ids = nil
+		for _, record := range next {
+			ids = append(ids, record.ID)
+		}
+		if fmt.Sprint(ids) != "[r13 r11 r09]" {
+			t.Errorf("Expected page [r13 r11 r09] after the cursor, got %v", ids)
+		}
+	})
+
+	t.Run("outbox", func(t *testing.T) {
+		s := open()
+		for i := 0; i < 10; i++ {
+			s.SaveReceipt(fmt.Sprintf("r%d", i), testRecord(int64(i)), models.ReceiptEvent{ID: fmt.Sprintf("e%d", i)})
+		}
+
+		pending, _ := s.PendingEvents(4)
+		if len(pending) != 4 {
+			t.Fatalf("Expected 4 events, got %d", len(pending))
+		}
+		for i, event := range pending {
+			if event.ID != fmt.Sprintf("e%d", i) {
+				t.Errorf("Expected events in write order, got %s at %d", event.ID, i)
+			}
+		}
+
+		s.MarkPublished("e0", "e1", "e2", "e3")
+		pending, _ = s.PendingEvents(100)
+		if len(pending) != 6 || pending[0].ID != "e4" {
+			t.Errorf("Expected e4 to e9 left, got %+v", pending)
+		}
+	})
+
+	t.Run("publish claims events", func(t *testing.T) {
+		s := open()
+		for i := 0; i < 50; i++ {
+			s.SaveReceipt(fmt.Sprintf("r%d", i), testRecord(0), models.ReceiptEvent{ID: fmt.Sprintf("e%d", i)})
+		}
+
+		failed := errors.New("subscriber failed")
+		if _, err := s.PublishEvents(5, func([]models.ReceiptEvent) error { return failed }); !errors.Is(err, failed) {
+			t.Errorf("Expected the publish error back, got %v", err)
+		}
+		if pending, _ := s.PendingEvents(100); len(pending) != 50 {
+			t.Fatalf("Expected a failed publish to keep its events, got %d left", len(pending))
+		}
+
+		var mutex sync.Mutex
+		seen := map[string]int{}
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					n, err := s.PublishEvents(5, func(events []models.ReceiptEvent) error {
+						mutex.Lock()
+						defer mutex.Unlock()
+						for _, event := range events {
+							seen[event.ID]++
+						}
+						return nil
+					})
+					if err != nil || n == 0 {
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(seen) != 50 {
+			t.Errorf("Expected 50 events published, got %d", len(seen))
+		}
+		for id, count := range seen {
+			if count != 1 {
+				t.Errorf("Expected %s published once, got %d", id, count)
+			}
+		}
+		if pending, _ := s.PendingEvents(100); len(pending) != 0 {
+			t.Errorf("Expected an empty outbox, got %d events", len(pending))
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Frames are how the write-ahead log and the snapshot are laid out on disk:
+// a 4 byte big-endian payload length, the payload's 4 byte CRC-32C, then
+// the payload itself.
+const frameHeaderSize = 8
+
+// maxFrameSize rejects lengths no real entry has, which is what a garbled
+// header usually decodes to. Writes over it fail, so nothing is written
+// that cannot be read back.
+var maxFrameSize = 64 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// errTornFrame is a frame cut short by the end of the file, what a
+	// crash in the middle of a write leaves behind
+	errTornFrame = errors.New("torn frame")
+	// errBadChecksum is a complete frame whose payload does not match its
+	// checksum
+	errBadChecksum = errors.New("frame checksum mismatch")
+	// errFrameTooLarge is a payload over maxFrameSize
+	errFrameTooLarge = errors.New("frame too large")
+)
+
+// writeFrame appends payload as one frame
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return errFrameTooLarge
+	}
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[frameHeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads the frame at the start of data and returns its payload
+// and size on disk. The size is 0 when the header itself is unusable.
+func readFrame(data []byte) ([]byte, int, error) {
+	if len(data) < frameHeaderSize {
+		return nil, 0, errTornFrame
+	}
+	// entries are never empty, a zero length is a zero-filled tail
+	length := binary.BigEndian.Uint32(data[0:4])
+	if length == 0 || int64(length) > int64(maxFrameSize) {
+		return nil, 0, errBadChecksum
+	}
+	size := frameHeaderSize + int(length)
+	if len(data) < size {
+		return nil, 0, errTornFrame
+	}
+	payload := data[frameHeaderSize:size]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, size, errBadChecksum
+	}
+	return payload, size, nil
+}
+
+// syncDir makes a rename or removal in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+// ErrCorruptWebhookFile is a webhook storage file that fails its checksum
+var ErrCorruptWebhookFile = errors.New("Webhook file is corrupt")
+
+const (
+	subscriptionsDir  = "subscriptions"
+	deliveriesDir     = "deliveries"
+	webhookFileSuffix = ".whk"
+)
+
+// FileWebhookStorage keeps subscriptions and deliveries in memory and
+// writes each one through to its own file, so subscriptions and pending
+// retries survive a restart. Files are framed and checksummed like the
+// write-ahead log and synced before a write returns.
+type FileWebhookStorage struct {
+	memory *InMemoryWebhookStorage
+	dir    string
+	// writing keeps a change's file and its copy in memory in step
+	writing sync.Mutex
+}
+
+// OpenFileWebhookStorage loads the subscriptions and deliveries kept in
+// dir, creating it if missing
+func OpenFileWebhookStorage(dir string) (*FileWebhookStorage, error) {
+	s := &FileWebhookStorage{memory: NewInMemoryWebhookStorage(), dir: dir}
+	err := s.load(subscriptionsDir, func(payload []byte) error {
+		var subscription models.WebhookSubscription
+		if err := json.Unmarshal(payload, &subscription); err != nil {
+			return err
+		}
+		s.memory.subscriptions[subscription.ID] = subscription
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.load(deliveriesDir, func(payload []byte) error {
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal(payload, &delivery); err != nil {
+			return err
+		}
+		s.memory.deliveries[delivery.ID] = delivery
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads every file of one kind. Temporary files a crash left behind
+// are removed.
+func (s *FileWebhookStorage) load(kind string, decode func([]byte) error) error {
+	dir := filepath.Join(s.dir, kind)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), webhookFileSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		payload, size, err := readFrame(data)
+		if err != nil || size != len(data) {
+			return fmt.Errorf("%w: %s", ErrCorruptWebhookFile, path)
+		}
+		if err := decode(payload); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrCorruptWebhookFile, path, err)
+		}
+	}
+	return nil
+}
+
+// path names files by a hash of the ID, so any ID is a safe file name
+func (s *FileWebhookStorage) path(kind, id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, kind, hex.EncodeToString(sum[:])+webhookFileSuffix)
+}
+
+// put writes v to a temporary file and renames it into place, so a crash
+// leaves either the old copy or the new one
+func (s *FileWebhookStorage) put(kind, id string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var frame bytes.Buffer
+	if err := writeFrame(&frame, payload); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.dir, kind)
+	tmp, err := os.CreateTemp(dir, "put-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(frame.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path(kind, id)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (s *FileWebhookStorage) remove(kind, id string) error {
+	if err := os.Remove(s.path(kind, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileWebhookStorage) SaveSubscription(subscription models.WebhookSubscription) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+
+	if err := s.put(subscriptionsDir, subscription.ID, subscription); err != nil {
+		return err
+	}
+	return s.memory.SaveSubscription(subscription)
+}
+
+func (s *FileWebhookStorage) GetSubscription(id string) (models.WebhookSubscription, bool) {
+	return s.memory.GetSubscription(id)
+}
+
+func (s *FileWebhookStorage) ListSubscriptions() ([]models.WebhookSubscription, error) {
+	return s.memory.ListSubscriptions()
+}
+
+func (s *FileWebhookStorage) DeleteSubscription(id string) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+
+	if _, found := s.memory.GetSubscription(id); !found {
+		return ErrWebhookNotFound
+	}
+	if err := s.remove(subscriptionsDir, id); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Join(s.dir, subscriptionsDir)); err != nil {
+		return err
+	}
+	return s.memory.DeleteSubscription(id)
+}
+
+func (s *FileWebhookStorage) SaveDelivery(delivery models.WebhookDelivery) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+
+	if err := s.put(deliveriesDir, delivery.ID, delivery); err != nil {
+		return err
+	}
+	return s.memory.SaveDelivery(delivery)
+}
+
+func (s *FileWebhookStorage) GetDelivery(id string) (models.WebhookDelivery, bool) {
+	return s.memory.GetDelivery(id)
+}
+
+func (s *FileWebhookStorage) ListDeliveries(filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	return s.memory.ListDeliveries(filter)
+}
+
+// DeleteDeliveriesBefore removes the files first, so a crash part way
+// leaves deliveries that the next sweep drops again
+func (s *FileWebhookStorage) DeleteDeliveriesBefore(cutoff time.Time) (int, error) {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+
+	s.memory.mutex.RLock()
+	var ids []string
+	for id, delivery := range s.memory.deliveries {
+		if finishedBefore(delivery, cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	s.memory.mutex.RUnlock()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, id := range ids {
+		if err := s.remove(deliveriesDir, id); err != nil {
+			return 0, err
+		}
+	}
+	if err := syncDir(filepath.Join(s.dir, deliveriesDir)); err != nil {
+		return 0, err
+	}
+
+	s.memory.mutex.Lock()
+	defer s.memory.mutex.Unlock()
+	for _, id := range ids {
+		delete(s.memory.deliveries, id)
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("Webhook not found")
+	ErrDeliveryNotFound = errors.New("Webhook delivery not found")
+)
+
+// WebhookStorage keeps webhook subscriptions and their delivery history
+type WebhookStorage interface {
+	// SaveSubscription inserts or replaces the subscription with the same ID
+	SaveSubscription(subscription models.WebhookSubscription) error
+	GetSubscription(id string) (models.WebhookSubscription, bool)
+	// ListSubscriptions returns every subscription ordered by ID
+	ListSubscriptions() ([]models.WebhookSubscription, error)
+	DeleteSubscription(id string) error
+
+	// SaveDelivery inserts or replaces the delivery with the same ID
+	SaveDelivery(delivery models.WebhookDelivery) error
+	GetDelivery(id string) (models.WebhookDelivery, bool)
+	// ListDeliveries returns matching deliveries, oldest first
+	ListDeliveries(filter DeliveryFilter) ([]models.WebhookDelivery, error)
+	// DeleteDeliveriesBefore drops delivered and dead deliveries created
+	// before cutoff and returns how many it dropped. Pending deliveries
+	// are kept.
+	DeleteDeliveriesBefore(cutoff time.Time) (int, error)
+}
+
+// DeliveryFilter selects deliveries for ListDeliveries; empty fields match all
+type DeliveryFilter struct {
+	SubscriptionID string
+	Status         models.DeliveryStatus
+	// After only matches deliveries listed after this one
+	After *models.WebhookDelivery
+	// Limit caps how many are returned, zero returns all
+	Limit int
+}
+
+func (f DeliveryFilter) matches(delivery models.WebhookDelivery) bool {
+	if f.SubscriptionID != "" && delivery.SubscriptionID != f.SubscriptionID {
+		return false
+	}
+	if f.Status != "" && delivery.Status != f.Status {
+		return false
+	}
+	if f.After != nil && !deliveredBefore(*f.After, delivery) {
+		return false
+	}
+	return true
+}
+
+// deliveredBefore reports whether a is listed before b, oldest first and
+// then by ID
+func deliveredBefore(a, b models.WebhookDelivery) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// finishedBefore reports whether a delivery is no longer pending and was
+// created before cutoff
+func finishedBefore(delivery models.WebhookDelivery, cutoff time.Time) bool {
+	return delivery.Status != models.DeliveryPending && delivery.CreatedAt.Before(cutoff)
+}
+
+type InMemoryWebhookStorage struct {
+	subscriptions map[string]models.WebhookSubscription
+	deliveries    map[string]models.WebhookDelivery
+	mutex         *sync.RWMutex
+}
+
+func NewInMemoryWebhookStorage() *InMemoryWebhookStorage {
+	return &InMemoryWebhookStorage{
+		subscriptions: map[string]models.WebhookSubscription{},
+		deliveries:    map[string]models.WebhookDelivery{},
+		mutex:         &sync.RWMutex{},
+	}
+}
+
+func (s *InMemoryWebhookStorage) SaveSubscription(subscription models.WebhookSubscription) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.subscriptions[subscription.ID] = subscription
+	return nil
+}
+
+func (s *InMemoryWebhookStorage) GetSubscription(id string) (models.WebhookSubscription, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	subscription, found := s.subscriptions[id]
+	return subscription, found
+}
+
+func (s *InMemoryWebhookStorage) ListSubscriptions() ([]models.WebhookSubscription, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	subscriptions := make([]models.WebhookSubscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions, nil
+}
+
+func (s *InMemoryWebhookStorage) DeleteSubscription(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.subscriptions[id]; !found {
+		return ErrWebhookNotFound
+	}
+	delete(s.subscriptions, id)
+	return nil
+}
+
+func (s *InMemoryWebhookStorage) SaveDelivery(delivery models.WebhookDelivery) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (s *InMemoryWebhookStorage) GetDelivery(id string) (models.WebhookDelivery, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	delivery, found := s.deliveries[id]
+	return delivery, found
+}
+
+func (s *InMemoryWebhookStorage) ListDeliveries(filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if filter.matches(delivery) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveredBefore(deliveries[i], deliveries[j])
+	})
+	if filter.Limit > 0 && filter.Limit < len(deliveries) {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+func (s *InMemoryWebhookStorage) DeleteDeliveriesBefore(cutoff time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted := 0
+	for id, delivery := range s.deliveries {
+		if finishedBefore(delivery, cutoff) {
+			delete(s.deliveries, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
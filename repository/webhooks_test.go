@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+)
+
+func TestWebhookStorage(t *testing.T) {
+	t.Run("in-memory", func(t *testing.T) {
+		testWebhookStorage(t, func() WebhookStorage { return NewInMemoryWebhookStorage() })
+	})
+	t.Run("files", func(t *testing.T) {
+		testWebhookStorage(t, func() WebhookStorage {
+			s, err := OpenFileWebhookStorage(t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to open webhook storage: %v", err)
+			}
+			return s
+		})
+	})
+}
+
+func testDelivery(id, subscriptionID string, status models.DeliveryStatus, createdAt time.Time) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		ID:             id,
+		SubscriptionID: subscriptionID,
+		Event:          models.EventReceiptProcessed,
+		Status:         status,
+		Payload:        []byte(`{"id":"` + id + `"}`),
+		CreatedAt:      createdAt,
+	}
+}
+
+func testWebhookStorage(t *testing.T, open func() WebhookStorage) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("subscriptions", func(t *testing.T) {
+		s := open()
+		s.SaveSubscription(models.WebhookSubscription{ID: "orders", URL: "https://example.com/a", Events: []models.WebhookEvent{models.EventReceiptProcessed}})
+		s.SaveSubscription(models.WebhookSubscription{ID: "audit", URL: "https://example.com/b"})
+		s.SaveSubscription(models.WebhookSubscription{ID: "orders", URL: "https://example.com/c"})
+
+		if subscription, found := s.GetSubscription("orders"); !found || subscription.URL != "https://example.com/c" {
+			t.Errorf("Expected orders replaced, got %+v", subscription)
+		}
+		if subscriptions, _ := s.ListSubscriptions(); len(subscriptions) != 2 || subscriptions[0].ID != "audit" {
+			t.Errorf("Expected audit and orders by ID, got %+v", subscriptions)
+		}
+		if err := s.DeleteSubscription("audit"); err != nil {
+			t.Fatalf("DeleteSubscription failed: %v", err)
+		}
+		if err := s.DeleteSubscription("audit"); !errors.Is(err, ErrWebhookNotFound) {
+			t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+		}
+	})
+
+	t.Run("deliveries", func(t *testing.T) {
+		s := open()
+		for i := 0; i < 10; i++ {
+			status := models.DeliveryDelivered
+			if i%2 == 1 {
+				status = models.DeliveryDead
+			}
+			s.SaveDelivery(testDelivery(fmt.Sprintf("d%d", i), "orders", status, start.Add(time.Duration(10-i)*time.Minute)))
+		}
+		s.SaveDelivery(testDelivery("other", "audit", models.DeliveryPending, start))
+
+		if delivery, found := s.GetDelivery("d3"); !found || string(delivery.Payload) != `{"id":"d3"}` {
+			t.Errorf("Expected d3 with its payload, got %+v", delivery)
+		}
+		if deliveries, _ := s.ListDeliveries(DeliveryFilter{SubscriptionID: "orders"}); len(deliveries) != 10 {
+			t.Errorf("Expected 10 deliveries for orders, got %d", len(deliveries))
+		}
+
+		// dead deliveries, oldest first, are d9, d7, d5, ...
+		first, _ := s.ListDeliveries(DeliveryFilter{Status: models.DeliveryDead, Limit: 2})
+		if len(first) != 2 {
+			t.Fatalf("Expected a page of 2, got %d", len(first))
+		}
+		next, _ := s.ListDeliveries(DeliveryFilter{Status: models.DeliveryDead, After: &first[1], Limit: 2})
+		var ids []string
+		for _, delivery := range append(first, next...) {
+			ids = append(ids, delivery.ID)
+		}
+		if fmt.Sprint(ids) != "[d9 d7 d5 d3]" {
+			t.Errorf("Expected pages [d9 d7] [d5 d3], got %v", ids)
+		}
+		if deliveries, err := s.ListDeliveries(DeliveryFilter{SubscriptionID: "missing"}); err != nil || len(deliveries) != 0 {
+			t.Errorf("Expected no deliveries, got %d, %v", len(deliveries), err)
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		s := open()
+		s.SaveDelivery(testDelivery("old-delivered", "orders", models.DeliveryDelivered, start))
+		s.SaveDelivery(testDelivery("old-dead", "orders", models.DeliveryDead, start))
+		s.SaveDelivery(testDelivery("old-pending", "orders", models.DeliveryPending, start))
+		s.SaveDelivery(testDelivery("new", "orders", models.DeliveryDelivered, start.Add(time.Hour)))
+
+		deleted, err := s.DeleteDeliveriesBefore(start.Add(time.Minute))
+		if err != nil || deleted != 2 {
+			t.Fatalf("Expected 2 deliveries dropped, got %d, %v", deleted, err)
+		}
+		deliveries, _ := s.ListDeliveries(DeliveryFilter{})
+		var ids []string
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		if fmt.Sprint(ids) != "[old-pending new]" {
+			t.Errorf("Expected the pending and the new delivery kept, got %v", ids)
+		}
+	})
+}
+
+func TestFileWebhookStorage(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("survives a reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenFileWebhookStorage(dir)
+		s.SaveSubscription(models.WebhookSubscription{ID: "orders", URL: "https://example.com/a", Secret: "s3cret"})
+		s.SaveSubscription(models.WebhookSubscription{ID: "audit", URL: "https://example.com/b"})
+		s.DeleteSubscription("audit")
+		s.SaveDelivery(testDelivery("d1", "orders", models.DeliveryPending, start))
+		s.SaveDelivery(testDelivery("d2", "orders", models.DeliveryDead, start))
+		s.DeleteDeliveriesBefore(start.Add(time.Minute))
+
+		s, err := OpenFileWebhookStorage(dir)
+		if err != nil {
+			t.Fatalf("Failed to reopen: %v", err)
+		}
+		if subscriptions, _ := s.ListSubscriptions(); len(subscriptions) != 1 || subscriptions[0].Secret != "s3cret" {
+			t.Errorf("Expected only orders with its secret, got %+v", subscriptions)
+		}
+		deliveries, _ := s.ListDeliveries(DeliveryFilter{})
+		if len(deliveries) != 1 || deliveries[0].ID != "d1" || deliveries[0].Status != models.DeliveryPending {
+			t.Errorf("Expected only the pending d1, got %+v", deliveries)
+		}
+	})
+
+	t.Run("damaged file", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenFileWebhookStorage(dir)
+		s.SaveDelivery(testDelivery("d1", "orders", models.DeliveryPending, start))
+
+		path := s.path(deliveriesDir, "d1")
+		data, _ := os.ReadFile(path)
+		data[len(data)-2] ^= 0xff
+		os.WriteFile(path, data, 0o644)
+
+		if _, err := OpenFileWebhookStorage(dir); !errors.Is(err, ErrCorruptWebhookFile) {
+			t.Errorf("Expected ErrCorruptWebhookFile, got %v", err)
+		}
+	})
+
+	t.Run("drops interrupted writes", func(t *testing.T) {
+		dir := t.TempDir()
+		OpenFileWebhookStorage(dir)
+		tmp := filepath.Join(dir, deliveriesDir, "put-1.tmp")
+		os.WriteFile(tmp, []byte("partial"), 0o644)
+
+		if _, err := OpenFileWebhookStorage(dir); err != nil {
+			t.Fatalf("Failed to reopen: %v", err)
+		}
+		if _, err := os.Stat(tmp); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the temporary file removed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// MaxSignatureSkew is how far a signed request's timestamp may drift from
+// the server clock before it is treated as a replay
+const MaxSignatureSkew = 5 * time.Minute
+
+var (
+	ErrInvalidAPIKey     = errors.New("Invalid API key")
+	ErrSignatureRequired = errors.New("Request signature is required for this client")
+	ErrInvalidSignature  = errors.New("Invalid request signature")
+	ErrStaleSignature    = errors.New("Request signature timestamp is outside the allowed window")
+)
+
+// ClientService registers API clients and checks their credentials
+type ClientService struct {
+	storage repository.ClientStorage
+	clock   utils.Clock
+}
+
+func NewClientService(storage repository.ClientStorage, clock utils.Clock) *ClientService {
+	return &ClientService{
+		storage: storage,
+		clock:   clock,
+	}
+}
+
+// RegisterClient stores the client with a hash of apiKey, never the key itself
+func (s *ClientService) RegisterClient(client models.Client, apiKey string) error {
+	if strings.TrimSpace(client.ID) == "" {
+		return errors.New("Client ID is required")
+	}
+	if strings.TrimSpace(apiKey) == "" {
+		return errors.New("API key is required")
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = models.DefaultClientScopes
+	}
+	client.APIKeyHash = HashAPIKey(apiKey)
+	return s.storage.SaveClient(client)
+}
+
+// AuthenticateKey finds the client owning apiKey
+func (s *ClientService) AuthenticateKey(apiKey string) (models.Client, error) {
+	if apiKey == "" {
+		return models.Client{}, ErrInvalidAPIKey
+	}
+	client, found := s.storage.GetClientByKeyHash(HashAPIKey(apiKey))
+	if !found {
+		return models.Client{}, ErrInvalidAPIKey
+	}
+	return client, nil
+}
+
+// VerifySignature checks an HMAC-signed request for clients that have a
+// signing secret. Clients without one may send unsigned requests.
+func (s *ClientService) VerifySignature(client models.Client, timestamp, signature, method, path string, body []byte) error {
+	if client.SigningSecret == "" {
+		return nil
+	}
+	if timestamp == "" || signature == "" {
+		return ErrSignatureRequired
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	skew := s.clock.Now().Sub(time.Unix(unix, 0))
+	if skew > MaxSignatureSkew || skew < -MaxSignatureSkew {
+		return ErrStaleSignature
+	}
+
+	expected := SignRequest(client.SigningSecret, timestamp, method, path, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// HashAPIKey returns the hex SHA-256 of an API key. Keys are random and
+// long, so a fast unsalted hash is enough to keep them out of storage.
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignRequest computes the hex HMAC-SHA256 a client sends in X-Signature:
+// timestamp, method, path and the body hash, joined by newlines
+func SignRequest(secret, timestamp, method, path string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+
+	var payload bytes.Buffer
+	payload.WriteString(timestamp + "\n")
+	payload.WriteString(strings.ToUpper(method) + "\n")
+	payload.WriteString(path + "\n")
+	payload.WriteString(hex.EncodeToString(bodyHash[:]))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload.Bytes())
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/receiptcsv"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// ImportReceipts validates and processes every receipt of a CSV file for
+// the caller. Receipts with unreadable rows or that fail validation are
+// reported and skipped, the rest are processed. The error is set when the
+// file cannot be read at all.
+func (s *ReceiptService) ImportReceipts(caller models.Principal, r io.Reader) (models.ImportResult, error) {
+	receipts, rowErrors, err := receiptcsv.Read(r, s.csv)
+	if err != nil {
+		return models.ImportResult{}, err
+	}
+
+	result := models.ImportResult{Receipts: []models.ImportedReceipt{}, Errors: rowErrors}
+	for _, parsed := range receipts {
+		if len(parsed.Errors) > 0 {
+			result.Errors = append(result.Errors, parsed.Errors...)
+			result.Failed++
+			continue
+		}
+
+		receipt := parsed.Receipt
+		if err := s.ValidateReceipt(&receipt); err != nil {
+			result.Errors = append(result.Errors, models.ImportError{Rows: parsed.Rows, Key: parsed.Key, Error: "Invalid receipt: " + err.Error()})
+			result.Failed++
+			continue
+		}
+
+		id, err := s.ProcessReceipt(caller, receipt)
+		if err != nil {
+			utils.Logger.WithError(err).WithField("key", parsed.Key).Error("Failed to process imported receipt")
+			result.Errors = append(result.Errors, models.ImportError{Rows: parsed.Rows, Key: parsed.Key, Error: "Server error processing receipt"})
+			result.Failed++
+			continue
+		}
+		result.Receipts = append(result.Receipts, models.ImportedReceipt{Key: parsed.Key, ID: id, Rows: parsed.Rows})
+		result.Imported++
+	}
+
+	utils.Logger.WithFields(logrus.Fields{
+		"imported": result.Imported,
+		"failed":   result.Failed,
+	}).Info("Imported receipts from CSV")
+	return result, nil
+}
+
+// exportBatchSize receipts are read from storage at a time while exporting
+const exportBatchSize = 500
+
+// ExportReceipts writes the receipts the caller can read as CSV, oldest
+// submission first. Receipts are read in batches, each starting after the
+// last receipt written, so none is written twice. Receipts stored during
+// the export are included if they sort after that point.
+func (s *ReceiptService) ExportReceipts(caller models.Principal, w io.Writer) error {
+	writer := receiptcsv.NewWriter(w, s.csv)
+	if err := writer.WriteHeader(); err != nil {
+		return err
+	}
+
+	filter := readableBy(caller)
+	filter.Limit = exportBatchSize
+	for {
+		records, err := s.storage.ListReceipts(filter)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		if len(records) < exportBatchSize {
+			return writer.Flush()
+		}
+		filter.After = repository.CursorAfter(records[len(records)-1])
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// EventHandler receives published receipt events. An error leaves the
+// event and the rest of its batch in the outbox to be published again.
+// Events are delivered at least once, so a handler may see the same event
+// ID again after an error or a crash and should ignore it.
+type EventHandler func(event models.ReceiptEvent) error
+
+// EventBusConfig controls how often the outbox is read
+type EventBusConfig struct {
+	// PollInterval is how often the outbox is checked for events written
+	// without a Notify, e.g. by another process sharing the storage
+	PollInterval time.Duration
+	// BatchSize is how many events are read from the outbox at a time
+	BatchSize int
+}
+
+// DefaultEventBusConfig is used for fields left at zero
+var DefaultEventBusConfig = EventBusConfig{
+	PollInterval: time.Second,
+	BatchSize:    100,
+}
+
+type eventSubscriber struct {
+	handler EventHandler
+	types   map[models.ReceiptEventType]bool
+}
+
+// EventBus publishes the receipt events stored in an outbox to its
+// subscribers, in the order they were written. Events only leave the
+// outbox once every subscriber has handled them without an error. The outbox claims the events
+// a bus publishes, so buses in several instances sharing a storage each
+// publish a share of the events and none publishes one twice.
+type EventBus struct {
+	outbox      repository.Outbox
+	config      EventBusConfig
+	subscribers []eventSubscriber
+	mutex       sync.RWMutex
+	// flushing keeps a Flush and the relay publishing one batch at a time,
+	// so this bus publishes in order
+	flushing sync.Mutex
+	wake     chan struct{}
+	done     chan struct{}
+	relay    sync.WaitGroup
+	stop     sync.Once
+}
+
+func NewEventBus(outbox repository.Outbox, config EventBusConfig) *EventBus {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultEventBusConfig.PollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultEventBusConfig.BatchSize
+	}
+	return &EventBus{
+		outbox: outbox,
+		config: config,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Subscribe calls handler for events of the given types, or for every
+// event when none are given. Handlers run one at a time on the relay, so
+// slow work belongs on the subscriber's own queue.
+func (b *EventBus) Subscribe(handler EventHandler, types ...models.ReceiptEventType) {
+	sub := eventSubscriber{handler: handler}
+	if len(types) > 0 {
+		sub.types = map[models.ReceiptEventType]bool{}
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers = append(b.subscribers, sub)
+}
+
+// Notify tells the relay new events were written, without waiting for it
+func (b *EventBus) Notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+		// a wake-up is already pending
+	}
+}
+
+// Flush publishes every event waiting in the outbox. It stops at the first
+// batch a handler fails, which stays in the outbox.
+func (b *EventBus) Flush() error {
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+
+	for {
+		published, err := b.outbox.PublishEvents(b.config.BatchSize, b.publishAll)
+		if err != nil || published == 0 {
+			return err
+		}
+	}
+}
+
+// publishAll stops at the first handler error, so the outbox keeps the
+// batch and it is published again on the next flush
+func (b *EventBus) publishAll(events []models.ReceiptEvent) error {
+	for _, event := range events {
+		if err := b.publish(event); err != nil {
+			return fmt.Errorf("event %s: %w", event.ID, err)
+		}
+	}
+	return nil
+}
+
+func (b *EventBus) publish(event models.ReceiptEvent) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, sub := range b.subscribers {
+		if sub.types == nil || sub.types[event.Type] {
+			if err := sub.handler(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Start launches the relay that moves events from the outbox to the
+// subscribers
+func (b *EventBus) Start() {
+	b.relay.Add(1)
+	go func() {
+		defer b.relay.Done()
+		ticker := time.NewTicker(b.config.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.done:
+				b.flush()
+				return
+			case <-b.wake:
+			case <-ticker.C:
+			}
+			b.flush()
+		}
+	}()
+}
+
+// Stop publishes what is left in the outbox and stops the relay
+func (b *EventBus) Stop() {
+	b.stop.Do(func() {
+		close(b.done)
+	})
+	b.relay.Wait()
+}
+
+func (b *EventBus) flush() {
+	if err := b.Flush(); err != nil {
+		utils.Logger.WithError(err).Error("Failed to publish receipt events")
+	}
+}
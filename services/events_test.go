@@ -0,0 +1,204 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+)
+
+// eventLog is an EventHandler that remembers what it was given. It fails
+// its first failures calls.
+type eventLog struct {
+	mutex    sync.Mutex
+	events   []models.ReceiptEvent
+	failures int
+}
+
+func (l *eventLog) handle(event models.ReceiptEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.failures > 0 {
+		l.failures--
+		return errors.New("subscriber unavailable")
+	}
+	l.events = append(l.events, event)
+	return nil
+}
+
+func (l *eventLog) received() []models.ReceiptEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]models.ReceiptEvent(nil), l.events...)
+}
+
+// failingStorage refuses every new receipt
+type failingStorage struct {
+	*repository.InMemoryStorage
+}
+
+func (s failingStorage) SaveReceipt(id string, record models.ReceiptWithPoints, events ...models.ReceiptEvent) error {
+	return errors.New("disk full")
+}
+
+var eventReceipt = models.Receipt{
+	Retailer:     "Target",
+	PurchaseDate: "2022-01-01",
+	PurchaseTime: "13:01",
+	Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+	Total:        "1.25",
+}
+
+func TestEventBus(t *testing.T) {
+	t.Run("processed receipts", func(t *testing.T) {
+		storage := repository.NewInMemoryStorage()
+		bus := NewEventBus(storage, EventBusConfig{})
+		all, processed, voided := &eventLog{}, &eventLog{}, &eventLog{}
+		bus.Subscribe(all.handle)
+		bus.Subscribe(processed.handle, models.ReceiptProcessed)
+		bus.Subscribe(voided.handle, models.ReceiptVoided)
+		receipts := NewReceiptService(storage, WithEventBus(bus))
+
+		id, _ := receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, eventReceipt)
+		// written with the receipt, not yet published
+		if pending, _ := storage.PendingEvents(10); len(pending) != 1 || len(all.received()) != 0 {
+			t.Fatalf("Expected one event waiting in the outbox, got %+v", pending)
+		}
+
+		if err := bus.Flush(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		events := processed.received()
+		if len(events) != 1 || events[0].ReceiptID != id || events[0].ClientID != "alice" || events[0].Receipt.Points == 0 {
+			t.Errorf("Expected a ReceiptProcessed event for %s, got %+v", id, events)
+		}
+		if len(all.received()) != 1 || len(voided.received()) != 0 {
+			t.Errorf("Expected the event to reach only matching subscribers")
+		}
+		if pending, _ := storage.PendingEvents(10); len(pending) != 0 {
+			t.Errorf("Expected an empty outbox after publishing, got %+v", pending)
+		}
+	})
+
+	t.Run("failed saves emit nothing", func(t *testing.T) {
+		storage := failingStorage{repository.NewInMemoryStorage()}
+		bus := NewEventBus(storage, EventBusConfig{})
+		log := &eventLog{}
+		bus.Subscribe(log.handle)
+		receipts := NewReceiptService(storage, WithEventBus(bus))
+
+		if _, err := receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, eventReceipt); err == nil {
+			t.Fatal("Expected the save to fail")
+		}
+		bus.Flush()
+		if events := log.received(); len(events) != 0 {
+			t.Errorf("Expected no events for a failed save, got %+v", events)
+		}
+	})
+
+	t.Run("failed handler gets the event again", func(t *testing.T) {
+		storage := repository.NewInMemoryStorage()
+		bus := NewEventBus(storage, EventBusConfig{})
+		log := &eventLog{failures: 1}
+		bus.Subscribe(log.handle)
+		receipts := NewReceiptService(storage, WithEventBus(bus))
+
+		id, _ := receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, eventReceipt)
+		if err := bus.Flush(); err == nil {
+			t.Fatal("Expected the handler's error")
+		}
+		if pending, _ := storage.PendingEvents(10); len(pending) != 1 || len(log.received()) != 0 {
+			t.Fatalf("Expected the event kept in the outbox, got %+v", pending)
+		}
+
+		if err := bus.Flush(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if events := log.received(); len(events) != 1 || events[0].ReceiptID != id {
+			t.Errorf("Expected the event redelivered, got %+v", events)
+		}
+		if pending, _ := storage.PendingEvents(10); len(pending) != 0 {
+			t.Errorf("Expected an empty outbox after redelivery, got %+v", pending)
+		}
+	})
+
+	t.Run("reviews", func(t *testing.T) {
+		storage := repository.NewInMemoryStorage()
+		storage.SaveReceipt("held-1", models.ReceiptWithPoints{ClientID: "alice", Points: 40, Status: models.StatusPendingReview})
+		storage.SaveReceipt("held-2", models.ReceiptWithPoints{ClientID: "alice", Points: 50, Status: models.StatusPendingReview})
+		bus := NewEventBus(storage, EventBusConfig{})
+		log := &eventLog{}
+		bus.Subscribe(log.handle)
+		receipts := NewReceiptService(storage, WithEventBus(bus))
+
+		receipts.ApproveReceipt("held-1")
+		receipts.RejectReceipt("held-2")
+		// no longer held, the update fails and writes no event
+		if err := receipts.RejectReceipt("held-1"); err != ErrNotPendingReview {
+			t.Fatalf("Expected ErrNotPendingReview, got %v", err)
+		}
+		bus.Flush()
+
+		events := log.received()
+		if len(events) != 2 {
+			t.Fatalf("Expected two events, got %+v", events)
+		}
+		approved, rejected := events[0], events[1]
+		if approved.Type != models.PointsAdjusted || approved.ReceiptID != "held-1" || approved.PreviousPoints != 0 ||
+			approved.Receipt.Points != 40 || approved.Reason != models.ReasonReviewApproved {
+			t.Errorf("Expected held-1 adjusted from 0 to 40 points, got %+v", approved)
+		}
+		if rejected.Type != models.ReceiptVoided || rejected.ReceiptID != "held-2" ||
+			rejected.Receipt.Status != models.StatusRejected || rejected.Reason != models.ReasonReviewRejected {
+			t.Errorf("Expected held-2 voided, got %+v", rejected)
+		}
+	})
+
+	t.Run("without a bus", func(t *testing.T) {
+		storage := repository.NewInMemoryStorage()
+		receipts := NewReceiptService(storage)
+		receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, eventReceipt)
+		if pending, _ := storage.PendingEvents(10); len(pending) != 0 {
+			t.Errorf("Expected nothing in the outbox without a bus, got %+v", pending)
+		}
+	})
+
+	t.Run("relay", func(t *testing.T) {
+		storage := repository.NewInMemoryStorage()
+		bus := NewEventBus(storage, EventBusConfig{PollInterval: time.Hour, BatchSize: 2})
+		log := &eventLog{}
+		bus.Subscribe(log.handle)
+		receipts := NewReceiptService(storage, WithEventBus(bus))
+		bus.Start()
+
+		// Notify wakes the relay long before the next poll
+		id, _ := receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, eventReceipt)
+		deadline := time.Now().Add(5 * time.Second)
+		for len(log.received()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if events := log.received(); len(events) != 1 || events[0].ReceiptID != id {
+			t.Fatalf("Expected the relay to publish %s, got %+v", id, events)
+		}
+
+		// Stop publishes what is left, in the order it was written
+		var ids []string
+		for i := 0; i < 5; i++ {
+			id, _ := receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, eventReceipt)
+			ids = append(ids, id)
+		}
+		bus.Stop()
+		events := log.received()[1:]
+		if len(events) != len(ids) {
+			t.Fatalf("Expected %d more events after Stop, got %d", len(ids), len(events))
+		}
+		for i, event := range events {
+			if event.ReceiptID != ids[i] {
+				t.Errorf("Event %d is for %s, expected %s", i, event.ReceiptID, ids[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// Fraud heuristic names, reported in FraudSignal.Rule
+const (
+	SignalFuturePurchase   = "future_purchase"
+	SignalImplausibleTotal = "implausible_total"
+	SignalTotalMismatch    = "total_mismatch"
+	SignalSharedReceipt    = "shared_receipt"
+	SignalResubmitted      = "resubmitted_receipt"
+	SignalVelocity         = "velocity"
+	SignalRuleOptimal      = "rule_optimal"
+)
+
+// FraudConfig tunes the fraud heuristics
+type FraudConfig struct {
+	// HoldThreshold is the score at which points are held for review
+	HoldThreshold float64
+	// MaxTotalCents is the largest total considered plausible
+	MaxTotalCents int64
+	// VelocityLimit submissions per client within VelocityWindow
+	VelocityLimit  int
+	VelocityWindow time.Duration
+	// DuplicateWindow is how long a receipt's fingerprint is remembered
+	// after it was last submitted
+	DuplicateWindow time.Duration
+}
+
+// DefaultFraudConfig holds receipts scoring 0.7 or more
+var DefaultFraudConfig = FraudConfig{
+	HoldThreshold:   0.7,
+	MaxTotalCents:   500000,
+	VelocityLimit:   30,
+	VelocityWindow:  time.Hour,
+	DuplicateWindow: 30 * 24 * time.Hour,
+}
+
+// FraudDetector scores receipts against heuristics for people gaming the
+// points rules. It remembers what it has seen in process, so duplicate and
+// velocity checks only cover receipts this instance has processed.
+// Anonymous callers cannot be told apart, so those two checks skip them.
+type FraudDetector struct {
+	config FraudConfig
+	clock  utils.Clock
+
+	mutex        *sync.Mutex
+	fingerprints map[string]*fingerprintClients
+	// fingerprintsSeen lists submissions oldest first, to forget
+	// fingerprints once DuplicateWindow has passed
+	fingerprintsSeen []fingerprintSeen
+	// client -> recent submission times, oldest first
+	submissions map[string][]time.Time
+	// lastSweep is when clients without recent submissions were dropped
+	lastSweep time.Time
+}
+
+// fingerprintClients are the clients that submitted a receipt
+type fingerprintClients struct {
+	clients  map[string]bool
+	lastSeen time.Time
+}
+
+type fingerprintSeen struct {
+	fingerprint string
+	at          time.Time
+}
+
+func NewFraudDetector(config FraudConfig, clock utils.Clock) *FraudDetector {
+	if config.DuplicateWindow <= 0 {
+		config.DuplicateWindow = DefaultFraudConfig.DuplicateWindow
+	}
+	return &FraudDetector{
+		config:       config,
+		clock:        clock,
+		mutex:        &sync.Mutex{},
+		fingerprints: map[string]*fingerprintClients{},
+		submissions:  map[string][]time.Time{},
+		lastSweep:    clock.Now(),
+	}
+}
+
+// Assess scores a receipt submitted by clientID and records the submission
+func (d *FraudDetector) Assess(clientID string, receipt *models.Receipt) models.FraudAssessment {
+	now := d.clock.Now()
+
+	var signals []models.FraudSignal
+	signals = append(signals, d.checkPurchaseDate(receipt, now)...)
+	signals = append(signals, d.checkTotal(receipt)...)
+	if clientID != "" {
+		signals = append(signals, d.checkDuplicates(clientID, receipt, now)...)
+		signals = append(signals, d.checkVelocity(clientID, now)...)
+	}
+	signals = append(signals, checkRuleOptimal(receipt)...)
+
+	score := 0.0
+	for _, signal := range signals {
+		score += signal.Score
+	}
+	score = math.Min(1, score)
+
+	assessment := models.FraudAssessment{
+		Score:   score,
+		Signals: signals,
+		Held:    score >= d.config.HoldThreshold,
+	}
+
+	if len(signals) > 0 {
+		utils.Logger.WithFields(logrus.Fields{
+			"client":  clientID,
+			"score":   score,
+			"signals": len(signals),
+			"held":    assessment.Held,
+		}).Info("Fraud signals raised for receipt")
+	}
+	return assessment
+}
+
+// a purchase cannot happen after the receipt is submitted
+func (d *FraudDetector) checkPurchaseDate(receipt *models.Receipt, now time.Time) []models.FraudSignal {
+	purchased, err := receipt.PurchaseTimestamp()
+	if err != nil {
+		return nil
+	}
+	// naive local times can be up to a day ahead of UTC
+	if purchased.After(now.Add(24 * time.Hour)) {
+		return []models.FraudSignal{{
+			Rule:   SignalFuturePurchase,
+			Score:  1,
+			Detail: "purchased " + receipt.PurchaseDate + " " + receipt.PurchaseTime,
+		}}
+	}
+	return nil
+}
+
+// totals must be plausible and add up to the items, adjusted for tax, tip
+// and discounts
+func (d *FraudDetector) checkTotal(receipt *models.Receipt) []models.FraudSignal {
+	total, err := models.ParseCents(receipt.Total)
+	if err != nil {
+		return nil
+	}
+
+	var signals []models.FraudSignal
+	if total > d.config.MaxTotalCents {
+		signals = append(signals, models.FraudSignal{
+			Rule:   SignalImplausibleTotal,
+			Score:  0.5,
+			Detail: "total " + receipt.Total + " is above the plausible maximum",
+		})
+	}
+
+	var itemSum int64
+	for _, item := range receipt.Items {
+		price, err := models.ParseCents(item.Price)
+		if err != nil {
+			return signals
+		}
+		itemSum += price
+	}
+	if expected := itemSum + receipt.AdjustmentCents(); expected != total {
+		detail := fmt.Sprintf("items add up to %d.%02d", itemSum/100, itemSum%100)
+		if expected != itemSum {
+			detail += fmt.Sprintf(", %d.%02d with tax, tip and discounts", expected/100, expected%100)
+		}
+		signals = append(signals, models.FraudSignal{
+			Rule:   SignalTotalMismatch,
+			Score:  0.4,
+			Detail: detail + ", total is " + receipt.Total,
+		})
+	}
+	return signals
+}
+
+// the same physical receipt should only be submitted once, by one client
+func (d *FraudDetector) checkDuplicates(clientID string, receipt *models.Receipt, now time.Time) []models.FraudSignal {
+	fingerprint := ReceiptFingerprint(receipt)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.forgetFingerprints(now)
+	seen, found := d.fingerprints[fingerprint]
+	if !found {
+		seen = &fingerprintClients{clients: map[string]bool{}}
+		d.fingerprints[fingerprint] = seen
+	}
+	resubmitted := seen.clients[clientID]
+	others := len(seen.clients)
+	if resubmitted {
+		others--
+	}
+	seen.clients[clientID] = true
+	seen.lastSeen = now
+	d.fingerprintsSeen = append(d.fingerprintsSeen, fingerprintSeen{fingerprint: fingerprint, at: now})
+
+	var signals []models.FraudSignal
+	if others > 0 {
+		signals = append(signals, models.FraudSignal{
+			Rule:   SignalSharedReceipt,
+			Score:  math.Min(1, 0.6*float64(others)),
+			Detail: fmt.Sprintf("same receipt submitted by %d other client(s)", others),
+		})
+	}
+	if resubmitted {
+		signals = append(signals, models.FraudSignal{
+			Rule:   SignalResubmitted,
+			Score:  0.4,
+			Detail: "client already submitted this receipt",
+		})
+	}
+	return signals
+}
+
+// forgetFingerprints drops fingerprints not submitted within
+// DuplicateWindow. Callers hold the mutex.
+func (d *FraudDetector) forgetFingerprints(now time.Time) {
+	cutoff := now.Add(-d.config.DuplicateWindow)
+	for len(d.fingerprintsSeen) > 0 && d.fingerprintsSeen[0].at.Before(cutoff) {
+		oldest := d.fingerprintsSeen[0]
+		d.fingerprintsSeen = d.fingerprintsSeen[1:]
+		// a later submission of the same receipt has its own entry
+		if seen := d.fingerprints[oldest.fingerprint]; seen != nil && !seen.lastSeen.After(oldest.at) {
+			delete(d.fingerprints, oldest.fingerprint)
+		}
+	}
+}
+
+// too many submissions from one client in a short window
+func (d *FraudDetector) checkVelocity(clientID string, now time.Time) []models.FraudSignal {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cutoff := now.Add(-d.config.VelocityWindow)
+	// once per window, drop the clients that have gone quiet
+	if !d.lastSweep.After(cutoff) {
+		for client, times := range d.submissions {
+			if times[len(times)-1].Before(cutoff) {
+				delete(d.submissions, client)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	recent := d.submissions[clientID]
+	for len(recent) > 0 && recent[0].Before(cutoff) {
+		recent = recent[1:]
+	}
+	recent = append(recent, now)
+	d.submissions[clientID] = recent
+
+	if len(recent) > d.config.VelocityLimit {
+		return []models.FraudSignal{{
+			Rule:   SignalVelocity,
+			Score:  0.4,
+			Detail: fmt.Sprintf("%d receipts within %s", len(recent), d.config.VelocityWindow),
+		}}
+	}
+	return nil
+}
+
+// checkRuleOptimal flags receipts that hit almost every bonus rule at once:
+// padded retailer names, round totals, odd days and the 2-4pm window
+func checkRuleOptimal(receipt *models.Receipt) []models.FraudSignal {
+	var hits []string
+	if calculateRetailerNamePoints(receipt.Retailer) > 30 {
+		hits = append(hits, "padded retailer name")
+	}
+	if calculateRoundDollarPoints(receipt.Total) > 0 {
+		hits = append(hits, "round dollar total")
+	}
+	if purchased, err := receipt.PurchaseTimestamp(); err == nil {
+		if calculateOddDayPoints(purchased) > 0 {
+			hits = append(hits, "odd day")
+		}
+		if calculateTimeRangePoints(purchased) > 0 {
+			hits = append(hits, "2-4pm purchase")
+		}
+	}
+	if len(receipt.Items) >= 2 && allDescriptionsScore(receipt.Items) {
+		hits = append(hits, "every description length a multiple of 3")
+	}
+
+	if len(hits) < 3 {
+		return nil
+	}
+	return []models.FraudSignal{{
+		Rule:   SignalRuleOptimal,
+		Score:  0.15 * float64(len(hits)),
+		Detail: strings.Join(hits, ", "),
+	}}
+}
+
+func allDescriptionsScore(items []models.Item) bool {
+	for _, item := range items {
+		if models.TextLength(item.ShortDescription)%3 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ReceiptFingerprint identifies a physical receipt regardless of who sent
+// it or how the retailer name and item order were typed
+func ReceiptFingerprint(receipt *models.Receipt) string {
+	items := make([]string, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items = append(items, strings.ToLower(strings.TrimSpace(item.ShortDescription))+"="+item.Price)
+	}
+	sort.Strings(items)
+
+	parts := []string{
+		strings.ToLower(strings.Join(strings.Fields(receipt.Retailer), " ")),
+		receipt.PurchaseDate,
+		receipt.PurchaseTime,
+		receipt.Total,
+		strings.Join(items, ";"),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+func hasSignal(assessment models.FraudAssessment, rule string) bool {
+	for _, signal := range assessment.Signals {
+		if signal.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFraudDetectorAssess(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	plain := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-06-14",
+		PurchaseTime: "10:12",
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Doritos", Price: "3.35"},
+		},
+		Total: "9.84",
+	}
+
+	tests := []struct {
+		name     string
+		receipt  models.Receipt
+		expected string
+		held     bool
+	}{
+		{
+			name:    "ordinary receipt",
+			receipt: plain,
+		},
+		{
+			name: "future purchase date",
+			receipt: models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: "2099-01-01",
+				PurchaseTime: "10:12",
+				Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+				Total:        "1.25",
+			},
+			expected: SignalFuturePurchase,
+			held:     true,
+		},
+		{
+			name: "implausible total",
+			receipt: models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: "2022-06-14",
+				PurchaseTime: "10:12",
+				Items:        []models.Item{{ShortDescription: "Television", Price: "9999.99"}},
+				Total:        "9999.99",
+			},
+			expected: SignalImplausibleTotal,
+		},
+		{
+			name: "total does not add up",
+			receipt: models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: "2022-06-14",
+				PurchaseTime: "10:12",
+				Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+				Total:        "100.00",
+			},
+			expected: SignalTotalMismatch,
+		},
+		{
+			name: "tax, tip and discounts",
+			receipt: models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: "2022-06-14",
+				PurchaseTime: "10:12",
+				Items:        []models.Item{{ShortDescription: "Pepsi", Price: "10.00"}},
+				Subtotal:     "10.00",
+				Discounts:    []models.Discount{{Description: "Coupon", Amount: "1.00"}},
+				Tax:          "0.72",
+				Tip:          "1.50",
+				Total:        "11.22",
+			},
+		},
+		{
+			name: "items do not add up to the subtotal",
+			receipt: models.Receipt{
+				Retailer:     "Target",
+				PurchaseDate: "2022-06-14",
+				PurchaseTime: "10:12",
+				Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+				Subtotal:     "10.00",
+				Tax:          "0.80",
+				Total:        "10.80",
+			},
+			expected: SignalTotalMismatch,
+		},
+		{
+			name: "rule optimal receipt",
+			receipt: models.Receipt{
+				Retailer:     "Target Store 1234567890 ABCDEFGHIJKLMNOPQRST",
+				PurchaseDate: "2022-06-13",
+				PurchaseTime: "14:33",
+				Items: []models.Item{
+					{ShortDescription: "ABC", Price: "5.00"},
+					{ShortDescription: "DEFGHI", Price: "5.00"},
+				},
+				Total: "10.00",
+			},
+			expected: SignalRuleOptimal,
+			held:     true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			detector := NewFraudDetector(DefaultFraudConfig, utils.NewFakeClock(now))
+
+			assessment := detector.Assess("client", &tc.receipt)
+			if tc.expected == "" && len(assessment.Signals) != 0 {
+				t.Fatalf("Expected no signals, got %+v", assessment.Signals)
+			}
+			if tc.expected != "" && !hasSignal(assessment, tc.expected) {
+				t.Fatalf("Expected %s signal, got %+v", tc.expected, assessment.Signals)
+			}
+			if assessment.Held != tc.held {
+				t.Errorf("Expected held=%v with score %.2f", tc.held, assessment.Score)
+			}
+		})
+	}
+
+	t.Run("same receipt from many clients", func(t *testing.T) {
+		detector := NewFraudDetector(DefaultFraudConfig, utils.NewFakeClock(now))
+
+		detector.Assess("alice", &plain)
+		if a := detector.Assess("alice", &plain); !hasSignal(a, SignalResubmitted) || a.Held {
+			t.Errorf("Resubmission should be flagged but not held, got %+v", a)
+		}
+		detector.Assess("bob", &plain)
+		if a := detector.Assess("carol", &plain); !hasSignal(a, SignalSharedReceipt) || !a.Held {
+			t.Errorf("Receipt shared by three clients should be held, got %+v", a)
+		}
+	})
+
+	t.Run("velocity per client", func(t *testing.T) {
+		clock := utils.NewFakeClock(now)
+		detector := NewFraudDetector(FraudConfig{HoldThreshold: 0.7, MaxTotalCents: 500000, VelocityLimit: 3, VelocityWindow: time.Hour}, clock)
+
+		for i := 0; i < 3; i++ {
+			if a := detector.Assess("alice", &plain); hasSignal(a, SignalVelocity) {
+				t.Fatalf("Submission %d should be within the limit", i)
+			}
+		}
+		if a := detector.Assess("alice", &plain); !hasSignal(a, SignalVelocity) {
+			t.Error("Fourth submission within the hour should be flagged")
+		}
+
+		clock.Advance(2 * time.Hour)
+		if a := detector.Assess("alice", &plain); hasSignal(a, SignalVelocity) {
+			t.Error("Window should have moved on")
+		}
+	})
+
+	t.Run("anonymous callers", func(t *testing.T) {
+		detector := NewFraudDetector(FraudConfig{HoldThreshold: 0.7, MaxTotalCents: 500000, VelocityLimit: 1, VelocityWindow: time.Hour}, utils.NewFakeClock(now))
+
+		for i := 0; i < 3; i++ {
+			if a := detector.Assess("", &plain); len(a.Signals) != 0 {
+				t.Fatalf("Anonymous submission %d should not be flagged, got %+v", i, a.Signals)
+			}
+		}
+		if a := detector.Assess("alice", &plain); len(a.Signals) != 0 {
+			t.Errorf("Anonymous submissions should not count as other clients, got %+v", a.Signals)
+		}
+	})
+
+	t.Run("old submissions are forgotten", func(t *testing.T) {
+		clock := utils.NewFakeClock(now)
+		detector := NewFraudDetector(FraudConfig{HoldThreshold: 0.7, MaxTotalCents: 500000, VelocityLimit: 30, VelocityWindow: time.Hour, DuplicateWindow: 24 * time.Hour}, clock)
+
+		detector.Assess("alice", &plain)
+		clock.Advance(12 * time.Hour)
+		other := plain
+		other.Total = "1.00"
+		detector.Assess("bob", &other)
+		clock.Advance(13 * time.Hour)
+
+		if a := detector.Assess("carol", &plain); hasSignal(a, SignalSharedReceipt) {
+			t.Errorf("Fingerprint should have been forgotten, got %+v", a.Signals)
+		}
+		// bob's receipt is still remembered, his submission times are not
+		if len(detector.fingerprints) != 2 || len(detector.submissions) != 1 {
+			t.Errorf("Expected old state dropped, got %d fingerprints and %d clients", len(detector.fingerprints), len(detector.submissions))
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var (
+	ErrJobQueueFull = errors.New("Job queue is full")
+	ErrJobsStopped  = errors.New("Job queue is shutting down")
+)
+
+// JobConfig sizes the asynchronous processing pool
+type JobConfig struct {
+	// Workers is the number of receipts processed at the same time
+	Workers int
+	// QueueSize is how many jobs may wait for a worker before new ones
+	// are refused
+	QueueSize int
+	// Retention is how long a done or failed job can still be polled
+	Retention time.Duration
+}
+
+// DefaultJobConfig is used for fields left at zero
+var DefaultJobConfig = JobConfig{
+	Workers:   4,
+	QueueSize: 100,
+	Retention: time.Hour,
+}
+
+// jobSweepInterval is how often the workers drop jobs past their retention
+const jobSweepInterval = time.Minute
+
+// queuedJob is a job with what it needs to run, which is not stored
+type queuedJob struct {
+	id      string
+	caller  models.Principal
+	receipt models.Receipt
+}
+
+// JobService processes receipts in the background on a bounded pool of
+// workers, for clients that would rather poll than wait
+type JobService struct {
+	storage  repository.JobStorage
+	receipts *ReceiptService
+	config   JobConfig
+	clock    utils.Clock
+	queue    chan queuedJob
+	// mutex guards closed, so no job is queued after Stop closes the queue
+	mutex   sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+	// sweeping guards lastSweep, so one worker at a time drops old jobs
+	sweeping  sync.Mutex
+	lastSweep time.Time
+}
+
+func NewJobService(storage repository.JobStorage, receipts *ReceiptService, config JobConfig, clock utils.Clock) *JobService {
+	if config.Workers <= 0 {
+		config.Workers = DefaultJobConfig.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultJobConfig.QueueSize
+	}
+	if config.Retention <= 0 {
+		config.Retention = DefaultJobConfig.Retention
+	}
+	return &JobService{
+		storage:  storage,
+		receipts: receipts,
+		config:   config,
+		clock:    clock,
+		queue:    make(chan queuedJob, config.QueueSize),
+	}
+}
+
+// Submit queues a validated receipt for processing and returns the pending
+// job. It never waits for a worker: a full queue is ErrJobQueueFull.
+func (s *JobService) Submit(caller models.Principal, receipt models.Receipt) (models.Job, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return models.Job{}, ErrJobsStopped
+	}
+
+	job := models.Job{
+		ID:        uuid.New().String(),
+		Status:    models.JobPending,
+		ClientID:  caller.ClientID,
+		CreatedAt: s.clock.Now().UTC(),
+	}
+	// saved first so a fast worker always finds it
+	if err := s.storage.SaveJob(job); err != nil {
+		return models.Job{}, err
+	}
+
+	select {
+	case s.queue <- queuedJob{id: job.ID, caller: caller, receipt: receipt}:
+		return job, nil
+	default:
+		// the caller gets no ID, so nobody could poll the job
+		if err := s.storage.DeleteJob(job.ID); err != nil {
+			utils.Logger.WithError(err).WithField("job", job.ID).Error("Failed to delete refused job")
+		}
+		return models.Job{}, ErrJobQueueFull
+	}
+}
+
+// GetJob returns a job the caller is allowed to see, with the same
+// visibility rules as receipts
+func (s *JobService) GetJob(caller models.Principal, id string) (models.Job, bool) {
+	job, found := s.storage.GetJob(id)
+	if !found || (!caller.HasScope(models.ScopeAdmin) && job.ClientID != caller.ClientID) {
+		return models.Job{}, false
+	}
+	return job, true
+}
+
+// Start launches the workers
+func (s *JobService) Start() {
+	for i := 0; i < s.config.Workers; i++ {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			for queued := range s.queue {
+				s.run(queued)
+				s.sweep()
+			}
+		}()
+	}
+}
+
+// Stop refuses new jobs and waits for the workers to finish every job
+// already queued
+func (s *JobService) Stop() {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+	s.workers.Wait()
+}
+
+func (s *JobService) run(queued queuedJob) {
+	job, found := s.storage.GetJob(queued.id)
+	if !found {
+		utils.Logger.WithField("job", queued.id).Error("Queued job is missing from storage")
+		return
+	}
+
+	started := s.clock.Now().UTC()
+	job.Status = models.JobProcessing
+	job.StartedAt = &started
+	if err := s.storage.SaveJob(job); err != nil {
+		utils.Logger.WithError(err).WithField("job", job.ID).Error("Failed to save job")
+	}
+
+	id, err := s.receipts.ProcessReceipt(queued.caller, queued.receipt)
+	if err != nil {
+		utils.Logger.WithError(err).WithField("job", job.ID).Error("Failed to process receipt")
+		job.Status = models.JobFailed
+		job.Error = "Server error processing receipt"
+		s.finish(job)
+		return
+	}
+
+	points, _ := s.receipts.GetPoints(queued.caller, id)
+	job.Status = models.JobDone
+	job.ReceiptID = id
+	job.Points = &points.Points
+	s.finish(job)
+
+	utils.Logger.WithFields(logrus.Fields{
+		"job": job.ID,
+		"id":  id,
+	}).Info("Receipt processed asynchronously")
+}
+
+// sweep drops jobs that finished more than Retention ago, at most once
+// every jobSweepInterval
+func (s *JobService) sweep() {
+	s.sweeping.Lock()
+	defer s.sweeping.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) < jobSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	deleted, err := s.storage.DeleteFinishedBefore(now.Add(-s.config.Retention))
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to drop finished jobs")
+		return
+	}
+	if deleted > 0 {
+		utils.Logger.WithField("jobs", deleted).Info("Dropped finished jobs")
+	}
+}
+
+func (s *JobService) finish(job models.Job) {
+	finished := s.clock.Now().UTC()
+	job.FinishedAt = &finished
+	if err := s.storage.SaveJob(job); err != nil {
+		utils.Logger.WithError(err).WithField("job", job.ID).Error("Failed to save job")
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var jobClock = utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+
+func newTestJobs(storage repository.ReceiptStorage, config JobConfig) *JobService {
+	return NewJobService(repository.NewInMemoryJobStorage(), NewReceiptService(storage), config, jobClock)
+}
+
+// waitForJob polls until the job has finished
+func waitForJob(t *testing.T, jobs *JobService, caller models.Principal, id string) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, found := jobs.GetJob(caller, id)
+		if !found {
+			t.Fatalf("Job %s not found", id)
+		}
+		if job.Status == models.JobDone || job.Status == models.JobFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job did not finish: %+v", job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	alice := models.Principal{ClientID: "alice"}
+
+	t.Run("done", func(t *testing.T) {
+		jobs := newTestJobs(repository.NewInMemoryStorage(), JobConfig{Workers: 2})
+		jobs.Start()
+		defer jobs.Stop()
+
+		job, err := jobs.Submit(alice, eventReceipt)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if job.Status != models.JobPending || job.ID == "" {
+			t.Errorf("Expected a pending job, got %+v", job)
+		}
+
+		job = waitForJob(t, jobs, alice, job.ID)
+		if job.Status != models.JobDone || job.ReceiptID == "" || job.Points == nil || *job.Points != 37 {
+			t.Errorf("Expected a done job with 37 points, got %+v", job)
+		}
+		if job.StartedAt == nil || job.FinishedAt == nil {
+			t.Errorf("Expected start and finish times, got %+v", job)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		jobs := newTestJobs(failingStorage{repository.NewInMemoryStorage()}, JobConfig{})
+		jobs.Start()
+		defer jobs.Stop()
+
+		job, _ := jobs.Submit(alice, eventReceipt)
+		job = waitForJob(t, jobs, alice, job.ID)
+		if job.Status != models.JobFailed || job.Error == "" || job.ReceiptID != "" {
+			t.Errorf("Expected a failed job, got %+v", job)
+		}
+	})
+
+	t.Run("visibility", func(t *testing.T) {
+		jobs := newTestJobs(repository.NewInMemoryStorage(), JobConfig{})
+		job, _ := jobs.Submit(alice, eventReceipt)
+
+		if _, found := jobs.GetJob(models.Principal{ClientID: "bob"}, job.ID); found {
+			t.Error("Other clients should not see the job")
+		}
+		if _, found := jobs.GetJob(models.Principal{ClientID: "ops", Scopes: []string{models.ScopeAdmin}}, job.ID); !found {
+			t.Error("Admins should see every job")
+		}
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		// not started, so nothing leaves the queue
+		jobs := newTestJobs(repository.NewInMemoryStorage(), JobConfig{QueueSize: 1})
+		first, _ := jobs.Submit(alice, eventReceipt)
+		if _, err := jobs.Submit(alice, eventReceipt); err != ErrJobQueueFull {
+			t.Fatalf("Expected ErrJobQueueFull, got %v", err)
+		}
+		if job, _ := jobs.GetJob(alice, first.ID); job.Status != models.JobPending {
+			t.Errorf("Expected the first job to wait, got %+v", job)
+		}
+	})
+
+	t.Run("stop drains the queue", func(t *testing.T) {
+		jobs := newTestJobs(repository.NewInMemoryStorage(), JobConfig{Workers: 1, QueueSize: 5})
+		var ids []string
+		for i := 0; i < 5; i++ {
+			job, err := jobs.Submit(alice, eventReceipt)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids = append(ids, job.ID)
+		}
+
+		jobs.Start()
+		jobs.Stop()
+		for _, id := range ids {
+			if job, _ := jobs.GetJob(alice, id); job.Status != models.JobDone {
+				t.Errorf("Expected job %s to be done after Stop, got %+v", id, job)
+			}
+		}
+		if _, err := jobs.Submit(alice, eventReceipt); err != ErrJobsStopped {
+			t.Errorf("Expected ErrJobsStopped, got %v", err)
+		}
+	})
+
+	t.Run("finished jobs are dropped after the retention", func(t *testing.T) {
+		clock := utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+		jobs := NewJobService(repository.NewInMemoryJobStorage(), NewReceiptService(repository.NewInMemoryStorage()), JobConfig{Workers: 1, Retention: time.Hour}, clock)
+		jobs.Start()
+
+		old, _ := jobs.Submit(alice, eventReceipt)
+		waitForJob(t, jobs, alice, old.ID)
+		clock.Advance(30 * time.Minute)
+		recent, _ := jobs.Submit(alice, eventReceipt)
+		waitForJob(t, jobs, alice, recent.ID)
+
+		// the next job's worker sweeps, and Stop waits for it
+		clock.Advance(45 * time.Minute)
+		jobs.Submit(alice, eventReceipt)
+		jobs.Stop()
+
+		if _, found := jobs.GetJob(alice, old.ID); found {
+			t.Error("Expected the job finished 75 minutes ago to be dropped")
+		}
+		if _, found := jobs.GetJob(alice, recent.ID); !found {
+			t.Error("Expected the job finished 45 minutes ago to be kept")
+		}
+	})
+}
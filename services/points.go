@@ -3,7 +3,6 @@ package services
 import (
 	"math"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -11,44 +10,86 @@ import (
 	"github.com/ycChu711/receipt-processor/utils"
 )
 
+// Base rule names, reported in PointsLine.Rule
+const (
+	RuleRetailerName      = "retailer_name"
+	RuleRoundDollar       = "round_dollar"
+	RuleQuarterMultiple   = "quarter_multiple"
+	RuleItemPairs         = "item_pairs"
+	RuleDescriptionLength = "description_length"
+	RuleOddDay            = "odd_day"
+	RuleAfternoon         = "afternoon"
+)
+
+// ItemCounting decides how item quantities feed rules 4 and 5
+type ItemCounting string
+
+const (
+	// CountLines treats every line as one item at its price and ignores
+	// quantities, so receipts score the same whether or not they send them
+	CountLines ItemCounting = "lines"
+	// CountUnits counts a line with a whole number quantity as that many
+	// items: rule 4 pairs units and rule 5 scores each unit at its unit
+	// price. Lines with a fractional quantity are one item at their price.
+	CountUnits ItemCounting = "units"
+)
+
+// PointsRules configure how the base rules read a receipt
+type PointsRules struct {
+	ItemCounting ItemCounting
+}
+
+// DefaultPointsRules count receipt lines
+var DefaultPointsRules = PointsRules{ItemCounting: CountLines}
+
 func CalculatePoints(receipt *models.Receipt) int64 {
+	return models.SumPoints(CalculateBreakdown(receipt))
+}
+
+// CalculateBreakdown scores a receipt against the seven base rules with
+// DefaultPointsRules, one line per rule in rule order
+func CalculateBreakdown(receipt *models.Receipt) []models.PointsLine {
+	return CalculateBreakdownWith(receipt, DefaultPointsRules)
+}
+
+// CalculateBreakdownWith scores a receipt against the seven base rules,
+// one line per rule in rule order
+func CalculateBreakdownWith(receipt *models.Receipt, rules PointsRules) []models.PointsLine {
 	utils.Logger.WithField("retailer", receipt.Retailer).Info("Starting points calculation")
 
-	retailerPoints := calculateRetailerNamePoints(receipt.Retailer)
-	roundDollarPoints := calculateRoundDollarPoints(receipt.Total)
-	quarterMultiplePoints := calculateQuarterMultiplePoints(receipt.Total)
-	itemPairPoints := calculateItemPairPoints(len(receipt.Items))
-	descriptionPoints := calculateDescriptionLengthPoints(receipt.Items)
-	oddDayPoints := calculateOddDayPoints(receipt.PurchaseDate)
-	timeRangePoints := calculateTimeRangePoints(receipt.PurchaseTime)
+	// validation normalizes the receipt, so this only fails for receipts
+	// that skipped it
+	purchased, err := receipt.PurchaseTimestamp()
+	if err != nil {
+		utils.Logger.WithError(err).Warn("Scoring receipt without a valid purchase time")
+	}
 
-	totalPoints := retailerPoints + roundDollarPoints + quarterMultiplePoints +
-		itemPairPoints + descriptionPoints + oddDayPoints + timeRangePoints
+	lines := []models.PointsLine{
+		{Rule: RuleRetailerName, Points: calculateRetailerNamePoints(receipt.Retailer)},
+		{Rule: RuleRoundDollar, Points: calculateRoundDollarPoints(receipt.Total)},
+		{Rule: RuleQuarterMultiple, Points: calculateQuarterMultiplePoints(receipt.Total)},
+		{Rule: RuleItemPairs, Points: calculateItemPairPoints(countItems(receipt.Items, rules.ItemCounting))},
+		{Rule: RuleDescriptionLength, Points: calculateDescriptionLengthPoints(receipt.Items, rules.ItemCounting)},
+		{Rule: RuleOddDay, Points: calculateOddDayPoints(purchased)},
+		{Rule: RuleAfternoon, Points: calculateTimeRangePoints(purchased)},
+	}
 
 	utils.Logger.WithFields(logrus.Fields{
 		"retailer":     receipt.Retailer,
-		"final_points": totalPoints,
+		"final_points": models.SumPoints(lines),
 	}).Info("Completed points calculation")
 
-	return totalPoints
+	return lines
 }
 
-// Rule 1: One point for every alphanumeric character in the retailer name
+// Rule 1: One point for every alphanumeric character in the retailer name,
+// letters and digits in any script count
 func calculateRetailerNamePoints(name string) int64 {
-	points := int64(0)
-	validChars := ""
-
-	for _, char := range name {
-		if (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
-			points++
-			validChars += string(char)
-		}
-	}
+	points := int64(models.CountAlphanumeric(name))
 
 	utils.Logger.WithFields(logrus.Fields{
 		"rule":             "1",
 		"retailer":         name,
-		"alphanumeric":     validChars,
 		"count":            points,
 		"points_from_rule": points,
 	}).Debug("Retailer name points rule")
@@ -85,6 +126,18 @@ func calculateQuarterMultiplePoints(total string) int64 {
 	return points
 }
 
+// countItems is the number of items rule 4 pairs up
+func countItems(items []models.Item, counting ItemCounting) int {
+	if counting != CountUnits {
+		return len(items)
+	}
+	count := 0
+	for _, item := range items {
+		count += int(item.Units())
+	}
+	return count
+}
+
 // Rule 4: 5 points for every two items on the receipt
 func calculateItemPairPoints(itemCount int) int64 {
 	pairs := itemCount / 2
@@ -95,18 +148,24 @@ func calculateItemPairPoints(itemCount int) int64 {
 }
 
 // Rule 5: If the trimmed length of the item description is a multiple of 3,
-// multiply the price by 0.2 and round up to the nearest integer
-func calculateDescriptionLengthPoints(items []models.Item) int64 {
+// multiply the price by 0.2 and round up to the nearest integer. Length is
+// counted in characters, not bytes. When counting units, each unit is
+// scored at its unit price.
+func calculateDescriptionLengthPoints(items []models.Item, counting ItemCounting) int64 {
 	var totalPoints int64 = 0
 
 	for i, item := range items {
-		trimmedDesc := strings.TrimSpace(item.ShortDescription)
-		trimmedLen := len(trimmedDesc)
+		trimmedLen := models.TextLength(item.ShortDescription)
 		itemPoints := int64(0)
 
 		if trimmedLen > 0 && trimmedLen%3 == 0 {
 			price, _ := strconv.ParseFloat(item.Price, 64)
-			itemPoints = int64(math.Ceil(price * 0.2))
+			units := int64(1)
+			if counting == CountUnits && item.Units() > 1 {
+				units = item.Units()
+				price = unitPrice(item, price, units)
+			}
+			itemPoints = int64(math.Ceil(price*0.2)) * units
 			totalPoints += itemPoints
 
 		} else {
@@ -119,10 +178,18 @@ func calculateDescriptionLengthPoints(items []models.Item) int64 {
 	return totalPoints
 }
 
-// Rule 6: 6 points if the day in the purchase date is odd
-func calculateOddDayPoints(purchaseDate string) int64 {
-	date, _ := time.Parse("2006-01-02", purchaseDate)
-	day := date.Day()
+// unitPrice is the sent unit price, or the line price split evenly
+func unitPrice(item models.Item, linePrice float64, units int64) float64 {
+	if item.UnitPrice != "" {
+		price, _ := strconv.ParseFloat(item.UnitPrice, 64)
+		return price
+	}
+	return linePrice / float64(units)
+}
+
+// Rule 6: 6 points if the day in the purchase date is odd, in store local time
+func calculateOddDayPoints(purchased time.Time) int64 {
+	day := purchased.Day()
 	isOddDay := day%2 == 1
 	points := int64(0)
 
@@ -133,11 +200,11 @@ func calculateOddDayPoints(purchaseDate string) int64 {
 	return points
 }
 
-// Rule 7: 10 points if the time of purchase is after 2:00pm and before 4:00pm
-func calculateTimeRangePoints(purchaseTime string) int64 {
-	time, _ := time.Parse("15:04", purchaseTime)
-	hour := time.Hour()
-	minute := time.Minute()
+// Rule 7: 10 points if the time of purchase is after 2:00pm and before 4:00pm,
+// in store local time
+func calculateTimeRangePoints(purchased time.Time) int64 {
+	hour := purchased.Hour()
+	minute := purchased.Minute()
 
 	inTimeRange := (hour == 14 && minute > 0) || (hour == 15)
 	points := int64(0)
@@ -374,6 +374,209 @@ func TestCalculatePoints(t *testing.T) {
 			},
 			expected: 1,
 		},
+		{
+			name: "accented retailer name",
+			receipt: models.Receipt{
+				Retailer:     "Café Nero",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 89,
+		},
+		{
+			name: "decomposed accent counts once",
+			receipt: models.Receipt{
+				Retailer:     "Cafe\u0301 Nero",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 89,
+		},
+		{
+			name: "apostrophe in retailer name",
+			receipt: models.Receipt{
+				Retailer:     "Trader Joe's",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 91,
+		},
+		{
+			name: "store number",
+			receipt: models.Receipt{
+				Retailer:     "7-Eleven #42",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 90,
+		},
+		{
+			name: "japanese retailer name",
+			receipt: models.Receipt{
+				Retailer:     "東京ストア",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 86,
+		},
+		{
+			name: "description length in characters",
+			receipt: models.Receipt{
+				Retailer:     "Shop",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Pão", Price: "5.00"}},
+				Total:        "5.00",
+			},
+			expected: 86,
+		},
+		{
+			name: "decomposed description length",
+			receipt: models.Receipt{
+				Retailer:     "Shop",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Pa\u0303o", Price: "5.00"}},
+				Total:        "5.00",
+			},
+			expected: 86,
+		},
+		{
+			name: "cyrillic description",
+			receipt: models.Receipt{
+				Retailer:     "Shop",
+				PurchaseDate: defaultTestDate,
+				PurchaseTime: defaultTestTime,
+				Items:        []models.Item{{ShortDescription: "Хлеб", Price: "5.00"}},
+				Total:        "5.00",
+			},
+			expected: 85,
+		},
+		{
+			name: "UTC timestamp read in store zone",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2022-01-02",
+				PurchaseTime:  "20:30",
+				TimeZone:      "America/Chicago",
+				TimestampZone: "UTC",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 89,
+		},
+		{
+			name: "UTC timestamp lands on previous local day",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2022-01-02",
+				PurchaseTime:  "03:00",
+				TimeZone:      "America/Los_Angeles",
+				TimestampZone: "UTC",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 85,
+		},
+		{
+			name: "fixed UTC offset",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2022-01-02",
+				PurchaseTime:  "09:00",
+				TimeZone:      "+05:30",
+				TimestampZone: "Z",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 89,
+		},
+		{
+			name: "day DST starts, after the switch",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2024-03-10",
+				PurchaseTime:  "18:30",
+				TimeZone:      "America/New_York",
+				TimestampZone: "UTC",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 89,
+		},
+		{
+			name: "day before DST starts",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2024-03-09",
+				PurchaseTime:  "18:30",
+				TimeZone:      "America/New_York",
+				TimestampZone: "UTC",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 85,
+		},
+		{
+			name: "day before DST ends",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2024-11-02",
+				PurchaseTime:  "18:30",
+				TimeZone:      "America/New_York",
+				TimestampZone: "UTC",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 89,
+		},
+		{
+			name: "day DST ends, after the switch",
+			receipt: models.Receipt{
+				Retailer:      "Shop",
+				PurchaseDate:  "2024-11-03",
+				PurchaseTime:  "18:30",
+				TimeZone:      "America/New_York",
+				TimestampZone: "UTC",
+				Items:         []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:         "1.00",
+			},
+			expected: 85,
+		},
+		{
+			name: "local time just after spring forward",
+			receipt: models.Receipt{
+				Retailer:     "Shop",
+				PurchaseDate: "2024-03-10",
+				PurchaseTime: "03:30",
+				TimeZone:     "America/New_York",
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 79,
+		},
+		{
+			name: "repeated hour when clocks fall back",
+			receipt: models.Receipt{
+				Retailer:     "Shop",
+				PurchaseDate: "2024-11-03",
+				PurchaseTime: "01:30",
+				TimeZone:     "America/New_York",
+				Items:        []models.Item{{ShortDescription: "Item", Price: "1.00"}},
+				Total:        "1.00",
+			},
+			expected: 85,
+		},
 	}
 
 	for _, tc := range tests {
@@ -385,3 +588,72 @@ func TestCalculatePoints(t *testing.T) {
 		})
 	}
 }
+
+func TestItemCounting(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []models.Item
+		// item_pairs and description_length points for each mode
+		lines [2]int64
+		units [2]int64
+	}{
+		{
+			name:  "pack of four",
+			items: []models.Item{{ShortDescription: "Gatorade", Price: "9.00", Quantity: "4", UnitPrice: "2.25"}},
+			lines: [2]int64{0, 0},
+			units: [2]int64{10, 0},
+		},
+		{
+			name:  "scored at the unit price",
+			items: []models.Item{{ShortDescription: "Emils Cheese Pizza", Price: "12.00", Quantity: "2", UnitPrice: "6.00"}},
+			lines: [2]int64{0, 3},
+			units: [2]int64{5, 4},
+		},
+		{
+			name:  "price split without a unit price",
+			items: []models.Item{{ShortDescription: "Emils Cheese Pizza", Price: "12.00", Quantity: "2"}},
+			lines: [2]int64{0, 3},
+			units: [2]int64{5, 4},
+		},
+		{
+			name: "sold by weight",
+			items: []models.Item{
+				{ShortDescription: "Apples", Price: "3.00", Quantity: "1.5", UnitPrice: "2.00"},
+				{ShortDescription: "Pears", Price: "1.00"},
+			},
+			lines: [2]int64{5, 1},
+			units: [2]int64{5, 1},
+		},
+		{
+			name: "no quantities",
+			items: []models.Item{
+				{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+				{ShortDescription: "Pepsi", Price: "1.25"},
+			},
+			lines: [2]int64{5, 3},
+			units: [2]int64{5, 3},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := models.Receipt{
+				Retailer:     "Shop",
+				PurchaseDate: "2022-01-02",
+				PurchaseTime: "10:00",
+				Items:        tc.items,
+				Total:        "1.01",
+			}
+			for counting, expected := range map[ItemCounting][2]int64{CountLines: tc.lines, CountUnits: tc.units} {
+				lines := CalculateBreakdownWith(&receipt, PointsRules{ItemCounting: counting})
+				got := [2]int64{lines[3].Points, lines[4].Points}
+				if lines[3].Rule != RuleItemPairs || lines[4].Rule != RuleDescriptionLength {
+					t.Fatalf("Unexpected rule order %+v", lines)
+				}
+				if got != expected {
+					t.Errorf("Counting %s: expected pairs and description points %v, got %v", counting, expected, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var ErrPromotionExists = errors.New("Promotion already exists")
+
+// PromotionRulePrefix marks promotion lines in a points breakdown, followed
+// by the promotion ID
+const PromotionRulePrefix = "promotion:"
+
+// PromotionService manages promotions and applies them after the base rules
+type PromotionService struct {
+	storage repository.PromotionStorage
+	// serializes the existence checks of create and update
+	mutex *sync.Mutex
+}
+
+func NewPromotionService(storage repository.PromotionStorage) *PromotionService {
+	return &PromotionService{
+		storage: storage,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// CreatePromotion adds a promotion, failing with ErrPromotionExists if the
+// ID is taken
+func (s *PromotionService) CreatePromotion(promotion models.Promotion) error {
+	if err := promotion.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.storage.GetPromotion(promotion.ID); found {
+		return ErrPromotionExists
+	}
+	return s.save(promotion, "Promotion created")
+}
+
+// UpdatePromotion replaces an existing promotion. Receipts already scored
+// keep their points.
+func (s *PromotionService) UpdatePromotion(promotion models.Promotion) error {
+	if err := promotion.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.storage.GetPromotion(promotion.ID); !found {
+		return repository.ErrPromotionNotFound
+	}
+	return s.save(promotion, "Promotion updated")
+}
+
+func (s *PromotionService) save(promotion models.Promotion, message string) error {
+	if err := s.storage.SavePromotion(promotion); err != nil {
+		return err
+	}
+
+	utils.Logger.WithFields(logrus.Fields{
+		"promotion": promotion.ID,
+		"starts":    promotion.StartsAt,
+		"ends":      promotion.EndsAt,
+	}).Info(message)
+	return nil
+}
+
+func (s *PromotionService) DeletePromotion(id string) error {
+	if err := s.storage.DeletePromotion(id); err != nil {
+		return err
+	}
+	utils.Logger.WithField("promotion", id).Info("Promotion deleted")
+	return nil
+}
+
+func (s *PromotionService) GetPromotion(id string) (models.Promotion, bool) {
+	return s.storage.GetPromotion(id)
+}
+
+// ListPromotions returns every promotion ordered by ID
+func (s *PromotionService) ListPromotions() ([]models.Promotion, error) {
+	return s.storage.ListPromotions()
+}
+
+type promotionAward struct {
+	promotion models.Promotion
+	points    int64
+}
+
+// Apply returns the promotion lines for a receipt whose base rules scored
+// base. retailerID is the directory retailer the receipt matched, if any.
+// See models.Promotion for how promotions stack.
+func (s *PromotionService) Apply(receipt *models.Receipt, retailerID string, base []models.PointsLine) []models.PointsLine {
+	purchased, err := receipt.PurchaseTimestamp()
+	if err != nil {
+		return nil
+	}
+	promotions, err := s.storage.ListPromotions()
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to load promotions, scoring without them")
+		return nil
+	}
+	basePoints := models.SumPoints(base)
+
+	// ties go to the lowest ID, promotions are listed in ID order
+	var exclusive, multiplier *promotionAward
+	var bonuses []promotionAward
+	for _, promotion := range promotions {
+		points, matched := evaluatePromotion(promotion, receipt, retailerID, purchased, basePoints)
+		if !matched {
+			continue
+		}
+		award := promotionAward{promotion: promotion, points: points}
+		switch {
+		case promotion.Exclusive:
+			if exclusive == nil || points > exclusive.points {
+				exclusive = &award
+			}
+		case promotion.Multiplier != 0:
+			if multiplier == nil || points > multiplier.points {
+				multiplier = &award
+			}
+		default:
+			bonuses = append(bonuses, award)
+		}
+	}
+
+	var awards []promotionAward
+	if exclusive != nil {
+		awards = append(awards, *exclusive)
+	} else {
+		if multiplier != nil {
+			awards = append(awards, *multiplier)
+		}
+		awards = append(awards, bonuses...)
+	}
+
+	lines := make([]models.PointsLine, 0, len(awards))
+	for _, award := range awards {
+		lines = append(lines, models.PointsLine{Rule: PromotionRulePrefix + award.promotion.ID, Points: award.points})
+	}
+	if len(lines) > 0 {
+		utils.Logger.WithFields(logrus.Fields{
+			"retailer":   retailerID,
+			"promotions": len(lines),
+			"points":     models.SumPoints(lines),
+		}).Info("Promotions applied to receipt")
+	}
+	return lines
+}
+
+// evaluatePromotion reports whether a promotion matches the receipt and the
+// points it is worth
+func evaluatePromotion(promotion models.Promotion, receipt *models.Receipt, retailerID string, purchased time.Time, basePoints int64) (int64, bool) {
+	if !promotion.Active(purchased) {
+		return 0, false
+	}
+	if promotion.RetailerID != "" && promotion.RetailerID != retailerID {
+		return 0, false
+	}
+	if promotion.PaymentMethod != "" && (receipt.Payment == nil || receipt.Payment.Method != promotion.PaymentMethod) {
+		return 0, false
+	}
+
+	matchingItems := len(receipt.Items)
+	if promotion.ItemPattern != "" {
+		// validated when saved
+		regex, err := regexp.Compile(promotion.ItemPattern)
+		if err != nil {
+			return 0, false
+		}
+		matchingItems = 0
+		for _, item := range receipt.Items {
+			if regex.MatchString(strings.TrimSpace(models.NormalizeText(item.ShortDescription))) {
+				matchingItems++
+			}
+		}
+		if matchingItems == 0 {
+			return 0, false
+		}
+	}
+
+	switch {
+	case promotion.Multiplier != 0:
+		// the line holds the extra points, the base rules keep their own
+		return int64(math.Round(float64(basePoints) * (promotion.Multiplier - 1))), true
+	case promotion.PerItem:
+		return promotion.Bonus * int64(matchingItems), true
+	}
+	return promotion.Bonus, true
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+func TestPromotionApply(t *testing.T) {
+	weekendStart := time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC)
+	weekendEnd := time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)
+
+	// Saturday morning, 36 base points
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-06-04",
+		PurchaseTime: "10:00",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total:   "4.50",
+		Payment: &models.Payment{Method: models.PaymentStoreCard, Last4: "4242"},
+	}
+
+	double := models.Promotion{ID: "double", Name: "Double points at Target", RetailerID: "target",
+		StartsAt: weekendStart, EndsAt: weekendEnd, Multiplier: 2}
+	triple := models.Promotion{ID: "triple", Name: "Triple points everywhere",
+		StartsAt: weekendStart, EndsAt: weekendEnd, Multiplier: 3}
+	perItem := models.Promotion{ID: "gatorade", Name: "10 per Gatorade", ItemPattern: "(?i)gatorade",
+		StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 10, PerItem: true}
+	flat := models.Promotion{ID: "welcome", Name: "Welcome bonus",
+		StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 5}
+	exclusive := models.Promotion{ID: "launch", Name: "Launch day", Exclusive: true,
+		StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 50}
+	otherStore := models.Promotion{ID: "mm-gatorade", Name: "+100 for Gatorade at M&M", RetailerID: "mm-corner-market",
+		ItemPattern: "(?i)gatorade", StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 100}
+	lastWeek := models.Promotion{ID: "last-week", Name: "Last week",
+		StartsAt: weekendStart.AddDate(0, 0, -7), EndsAt: weekendStart, Bonus: 100}
+	storeCard := models.Promotion{ID: "store-card", Name: "Store card bonus", PaymentMethod: models.PaymentStoreCard,
+		StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 25}
+	cash := models.Promotion{ID: "cash", Name: "Cash bonus", PaymentMethod: models.PaymentCash,
+		StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 25}
+	pizza := models.Promotion{ID: "pizza", Name: "Pizza bonus", ItemPattern: "(?i)pizza",
+		StartsAt: weekendStart, EndsAt: weekendEnd, Bonus: 100}
+
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		expected   []models.PointsLine
+	}{
+		{
+			name:       "multiplier",
+			promotions: []models.Promotion{double},
+			expected:   []models.PointsLine{{Rule: "promotion:double", Points: 36}},
+		},
+		{
+			name:       "largest multiplier wins",
+			promotions: []models.Promotion{double, triple},
+			expected:   []models.PointsLine{{Rule: "promotion:triple", Points: 72}},
+		},
+		{
+			name:       "bonuses stack with a multiplier",
+			promotions: []models.Promotion{double, perItem, flat},
+			expected: []models.PointsLine{
+				{Rule: "promotion:double", Points: 36},
+				{Rule: "promotion:gatorade", Points: 20},
+				{Rule: "promotion:welcome", Points: 5},
+			},
+		},
+		{
+			name:       "exclusive applies alone",
+			promotions: []models.Promotion{double, perItem, exclusive},
+			expected:   []models.PointsLine{{Rule: "promotion:launch", Points: 50}},
+		},
+		{
+			name:       "other retailer",
+			promotions: []models.Promotion{otherStore},
+		},
+		{
+			name:       "outside the window",
+			promotions: []models.Promotion{lastWeek},
+		},
+		{
+			name:       "payment method",
+			promotions: []models.Promotion{storeCard, cash},
+			expected:   []models.PointsLine{{Rule: "promotion:store-card", Points: 25}},
+		},
+		{
+			name:       "no matching item",
+			promotions: []models.Promotion{pizza},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			promotions := NewPromotionService(repository.NewInMemoryPromotionStorage())
+			for _, promotion := range tc.promotions {
+				if err := promotions.CreatePromotion(promotion); err != nil {
+					t.Fatalf("Failed to create promotion %s: %v", promotion.ID, err)
+				}
+			}
+
+			base := CalculateBreakdown(&receipt)
+			if total := models.SumPoints(base); total != 36 {
+				t.Fatalf("Expected 36 base points, got %d", total)
+			}
+			lines := promotions.Apply(&receipt, "target", base)
+			if len(lines) == 0 && len(tc.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, lines)
+			}
+		})
+	}
+}
+
+func TestPromotionValidation(t *testing.T) {
+	start := time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC)
+	valid := models.Promotion{ID: "double", Name: "Double", StartsAt: start, EndsAt: start.Add(48 * time.Hour), Multiplier: 2}
+
+	tests := []struct {
+		name   string
+		modify func(*models.Promotion)
+	}{
+		{name: "bad id", modify: func(p *models.Promotion) { p.ID = "Double Points" }},
+		{name: "no name", modify: func(p *models.Promotion) { p.Name = "" }},
+		{name: "ends before start", modify: func(p *models.Promotion) { p.EndsAt = start.Add(-time.Hour) }},
+		{name: "multiplier and bonus", modify: func(p *models.Promotion) { p.Bonus = 10 }},
+		{name: "multiplier below one", modify: func(p *models.Promotion) { p.Multiplier = 0.5 }},
+		{name: "no award", modify: func(p *models.Promotion) { p.Multiplier = 0 }},
+		{name: "per item multiplier", modify: func(p *models.Promotion) { p.PerItem = true; p.ItemPattern = "x" }},
+		{name: "bad item pattern", modify: func(p *models.Promotion) { p.ItemPattern = "(" }},
+		{name: "unknown payment method", modify: func(p *models.Promotion) { p.PaymentMethod = "barter" }},
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Valid promotion rejected: %v", err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			promotion := valid
+			tc.modify(&promotion)
+			if err := promotion.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestProcessReceiptAppliesPromotions(t *testing.T) {
+	storage := repository.NewInMemoryStorage()
+	promotions := NewPromotionService(repository.NewInMemoryPromotionStorage())
+	promotions.CreatePromotion(models.Promotion{
+		ID:         "target-double",
+		Name:       "Double points at Target this weekend",
+		RetailerID: "target",
+		StartsAt:   time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC),
+		EndsAt:     time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC),
+		Multiplier: 2,
+	})
+	service := NewReceiptService(storage,
+		WithClock(utils.NewFakeClock(time.Date(2022, 6, 6, 12, 0, 0, 0, time.UTC))),
+		WithRetailers(newTestRetailerService(t)),
+		WithPromotions(promotions),
+	)
+
+	receipt := models.Receipt{
+		Retailer:     "TARGET #1234",
+		PurchaseDate: "2022-06-04",
+		PurchaseTime: "10:00",
+		Items:        []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}},
+		Total:        "2.25",
+	}
+	if err := service.ValidateReceipt(&receipt); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	id, err := service.ProcessReceipt(models.Principal{}, receipt)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	points, _ := service.GetPoints(models.Principal{}, id)
+	base := CalculatePoints(&receipt)
+	if points.Points != 2*base {
+		t.Errorf("Expected double the %d base points, got %d", base, points.Points)
+	}
+	last := points.Breakdown[len(points.Breakdown)-1]
+	if last.Rule != "promotion:target-double" || last.Points != base {
+		t.Errorf("Breakdown should end with the promotion, got %+v", points.Breakdown)
+	}
+}
@@ -1,39 +1,301 @@
 package services
 
 import (
+	"errors"
+
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/parser"
+	"github.com/ycChu711/receipt-processor/receiptcsv"
 	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
 )
 
+var ErrNotPendingReview = errors.New("Receipt is not pending review")
+
 // manages receipt processing and point calculations
 type ReceiptService struct {
-	storage repository.ReceiptStorage
+	storage    repository.ReceiptStorage
+	fraud      *FraudDetector
+	retailers  *RetailerService
+	promotions *PromotionService
+	events     *EventBus
+	clock      utils.Clock
+	validation models.ValidationRules
+	points     PointsRules
+	csv        receiptcsv.Mapping
+}
+
+// ServiceOption customises a ReceiptService
+type ServiceOption func(*ReceiptService)
+
+// WithFraudDetector scores every receipt and holds suspicious ones for review
+func WithFraudDetector(detector *FraudDetector) ServiceOption {
+	return func(s *ReceiptService) {
+		s.fraud = detector
+	}
+}
+
+// WithRetailers tags receipts with the directory retailer their name
+// matches, and uses its time zone for receipts that do not send one
+func WithRetailers(retailers *RetailerService) ServiceOption {
+	return func(s *ReceiptService) {
+		s.retailers = retailers
+	}
+}
+
+// WithPromotions applies running promotions after the base rules
+func WithPromotions(promotions *PromotionService) ServiceOption {
+	return func(s *ReceiptService) {
+		s.promotions = promotions
+	}
+}
+
+// WithEventBus writes a ReceiptEvent to the outbox with every receipt
+// change and wakes the bus to publish it. The bus must read the outbox of
+// the service's storage.
+func WithEventBus(events *EventBus) ServiceOption {
+	return func(s *ReceiptService) {
+		s.events = events
+	}
+}
+
+// WithClock sets the clock used for submission times and date validation
+func WithClock(clock utils.Clock) ServiceOption {
+	return func(s *ReceiptService) {
+		s.clock = clock
+	}
+}
+
+// WithValidationRules replaces the default purchase date bounds. The
+// service's clock is used regardless of rules.Clock.
+func WithValidationRules(rules models.ValidationRules) ServiceOption {
+	return func(s *ReceiptService) {
+		s.validation = rules
+	}
+}
+
+// WithPointsRules changes how the base rules read a receipt, e.g. to count
+// item quantities
+func WithPointsRules(rules PointsRules) ServiceOption {
+	return func(s *ReceiptService) {
+		s.points = rules
+	}
+}
+
+// WithCSVMapping renames the columns of CSV imports and exports
+func WithCSVMapping(mapping receiptcsv.Mapping) ServiceOption {
+	return func(s *ReceiptService) {
+		s.csv = mapping
+	}
 }
 
 // create new service with given storage
-func NewReceiptService(storage repository.ReceiptStorage) *ReceiptService {
-	return &ReceiptService{
-		storage: storage,
+func NewReceiptService(storage repository.ReceiptStorage, opts ...ServiceOption) *ReceiptService {
+	s := &ReceiptService{
+		storage:    storage,
+		clock:      utils.SystemClock{},
+		validation: models.DefaultValidationRules,
+		points:     DefaultPointsRules,
+		csv:        receiptcsv.DefaultMapping(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.validation.Clock = s.clock
+	return s
+}
+
+// ValidateReceipt checks a receipt against the service's validation rules
+// and normalizes its purchase time
+func (s *ReceiptService) ValidateReceipt(receipt *models.Receipt) error {
+	// the zone has to be known before the purchase time is normalized
+	if receipt.TimeZone == "" {
+		if retailer, found := s.matchRetailer(receipt.Retailer); found {
+			receipt.TimeZone = retailer.TimeZone
+		}
+	}
+	return receipt.ValidateWith(s.validation)
 }
 
 // Processes a receipt and returns the ID
-// generate unique id -> calculate points -> save receipt and points -> return id
-func (s *ReceiptService) ProcessReceipt(receipt models.Receipt) (string, error) {
+// generate unique id -> match retailer -> calculate points and promotions -> score fraud -> save receipt and points tagged with the caller, with its event -> return id
+func (s *ReceiptService) ProcessReceipt(caller models.Principal, receipt models.Receipt) (string, error) {
 
 	id := uuid.New().String()
 
-	points := CalculatePoints(&receipt)
+	var retailerID string
+	if retailer, found := s.matchRetailer(receipt.Retailer); found {
+		retailerID = retailer.ID
+	}
 
-	err := s.storage.SaveReceipt(id, receipt, points)
+	breakdown := CalculateBreakdownWith(&receipt, s.points)
+	if s.promotions != nil {
+		breakdown = append(breakdown, s.promotions.Apply(&receipt, retailerID, breakdown)...)
+	}
+
+	record := models.ReceiptWithPoints{
+		Receipt:     receipt,
+		Points:      models.SumPoints(breakdown),
+		Breakdown:   breakdown,
+		ClientID:    caller.ClientID,
+		RetailerID:  retailerID,
+		Status:      models.StatusActive,
+		SubmittedAt: s.clock.Now().UTC(),
+	}
+
+	if s.fraud != nil {
+		record.Fraud = s.fraud.Assess(caller.ClientID, &receipt)
+		if record.Fraud.Held {
+			record.Status = models.StatusPendingReview
+			utils.Logger.WithFields(logrus.Fields{
+				"id":    id,
+				"score": record.Fraud.Score,
+			}).Warn("Receipt held for fraud review")
+		}
+	}
+
+	record.ID = id
+	var events []models.ReceiptEvent
+	if s.events != nil {
+		events = append(events, s.newEvent(models.ReceiptProcessed, record))
+	}
+	err := s.storage.SaveReceipt(id, record, events...)
 	if err != nil {
 		return "", err
 	}
 
+	s.publish()
 	return id, nil
 }
 
-func (s *ReceiptService) GetPoints(id string) (int64, bool) {
-	return s.storage.GetPoints(id)
+// GetPoints returns the points for a receipt the caller is allowed to see.
+// Receipts owned by other clients are reported as not found so ids cannot
+// be probed.
+func (s *ReceiptService) GetPoints(caller models.Principal, id string) (models.PointsResponse, bool) {
+	record, found := s.storage.GetReceipt(id)
+	if !found || !canRead(caller, record) {
+		return models.PointsResponse{}, false
+	}
+
+	response := models.PointsResponse{Points: record.AwardedPoints(), Breakdown: record.Breakdown}
+	if record.Status != models.StatusActive {
+		response.Status = record.Status
+	}
+	return response, true
+}
+
+// GetReceipt returns a stored receipt the caller is allowed to see, with
+// the same visibility as GetPoints
+func (s *ReceiptService) GetReceipt(caller models.Principal, id string) (models.ReceiptDetail, bool) {
+	record, found := s.storage.GetReceipt(id)
+	if !found || !canRead(caller, record) {
+		return models.ReceiptDetail{}, false
+	}
+
+	return models.ReceiptDetail{
+		ID:          id,
+		Receipt:     record.Receipt,
+		Points:      record.AwardedPoints(),
+		Status:      record.CurrentStatus(),
+		RetailerID:  record.RetailerID,
+		SubmittedAt: record.SubmittedAt,
+	}, true
+}
+
+// RemovedReceipt reports why a receipt the caller could read is no longer
+// stored, for storages that drop receipts on their own
+func (s *ReceiptService) RemovedReceipt(caller models.Principal, id string) (repository.Removal, bool) {
+	tracker, ok := s.storage.(repository.RemovalTracker)
+	if !ok {
+		return repository.Removal{}, false
+	}
+	removal, found := tracker.Removed(id)
+	if !found || !canRead(caller, models.ReceiptWithPoints{ClientID: removal.ClientID}) {
+		return repository.Removal{}, false
+	}
+	return removal, true
+}
+
+// ListReceipts returns a page of the receipts the caller can read, oldest
+// submission first. An empty status matches every receipt.
+func (s *ReceiptService) ListReceipts(caller models.Principal, status models.ReceiptStatus, offset, limit int) (models.ReceiptList, error) {
+	filter := readableBy(caller)
+	filter.Status = status
+	total, err := s.storage.CountReceipts(filter)
+	if err != nil {
+		return models.ReceiptList{}, err
+	}
+	filter.Offset, filter.Limit = offset, limit
+	records, err := s.storage.ListReceipts(filter)
+	if err != nil {
+		return models.ReceiptList{}, err
+	}
+
+	list := models.ReceiptList{Receipts: make([]models.ReceiptSummary, 0, len(records)), Total: total}
+	for _, record := range records {
+		list.Receipts = append(list.Receipts, summarize(record))
+	}
+	return list, nil
+}
+
+// readableBy selects the receipts caller can read, which are its own
+// unless it holds the admin scope
+func readableBy(caller models.Principal) repository.ReceiptFilter {
+	if caller.HasScope(models.ScopeAdmin) {
+		return repository.ReceiptFilter{}
+	}
+	return repository.ReceiptFilter{ClientID: caller.ClientID}
+}
+
+// summarize is the short form of a stored receipt used by lists and events
+func summarize(record models.ReceiptWithPoints) models.ReceiptSummary {
+	return models.ReceiptSummary{
+		ID:           record.ID,
+		Retailer:     record.Receipt.Retailer,
+		PurchaseDate: record.Receipt.PurchaseDate,
+		Total:        record.Receipt.Total,
+		Points:       record.AwardedPoints(),
+		Status:       record.CurrentStatus(),
+		RetailerID:   record.RetailerID,
+		SubmittedAt:  record.SubmittedAt,
+	}
+}
+
+// newEvent describes a change to record, which must be the record as saved
+func (s *ReceiptService) newEvent(eventType models.ReceiptEventType, record models.ReceiptWithPoints) models.ReceiptEvent {
+	return models.ReceiptEvent{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		ReceiptID:  record.ID,
+		ClientID:   record.ClientID,
+		OccurredAt: s.clock.Now().UTC(),
+		Receipt:    summarize(record),
+	}
+}
+
+// publish wakes the event bus after events were saved
+func (s *ReceiptService) publish() {
+	if s.events != nil {
+		s.events.Notify()
+	}
+}
+
+// ParseReceipt reads a plain-text receipt into a draft using the service's
+// character policy. The draft is not validated.
+func (s *ReceiptService) ParseReceipt(text string) models.ReceiptDraft {
+	return parser.New(s.validation.Characters).Parse(text)
+}
+
+// clients only see their own receipts unless they hold the admin scope
+func canRead(caller models.Principal, record models.ReceiptWithPoints) bool {
+	return caller.HasScope(models.ScopeAdmin) || record.ClientID == caller.ClientID
+}
+
+func (s *ReceiptService) matchRetailer(name string) (models.Retailer, bool) {
+	if s.retailers == nil {
+		return models.Retailer{}, false
+	}
+	return s.retailers.Match(name)
 }
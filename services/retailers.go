@@ -0,0 +1,173 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var ErrRetailerExists = errors.New("Retailer already exists")
+
+// RetailerService manages the retailer directory and matches the names
+// printed on receipts against it
+type RetailerService struct {
+	storage repository.RetailerStorage
+
+	mutex *sync.RWMutex
+	// matcher is built from storage on first use and dropped on every change
+	matcher *retailerMatcher
+}
+
+type retailerMatcher struct {
+	// RetailerKey of ids, display names and aliases -> retailer
+	names    map[string]models.Retailer
+	patterns []retailerPattern
+}
+
+type retailerPattern struct {
+	regex    *regexp.Regexp
+	retailer models.Retailer
+}
+
+func NewRetailerService(storage repository.RetailerStorage) *RetailerService {
+	return &RetailerService{
+		storage: storage,
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+// CreateRetailer adds a retailer, failing with ErrRetailerExists if the ID
+// is taken
+func (s *RetailerService) CreateRetailer(retailer models.Retailer) error {
+	if err := retailer.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.storage.GetRetailer(retailer.ID); found {
+		return ErrRetailerExists
+	}
+	return s.save(retailer, "Retailer created")
+}
+
+// UpdateRetailer replaces an existing retailer
+func (s *RetailerService) UpdateRetailer(retailer models.Retailer) error {
+	if err := retailer.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.storage.GetRetailer(retailer.ID); !found {
+		return repository.ErrRetailerNotFound
+	}
+	return s.save(retailer, "Retailer updated")
+}
+
+func (s *RetailerService) save(retailer models.Retailer, message string) error {
+	if err := s.storage.SaveRetailer(retailer); err != nil {
+		return err
+	}
+	s.matcher = nil
+
+	utils.Logger.WithFields(logrus.Fields{
+		"retailer": retailer.ID,
+		"aliases":  len(retailer.Aliases),
+		"patterns": len(retailer.Patterns),
+	}).Info(message)
+	return nil
+}
+
+// DeleteRetailer removes a retailer. Receipts already tagged with it keep
+// the ID.
+func (s *RetailerService) DeleteRetailer(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.storage.DeleteRetailer(id); err != nil {
+		return err
+	}
+	s.matcher = nil
+
+	utils.Logger.WithField("retailer", id).Info("Retailer deleted")
+	return nil
+}
+
+func (s *RetailerService) GetRetailer(id string) (models.Retailer, bool) {
+	return s.storage.GetRetailer(id)
+}
+
+// ListRetailers returns the directory ordered by ID
+func (s *RetailerService) ListRetailers() ([]models.Retailer, error) {
+	return s.storage.ListRetailers()
+}
+
+// Match finds the retailer for a name printed on a receipt. Exact matches
+// on the ID, display name or an alias win over patterns; when several
+// retailers claim the same name the lowest ID wins.
+func (s *RetailerService) Match(name string) (models.Retailer, bool) {
+	matcher, err := s.currentMatcher()
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to load retailer directory")
+		return models.Retailer{}, false
+	}
+
+	if retailer, found := matcher.names[models.RetailerKey(name)]; found {
+		return retailer, true
+	}
+	normalized := models.NormalizeText(name)
+	for _, pattern := range matcher.patterns {
+		if pattern.regex.MatchString(normalized) {
+			return pattern.retailer, true
+		}
+	}
+	return models.Retailer{}, false
+}
+
+func (s *RetailerService) currentMatcher() (*retailerMatcher, error) {
+	s.mutex.RLock()
+	matcher := s.matcher
+	s.mutex.RUnlock()
+	if matcher != nil {
+		return matcher, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.matcher != nil {
+		return s.matcher, nil
+	}
+
+	retailers, err := s.storage.ListRetailers()
+	if err != nil {
+		return nil, err
+	}
+	matcher = &retailerMatcher{names: map[string]models.Retailer{}}
+	for _, retailer := range retailers {
+		names := append([]string{retailer.ID, retailer.DisplayName}, retailer.Aliases...)
+		for _, name := range names {
+			key := models.RetailerKey(name)
+			if _, taken := matcher.names[key]; !taken && key != "" {
+				matcher.names[key] = retailer
+			}
+		}
+		for _, pattern := range retailer.Patterns {
+			// validated when saved
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				continue
+			}
+			matcher.patterns = append(matcher.patterns, retailerPattern{regex: regex, retailer: retailer})
+		}
+	}
+	s.matcher = matcher
+	return matcher, nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+func newTestRetailerService(t *testing.T) *RetailerService {
+	retailers := NewRetailerService(repository.NewInMemoryRetailerStorage())
+	for _, retailer := range []models.Retailer{
+		{
+			ID:          "target",
+			DisplayName: "Target",
+			Aliases:     []string{"Target.com"},
+			Patterns:    []string{`(?i)^target\b`},
+			Category:    "general merchandise",
+			TimeZone:    "America/Chicago",
+		},
+		{
+			ID:          "trader-joes",
+			DisplayName: "Trader Joe's",
+			Patterns:    []string{`(?i)^trader\s+joe`},
+		},
+		{
+			ID:          "mm-corner-market",
+			DisplayName: "M&M Corner Market",
+		},
+	} {
+		if err := retailers.CreateRetailer(retailer); err != nil {
+			t.Fatalf("Failed to create retailer %s: %v", retailer.ID, err)
+		}
+	}
+	return retailers
+}
+
+func TestRetailerMatch(t *testing.T) {
+	retailers := newTestRetailerService(t)
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "Target", expected: "target"},
+		{name: "TARGET", expected: "target"},
+		{name: "  target  ", expected: "target"},
+		{name: "Target Store #1234", expected: "target"},
+		{name: "target.com", expected: "target"},
+		{name: "Trader Joe's", expected: "trader-joes"},
+		{name: "TRADER JOES #552", expected: "trader-joes"},
+		{name: "M&M Corner Market", expected: "mm-corner-market"},
+		{name: "m & m corner-market", expected: "mm-corner-market"},
+		{name: "Targeted Ads", expected: ""},
+		{name: "Walgreens", expected: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			retailer, found := retailers.Match(tc.name)
+			if tc.expected == "" {
+				if found {
+					t.Fatalf("Expected no match, got %s", retailer.ID)
+				}
+				return
+			}
+			if !found || retailer.ID != tc.expected {
+				t.Fatalf("Expected %s, got %q (found %v)", tc.expected, retailer.ID, found)
+			}
+		})
+	}
+}
+
+func TestRetailerDirectoryChanges(t *testing.T) {
+	retailers := newTestRetailerService(t)
+
+	t.Run("duplicate id", func(t *testing.T) {
+		err := retailers.CreateRetailer(models.Retailer{ID: "target", DisplayName: "Target"})
+		if err != ErrRetailerExists {
+			t.Fatalf("Expected ErrRetailerExists, got %v", err)
+		}
+	})
+
+	t.Run("invalid entry", func(t *testing.T) {
+		if err := retailers.CreateRetailer(models.Retailer{ID: "Bad ID", DisplayName: "Bad"}); err == nil {
+			t.Error("Expected error for invalid id")
+		}
+		if err := retailers.CreateRetailer(models.Retailer{ID: "bad", DisplayName: "Bad", Patterns: []string{"("}}); err == nil {
+			t.Error("Expected error for invalid pattern")
+		}
+	})
+
+	t.Run("update changes matching", func(t *testing.T) {
+		if _, found := retailers.Match("Walgreens #12"); found {
+			t.Fatal("Should not match before the update")
+		}
+		err := retailers.UpdateRetailer(models.Retailer{
+			ID:          "trader-joes",
+			DisplayName: "Trader Joe's",
+			Patterns:    []string{`(?i)^walgreens`},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if retailer, found := retailers.Match("Walgreens #12"); !found || retailer.ID != "trader-joes" {
+			t.Errorf("Expected the updated pattern to match, got %q", retailer.ID)
+		}
+	})
+
+	t.Run("update unknown", func(t *testing.T) {
+		err := retailers.UpdateRetailer(models.Retailer{ID: "costco", DisplayName: "Costco"})
+		if err != repository.ErrRetailerNotFound {
+			t.Fatalf("Expected ErrRetailerNotFound, got %v", err)
+		}
+	})
+
+	t.Run("delete stops matching", func(t *testing.T) {
+		if err := retailers.DeleteRetailer("mm-corner-market"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, found := retailers.Match("M&M Corner Market"); found {
+			t.Error("Deleted retailer still matches")
+		}
+	})
+}
+
+func TestProcessReceiptTagsRetailer(t *testing.T) {
+	storage := repository.NewInMemoryStorage()
+	service := NewReceiptService(storage,
+		WithClock(utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))),
+		WithRetailers(newTestRetailerService(t)),
+	)
+
+	receipt := models.Receipt{
+		Retailer:     "TARGET Store #1234",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "14:30",
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+		Total:        "1.25",
+	}
+	if err := service.ValidateReceipt(&receipt); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if receipt.TimeZone != "America/Chicago" {
+		t.Errorf("Expected the retailer's time zone, got %q", receipt.TimeZone)
+	}
+
+	id, err := service.ProcessReceipt(models.Principal{}, receipt)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	record, _ := storage.GetReceipt(id)
+	if record.RetailerID != "target" {
+		t.Errorf("Expected receipt tagged with target, got %q", record.RetailerID)
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+// PendingReviews lists a page of the receipts held by the fraud checks,
+// oldest first
+func (s *ReceiptService) PendingReviews(offset, limit int) ([]models.ReviewItem, error) {
+	records, err := s.storage.ListReceipts(repository.ReceiptFilter{Status: models.StatusPendingReview, Offset: offset, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.ReviewItem, 0, len(records))
+	for _, record := range records {
+		items = append(items, models.ReviewItem{
+			ID:          record.ID,
+			ClientID:    record.ClientID,
+			RetailerID:  record.RetailerID,
+			Receipt:     record.Receipt,
+			Points:      record.Points,
+			Fraud:       record.Fraud,
+			SubmittedAt: record.SubmittedAt,
+		})
+	}
+	return items, nil
+}
+
+// ApproveReceipt releases the held points of a receipt
+func (s *ReceiptService) ApproveReceipt(id string) error {
+	return s.resolveReview(id, models.StatusActive)
+}
+
+// RejectReceipt withholds the points of a receipt for good
+func (s *ReceiptService) RejectReceipt(id string) error {
+	return s.resolveReview(id, models.StatusRejected)
+}
+
+// resolveReview sets the final status of a held receipt. Approval is a
+// PointsAdjusted event from no points to the full award, rejection voids
+// the receipt.
+func (s *ReceiptService) resolveReview(id string, status models.ReceiptStatus) error {
+	err := s.storage.UpdateReceipt(id, func(record *models.ReceiptWithPoints) ([]models.ReceiptEvent, error) {
+		if record.Status != models.StatusPendingReview {
+			return nil, ErrNotPendingReview
+		}
+		previous := record.AwardedPoints()
+		record.Status = status
+		if s.events == nil {
+			return nil, nil
+		}
+
+		record.ID = id
+		event := s.newEvent(models.ReceiptVoided, *record)
+		event.Reason = models.ReasonReviewRejected
+		if status == models.StatusActive {
+			event.Type = models.PointsAdjusted
+			event.PreviousPoints = previous
+			event.Reason = models.ReasonReviewApproved
+		}
+		return []models.ReceiptEvent{event}, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.publish()
+
+	utils.Logger.WithFields(logrus.Fields{
+		"id":     id,
+		"status": status,
+	}).Info("Fraud review resolved")
+	return nil
+}
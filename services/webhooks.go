@@ -0,0 +1,575 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+var (
+	ErrWebhookExists   = errors.New("Webhook already exists")
+	ErrDeliveryNotDead = errors.New("Only dead webhook deliveries can be retried")
+	// ErrPrivateWebhookTarget is a delivery whose host resolved to an
+	// address PublicAddress refuses
+	ErrPrivateWebhookTarget = errors.New("Webhook host resolves to a private address")
+)
+
+// headers sent with every webhook delivery
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// maxWebhookResponseBytes bounds how much of a receiver's answer is read
+const maxWebhookResponseBytes = 64 << 10
+
+// WebhookConfig controls how webhook events are delivered
+type WebhookConfig struct {
+	// Workers is the number of deliveries sent at the same time
+	Workers int
+	// MaxAttempts is how often a delivery is tried before it is dead
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled after each
+	// failed attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds one attempt
+	Timeout time.Duration
+	// QueueSize is how many deliveries may wait for a worker. Deliveries
+	// that do not fit are retried after Backoff.
+	QueueSize int
+	// AllowPrivateTargets lets subscriptions and deliveries reach
+	// loopback, private and link-local addresses, for receivers on the
+	// service's own network
+	AllowPrivateTargets bool
+	// Retention is how long delivered and dead deliveries are kept
+	Retention time.Duration
+}
+
+// DefaultWebhookConfig retries for about 20 minutes before giving up
+var DefaultWebhookConfig = WebhookConfig{
+	Workers:     4,
+	MaxAttempts: 8,
+	Backoff:     10 * time.Second,
+	MaxBackoff:  time.Hour,
+	Timeout:     10 * time.Second,
+	QueueSize:   1000,
+	Retention:   7 * 24 * time.Hour,
+}
+
+// webhookSweepInterval is how often the workers drop deliveries past their
+// retention
+const webhookSweepInterval = time.Minute
+
+// resumeBatchSize pending deliveries are read at a time by Start
+const resumeBatchSize = 500
+
+// WebhookService manages webhook subscriptions and delivers receipt events
+// to them in the background. Call Start to begin delivering and Stop to
+// wait for deliveries in flight; deliveries still waiting stay pending.
+type WebhookService struct {
+	storage repository.WebhookStorage
+	config  WebhookConfig
+	clock   utils.Clock
+	http    *http.Client
+	queue   chan string
+	done    chan struct{}
+	workers *sync.WaitGroup
+	// serializes the existence checks of create and update, and the
+	// status check of retries
+	mutex *sync.Mutex
+	stop  *sync.Once
+	// sweeping guards lastSweep, so one worker at a time drops old
+	// deliveries
+	sweeping  sync.Mutex
+	lastSweep time.Time
+}
+
+// NewWebhookService delivers with config, zero fields take the defaults
+func NewWebhookService(storage repository.WebhookStorage, config WebhookConfig, clock utils.Clock) *WebhookService {
+	defaults := DefaultWebhookConfig
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaults.Backoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaults.MaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaults.Retention
+	}
+
+	return &WebhookService{
+		storage: storage,
+		config:  config,
+		clock:   clock,
+		http:    newWebhookClient(config),
+		queue:   make(chan string, config.QueueSize),
+		done:    make(chan struct{}),
+		workers: &sync.WaitGroup{},
+		mutex:   &sync.Mutex{},
+		stop:    &sync.Once{},
+	}
+}
+
+// newWebhookClient does not follow redirects, which could lead a delivery
+// anywhere, and unless private targets are allowed refuses to connect to
+// addresses PublicAddress rejects. The check runs on the address dialed,
+// after DNS, so a public name cannot resolve to a private host.
+func newWebhookClient(config WebhookConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivateTargets {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !models.PublicAddress(ip) {
+				return ErrPrivateWebhookTarget
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the receiver
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// ValidateSubscription checks a subscription, leaving out where its URL
+// points when private targets are allowed
+func (s *WebhookService) ValidateSubscription(subscription models.WebhookSubscription) error {
+	if s.config.AllowPrivateTargets {
+		return subscription.ValidateFields()
+	}
+	return subscription.Validate()
+}
+
+// CreateSubscription adds a subscription, failing with ErrWebhookExists if
+// the ID is taken
+func (s *WebhookService) CreateSubscription(subscription models.WebhookSubscription) error {
+	if err := s.ValidateSubscription(subscription); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.storage.GetSubscription(subscription.ID); found {
+		return ErrWebhookExists
+	}
+	return s.saveSubscription(subscription, "Webhook created")
+}
+
+// UpdateSubscription replaces an existing subscription. Deliveries already
+// queued go to the new URL.
+func (s *WebhookService) UpdateSubscription(subscription models.WebhookSubscription) error {
+	if err := s.ValidateSubscription(subscription); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.storage.GetSubscription(subscription.ID); !found {
+		return repository.ErrWebhookNotFound
+	}
+	return s.saveSubscription(subscription, "Webhook updated")
+}
+
+func (s *WebhookService) saveSubscription(subscription models.WebhookSubscription, message string) error {
+	if err := s.storage.SaveSubscription(subscription); err != nil {
+		return err
+	}
+
+	utils.Logger.WithFields(logrus.Fields{
+		"webhook": subscription.ID,
+		"url":     subscription.URL,
+		"events":  subscription.Events,
+	}).Info(message)
+	return nil
+}
+
+func (s *WebhookService) GetSubscription(id string) (models.WebhookSubscription, bool) {
+	return s.storage.GetSubscription(id)
+}
+
+func (s *WebhookService) ListSubscriptions() ([]models.WebhookSubscription, error) {
+	return s.storage.ListSubscriptions()
+}
+
+// DeleteSubscription removes a subscription. Its pending deliveries are
+// dropped as dead when their turn comes.
+func (s *WebhookService) DeleteSubscription(id string) error {
+	if err := s.storage.DeleteSubscription(id); err != nil {
+		return err
+	}
+	utils.Logger.WithField("webhook", id).Info("Webhook deleted")
+	return nil
+}
+
+// Deliveries returns up to limit deliveries of a subscription, oldest
+// first, starting after the delivery with ID after unless it is empty. A
+// zero limit returns the rest.
+func (s *WebhookService) Deliveries(subscriptionID, after string, limit int) ([]models.WebhookDelivery, error) {
+	if _, found := s.storage.GetSubscription(subscriptionID); !found {
+		return nil, repository.ErrWebhookNotFound
+	}
+	return s.listDeliveries(repository.DeliveryFilter{SubscriptionID: subscriptionID, Limit: limit}, after)
+}
+
+// DeadLetters returns up to limit deliveries that failed every attempt,
+// oldest first, paged like Deliveries
+func (s *WebhookService) DeadLetters(after string, limit int) ([]models.WebhookDelivery, error) {
+	return s.listDeliveries(repository.DeliveryFilter{Status: models.DeliveryDead, Limit: limit}, after)
+}
+
+// listDeliveries fails with ErrDeliveryNotFound when after is not a
+// delivery, for example one dropped since the previous page
+func (s *WebhookService) listDeliveries(filter repository.DeliveryFilter, after string) ([]models.WebhookDelivery, error) {
+	if after != "" {
+		delivery, found := s.storage.GetDelivery(after)
+		if !found {
+			return nil, repository.ErrDeliveryNotFound
+		}
+		filter.After = &delivery
+	}
+	return s.storage.ListDeliveries(filter)
+}
+
+// RetryDelivery queues a dead delivery again with a fresh set of attempts
+func (s *WebhookService) RetryDelivery(id string) (models.WebhookDelivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delivery, found := s.storage.GetDelivery(id)
+	if !found {
+		return delivery, repository.ErrDeliveryNotFound
+	}
+	if delivery.Status != models.DeliveryDead {
+		return delivery, ErrDeliveryNotDead
+	}
+
+	now := s.clock.Now().UTC()
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = &now
+	if err := s.storage.SaveDelivery(delivery); err != nil {
+		return delivery, err
+	}
+	utils.Logger.WithField("delivery", id).Info("Webhook delivery retried")
+	s.enqueue(id)
+	return delivery, nil
+}
+
+// HandleEvent is the event bus subscriber that turns receipt events into
+// webhook deliveries. It fails when the deliveries cannot be stored, so
+// the event stays in the outbox until they are.
+func (s *WebhookService) HandleEvent(event models.ReceiptEvent) error {
+	switch event.Type {
+	case models.ReceiptProcessed:
+		return s.notify(models.EventReceiptProcessed, event)
+	case models.PointsAdjusted:
+		if event.Reason == models.ReasonReviewApproved {
+			return s.notify(models.EventReceiptApproved, event)
+		}
+	case models.ReceiptVoided:
+		if event.Reason == models.ReasonReviewRejected {
+			return s.notify(models.EventReceiptRejected, event)
+		}
+	}
+	return nil
+}
+
+// notify queues the receipt event as a webhook event for every
+// subscription that asked for it. It only writes to storage and never
+// waits for a receiver. A delivery's ID comes from the event and the
+// subscription, so an event handled again adds no second delivery.
+func (s *WebhookService) notify(webhookEvent models.WebhookEvent, event models.ReceiptEvent) error {
+	subscriptions, err := s.storage.ListSubscriptions()
+	if err != nil {
+		return fmt.Errorf("list webhooks: %w", err)
+	}
+
+	now := s.clock.Now().UTC()
+	for _, subscription := range subscriptions {
+		if !subscription.Wants(webhookEvent) {
+			continue
+		}
+
+		id := deliveryID(event, subscription)
+		if _, found := s.storage.GetDelivery(id); found {
+			continue
+		}
+		payload, err := json.Marshal(models.WebhookPayload{
+			ID:         id,
+			Event:      webhookEvent,
+			OccurredAt: event.OccurredAt,
+			ClientID:   event.ClientID,
+			Receipt:    event.Receipt,
+		})
+		if err != nil {
+			return fmt.Errorf("encode webhook payload: %w", err)
+		}
+
+		next := now
+		delivery := models.WebhookDelivery{
+			ID:             id,
+			SubscriptionID: subscription.ID,
+			Event:          webhookEvent,
+			Status:         models.DeliveryPending,
+			Payload:        payload,
+			CreatedAt:      now,
+			NextAttemptAt:  &next,
+		}
+		if err := s.storage.SaveDelivery(delivery); err != nil {
+			return fmt.Errorf("save delivery for webhook %s: %w", subscription.ID, err)
+		}
+		s.enqueue(id)
+	}
+	return nil
+}
+
+// deliveryID is the same for every call with one event and subscription.
+// Events without an ID get a random one.
+func deliveryID(event models.ReceiptEvent, subscription models.WebhookSubscription) string {
+	if event.ID == "" {
+		return uuid.New().String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(event.ID+"/"+subscription.ID)).String()
+}
+
+// Start launches the delivery workers and queues the pending deliveries
+// kept in storage, whose retries a restart cancelled
+func (s *WebhookService) Start() {
+	for i := 0; i < s.config.Workers; i++ {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			for {
+				select {
+				case <-s.done:
+					return
+				case id := <-s.queue:
+					s.deliver(id)
+					s.sweep()
+				}
+			}
+		}()
+	}
+	s.resume()
+}
+
+// resume queues every pending delivery for its next attempt
+func (s *WebhookService) resume() {
+	filter := repository.DeliveryFilter{Status: models.DeliveryPending, Limit: resumeBatchSize}
+	now := s.clock.Now()
+	resumed := 0
+	for {
+		deliveries, err := s.storage.ListDeliveries(filter)
+		if err != nil {
+			utils.Logger.WithError(err).Error("Failed to list pending webhook deliveries")
+			return
+		}
+		for _, delivery := range deliveries {
+			if delivery.NextAttemptAt != nil && delivery.NextAttemptAt.After(now) {
+				s.retryAfter(delivery.ID, delivery.NextAttemptAt.Sub(now))
+			} else {
+				s.enqueue(delivery.ID)
+			}
+		}
+		resumed += len(deliveries)
+		if len(deliveries) < resumeBatchSize {
+			break
+		}
+		filter.After = &deliveries[len(deliveries)-1]
+	}
+	if resumed > 0 {
+		utils.Logger.WithField("deliveries", resumed).Info("Resumed pending webhook deliveries")
+	}
+}
+
+// sweep drops delivered and dead deliveries created more than Retention
+// ago, at most once every webhookSweepInterval
+func (s *WebhookService) sweep() {
+	s.sweeping.Lock()
+	defer s.sweeping.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) < webhookSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	deleted, err := s.storage.DeleteDeliveriesBefore(now.Add(-s.config.Retention))
+	if err != nil {
+		utils.Logger.WithError(err).Error("Failed to drop old webhook deliveries")
+		return
+	}
+	if deleted > 0 {
+		utils.Logger.WithField("deliveries", deleted).Info("Dropped old webhook deliveries")
+	}
+}
+
+// Stop waits for deliveries in flight and stops the workers and retries
+func (s *WebhookService) Stop() {
+	s.stop.Do(func() {
+		close(s.done)
+	})
+	s.workers.Wait()
+}
+
+// enqueue hands a delivery to the workers without blocking, a full queue
+// puts it off by one backoff
+func (s *WebhookService) enqueue(id string) {
+	select {
+	case s.queue <- id:
+	default:
+		utils.Logger.WithField("delivery", id).Warn("Webhook queue is full, delaying delivery")
+		s.retryAfter(id, s.config.Backoff)
+	}
+}
+
+func (s *WebhookService) retryAfter(id string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case <-s.done:
+		default:
+			s.enqueue(id)
+		}
+	})
+}
+
+// deliver makes one attempt and records its outcome
+func (s *WebhookService) deliver(id string) {
+	delivery, found := s.storage.GetDelivery(id)
+	if !found || delivery.Status != models.DeliveryPending {
+		return
+	}
+	logger := utils.Logger.WithFields(logrus.Fields{
+		"delivery": id,
+		"webhook":  delivery.SubscriptionID,
+		"event":    delivery.Event,
+	})
+
+	subscription, found := s.storage.GetSubscription(delivery.SubscriptionID)
+	if found {
+		delivery.LastStatusCode, delivery.LastError = s.post(subscription, delivery)
+	} else {
+		delivery.LastStatusCode, delivery.LastError = 0, "Webhook was deleted"
+	}
+	delivery.Attempts++
+	now := s.clock.Now().UTC()
+
+	switch {
+	case delivery.LastError == "":
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		logger.WithField("attempts", delivery.Attempts).Info("Webhook delivered")
+	case !found || delivery.Attempts >= s.config.MaxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.NextAttemptAt = nil
+		logger.WithField("error", delivery.LastError).Warn("Webhook delivery failed for good")
+	default:
+		delay := s.backoff(delivery.Attempts)
+		next := now.Add(delay)
+		delivery.NextAttemptAt = &next
+		logger.WithFields(logrus.Fields{
+			"error": delivery.LastError,
+			"retry": delay,
+		}).Warn("Webhook delivery failed")
+		defer s.retryAfter(id, delay)
+	}
+
+	if err := s.storage.SaveDelivery(delivery); err != nil {
+		logger.WithError(err).Error("Failed to save webhook delivery")
+	}
+}
+
+// post sends the payload and returns the receiver's status code, and an
+// error message unless it answered 2xx
+func (s *WebhookService) post(subscription models.WebhookSubscription, delivery models.WebhookDelivery) (int, string) {
+	req, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	timestamp := strconv.FormatInt(s.clock.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "receipt-processor-webhooks")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookEventHeader, string(delivery.Event))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(subscription.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Sprintf("Receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, ""
+}
+
+// backoff doubles the wait after each failed attempt
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	delay := s.config.Backoff
+	for i := 1; i < attempts && delay < s.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.config.MaxBackoff {
+		delay = s.config.MaxBackoff
+	}
+	return delay
+}
+
+// SignWebhook returns the hex HMAC-SHA256 receivers check the
+// X-Webhook-Signature header against, after its "sha256=" prefix. The
+// timestamp is signed with the body so old deliveries cannot be replayed.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,429 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ycChu711/receipt-processor/models"
+	"github.com/ycChu711/receipt-processor/repository"
+	"github.com/ycChu711/receipt-processor/utils"
+)
+
+const testWebhookSecret = "0123456789abcdef"
+
+// receiver records the webhooks posted to it and answers with the next of
+// its statuses, then 200 once they run out
+type receiver struct {
+	t        *testing.T
+	mutex    sync.Mutex
+	statuses []int
+	payloads []models.WebhookPayload
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	rec := &receiver{t: t, statuses: statuses}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	return rec, server.URL
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	signature := strings.TrimPrefix(r.Header.Get(WebhookSignatureHeader), "sha256=")
+	if signature != SignWebhook(testWebhookSecret, r.Header.Get(WebhookTimestampHeader), body) {
+		rec.t.Errorf("Webhook signature %q does not match the body", signature)
+	}
+
+	var payload models.WebhookPayload
+	json.Unmarshal(body, &payload)
+	if r.Header.Get(WebhookEventHeader) != string(payload.Event) || r.Header.Get(WebhookIDHeader) != payload.ID {
+		rec.t.Errorf("Webhook headers do not match the payload %+v", payload)
+	}
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.payloads = append(rec.payloads, payload)
+	status := http.StatusOK
+	if len(rec.statuses) > 0 {
+		status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rec *receiver) received() []models.WebhookPayload {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return append([]models.WebhookPayload(nil), rec.payloads...)
+}
+
+// newTestWebhooks delivers to receivers on 127.0.0.1
+func newTestWebhooks(t *testing.T, maxAttempts int) (*WebhookService, *repository.InMemoryWebhookStorage) {
+	storage := repository.NewInMemoryWebhookStorage()
+	webhooks := NewWebhookService(storage, WebhookConfig{
+		Workers:             2,
+		MaxAttempts:         maxAttempts,
+		Backoff:             time.Millisecond,
+		Timeout:             time.Second,
+		AllowPrivateTargets: true,
+	}, utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)))
+	webhooks.Start()
+	t.Cleanup(webhooks.Stop)
+	return webhooks, storage
+}
+
+// waitForDeliveries polls until every delivery has left pending
+func waitForDeliveries(t *testing.T, storage repository.WebhookStorage, count int) []models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _ := storage.ListDeliveries(repository.DeliveryFilter{})
+		settled := len(deliveries) == count
+		for _, delivery := range deliveries {
+			if delivery.Status == models.DeliveryPending {
+				settled = false
+			}
+		}
+		if settled {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Deliveries did not settle: %+v", deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	rec, url := newReceiver(t)
+	webhooks, storage := newTestWebhooks(t, 3)
+	webhooks.CreateSubscription(models.WebhookSubscription{ID: "crm", URL: url, Events: []models.WebhookEvent{models.EventReceiptProcessed}, Secret: testWebhookSecret})
+	webhooks.CreateSubscription(models.WebhookSubscription{ID: "reviews", URL: url, Events: []models.WebhookEvent{models.EventReceiptApproved}, Secret: testWebhookSecret})
+
+	receiptStorage := repository.NewInMemoryStorage()
+	bus := NewEventBus(receiptStorage, EventBusConfig{})
+	bus.Subscribe(webhooks.HandleEvent)
+	receipts := NewReceiptService(receiptStorage, WithEventBus(bus))
+	id, _ := receipts.ProcessReceipt(models.Principal{ClientID: "alice"}, models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}},
+		Total:        "1.25",
+	})
+	bus.Flush()
+
+	deliveries := waitForDeliveries(t, storage, 1)
+	if deliveries[0].SubscriptionID != "crm" || deliveries[0].Status != models.DeliveryDelivered || deliveries[0].Attempts != 1 {
+		t.Errorf("Expected one delivery to crm, got %+v", deliveries)
+	}
+	payloads := rec.received()
+	if len(payloads) != 1 {
+		t.Fatalf("Expected one webhook, got %d", len(payloads))
+	}
+	if payloads[0].Event != models.EventReceiptProcessed || payloads[0].ClientID != "alice" ||
+		payloads[0].Receipt.ID != id || payloads[0].Receipt.Status != models.StatusActive {
+		t.Errorf("Unexpected payload %+v", payloads[0])
+	}
+}
+
+func TestWebhookReviewEvents(t *testing.T) {
+	rec, url := newReceiver(t)
+	webhooks, storage := newTestWebhooks(t, 3)
+	webhooks.CreateSubscription(models.WebhookSubscription{ID: "reviews", URL: url,
+		Events: []models.WebhookEvent{models.EventReceiptApproved, models.EventReceiptRejected}, Secret: testWebhookSecret})
+
+	receiptStorage := repository.NewInMemoryStorage()
+	receiptStorage.SaveReceipt("held-1", models.ReceiptWithPoints{Points: 40, Status: models.StatusPendingReview})
+	receiptStorage.SaveReceipt("held-2", models.ReceiptWithPoints{Points: 50, Status: models.StatusPendingReview})
+	bus := NewEventBus(receiptStorage, EventBusConfig{})
+	bus.Subscribe(webhooks.HandleEvent)
+	receipts := NewReceiptService(receiptStorage, WithEventBus(bus))
+	receipts.ApproveReceipt("held-1")
+	receipts.RejectReceipt("held-2")
+	// not held, so nothing to send
+	receipts.ApproveReceipt("held-1")
+	bus.Flush()
+
+	waitForDeliveries(t, storage, 2)
+	events := map[string]models.WebhookPayload{}
+	for _, payload := range rec.received() {
+		events[payload.Receipt.ID] = payload
+	}
+	if events["held-1"].Event != models.EventReceiptApproved || events["held-1"].Receipt.Points != 40 {
+		t.Errorf("Expected held-1 approved with 40 points, got %+v", events["held-1"])
+	}
+	if events["held-2"].Event != models.EventReceiptRejected || events["held-2"].Receipt.Points != 0 {
+		t.Errorf("Expected held-2 rejected with no points, got %+v", events["held-2"])
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	event := models.ReceiptEvent{Type: models.ReceiptProcessed, ClientID: "alice", Receipt: models.ReceiptSummary{ID: "receipt-1"}}
+
+	t.Run("delivered after failures", func(t *testing.T) {
+		rec, url := newReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+		webhooks, storage := newTestWebhooks(t, 3)
+		webhooks.CreateSubscription(models.WebhookSubscription{ID: "crm", URL: url, Events: models.WebhookEvents, Secret: testWebhookSecret})
+
+		webhooks.HandleEvent(event)
+		deliveries := waitForDeliveries(t, storage, 1)
+		if deliveries[0].Status != models.DeliveryDelivered || deliveries[0].Attempts != 3 || deliveries[0].LastStatusCode != http.StatusOK {
+			t.Errorf("Expected delivery on the third attempt, got %+v", deliveries[0])
+		}
+		// every attempt sends the same body
+		payloads := rec.received()
+		if len(payloads) != 3 || payloads[0].ID != payloads[2].ID || payloads[0].ID != deliveries[0].ID {
+			t.Errorf("Expected the same delivery three times, got %+v", payloads)
+		}
+	})
+
+	t.Run("dead letter and manual retry", func(t *testing.T) {
+		_, url := newReceiver(t, 500, 500, 500)
+		webhooks, storage := newTestWebhooks(t, 2)
+		webhooks.CreateSubscription(models.WebhookSubscription{ID: "crm", URL: url, Events: models.WebhookEvents, Secret: testWebhookSecret})
+
+		webhooks.HandleEvent(event)
+		waitForDeliveries(t, storage, 1)
+		dead, _ := webhooks.DeadLetters("", 0)
+		if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastStatusCode != 500 || dead[0].LastError == "" {
+			t.Fatalf("Expected a dead delivery after 2 attempts, got %+v", dead)
+		}
+
+		if _, err := webhooks.RetryDelivery(dead[0].ID); err != nil {
+			t.Fatalf("Expected the retry to be queued, got %v", err)
+		}
+		// one more failure, then the receiver recovers
+		deliveries := waitForDeliveries(t, storage, 1)
+		if deliveries[0].Status != models.DeliveryDelivered || deliveries[0].Attempts != 2 {
+			t.Errorf("Expected delivery on the second retried attempt, got %+v", deliveries[0])
+		}
+		if _, err := webhooks.RetryDelivery(dead[0].ID); err != ErrDeliveryNotDead {
+			t.Errorf("Expected ErrDeliveryNotDead, got %v", err)
+		}
+		if dead, _ := webhooks.DeadLetters("", 0); len(dead) != 0 {
+			t.Errorf("Expected the dead-letter list to be empty, got %+v", dead)
+		}
+	})
+
+	t.Run("unreachable receiver", func(t *testing.T) {
+		webhooks, storage := newTestWebhooks(t, 2)
+		webhooks.CreateSubscription(models.WebhookSubscription{ID: "gone", URL: "http://127.0.0.1:1/hook", Events: models.WebhookEvents, Secret: testWebhookSecret})
+
+		webhooks.HandleEvent(event)
+		deliveries := waitForDeliveries(t, storage, 1)
+		if deliveries[0].Status != models.DeliveryDead || deliveries[0].LastStatusCode != 0 || deliveries[0].LastError == "" {
+			t.Errorf("Expected a dead delivery with the connection error, got %+v", deliveries[0])
+		}
+	})
+}
+
+// brokenWebhookStorage cannot save deliveries
+type brokenWebhookStorage struct {
+	*repository.InMemoryWebhookStorage
+}
+
+func (brokenWebhookStorage) SaveDelivery(models.WebhookDelivery) error {
+	return errors.New("disk full")
+}
+
+func TestWebhookEventsHandledAgain(t *testing.T) {
+	event := models.ReceiptEvent{ID: "event-1", Type: models.ReceiptProcessed, ClientID: "alice", Receipt: models.ReceiptSummary{ID: "receipt-1"}}
+	crm := models.WebhookSubscription{ID: "crm", URL: "https://crm.example.com/hooks", Events: models.WebhookEvents, Secret: testWebhookSecret}
+
+	t.Run("one delivery per event", func(t *testing.T) {
+		storage := repository.NewInMemoryWebhookStorage()
+		storage.SaveSubscription(crm)
+		webhooks := NewWebhookService(storage, WebhookConfig{}, utils.SystemClock{})
+		webhooks.HandleEvent(event)
+		if err := webhooks.HandleEvent(event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if deliveries, _ := storage.ListDeliveries(repository.DeliveryFilter{}); len(deliveries) != 1 {
+			t.Errorf("Expected one delivery for the repeated event, got %d", len(deliveries))
+		}
+	})
+
+	t.Run("failed save is returned", func(t *testing.T) {
+		storage := brokenWebhookStorage{repository.NewInMemoryWebhookStorage()}
+		storage.SaveSubscription(crm)
+		webhooks := NewWebhookService(storage, WebhookConfig{}, utils.SystemClock{})
+		if err := webhooks.HandleEvent(event); err == nil {
+			t.Error("Expected the failed save to fail the event")
+		}
+	})
+}
+
+func TestWebhookRestart(t *testing.T) {
+	rec, url := newReceiver(t)
+	storage := repository.NewInMemoryWebhookStorage()
+	storage.SaveSubscription(models.WebhookSubscription{ID: "crm", URL: url, Events: models.WebhookEvents, Secret: testWebhookSecret})
+	// left pending by the previous process, with its retry due
+	payload, _ := json.Marshal(models.WebhookPayload{ID: "d1", Event: models.EventReceiptProcessed})
+	next := time.Date(2022, 6, 1, 11, 59, 0, 0, time.UTC)
+	storage.SaveDelivery(models.WebhookDelivery{ID: "d1", SubscriptionID: "crm", Event: models.EventReceiptProcessed,
+		Status: models.DeliveryPending, Payload: payload, Attempts: 1, CreatedAt: next, NextAttemptAt: &next})
+
+	webhooks := NewWebhookService(storage, WebhookConfig{MaxAttempts: 3, Timeout: time.Second, AllowPrivateTargets: true},
+		utils.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)))
+	webhooks.Start()
+	defer webhooks.Stop()
+
+	deliveries := waitForDeliveries(t, storage, 1)
+	if deliveries[0].Status != models.DeliveryDelivered || deliveries[0].Attempts != 2 {
+		t.Errorf("Expected the pending delivery sent on start, got %+v", deliveries[0])
+	}
+	if payloads := rec.received(); len(payloads) != 1 || payloads[0].ID != "d1" {
+		t.Errorf("Expected d1 received, got %+v", payloads)
+	}
+}
+
+func TestWebhookRetention(t *testing.T) {
+	clock := utils.NewFakeClock(time.Date(2022, 6, 10, 12, 0, 0, 0, time.UTC))
+	storage := repository.NewInMemoryWebhookStorage()
+	webhooks := NewWebhookService(storage, WebhookConfig{Retention: 24 * time.Hour}, clock)
+	save := func(id string, status models.DeliveryStatus, age time.Duration) {
+		storage.SaveDelivery(models.WebhookDelivery{ID: id, SubscriptionID: "crm", Status: status, CreatedAt: clock.Now().Add(-age)})
+	}
+	save("old", models.DeliveryDelivered, 25*time.Hour)
+	save("old-dead", models.DeliveryDead, 48*time.Hour)
+	save("old-pending", models.DeliveryPending, 48*time.Hour)
+	save("recent", models.DeliveryDelivered, time.Hour)
+
+	webhooks.sweep()
+	deliveries, _ := storage.ListDeliveries(repository.DeliveryFilter{})
+	if len(deliveries) != 2 || deliveries[0].ID != "old-pending" || deliveries[1].ID != "recent" {
+		t.Fatalf("Expected the pending and the recent delivery kept, got %+v", deliveries)
+	}
+
+	// the next sweep waits for the interval
+	save("later", models.DeliveryDelivered, 25*time.Hour)
+	webhooks.sweep()
+	if _, found := storage.GetDelivery("later"); !found {
+		t.Error("Expected no second sweep within the interval")
+	}
+	clock.Advance(webhookSweepInterval)
+	webhooks.sweep()
+	if _, found := storage.GetDelivery("later"); found {
+		t.Error("Expected the sweep after the interval to drop it")
+	}
+}
+
+func TestWebhookDeliveryPages(t *testing.T) {
+	storage := repository.NewInMemoryWebhookStorage()
+	webhooks := NewWebhookService(storage, WebhookConfig{}, utils.SystemClock{})
+	storage.SaveSubscription(models.WebhookSubscription{ID: "crm", URL: "https://crm.example.com/hooks", Events: models.WebhookEvents})
+	created := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, id := range []string{"d1", "d2", "d3"} {
+		storage.SaveDelivery(models.WebhookDelivery{ID: id, SubscriptionID: "crm", Status: models.DeliveryDead, CreatedAt: created})
+	}
+
+	first, _ := webhooks.Deliveries("crm", "", 2)
+	if len(first) != 2 || first[1].ID != "d2" {
+		t.Fatalf("Expected d1 and d2, got %+v", first)
+	}
+	if rest, _ := webhooks.DeadLetters("d2", 2); len(rest) != 1 || rest[0].ID != "d3" {
+		t.Errorf("Expected d3 after d2, got %+v", rest)
+	}
+	if _, err := webhooks.Deliveries("crm", "missing", 2); err != repository.ErrDeliveryNotFound {
+		t.Errorf("Expected ErrDeliveryNotFound for an unknown cursor, got %v", err)
+	}
+}
+
+func TestWebhookTargets(t *testing.T) {
+	event := models.ReceiptEvent{Type: models.ReceiptProcessed, ClientID: "alice", Receipt: models.ReceiptSummary{ID: "receipt-1"}}
+
+	t.Run("redirects are not followed", func(t *testing.T) {
+		rec, target := newReceiver(t)
+		redirect := httptest.NewServer(http.RedirectHandler(target, http.StatusTemporaryRedirect))
+		defer redirect.Close()
+		webhooks, storage := newTestWebhooks(t, 1)
+		webhooks.CreateSubscription(models.WebhookSubscription{ID: "crm", URL: redirect.URL, Events: models.WebhookEvents, Secret: testWebhookSecret})
+
+		webhooks.HandleEvent(event)
+		deliveries := waitForDeliveries(t, storage, 1)
+		if deliveries[0].Status != models.DeliveryDead || deliveries[0].LastStatusCode != http.StatusTemporaryRedirect {
+			t.Errorf("Expected the redirect to fail the delivery, got %+v", deliveries[0])
+		}
+		if len(rec.received()) != 0 {
+			t.Error("Expected the redirect target not to be called")
+		}
+	})
+
+	t.Run("private address at delivery", func(t *testing.T) {
+		rec, url := newReceiver(t)
+		storage := repository.NewInMemoryWebhookStorage()
+		webhooks := NewWebhookService(storage, WebhookConfig{MaxAttempts: 1, Timeout: time.Second}, utils.SystemClock{})
+		webhooks.Start()
+		defer webhooks.Stop()
+		// saved past validation, as a public name resolving to 127.0.0.1 would be
+		storage.SaveSubscription(models.WebhookSubscription{ID: "crm", URL: url, Events: models.WebhookEvents, Secret: testWebhookSecret})
+
+		webhooks.HandleEvent(event)
+		deliveries := waitForDeliveries(t, storage, 1)
+		if deliveries[0].Status != models.DeliveryDead || !strings.Contains(deliveries[0].LastError, ErrPrivateWebhookTarget.Error()) {
+			t.Errorf("Expected the private address to be refused, got %+v", deliveries[0])
+		}
+		if len(rec.received()) != 0 {
+			t.Error("Expected the receiver not to be called")
+		}
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	webhooks := NewWebhookService(repository.NewInMemoryWebhookStorage(), WebhookConfig{
+		Backoff:    10 * time.Second,
+		MaxBackoff: time.Minute,
+	}, utils.SystemClock{})
+
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, want := range expected {
+		if got := webhooks.backoff(i + 1); got != want {
+			t.Errorf("After %d attempts expected %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	webhooks := NewWebhookService(repository.NewInMemoryWebhookStorage(), WebhookConfig{}, utils.SystemClock{})
+	crm := models.WebhookSubscription{ID: "crm", URL: "https://crm.example.com/hooks", Events: models.WebhookEvents, Secret: testWebhookSecret}
+
+	if err := webhooks.CreateSubscription(crm); err != nil {
+		t.Fatalf("Expected the subscription to be created, got %v", err)
+	}
+	if err := webhooks.CreateSubscription(crm); err != ErrWebhookExists {
+		t.Errorf("Expected ErrWebhookExists, got %v", err)
+	}
+	if err := webhooks.UpdateSubscription(models.WebhookSubscription{ID: "other", URL: crm.URL, Events: crm.Events, Secret: crm.Secret}); err != repository.ErrWebhookNotFound {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+	if _, err := webhooks.Deliveries("other", "", 0); err != repository.ErrWebhookNotFound {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+
+	invalid := []models.WebhookSubscription{
+		{ID: "Bad ID", URL: crm.URL, Events: crm.Events, Secret: crm.Secret},
+		{ID: "dead-letters", URL: crm.URL, Events: crm.Events, Secret: crm.Secret},
+		{ID: "relative", URL: "/hooks", Events: crm.Events, Secret: crm.Secret},
+		{ID: "ftp", URL: "ftp://crm.example.com/hooks", Events: crm.Events, Secret: crm.Secret},
+		{ID: "no-events", URL: crm.URL, Secret: crm.Secret},
+		{ID: "unknown-event", URL: crm.URL, Events: []models.WebhookEvent{"receipt.lost"}, Secret: crm.Secret},
+		{ID: "short-secret", URL: crm.URL, Events: crm.Events, Secret: "short"},
+		{ID: "loopback", URL: "http://127.0.0.1:8080/hooks", Events: crm.Events, Secret: crm.Secret},
+		{ID: "localhost", URL: "http://localhost/hooks", Events: crm.Events, Secret: crm.Secret},
+		{ID: "private", URL: "https://10.1.2.3/hooks", Events: crm.Events, Secret: crm.Secret},
+		{ID: "link-local", URL: "http://169.254.169.254/latest/meta-data", Events: crm.Events, Secret: crm.Secret},
+		{ID: "ipv6-loopback", URL: "http://[::1]/hooks", Events: crm.Events, Secret: crm.Secret},
+	}
+	for _, subscription := range invalid {
+		if err := webhooks.CreateSubscription(subscription); err == nil {
+			t.Errorf("Expected %s to be rejected", subscription.ID)
+		}
+	}
+}